@@ -36,6 +36,8 @@ const (
 	HaveProblems
 	// Everything is okay.
 	Running
+	// The app is stopping and does not accept new requests.
+	ShuttingDown
 )
 
 // CodeToText map stores text description of code enums.
@@ -43,6 +45,7 @@ var CodeToText = map[Code]string{
 	Unknown:      "UNKNOWN",
 	HaveProblems: "HAVE PROBLEMS",
 	Running:      "RUNNIN",
+	ShuttingDown: "SHUTTING DOWN",
 }
 
 // GetCodeText returns text description of code.
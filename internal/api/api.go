@@ -1,11 +1,14 @@
 package api
 
 import (
+	"context"
 	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -13,57 +16,166 @@ import (
 
 	"github.com/ferux/addressbook"
 
+	"github.com/ferux/addressbook/internal/auth"
 	"github.com/ferux/addressbook/internal/controllers"
 	"github.com/ferux/addressbook/internal/models"
 	"github.com/ferux/addressbook/internal/types"
+	"github.com/ferux/addressbook/internal/validation"
+	"github.com/ferux/addressbook/internal/vcard"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
+)
+
+// defaultSearchLimit is used when search request does not specify limit.
+const defaultSearchLimit = 20
 
-	"gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
+// Defaults of http server used when config does not specify them.
+const (
+	defaultTimeout           = time.Second * 30
+	defaultReadTimeout       = time.Second * 60
+	defaultReadHeaderTimeout = time.Second * 10
+	defaultIdleTimeout       = time.Second * 120
+	// writeTimeoutGap is added to request timeout, so there is time left to write 504.
+	writeTimeoutGap = time.Second * 10
 )
 
 var (
-	// ErrIDInvalid reports in case user id is not a bson_id
+	// ErrIDInvalid reports in case user id is not a valid id
 	ErrIDInvalid = errors.New("not a valid id")
+	// ErrLimitInvalid reports in case limit is not a positive number
+	ErrLimitInvalid = errors.New("not a valid limit")
+	// ErrQueryEmpty reports in case search query is not specified
+	ErrQueryEmpty = errors.New("query is empty")
+	// ErrTimeout reports in case request has not been served in time
+	ErrTimeout = errors.New("request timed out")
 )
 
 // API serves requests from clients.
-// TODO: copy session before getting data from db id:22 gh:15
 type API struct {
-	repo   *db.Repo
-	db     *controllers.Controller
-	logger *logrus.Entry
-	conf   types.API
+	repo      *db.Repo
+	db        *controllers.Controller
+	validator *validation.Validator
+	auth      *auth.Auth
+	server    *http.Server
+	logger    *logrus.Entry
+	conf      types.API
+	// stopping is closed on shutdown to end streams of events.
+	stopping chan struct{}
 }
 
 // NewAPI creates new instance of API.
-func NewAPI(repo *db.Repo, apiconf types.API) *API {
-	return &API{
-		repo:   repo,
-		db:     controllers.NewController(repo.DB),
-		logger: logrus.New().WithField("pkg", "daemon"),
-		conf:   apiconf,
+func NewAPI(repo *db.Repo, apiconf types.API, validator *validation.Validator, authenticator *auth.Auth) *API {
+	a := &API{
+		repo:      repo,
+		db:        repo.Controller(),
+		validator: validator,
+		auth:      authenticator,
+		logger:    logrus.New().WithField("pkg", "daemon"),
+		conf:      apiconf,
+		stopping:  make(chan struct{}),
+	}
+	timeout := apiconf.Timeot.Or(defaultTimeout)
+	maxHeaderBytes := apiconf.MaxHeaderBytes
+	if maxHeaderBytes <= 0 {
+		maxHeaderBytes = http.DefaultMaxHeaderBytes
 	}
+	a.server = &http.Server{
+		Addr:              apiconf.Listen,
+		Handler:           a.registerRoutes(),
+		ReadTimeout:       apiconf.ReadTimeout.Or(defaultReadTimeout),
+		ReadHeaderTimeout: apiconf.ReadHeaderTimeout.Or(defaultReadHeaderTimeout),
+		WriteTimeout:      apiconf.WriteTimeout.Or(timeout + writeTimeoutGap),
+		IdleTimeout:       apiconf.IdleTimeout.Or(defaultIdleTimeout),
+		MaxHeaderBytes:    maxHeaderBytes,
+	}
+	a.server.RegisterOnShutdown(func() { close(a.stopping) })
+	return a
 }
 
 func (a *API) sessionControl(f http.Handler) http.Handler {
 	m := func(w http.ResponseWriter, r *http.Request) {
-		sidcookie, err := r.Cookie("sessionid")
+		var sid string
+		if sidcookie, err := r.Cookie("sessionid"); err == nil {
+			if _, err = uuid.Parse(sidcookie.Value); err == nil {
+				sid = sidcookie.Value
+			}
+		}
+		sid = addSessionCookie(w, sid)
+		r = r.WithContext(WithSID(r.Context(), sid))
+		f.ServeHTTP(w, r)
+	}
+	return middlewareFunc(m)
+}
+
+// limitTime sets deadline of the request. Database calls made by the request
+// fail when it passes and the client gets 504. Streams of events are not limited.
+func (a *API) limitTime(f http.Handler) http.Handler {
+	timeout := a.conf.Timeot.Or(defaultTimeout)
+	m := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == eventsPath {
+			f.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		f.ServeHTTP(w, r.WithContext(ctx))
+	}
+	return middlewareFunc(m)
+}
+
+// copySession runs every request on its own copy of database session and
+// closes it when the request is served. The copy works on data of the
+// tenant of the request.
+func (a *API) copySession(f http.Handler) http.Handler {
+	m := func(w http.ResponseWriter, r *http.Request) {
+		c, release, err := a.repo.Copy(r.Context(), GetTenant(r.Context()))
 		if err != nil {
-			sid := addSessionCookie(w)
-			r = r.WithContext(WithSID(r.Context(), sid))
-		} else {
-			sidcookie.Expires = time.Now().Add(time.Hour * 24 * 7)
-			http.SetCookie(w, sidcookie)
+			a.logger.WithFields(logrus.Fields{
+				"requestID": GetRID(r.Context()),
+				"fn":        "copySession",
+			}).WithError(err).Error("can't open storage")
+			a.handleError(wrapError("can't open storage", r, http.StatusInternalServerError, err), w)
+			return
 		}
+		defer release()
+		r = r.WithContext(WithController(r.Context(), c))
 		f.ServeHTTP(w, r)
 	}
 	return middlewareFunc(m)
 }
 
+// selectBook makes controller of the request work on the book of its owner.
+func (a *API) selectBook(f http.Handler) http.Handler {
+	m := func(w http.ResponseWriter, r *http.Request) {
+		if c := GetController(r.Context()); c != nil {
+			c = c.WithOwner(a.owner(r)).WithActor(a.actor(r), GetRID(r.Context()))
+			r = r.WithContext(WithController(r.Context(), c))
+		}
+		f.ServeHTTP(w, r)
+	}
+	return middlewareFunc(m)
+}
+
+// owner returns the owner of the book used by the request: the book of the
+// client if it is authenticated or the browser session otherwise.
+func (a *API) owner(r *http.Request) string {
+	if p := GetPrincipal(r.Context()); p != nil {
+		return models.KeyOwner(p.Book)
+	}
+	return models.SessionOwner(GetSID(r.Context()))
+}
+
+// controller returns controller of the request. Requests which have not
+// passed copySession use the shared one of the default storage.
+func (a *API) controller(r *http.Request) *controllers.Controller {
+	if c := GetController(r.Context()); c != nil {
+		return c
+	}
+	return a.db.WithOwner(a.owner(r)).WithActor(a.actor(r), GetRID(r.Context()))
+}
+
 func (a *API) logRequests(f http.Handler) http.Handler {
 	m := func(w http.ResponseWriter, r *http.Request) {
 		atomic.AddUint64(&addressbook.RequestsCount, 1)
@@ -83,60 +195,55 @@ func (a *API) logRequests(f http.Handler) http.Handler {
 
 func (a *API) notFoundHandler() http.Handler {
 	h := func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Add("content-type", "text/html")
-		w.WriteHeader(http.StatusNotFound)
-		w.Write([]byte(fmt.Sprintf("invalid path %s", r.RequestURI)))
+		a.handleError(wrapError(fmt.Sprintf("invalid path %s", r.RequestURI), r, http.StatusNotFound, nil), w)
 	}
 	return middlewareFunc(h)
 }
 
-// Run runs API for serving
+// methodNotAllowedHandler reports a known path requested with another method.
+func (a *API) methodNotAllowedHandler() http.Handler {
+	h := func(w http.ResponseWriter, r *http.Request) {
+		a.handleError(wrapError(fmt.Sprintf("method %s is not allowed for %s", r.Method, r.URL.Path), r, http.StatusMethodNotAllowed, errMethodNotAllowed), w)
+	}
+	return middlewareFunc(h)
+}
+
+// Run runs API for serving. It returns nil after Shutdown has been called.
 func (a *API) Run() error {
 	a.logger.WithField("listen", a.conf.Listen).Info("starting api")
-	router := a.registerRoutes()
 	errc := make(chan error)
 	go func() {
-		errc <- http.ListenAndServe(a.conf.Listen, router)
+		errc <- a.server.ListenAndServe()
 	}()
 	addressbook.StatusCode = addressbook.Running
 	addressbook.Status = "You can use this microservice"
 	addressbook.StartedTime = time.Now()
 	err := <-errc
+	if err == http.ErrServerClosed {
+		return nil
+	}
 	addressbook.StatusCode = addressbook.HaveProblems
 	addressbook.Status = "Error: " + err.Error()
 	return err
 }
 
+// Shutdown stops accepting connections and waits till active requests are
+// served or ctx is done.
+func (a *API) Shutdown(ctx context.Context) error {
+	addressbook.StatusCode = addressbook.ShuttingDown
+	addressbook.Status = "shutting down"
+	a.logger.Info("shutting down api")
+	return a.server.Shutdown(ctx)
+}
+
 func (a *API) handleError(err error, w http.ResponseWriter) {
 	switch e := err.(type) {
 	case nil:
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Header().Add("content-type", "text/plain")
-		w.Write([]byte("unknown error"))
+		a.writeProblem(w, newProblem("unknown error", http.StatusInternalServerError, nil))
 	case *ResponseError:
-		if e.GetOrigin() == mgo.ErrNotFound || e.GetOrigin() == models.ErrAlreadyExists {
-			a.handleError(e.GetOrigin(), w)
-			return
-		}
-		w.WriteHeader(e.Code)
-		w.Header().Add("content-type", "application/json")
-		if errJSON := json.NewEncoder(w).Encode(e); errJSON != nil {
-			a.logger.WithError(errJSON).Info("can't encode")
-		}
+		a.writeProblem(w, e)
 	default:
-		switch err {
-		case models.ErrAlreadyExists:
-			http.Error(w, err.Error(), http.StatusConflict)
-		case mgo.ErrNotFound:
-			w.WriteHeader(http.StatusNotFound)
-			w.Header().Add("content-type", "text/plain")
-			w.Write([]byte("not found"))
-		default:
-			w.WriteHeader(http.StatusBadRequest)
-			w.Header().Add("content-type", "text/plain")
-			w.Write([]byte(err.Error()))
-		}
-
+		a.writeProblem(w, newProblem(err.Error(), http.StatusBadRequest, err))
 	}
 }
 
@@ -156,7 +263,14 @@ func (a *API) listUsersHandler(w http.ResponseWriter, r *http.Request) {
 		"fn":        "listUsersHandler",
 	})
 	logger.Info()
-	users, err := a.db.User().ListUsers()
+	q, err := parseListQuery(r)
+	if err != nil {
+		logger.WithError(err).Error("can't parse query")
+		err = wrapError(err.Error(), r, http.StatusBadRequest, err)
+		a.handleError(err, w)
+		return
+	}
+	page, err := a.controller(r).User().FindUsers(q)
 	if err != nil {
 		logger.WithError(err).Error("can't get users list")
 		err = wrapError("error getting userlist", r, http.StatusInternalServerError, err)
@@ -165,7 +279,7 @@ func (a *API) listUsersHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Header().Add("content-type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(&users)
+	json.NewEncoder(w).Encode(page)
 }
 
 func (a *API) createUserHandler(w http.ResponseWriter, r *http.Request) {
@@ -181,15 +295,12 @@ func (a *API) createUserHandler(w http.ResponseWriter, r *http.Request) {
 		a.handleError(err, w)
 		return
 	}
-	// TODO: handle this errors better. id:24 gh:16
-	if errs := checkCorrectValues(user); errs != nil {
-		logger.Error("errs", errs)
-		w.Header().Add("content-type", "text/plain")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(errs)
+	if errs := a.validator.Prepare(&user); errs != nil {
+		logger.WithError(errs).Error("invalid user")
+		a.handleError(validationError(r, errs), w)
 		return
 	}
-	id, err := a.db.User().CreateUser(&user)
+	id, err := a.controller(r).User().CreateUser(&user)
 	if err != nil {
 		a.logger.WithError(err).Error("can't insert user")
 		err = wrapError("can't create user", r, http.StatusInternalServerError, err)
@@ -198,8 +309,8 @@ func (a *API) createUserHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	user.ID = id
 	a.logger.WithField("userid", id).Info("user has been created")
+	w.Header().Set("content-type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	w.Header().Add("content-type", "application/json")
 	json.NewEncoder(w).Encode(&user)
 }
 
@@ -209,23 +320,31 @@ func (a *API) selectUserHandler(w http.ResponseWriter, r *http.Request) {
 		"fn":        "selectUserHandler",
 	})
 	logger.Info()
-	varsID := mux.Vars(r)["id"]
-	if !bson.IsObjectIdHex(varsID) {
+	id, ok := models.ParseID(mux.Vars(r)["id"])
+	if !ok {
 		logger.WithError(ErrIDInvalid).Error("invalid OID")
-		err := wrapError(ErrIDInvalid.Error(), r, http.StatusBadRequest, nil)
+		err := wrapError(ErrIDInvalid.Error(), r, http.StatusBadRequest, ErrIDInvalid)
 		a.handleError(err, w)
 		return
 	}
 
-	c := a.db.User()
-	id := bson.ObjectIdHex(varsID)
+	if at := r.URL.Query().Get("at"); at != "" {
+		a.selectUserAt(w, r, id, at)
+		return
+	}
+	c := a.controller(r).User()
 	user, err := c.SelectUser(id)
 	if err != nil {
 		logger.WithError(err).Error("can't get user")
-		err = wrapError("can't get user", r, http.StatusBadRequest, err)
+		err = wrapError("can't get user", r, http.StatusInternalServerError, err)
 		a.handleError(err, w)
 		return
 	}
+	w.Header().Set("ETag", userETag(user))
+	if notModified(r, user) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 	w.Header().Set("content-type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(user)
@@ -238,20 +357,34 @@ func (a *API) updateUserHandler(w http.ResponseWriter, r *http.Request) {
 	})
 	logger.Info()
 	user, err := findUser(r)
-	// TODO: better error handling. id:29 gh:18
 	if err != nil {
-		http.Error(w, "something went wrong", http.StatusBadRequest)
+		logger.WithError(err).Error("can't parse request")
+		if err == ErrIDInvalid {
+			a.handleError(wrapError(err.Error(), r, http.StatusBadRequest, err), w)
+			return
+		}
+		a.handleError(wrapError("error parsing body", r, http.StatusBadRequest, err), w)
+		return
+	}
+	if errs := a.validator.Prepare(user); errs != nil {
+		logger.WithError(errs).Error("invalid user")
+		a.handleError(validationError(r, errs), w)
 		return
 	}
 
-	if err := a.db.User().UpdateUser(user); err != nil {
+	c := a.controller(r).User()
+	if user.Version, err = expectedVersion(r, c, user.ID); err == nil {
+		_, err = c.UpdateUser(user)
+	}
+	if err != nil {
 		logger.WithError(err).Error("can't update data")
-		err = wrapError("can't update user's info", r, http.StatusBadRequest, err)
+		err = wrapError("can't update user's info", r, http.StatusInternalServerError, err)
 		a.handleError(err, w)
 		return
 	}
+	w.Header().Set("ETag", userETag(user))
+	w.Header().Set("content-type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	w.Header().Add("content-type", "applicaton/json")
 	json.NewEncoder(w).Encode(user)
 }
 
@@ -261,34 +394,167 @@ func (a *API) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
 		"fn":        "updateUserHandler",
 	})
 	logger.Info()
-	varsID := mux.Vars(r)["id"]
-	if !bson.IsObjectIdHex(varsID) {
+	id, ok := models.ParseID(mux.Vars(r)["id"])
+	if !ok {
 		logger.WithError(ErrIDInvalid).Error("invalid OID")
-		err := wrapError(ErrIDInvalid.Error(), r, http.StatusBadRequest, nil)
+		err := wrapError(ErrIDInvalid.Error(), r, http.StatusBadRequest, ErrIDInvalid)
 		a.handleError(err, w)
 		return
 	}
 
-	id := bson.ObjectIdHex(varsID)
-	err := a.db.User().DeleteUser(id)
+	_, err := a.controller(r).User().DeleteUser(id)
 	if err != nil {
 		logger.WithError(err).Error("can't delete user")
-		err = wrapError("can't delete user", r, http.StatusBadRequest, err)
+		err = wrapError("can't delete user", r, http.StatusInternalServerError, err)
 		a.handleError(err, w)
 		return
 	}
+	w.Header().Set("content-type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	w.Header().Add("content-type", "application/json")
 	json.NewEncoder(w).Encode(&models.User{ID: id})
 }
 
+func (a *API) searchHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "searchHandler",
+	})
+	logger.Info()
+	values := r.URL.Query()
+	text := strings.TrimSpace(values.Get("q"))
+	if text == "" {
+		logger.WithError(ErrQueryEmpty).Error("can't search")
+		err := wrapError(ErrQueryEmpty.Error(), r, http.StatusBadRequest, ErrQueryEmpty)
+		a.handleError(err, w)
+		return
+	}
+	limit := defaultSearchLimit
+	if l := values.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			logger.WithError(ErrLimitInvalid).Error("can't search")
+			err = wrapError(ErrLimitInvalid.Error(), r, http.StatusBadRequest, ErrLimitInvalid)
+			a.handleError(err, w)
+			return
+		}
+		limit = n
+	}
+	if limit > models.MaxSearchLimit {
+		limit = models.MaxSearchLimit
+	}
+	users, err := a.controller(r).User().SearchUsers(text, limit)
+	if err != nil {
+		logger.WithError(err).Error("can't search users")
+		err = wrapError("error searching users", r, http.StatusInternalServerError, err)
+		a.handleError(err, w)
+		return
+	}
+	w.Header().Add("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&users)
+}
+
+// Export formats.
+const (
+	formatCSV = "csv"
+	formatVCF = "vcf"
+)
+
+func (a *API) exportHandler(w http.ResponseWriter, r *http.Request) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "", formatCSV:
+		a.downloadCSVHandler(w, r)
+	case formatVCF:
+		a.downloadVCFHandler(w, r)
+	default:
+		err := wrapError("unknown format "+format, r, http.StatusBadRequest, nil)
+		a.handleError(err, w)
+	}
+}
+
+// vcardVersion returns vCard version requested by client.
+func vcardVersion(r *http.Request) (string, error) {
+	switch v := r.URL.Query().Get("version"); v {
+	case "", vcard.Version3:
+		return vcard.Version3, nil
+	case vcard.Version4:
+		return vcard.Version4, nil
+	default:
+		return "", vcard.ErrVersion
+	}
+}
+
+func (a *API) downloadVCFHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "downloadVCFHandler",
+	})
+	logger.Info()
+	version, err := vcardVersion(r)
+	if err != nil {
+		err = wrapError(err.Error(), r, http.StatusBadRequest, err)
+		a.handleError(err, w)
+		return
+	}
+	users, err := a.controller(r).User().ListUsers()
+	if err != nil {
+		logger.WithError(err).Error("can't get users list")
+		err = wrapError("error getting userlist", r, http.StatusInternalServerError, err)
+		a.handleError(err, w)
+		return
+	}
+	w.Header().Add("Content-type", vcard.MediaType+"; charset=utf-8")
+	w.Header().Add("Content-disposition", "attachment; filename=addressbook.vcf")
+	w.WriteHeader(http.StatusOK)
+	for i := range users {
+		if err = vcard.Encode(w, &users[i], version); err != nil {
+			logger.WithError(err).Error("can't write records")
+			return
+		}
+	}
+}
+
+func (a *API) selectVCFHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "selectVCFHandler",
+	})
+	logger.Info()
+	id, ok := models.ParseID(mux.Vars(r)["id"])
+	if !ok {
+		logger.WithError(ErrIDInvalid).Error("invalid OID")
+		err := wrapError(ErrIDInvalid.Error(), r, http.StatusBadRequest, ErrIDInvalid)
+		a.handleError(err, w)
+		return
+	}
+	version, err := vcardVersion(r)
+	if err != nil {
+		err = wrapError(err.Error(), r, http.StatusBadRequest, err)
+		a.handleError(err, w)
+		return
+	}
+	user, err := a.controller(r).User().SelectUser(id)
+	if err != nil {
+		logger.WithError(err).Error("can't get user")
+		err = wrapError("can't get user", r, http.StatusInternalServerError, err)
+		a.handleError(err, w)
+		return
+	}
+	w.Header().Add("Content-type", vcard.MediaType+"; charset=utf-8")
+	w.Header().Add("Content-disposition", "attachment; filename="+id.String()+".vcf")
+	w.WriteHeader(http.StatusOK)
+	if err = vcard.Encode(w, user, version); err != nil {
+		logger.WithError(err).Error("can't write record")
+	}
+}
+
 // todo: rework
 func (a *API) downloadCSVHandler(w http.ResponseWriter, r *http.Request) {
 	logger := a.logger.WithFields(logrus.Fields{
 		"requestID": GetRID(r.Context()),
-		"fn":        "updateUserHandler",
+		"fn":        "downloadCSVHandler",
 	})
-	users, err := a.db.User().ListUsers()
+	users, err := a.controller(r).User().ListUsers()
 	if err != nil {
 		logger.WithError(err).Error("can't get users list")
 		err = wrapError("error getting userlist", r, http.StatusInternalServerError, err)
@@ -296,9 +562,9 @@ func (a *API) downloadCSVHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	records := [][]string{}
-	for _, item := range users {
-		records = append(records, []string{item.ID.Hex(), item.FirstName, item.LastName, item.Email, item.Phone})
+	records := [][]string{csvHeader}
+	for i := range users {
+		records = append(records, csvRecord(&users[i]))
 	}
 	w.Header().Add("Content-type", "text/csv")
 	w.Header().Add("Content-disposition", "attachment; filename=import.csv")
@@ -0,0 +1,279 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ferux/addressbook/internal/auth"
+	"github.com/ferux/addressbook/internal/db"
+	"github.com/ferux/addressbook/internal/models"
+	"github.com/ferux/addressbook/internal/types"
+	"github.com/ferux/addressbook/internal/validation"
+	"github.com/ferux/addressbook/internal/webhook"
+)
+
+// testWebhooks retries deliveries quickly, so tests don't wait for them long.
+var testWebhooks = types.Webhooks{
+	MaxAttempts: 3,
+	Backoff:     types.Duration(time.Millisecond * 10),
+	MaxBackoff:  types.Duration(time.Millisecond * 50),
+	Timeout:     types.Duration(time.Second),
+}
+
+// testClient sends requests to API served on the memory storage. Auth is
+// disabled and the cookie jar keeps the session, so requests share a book.
+type testClient struct {
+	t      *testing.T
+	repo   *db.Repo
+	hooks  *webhook.Dispatcher
+	server *httptest.Server
+	client *http.Client
+}
+
+func newTestClient(t *testing.T) *testClient {
+	c := newConfiguredClient(t, types.API{}, types.Auth{Disabled: true})
+	// the first response sets the session cookie
+	c.expect(http.StatusOK, nil, "GET", "/api/v1/book/", "")
+	return c
+}
+
+// newConfiguredClient creates client of API configured by conf and authConf.
+func newConfiguredClient(t *testing.T, conf types.API, authConf types.Auth) *testClient {
+	repo, err := db.New(types.DB{Driver: types.DriverMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	validator, err := validation.New(types.Validation{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator, err := auth.New(authConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hooks := webhook.New(testWebhooks, repo.AddDeadLetter)
+	repo.SetNotifier(hooks)
+	a := NewAPI(repo, conf, validator, authenticator)
+	a.logger.Logger.SetOutput(ioutil.Discard)
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testClient{
+		t:      t,
+		repo:   repo,
+		hooks:  hooks,
+		server: httptest.NewServer(a.server.Handler),
+		client: &http.Client{Jar: jar},
+	}
+}
+
+func (c *testClient) close() {
+	c.server.Close()
+	c.hooks.Close()
+	c.repo.Close()
+}
+
+// do sends a request with header given as pairs of names and values.
+func (c *testClient) do(method, path, body string, header ...string) *http.Response {
+	c.t.Helper()
+	req, err := http.NewRequest(method, c.server.URL+path, strings.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return resp
+}
+
+// expect sends a request and checks status of the response. JSON body is
+// decoded into v unless v is nil. It returns the response with read body.
+func (c *testClient) expect(status int, v interface{}, method, path, body string, header ...string) (*http.Response, []byte) {
+	c.t.Helper()
+	resp := c.do(method, path, body, header...)
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if resp.StatusCode != status {
+		c.t.Fatalf("%s %s: got status %d, want %d: %s", method, path, resp.StatusCode, status, data)
+	}
+	if v != nil {
+		if err = json.Unmarshal(data, v); err != nil {
+			c.t.Fatalf("%s %s: can't decode %s: %v", method, path, data, err)
+		}
+	}
+	return resp, data
+}
+
+// createUser creates a user with names and emails and returns it.
+func (c *testClient) createUser(first, last string, emails ...string) *models.User {
+	c.t.Helper()
+	u := models.User{FirstName: first, LastName: last}
+	for _, e := range emails {
+		u.Emails = append(u.Emails, models.Email{Value: e})
+	}
+	body, err := json.Marshal(&u)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	var created models.User
+	c.expect(http.StatusOK, &created, "POST", "/api/v1/book/user", string(body))
+	return &created
+}
+
+// selectUser reads the user with id.
+func (c *testClient) selectUser(id models.ID) *models.User {
+	c.t.Helper()
+	var u models.User
+	c.expect(http.StatusOK, &u, "GET", "/api/v1/book/user/"+id.String(), "")
+	return &u
+}
+
+func TestUserCRUD(t *testing.T) {
+	c := newTestClient(t)
+	defer c.close()
+	ann := c.createUser("Ann", "Lee", "ann@example.com")
+	path := "/api/v1/book/user/" + ann.ID.String()
+	if got := c.selectUser(ann.ID); got.FirstName != "Ann" || len(got.Emails) != 1 {
+		t.Errorf("created user is %+v", got)
+	}
+	c.expect(http.StatusConflict, nil, "POST", "/api/v1/book/user",
+		`{"first_name": "Bob", "last_name": "Lee", "emails": [{"value": "ann@example.com"}]}`)
+
+	c.expect(http.StatusOK, nil, "PUT", path, userJSON("Anna", "Lee"))
+	if got := c.selectUser(ann.ID); got.FirstName != "Anna" || len(got.Emails) != 0 {
+		t.Errorf("updated user is %+v", got)
+	}
+	var page models.UserPage
+	c.expect(http.StatusOK, &page, "GET", "/api/v1/book/user", "")
+	if len(page.Users) != 1 || page.Users[0].ID != ann.ID || page.Total != 1 {
+		t.Errorf("book lists %+v", page)
+	}
+
+	c.expect(http.StatusOK, nil, "DELETE", path, "")
+	c.expect(http.StatusNotFound, nil, "GET", path, "")
+	c.expect(http.StatusNotFound, nil, "PUT", path, userJSON("Ann", "Lee"))
+}
+
+func TestUpperCaseID(t *testing.T) {
+	c := newTestClient(t)
+	defer c.close()
+	ann := c.createUser("Ann", "Lee")
+	path := "/api/v1/book/user/" + strings.ToUpper(ann.ID.String())
+	if got := c.selectUser(models.ID(strings.ToUpper(ann.ID.String()))); got.ID != ann.ID {
+		t.Errorf("user is selected with id %s", got.ID)
+	}
+	var patched models.User
+	c.expect(http.StatusOK, &patched, "PATCH", path, `{"first_name": "Anna"}`, "Content-Type", "application/merge-patch+json")
+	if patched.ID != ann.ID || patched.FirstName != "Anna" {
+		t.Errorf("patched user is %+v", patched)
+	}
+	c.expect(http.StatusOK, nil, "DELETE", path, "")
+}
+
+func TestListPagination(t *testing.T) {
+	c := newTestClient(t)
+	defer c.close()
+	names := []string{"Evans", "Adams", "Doe", "Clark", "Brown"}
+	for _, name := range names {
+		c.createUser("Ann", name)
+	}
+
+	var got []string
+	path := "/api/v1/book/user?limit=2&sort=last_name"
+	for pages := 0; ; pages++ {
+		if pages == len(names) {
+			t.Fatal("pagination does not end")
+		}
+		var page models.UserPage
+		c.expect(http.StatusOK, &page, "GET", path, "")
+		if page.Total != len(names) {
+			t.Errorf("total is %d, want %d", page.Total, len(names))
+		}
+		if len(page.Users) > 2 {
+			t.Fatalf("page has %d users, limit is 2", len(page.Users))
+		}
+		for _, u := range page.Users {
+			got = append(got, u.LastName)
+		}
+		if page.Next == "" {
+			break
+		}
+		path = "/api/v1/book/user?limit=2&sort=last_name&after=" + page.Next
+	}
+	want := "Adams Brown Clark Doe Evans"
+	if strings.Join(got, " ") != want {
+		t.Errorf("pages list %v, want %s", got, want)
+	}
+
+	var page models.UserPage
+	c.expect(http.StatusOK, &page, "GET", "/api/v1/book/user?sort=-last_name&limit=1", "")
+	if len(page.Users) != 1 || page.Users[0].LastName != "Evans" {
+		t.Errorf("descending page is %+v, want Evans", page.Users)
+	}
+	c.expect(http.StatusBadRequest, nil, "GET", "/api/v1/book/user?limit=0", "")
+}
+
+func TestSearch(t *testing.T) {
+	c := newTestClient(t)
+	defer c.close()
+	jon := c.createUser("Jonathan", "Smith", "jsmith@example.com")
+	c.createUser("Mary", "Jones", "mary@example.com")
+	c.createUser("Peter", "Parker")
+
+	for _, q := range []string{"jon smi", "jonathn", "jsmith"} {
+		var users []models.User
+		c.expect(http.StatusOK, &users, "GET", "/api/v1/book/search?q="+strings.Replace(q, " ", "+", -1), "")
+		if len(users) == 0 || users[0].ID != jon.ID {
+			t.Errorf("search of %q found %+v, want %s first", q, users, jon.ID)
+		}
+	}
+
+	var users []models.User
+	c.expect(http.StatusOK, &users, "GET", "/api/v1/book/search?q=zzzzzz", "")
+	if len(users) != 0 {
+		t.Errorf("search of unknown word found %+v", users)
+	}
+	c.expect(http.StatusBadRequest, nil, "GET", "/api/v1/book/search", "")
+}
+
+func TestValidationErrors(t *testing.T) {
+	c := newTestClient(t)
+	defer c.close()
+	var problem ResponseError
+	c.expect(http.StatusBadRequest, &problem, "POST", "/api/v1/book/user",
+		`{"first_name": "José", "last_name": "R2D2", "emails": [{"value": "ann"}]}`)
+	if len(problem.Errors) != 2 || problem.Errors[0].Field != "last_name" || problem.Errors[1].Field != "emails[0].value" {
+		t.Errorf("problem is %+v, want errors of last name and email", problem)
+	}
+}
+
+// eventually retries check until it succeeds or a few seconds pass.
+func eventually(t *testing.T, what string, check func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second * 5); !check(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: timed out", what)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+// userJSON returns JSON of a user with names, it is used as body of writes.
+func userJSON(first, last string) string {
+	return fmt.Sprintf(`{"first_name":%q,"last_name":%q}`, first, last)
+}
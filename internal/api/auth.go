@@ -0,0 +1,390 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ferux/addressbook/internal/auth"
+	"github.com/ferux/addressbook/internal/controllers"
+	"github.com/ferux/addressbook/internal/models"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrNoCredentials reports in case request has neither API key nor token
+	ErrNoCredentials = errors.New("api key or bearer token is required")
+	// ErrForbidden reports in case client is not allowed to do the request
+	ErrForbidden = errors.New("not allowed")
+	// ErrAnonymous reports in case request needs authenticated client
+	ErrAnonymous = errors.New("api key or bearer token is required to claim a book")
+	// ErrWrongTenant reports in case token has been issued in another tenant
+	ErrWrongTenant = auth.ErrWrongTenant
+	// ErrKeyNameEmpty reports in case new key has no name
+	ErrKeyNameEmpty = errors.New("name of the key is required")
+	// ErrRoleInvalid reports in case role is unknown
+	ErrRoleInvalid = errors.New("role should be viewer, editor or admin")
+	// ErrBookInvalid reports in case new key is given a book which no key has
+	ErrBookInvalid = errors.New("book should be the book of an existing key")
+)
+
+// publicPaths are served without credentials.
+var publicPaths = map[string]bool{
+	"/status": true,
+}
+
+// authenticate serves only requests with valid API key or bearer token and
+// stores the client in context of the request. Requests without credentials
+// are served anonymously if config allows it.
+func (a *API) authenticate(f http.Handler) http.Handler {
+	m := func(w http.ResponseWriter, r *http.Request) {
+		if !a.auth.Enabled() || publicPaths[r.URL.Path] {
+			f.ServeHTTP(w, r)
+			return
+		}
+		p, err := a.principal(r, a.controller(r).Key(), tenantID(r))
+		if err == ErrNoCredentials && a.auth.Anonymous() {
+			f.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			a.logger.WithFields(logrus.Fields{
+				"requestID": GetRID(r.Context()),
+				"fn":        "authenticate",
+			}).WithError(err).Info("not authenticated")
+			a.unauthorized(w, r, err)
+			return
+		}
+		f.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+	}
+	return middlewareFunc(m)
+}
+
+// principal finds the client by credentials of the request among keys of
+// tenant. API key is taken from X-API-Key header, from password of Basic
+// authorization, which is used by CardDAV clients, or from Bearer authorization.
+func (a *API) principal(r *http.Request, keys controllers.KeyStore, tenant string) (*auth.Principal, error) {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		if _, password, ok := r.BasicAuth(); ok {
+			key = password
+		}
+	}
+	if key == "" {
+		header := r.Header.Get("Authorization")
+		const bearer = "bearer "
+		if len(header) <= len(bearer) || strings.ToLower(header[:len(bearer)]) != bearer {
+			return nil, ErrNoCredentials
+		}
+		credential := strings.TrimSpace(header[len(bearer):])
+		if !auth.IsKey(credential) {
+			return a.auth.Verify(keys, tenant, credential)
+		}
+		key = credential
+	}
+	return a.auth.CheckKey(keys, tenant, key)
+}
+
+func (a *API) unauthorized(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case ErrNoCredentials, ErrWrongTenant, auth.ErrInvalidKey, auth.ErrInvalidToken, auth.ErrTokenExpired:
+		w.Header().Add("WWW-Authenticate", `Bearer realm="addressbook"`)
+		w.Header().Add("WWW-Authenticate", `Basic realm="addressbook"`)
+		a.handleError(wrapError(err.Error(), r, http.StatusUnauthorized, err), w)
+	default:
+		a.handleError(wrapError("can't check credentials", r, http.StatusInternalServerError, err), w)
+	}
+}
+
+// allow serves only requests of clients whose role grants perm.
+func (a *API) allow(perm auth.Permission, h http.HandlerFunc) http.HandlerFunc {
+	return a.allowBy(func(*http.Request) auth.Permission { return perm }, h)
+}
+
+// allowBy is allow for routes which need permission depending on the request.
+func (a *API) allowBy(perm func(r *http.Request) auth.Permission, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if p := perm(r); !a.can(r, p) {
+			a.forbidden(w, r, p)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// can reports whether the client of the request is granted perm. Requests
+// without credentials have anonymous role, everything is allowed when
+// authentication is disabled.
+func (a *API) can(r *http.Request, perm auth.Permission) bool {
+	if !a.auth.Enabled() {
+		return true
+	}
+	if p := GetPrincipal(r.Context()); p != nil {
+		return p.Can(perm)
+	}
+	return auth.RoleCan(auth.AnonymousRole, perm)
+}
+
+// forbidden tells the client that its role does not grant perm.
+func (a *API) forbidden(w http.ResponseWriter, r *http.Request, perm auth.Permission) {
+	a.logger.WithFields(logrus.Fields{
+		"requestID":  GetRID(r.Context()),
+		"permission": perm,
+	}).Info("forbidden")
+	msg := ErrForbidden.Error() + ": " + string(perm) + " permission is required"
+	a.handleError(wrapError(msg, r, http.StatusForbidden, ErrForbidden), w)
+}
+
+// requireOperator serves only requests of the operator, who uses admin key from config.
+func (a *API) requireOperator(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if p := GetPrincipal(r.Context()); a.auth.Enabled() && (p == nil || !p.Operator()) {
+			a.handleError(wrapError(ErrForbidden.Error(), r, http.StatusForbidden, ErrForbidden), w)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// tokenResponse is returned by tokenHandler.
+type tokenResponse struct {
+	Token     string    `json:"token"`
+	TokenType string    `json:"token_type"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// tokenHandler exchanges API key for a bearer token. Tokens can't be
+// exchanged for new ones. They are checked against stored keys, so a token
+// stops working when its key is deleted.
+func (a *API) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "tokenHandler",
+	})
+	logger.Info()
+	p := GetPrincipal(r.Context())
+	if p == nil || p.Method != auth.MethodKey {
+		err := errors.New("token is issued only in exchange for api key")
+		a.handleError(wrapError(err.Error(), r, http.StatusBadRequest, err), w)
+		return
+	}
+	token, expires, err := a.auth.Issue(p)
+	if err != nil {
+		logger.WithError(err).Error("can't issue token")
+		a.handleError(wrapError("can't issue token", r, http.StatusInternalServerError, err), w)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	w.Header().Set("cache-control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&tokenResponse{Token: token, TokenType: "Bearer", ExpiresAt: expires})
+}
+
+func (a *API) listKeysHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "listKeysHandler",
+	})
+	logger.Info()
+	keys, err := a.controller(r).Key().ListKeys()
+	if err != nil {
+		logger.WithError(err).Error("can't get keys")
+		a.handleError(wrapError("can't get keys", r, http.StatusInternalServerError, err), w)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(keys)
+}
+
+// createdKey is returned once when the key is created, later only its hash is known.
+type createdKey struct {
+	*models.APIKey
+	Key string `json:"key"`
+}
+
+func (a *API) createKeyHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "createKeyHandler",
+	})
+	logger.Info()
+	var req struct {
+		Name string `json:"name"`
+		Role string `json:"role"`
+		// Book is the book of another key, the new key shares it.
+		Book string `json:"book"`
+		// Admin is accepted from clients written before roles.
+		Admin bool `json:"admin"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		a.handleError(wrapError("error parsing body", r, http.StatusBadRequest, err), w)
+		return
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		a.handleError(wrapError(ErrKeyNameEmpty.Error(), r, http.StatusBadRequest, ErrKeyNameEmpty), w)
+		return
+	}
+	if req.Role == "" {
+		req.Role = models.RoleEditor
+		if req.Admin {
+			req.Role = models.RoleAdmin
+		}
+	}
+	if !models.IsValidRole(req.Role) {
+		a.handleError(wrapError(ErrRoleInvalid.Error(), r, http.StatusBadRequest, ErrRoleInvalid), w)
+		return
+	}
+	keys := a.controller(r).Key()
+	if req.Book != "" {
+		ok, err := hasBook(keys, req.Book)
+		if err != nil {
+			logger.WithError(err).Error("can't get keys")
+			a.handleError(wrapError("can't get keys", r, http.StatusInternalServerError, err), w)
+			return
+		}
+		if !ok {
+			a.handleError(wrapError(ErrBookInvalid.Error(), r, http.StatusBadRequest, ErrBookInvalid), w)
+			return
+		}
+	}
+	key, k, err := auth.NewKey(req.Name, req.Role)
+	if err == nil {
+		k.Book = req.Book
+		err = keys.CreateKey(k)
+	}
+	if err != nil {
+		logger.WithError(err).Error("can't create key")
+		a.handleError(wrapError("can't create key", r, http.StatusInternalServerError, err), w)
+		return
+	}
+	logger.WithField("keyid", k.ID).Info("key has been created")
+	w.Header().Set("content-type", "application/json")
+	w.Header().Set("cache-control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(&createdKey{APIKey: k, Key: key})
+}
+
+// hasBook checks if any of keys works on book.
+func hasBook(keys controllers.KeyStore, book string) (bool, error) {
+	list, err := keys.ListKeys()
+	if err != nil {
+		return false, err
+	}
+	for _, k := range list {
+		if k.Book == book {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// roleRequest assigns role to a key.
+type roleRequest struct {
+	Role string `json:"role"`
+}
+
+// setKeyRoleHandler changes role of the key. Tokens issued for the key get
+// the new role at once.
+func (a *API) setKeyRoleHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "setKeyRoleHandler",
+	})
+	logger.Info()
+	id, ok := models.ParseID(mux.Vars(r)["id"])
+	if !ok {
+		a.handleError(wrapError(ErrIDInvalid.Error(), r, http.StatusBadRequest, ErrIDInvalid), w)
+		return
+	}
+	var req roleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		a.handleError(wrapError("error parsing body", r, http.StatusBadRequest, err), w)
+		return
+	}
+	if !models.IsValidRole(req.Role) {
+		a.handleError(wrapError(ErrRoleInvalid.Error(), r, http.StatusBadRequest, ErrRoleInvalid), w)
+		return
+	}
+	if err := a.controller(r).Key().SetKeyRole(id, req.Role); err != nil {
+		logger.WithError(err).Error("can't change role of key")
+		a.handleError(wrapError("can't change role of key", r, http.StatusInternalServerError, err), w)
+		return
+	}
+	logger.WithFields(logrus.Fields{"keyid": id, "role": req.Role}).Info("role of key has been changed")
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&req)
+}
+
+func (a *API) deleteKeyHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "deleteKeyHandler",
+	})
+	logger.Info()
+	id, ok := models.ParseID(mux.Vars(r)["id"])
+	if !ok {
+		a.handleError(wrapError(ErrIDInvalid.Error(), r, http.StatusBadRequest, ErrIDInvalid), w)
+		return
+	}
+	if err := a.controller(r).Key().DeleteKey(id); err != nil {
+		logger.WithError(err).Error("can't delete key")
+		a.handleError(wrapError("can't delete key", r, http.StatusInternalServerError, err), w)
+		return
+	}
+	logger.WithField("keyid", id).Info("key has been deleted")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// claimRequest selects the book to claim. Session book is claimed by default.
+type claimRequest struct {
+	// Legacy selects the book of users stored before books became private. It is admin only.
+	Legacy bool `json:"legacy"`
+}
+
+// claimHandler moves users of the anonymous book of the session, which is
+// known by sessionid cookie, to the book of the authenticated client.
+func (a *API) claimHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "claimHandler",
+	})
+	logger.Info()
+	p := GetPrincipal(r.Context())
+	if p == nil {
+		a.handleError(wrapError(ErrAnonymous.Error(), r, http.StatusUnauthorized, ErrAnonymous), w)
+		return
+	}
+	var req claimRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		a.handleError(wrapError("error parsing body", r, http.StatusBadRequest, err), w)
+		return
+	}
+	from := models.SessionOwner(GetSID(r.Context()))
+	if req.Legacy {
+		if !p.Can(auth.PermAdmin) {
+			a.forbidden(w, r, auth.PermAdmin)
+			return
+		}
+		from = models.LegacyOwner
+	}
+	res, err := a.controller(r).User().ClaimUsers(from)
+	if err != nil {
+		logger.WithError(err).Error("can't claim users")
+		a.handleError(wrapError("can't claim users", r, http.StatusInternalServerError, err), w)
+		return
+	}
+	logger.WithFields(logrus.Fields{
+		"claimed": len(res.Claimed),
+		"skipped": len(res.Skipped),
+	}).Info("book has been claimed")
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"testing"
+
+	"github.com/ferux/addressbook/internal/models"
+	"github.com/ferux/addressbook/internal/types"
+)
+
+// testAdminKey is the admin key from config of clients with authentication.
+const testAdminKey = "test-admin-key-0123456789"
+
+func newAuthClient(t *testing.T) *testClient {
+	return newConfiguredClient(t, types.API{}, types.Auth{AdminKey: testAdminKey, TokenSecret: "secret"})
+}
+
+// createKey creates a key with role by the admin key from config and returns it.
+func (c *testClient) createKey(name, role string) *createdKey {
+	c.t.Helper()
+	body, _ := json.Marshal(map[string]string{"name": name, "role": role})
+	k := &createdKey{}
+	c.expect(http.StatusCreated, k, "POST", "/api/v1/admin/keys", string(body), "X-API-Key", testAdminKey)
+	return k
+}
+
+func TestAPIKeysAndTokens(t *testing.T) {
+	c := newAuthClient(t)
+	defer c.close()
+	c.expect(http.StatusUnauthorized, nil, "GET", "/api/v1/book/user", "")
+	c.expect(http.StatusUnauthorized, nil, "GET", "/api/v1/book/user", "", "X-API-Key", "abk_unknown")
+	c.expect(http.StatusOK, nil, "GET", "/status", "")
+
+	k := c.createKey("ci", models.RoleEditor)
+	var keys []models.APIKey
+	c.expect(http.StatusOK, &keys, "GET", "/api/v1/admin/keys", "", "X-API-Key", testAdminKey)
+	if len(keys) != 1 || keys[0].ID != k.ID {
+		t.Fatalf("keys are %+v", keys)
+	}
+	c.expect(http.StatusOK, nil, "POST", "/api/v1/book/user", userJSON("Ann", "Lee"), "X-API-Key", k.Key)
+	c.expect(http.StatusOK, nil, "GET", "/api/v1/book/user", "", "Authorization", "Bearer "+k.Key)
+	c.expect(http.StatusOK, nil, "GET", "/api/v1/book/user", "", "Authorization", basicAuth("ci", k.Key))
+
+	var token tokenResponse
+	c.expect(http.StatusOK, &token, "POST", "/api/v1/auth/token", "", "X-API-Key", k.Key)
+	var page models.UserPage
+	c.expect(http.StatusOK, &page, "GET", "/api/v1/book/user", "", "Authorization", "Bearer "+token.Token)
+	if len(page.Users) != 1 {
+		t.Errorf("book of token lists %+v, want the user created by its key", page.Users)
+	}
+	c.expect(http.StatusBadRequest, nil, "POST", "/api/v1/auth/token", "", "Authorization", "Bearer "+token.Token)
+
+	c.expect(http.StatusNoContent, nil, "DELETE", "/api/v1/admin/keys/"+k.ID.String(), "", "X-API-Key", testAdminKey)
+	c.expect(http.StatusUnauthorized, nil, "GET", "/api/v1/book/user", "", "X-API-Key", k.Key)
+	c.expect(http.StatusUnauthorized, nil, "GET", "/api/v1/book/user", "", "Authorization", "Bearer "+token.Token)
+}
+
+// newSession returns client of the same server with another browser session.
+func (c *testClient) newSession() *testClient {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	other := *c
+	other.client = &http.Client{Jar: jar}
+	return &other
+}
+
+func TestSessionBooks(t *testing.T) {
+	c := newConfiguredClient(t, types.API{}, types.Auth{Anonymous: true, AdminKey: testAdminKey})
+	defer c.close()
+	ann := c.createUser("Ann", "Lee", "ann@example.com")
+	other := c.newSession()
+	if names := other.lastNames(); names != "" {
+		t.Errorf("another session has %q in its book", names)
+	}
+	// books are separate, so the email is free in another one
+	other.createUser("Bob", "Lee", "ann@example.com")
+	other.expect(http.StatusNotFound, nil, "GET", "/api/v1/book/user/"+ann.ID.String(), "")
+	c.expect(http.StatusUnauthorized, nil, "POST", "/api/v1/book/claim", "")
+
+	k := c.createKey("phone", models.RoleEditor)
+	var res models.ClaimResult
+	c.expect(http.StatusOK, &res, "POST", "/api/v1/book/claim", "", "X-API-Key", k.Key)
+	if len(res.Claimed) != 1 || res.Claimed[0] != ann.ID {
+		t.Errorf("claim result is %+v", res)
+	}
+	if names := c.lastNames(); names != "" {
+		t.Errorf("session has %q after claim", names)
+	}
+
+	// a key created with the book of another key shares it
+	var shared createdKey
+	c.expect(http.StatusCreated, &shared, "POST", "/api/v1/admin/keys", `{"name": "laptop", "book": "`+k.Book+`"}`,
+		"X-API-Key", testAdminKey)
+	c.expect(http.StatusOK, nil, "GET", "/api/v1/book/user/"+ann.ID.String(), "", "X-API-Key", shared.Key)
+	c.expect(http.StatusBadRequest, nil, "POST", "/api/v1/admin/keys", `{"name": "tablet", "book": "nobody"}`,
+		"X-API-Key", testAdminKey)
+	own := c.createKey("tablet", models.RoleEditor)
+	c.expect(http.StatusNotFound, nil, "GET", "/api/v1/book/user/"+ann.ID.String(), "", "X-API-Key", own.Key)
+}
+
+func basicAuth(user, password string) string {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.SetBasicAuth(user, password)
+	return req.Header.Get("Authorization")
+}
+
+func TestRoles(t *testing.T) {
+	c := newAuthClient(t)
+	defer c.close()
+	viewer := c.createKey("viewer", models.RoleViewer)
+	editor := c.createKey("editor", models.RoleEditor)
+	admin := c.createKey("admin", models.RoleAdmin)
+
+	c.expect(http.StatusOK, nil, "GET", "/api/v1/book/user", "", "X-API-Key", viewer.Key)
+	c.expect(http.StatusForbidden, nil, "POST", "/api/v1/book/user", userJSON("Ann", "Lee"), "X-API-Key", viewer.Key)
+	c.expect(http.StatusForbidden, nil, "POST", "/api/v1/book/import", ",Ann,Lee,,,,,,,,\n",
+		"X-API-Key", viewer.Key, "Content-Type", "text/csv")
+
+	c.expect(http.StatusOK, nil, "POST", "/api/v1/book/user", userJSON("Ann", "Lee"), "X-API-Key", editor.Key)
+	c.expect(http.StatusForbidden, nil, "POST", "/api/v1/book/import", ",Ann,Lee,,,,,,,,\n",
+		"X-API-Key", editor.Key, "Content-Type", "text/csv", "Append-type", appendClear)
+	c.expect(http.StatusForbidden, nil, "GET", "/api/v1/admin/keys", "", "X-API-Key", editor.Key)
+	c.expect(http.StatusForbidden, nil, "GET", "/api/v1/book/webhooks", "", "X-API-Key", editor.Key)
+
+	c.expect(http.StatusOK, nil, "GET", "/api/v1/admin/keys", "", "X-API-Key", admin.Key)
+	c.expect(http.StatusOK, nil, "POST", "/api/v1/book/import", ",Ann,Lee,,,,,,,,\n",
+		"X-API-Key", admin.Key, "Content-Type", "text/csv", "Append-type", appendClear)
+	// keys of tenants are not operators
+	c.expect(http.StatusForbidden, nil, "GET", "/api/v1/admin/tenants", "", "X-API-Key", admin.Key)
+
+	// a new role applies at once to the key and to tokens issued for it
+	var token tokenResponse
+	c.expect(http.StatusOK, &token, "POST", "/api/v1/auth/token", "", "X-API-Key", viewer.Key)
+	c.expect(http.StatusOK, nil, "PUT", "/api/v1/admin/keys/"+viewer.ID.String()+"/role", `{"role": "editor"}`, "X-API-Key", admin.Key)
+	c.expect(http.StatusOK, nil, "POST", "/api/v1/book/user", userJSON("Bob", "Lee"), "X-API-Key", viewer.Key)
+	c.expect(http.StatusOK, nil, "POST", "/api/v1/book/user", userJSON("Cy", "Lee"), "Authorization", "Bearer "+token.Token)
+	c.expect(http.StatusBadRequest, nil, "PUT", "/api/v1/admin/keys/"+viewer.ID.String()+"/role", `{"role": "owner"}`, "X-API-Key", admin.Key)
+}
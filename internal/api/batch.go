@@ -0,0 +1,182 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/ferux/addressbook/internal/models"
+	"github.com/ferux/addressbook/internal/validation"
+	"github.com/sirupsen/logrus"
+)
+
+// maxBatchSize limits the amount of operations of one batch.
+const maxBatchSize = 1000
+
+var (
+	// ErrBatchEmpty reports in case batch has no operations
+	ErrBatchEmpty = errors.New("batch has no operations")
+	// ErrBatchTooLarge reports in case batch has more than maxBatchSize operations
+	ErrBatchTooLarge = errors.New("batch has too many operations")
+	// ErrBatchOp reports in case operation of batch is unknown or misses user
+	ErrBatchOp = errors.New("operation is unknown or misses user")
+	// ErrBatchRepeated reports in case user is changed by several operations of batch
+	ErrBatchRepeated = errors.New("user is changed by another operation of the batch")
+)
+
+// batchRequest is the body of batch request. Atomic batch is applied only if
+// all its operations succeed.
+type batchRequest struct {
+	Atomic     bool             `json:"atomic"`
+	Operations []models.BatchOp `json:"operations"`
+}
+
+// batchResult is the outcome of one operation of batch.
+type batchResult struct {
+	Op      string         `json:"op"`
+	ID      models.ID      `json:"id,omitempty"`
+	Version int64          `json:"version,omitempty"`
+	Status  int            `json:"status"`
+	Error   *ResponseError `json:"error,omitempty"`
+}
+
+// batchResponse lists results in the order of operations.
+type batchResponse struct {
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Results   []batchResult `json:"results"`
+}
+
+// batchHandler applies a list of create, update, upsert and delete
+// operations. Invalid operations are reported without reaching storage.
+func (a *API) batchHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "batchHandler",
+	})
+	logger.Info()
+	defer r.Body.Close()
+	var req batchRequest
+	if err := json.NewDecoder(&limitedReader{r: r.Body, n: MAXFILESIZE}).Decode(&req); err != nil {
+		logger.WithError(err).Error("can't parse request")
+		status := http.StatusBadRequest
+		if err == ErrFileTooLarge {
+			status = http.StatusRequestEntityTooLarge
+		}
+		a.handleError(wrapError("error parsing body", r, status, err), w)
+		return
+	}
+	switch {
+	case len(req.Operations) == 0:
+		a.handleError(wrapError(ErrBatchEmpty.Error(), r, http.StatusBadRequest, ErrBatchEmpty), w)
+		return
+	case len(req.Operations) > maxBatchSize:
+		a.handleError(wrapError(ErrBatchTooLarge.Error(), r, http.StatusRequestEntityTooLarge, ErrBatchTooLarge), w)
+		return
+	}
+
+	errs := make([]error, len(req.Operations))
+	valid := make([]int, 0, len(req.Operations))
+	targets := make(map[models.ID]bool)
+	for i := range req.Operations {
+		if errs[i] = a.checkBatchOp(&req.Operations[i], targets); errs[i] == nil {
+			valid = append(valid, i)
+		}
+	}
+	switch {
+	case req.Atomic && len(valid) < len(req.Operations):
+		for _, i := range valid {
+			errs[i] = models.ErrNotApplied
+		}
+	case len(valid) > 0:
+		ops := make([]models.BatchOp, len(valid))
+		for n, i := range valid {
+			ops[n] = req.Operations[i]
+		}
+		applied, err := a.controller(r).User().BatchUsers(ops, req.Atomic)
+		if err != nil {
+			logger.WithError(err).Error("can't apply batch")
+			a.handleError(wrapError("can't apply batch", r, http.StatusInternalServerError, err), w)
+			return
+		}
+		for n, i := range valid {
+			errs[i] = applied[n]
+		}
+	}
+
+	resp := batchResponse{Results: make([]batchResult, len(req.Operations))}
+	for i, op := range req.Operations {
+		res := &resp.Results[i]
+		res.Op = op.Op
+		if errs[i] != nil {
+			resp.Failed++
+			res.ID = op.ID
+			res.Error = batchProblem(errs[i])
+			res.Status = res.Error.Status
+			continue
+		}
+		resp.Succeeded++
+		res.Status = http.StatusOK
+		switch op.Op {
+		case models.BatchCreate:
+			res.Status = http.StatusCreated
+			res.ID, res.Version = op.User.ID, op.User.Version
+		case models.BatchDelete:
+			res.ID = op.ID
+		default:
+			res.ID, res.Version = op.User.ID, op.User.Version
+		}
+	}
+	logger.WithFields(logrus.Fields{"succeeded": resp.Succeeded, "failed": resp.Failed}).Info("batch applied")
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&resp)
+}
+
+// checkBatchOp checks op and prepares its user like single requests do.
+// targets collects ids of users changed by the batch.
+func (a *API) checkBatchOp(op *models.BatchOp, targets map[models.ID]bool) error {
+	if !models.IsValidBatchOp(op.Op) || (op.User == nil && op.Op != models.BatchDelete) {
+		return ErrBatchOp
+	}
+	id := op.ID
+	switch op.Op {
+	case models.BatchCreate:
+		op.ID, id = "", ""
+	case models.BatchUpsert:
+		id = op.User.ID
+		if id != "" && !models.IsValidID(string(id)) {
+			return ErrIDInvalid
+		}
+	default:
+		if !models.IsValidID(string(id)) {
+			return ErrIDInvalid
+		}
+	}
+	if id != "" {
+		if targets[id] {
+			return ErrBatchRepeated
+		}
+		targets[id] = true
+	}
+	if op.User == nil {
+		return nil
+	}
+	if op.Op == models.BatchUpdate {
+		op.User.ID = op.ID
+	}
+	if errs := a.validator.Prepare(op.User); errs != nil {
+		return errs
+	}
+	return nil
+}
+
+// batchProblem describes error of an operation.
+func batchProblem(err error) *ResponseError {
+	if errs, ok := err.(validation.Errors); ok {
+		e := newProblem("validation failed", http.StatusBadRequest, errs)
+		e.Errors = errs
+		return e
+	}
+	return newProblem(err.Error(), http.StatusInternalServerError, err)
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+)
+
+// statuses returns status of every result of batch.
+func (b *batchResponse) statuses() []int {
+	res := make([]int, 0, len(b.Results))
+	for _, r := range b.Results {
+		res = append(res, r.Status)
+	}
+	return res
+}
+
+func TestBatch(t *testing.T) {
+	c := newTestClient(t)
+	defer c.close()
+	ann := c.createUser("Ann", "Lee", "ann@example.com")
+	id := ann.ID.String()
+
+	var res batchResponse
+	c.expect(http.StatusOK, &res, "POST", "/api/v1/book/user:batch", `{"operations": [
+		{"op": "create", "user": {"first_name": "Bob", "last_name": "Lee"}},
+		{"op": "update", "id": "`+id+`", "user": {"first_name": "Anna", "last_name": "Lee", "version": 1}},
+		{"op": "upsert", "user": {"id": "5b4f1b7b8d6e4a2a1c3e9f11", "first_name": "Eve", "last_name": "Lee"}},
+		{"op": "delete", "id": "5b4f1b7b8d6e4a2a1c3e9f12"},
+		{"op": "create", "user": {"first_name": "Cy", "last_name": ""}}
+	]}`)
+	want := []int{http.StatusCreated, http.StatusOK, http.StatusOK, http.StatusNotFound, http.StatusBadRequest}
+	if got := res.statuses(); !equalInts(got, want) {
+		t.Fatalf("statuses are %v, want %v", got, want)
+	}
+	if res.Succeeded != 3 || res.Failed != 2 {
+		t.Errorf("batch reports %d succeeded and %d failed, want 3 and 2", res.Succeeded, res.Failed)
+	}
+	if res.Results[3].Error == nil || res.Results[3].Error.Code != ProblemNotFound {
+		t.Errorf("error of missing user is %+v", res.Results[3].Error)
+	}
+	if u := c.selectUser(ann.ID); u.FirstName != "Anna" || u.Version != 2 || res.Results[1].Version != 2 {
+		t.Errorf("updated user is %+v, result %+v", u, res.Results[1])
+	}
+	if names := c.lastNames(); names != "Lee Lee Lee" {
+		t.Errorf("book has %q after batch", names)
+	}
+
+	res = batchResponse{}
+	c.expect(http.StatusOK, &res, "POST", "/api/v1/book/user:batch", `{"atomic": true, "operations": [
+		{"op": "create", "user": {"first_name": "Dan", "last_name": "Doe"}},
+		{"op": "update", "id": "`+id+`", "user": {"first_name": "Ann", "last_name": "Lee", "version": 1}}
+	]}`)
+	want = []int{http.StatusFailedDependency, http.StatusPreconditionFailed}
+	if got := res.statuses(); !equalInts(got, want) {
+		t.Fatalf("atomic statuses are %v, want %v", got, want)
+	}
+	if res.Results[0].Error == nil || res.Results[0].Error.Code != ProblemNotApplied {
+		t.Errorf("error of rolled back operation is %+v", res.Results[0].Error)
+	}
+	if names := c.lastNames(); names != "Lee Lee Lee" {
+		t.Errorf("book has %q after failed atomic batch", names)
+	}
+
+	c.createUser("Gus", "Lee", "gus@example.com")
+	res = batchResponse{}
+	c.expect(http.StatusOK, &res, "POST", "/api/v1/book/user:batch", `{"operations": [
+		{"op": "create", "user": {"first_name": "Dan", "last_name": "Doe", "emails": [{"value": "gus@example.com"}]}},
+		{"op": "delete", "id": "`+id+`"},
+		{"op": "update", "id": "`+id+`", "user": {"first_name": "Ann", "last_name": "Lee"}}
+	]}`)
+	if got := res.statuses(); got[0] != http.StatusConflict || got[2] != http.StatusBadRequest {
+		t.Errorf("statuses are %v, want conflict of email and rejected second change of the user", got)
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
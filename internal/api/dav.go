@@ -0,0 +1,818 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ferux/addressbook/internal/auth"
+	"github.com/ferux/addressbook/internal/controllers"
+	"github.com/ferux/addressbook/internal/models"
+	"github.com/ferux/addressbook/internal/vcard"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CardDAV (RFC 6352) serves one principal with one address book:
+//
+//	/dav/                      principal and address book home
+//	/dav/addressbook/          address book
+//	/dav/addressbook/{id}.vcf  contact
+const (
+	davPrefix    = "/dav"
+	davPrincipal = davPrefix + "/"
+	davBook      = davPrincipal + "addressbook/"
+)
+
+// XML namespaces used by CardDAV.
+const (
+	nsDAV  = "DAV:"
+	nsCard = "urn:ietf:params:xml:ns:carddav"
+	nsCS   = "http://calendarserver.org/ns/"
+)
+
+// syncTokenPrefix makes sync token an URI as RFC 6578 requires.
+const syncTokenPrefix = "urn:x-addressbook:sync:"
+
+// davContentType is a content type of contacts served by CardDAV.
+const davContentType = vcard.MediaType + "; charset=utf-8"
+
+var (
+	// ErrSyncToken reports in case sync token is unknown or too old.
+	ErrSyncToken = errors.New("invalid sync token")
+	// ErrPrecondition reports in case If-Match or If-None-Match condition failed.
+	ErrPrecondition = errors.New("precondition failed")
+	// ErrResourceName reports in case contact is created with a name which is not an id.
+	ErrResourceName = errors.New("name of contact should be a new id followed by .vcf")
+)
+
+var davPrefixes = map[string]string{nsDAV: "d", nsCard: "card", nsCS: "cs"}
+
+// davProps is a list of requested properties.
+type davProps struct {
+	Names []davName `xml:",any"`
+}
+
+type davName struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+}
+
+type davPropfind struct {
+	XMLName  xml.Name  `xml:"DAV: propfind"`
+	AllProp  *struct{} `xml:"DAV: allprop"`
+	PropName *struct{} `xml:"DAV: propname"`
+	Prop     *davProps `xml:"DAV: prop"`
+}
+
+type davReport struct {
+	XMLName   xml.Name
+	Prop      *davProps      `xml:"DAV: prop"`
+	Hrefs     []string       `xml:"DAV: href"`
+	SyncToken string         `xml:"DAV: sync-token"`
+	Filter    *davCardFilter `xml:"urn:ietf:params:xml:ns:carddav filter"`
+	Limit     *struct {
+		NResults int `xml:"urn:ietf:params:xml:ns:carddav nresults"`
+	} `xml:"urn:ietf:params:xml:ns:carddav limit"`
+}
+
+type davCardFilter struct {
+	Test        string          `xml:"test,attr"`
+	PropFilters []davPropFilter `xml:"urn:ietf:params:xml:ns:carddav prop-filter"`
+}
+
+type davPropFilter struct {
+	Name         string         `xml:"name,attr"`
+	Test         string         `xml:"test,attr"`
+	IsNotDefined *struct{}      `xml:"urn:ietf:params:xml:ns:carddav is-not-defined"`
+	TextMatches  []davTextMatch `xml:"urn:ietf:params:xml:ns:carddav text-match"`
+}
+
+type davTextMatch struct {
+	MatchType string `xml:"match-type,attr"`
+	Negate    string `xml:"negate-condition,attr"`
+	Value     string `xml:",chardata"`
+}
+
+// davProp is a property value. Inner is raw XML.
+type davProp struct {
+	Name  xml.Name
+	Inner string
+}
+
+// davResponse is one response element of multistatus. Status is used
+// for resources without properties, e.g. deleted ones.
+type davResponse struct {
+	Href    string
+	Found   []davProp
+	Missing []xml.Name
+	Status  int
+}
+
+// davContact is a contact with its encoded card.
+type davContact struct {
+	user *models.User
+	card []byte
+	etag string
+}
+
+func newDAVContact(u *models.User, version string) (*davContact, error) {
+	var buf bytes.Buffer
+	if err := vcard.Encode(&buf, u, version); err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum(buf.Bytes())
+	return &davContact{user: u, card: buf.Bytes(), etag: `"` + hex.EncodeToString(sum[:]) + `"`}, nil
+}
+
+func (c *davContact) href() string {
+	return davBook + c.user.ID.String() + ".vcf"
+}
+
+func (a *API) wellKnownCardDAVHandler(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, davPrincipal, http.StatusMovedPermanently)
+}
+
+// davPermission returns the permission needed by DAV request: PUT and
+// DELETE change the book, other methods read it.
+func davPermission(r *http.Request) auth.Permission {
+	if r.Method == http.MethodPut || r.Method == http.MethodDelete {
+		return auth.PermWrite
+	}
+	return auth.PermRead
+}
+
+func (a *API) davHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "davHandler",
+		"method":    r.Method,
+	})
+	logger.Info()
+	defer r.Body.Close()
+
+	w.Header().Set("DAV", "1, 3, addressbook")
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, REPORT")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	path := strings.TrimSuffix(r.URL.Path, "/") + "/"
+	var err error
+	switch {
+	case path == davPrincipal:
+		err = a.davCollection(w, r, false)
+	case path == davBook:
+		err = a.davCollection(w, r, true)
+	case strings.HasPrefix(path, davBook) && !strings.Contains(strings.Trim(path[len(davBook):], "/"), "/"):
+		id, ok := davResourceID(r.URL.Path[len(davBook):])
+		switch {
+		case ok:
+			err = a.davContact(w, r, id)
+		case r.Method == http.MethodPut:
+			err = ErrResourceName
+		default:
+			err = models.ErrNotFound
+		}
+	default:
+		err = models.ErrNotFound
+	}
+	if err != nil {
+		logger.WithError(err).Error("can't serve dav request")
+		a.davError(w, r, err)
+	}
+}
+
+// davError reports err as problem+json unless DAV defines the condition
+// element for it.
+func (a *API) davError(w http.ResponseWriter, r *http.Request, err error) {
+	if isTimeout(r, err) {
+		a.handleError(wrapError(ErrTimeout.Error(), r, http.StatusGatewayTimeout, err), w)
+		return
+	}
+	switch err {
+	case models.ErrNotFound, models.ErrAlreadyExists, ErrPrecondition, ErrResourceName, errMethodNotAllowed:
+		a.handleError(wrapError(err.Error(), r, http.StatusBadRequest, err), w)
+	case ErrSyncToken:
+		writeDAVCondition(w, http.StatusForbidden, nsDAV, "valid-sync-token")
+	case controllers.ErrQuotaExceeded:
+		writeDAVCondition(w, http.StatusInsufficientStorage, nsDAV, "quota-not-exceeded")
+	default:
+		if _, ok := err.(*davBadRequest); ok {
+			a.handleError(wrapError(err.Error(), r, http.StatusBadRequest, err), w)
+			return
+		}
+		a.handleError(wrapError("internal error", r, http.StatusInternalServerError, err), w)
+	}
+}
+
+var errMethodNotAllowed = errors.New("method not allowed")
+
+// davBadRequest reports a problem with request sent by client.
+type davBadRequest struct{ msg string }
+
+func (e *davBadRequest) Error() string {
+	return e.msg
+}
+
+// davResourceID converts resource name to user id. Names chosen by clients
+// are not stored, so contacts are named only by ids.
+func davResourceID(name string) (models.ID, bool) {
+	return models.ParseID(strings.TrimSuffix(strings.Trim(name, "/"), ".vcf"))
+}
+
+func (a *API) davCollection(w http.ResponseWriter, r *http.Request, book bool) error {
+	switch r.Method {
+	case "PROPFIND":
+	case "REPORT":
+		if !book {
+			return errMethodNotAllowed
+		}
+		return a.davReport(w, r)
+	default:
+		return errMethodNotAllowed
+	}
+
+	names, all, err := parsePropfind(r.Body)
+	if err != nil {
+		return err
+	}
+	depth := r.Header.Get("Depth")
+	c := a.controller(r).User()
+	token := a.syncToken(a.controller(r).Journal())
+	var responses []davResponse
+	if book {
+		responses = append(responses, propResponse(davBook, bookProps(token), names, all))
+	} else {
+		responses = append(responses, propResponse(davPrincipal, principalProps(), names, all))
+	}
+	if depth == "0" {
+		writeMultistatus(w, responses, "")
+		return nil
+	}
+	if !book {
+		responses = append(responses, propResponse(davBook, bookProps(token), names, all))
+		writeMultistatus(w, responses, "")
+		return nil
+	}
+	users, err := c.ListUsers()
+	if err != nil {
+		return err
+	}
+	for i := range users {
+		contact, err := newDAVContact(&users[i], vcard.Version3)
+		if err != nil {
+			return err
+		}
+		responses = append(responses, propResponse(contact.href(), contactProps(contact, names), names, all))
+	}
+	writeMultistatus(w, responses, "")
+	return nil
+}
+
+func (a *API) davReport(w http.ResponseWriter, r *http.Request) error {
+	var report davReport
+	if err := xml.NewDecoder(io.LimitReader(r.Body, MAXFILESIZE)).Decode(&report); err != nil {
+		return &davBadRequest{msg: "can't parse report: " + err.Error()}
+	}
+	var names []davName
+	if report.Prop != nil {
+		names = report.Prop.Names
+	}
+	c := a.controller(r).User()
+	responses := make([]davResponse, 0)
+
+	switch report.XMLName {
+	case xml.Name{Space: nsCard, Local: "addressbook-query"}:
+		users, err := c.ListUsers()
+		if err != nil {
+			return err
+		}
+		for i := range users {
+			if report.Limit != nil && report.Limit.NResults > 0 && len(responses) >= report.Limit.NResults {
+				break
+			}
+			contact, err := newDAVContact(&users[i], addressDataVersion(names))
+			if err != nil {
+				return err
+			}
+			if !report.Filter.match(contact.card) {
+				continue
+			}
+			responses = append(responses, propResponse(contact.href(), contactProps(contact, names), names, false))
+		}
+		writeMultistatus(w, responses, "")
+		return nil
+
+	case xml.Name{Space: nsCard, Local: "addressbook-multiget"}:
+		for _, href := range report.Hrefs {
+			if u, err := url.Parse(strings.TrimSpace(href)); err == nil {
+				href = u.Path
+			}
+			id, ok := davResourceID(strings.TrimPrefix(href, davBook))
+			if !ok || !strings.HasPrefix(href, davBook) {
+				responses = append(responses, davResponse{Href: href, Status: http.StatusNotFound})
+				continue
+			}
+			user, err := c.SelectUser(id)
+			if err == models.ErrNotFound {
+				responses = append(responses, davResponse{Href: href, Status: http.StatusNotFound})
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			contact, err := newDAVContact(user, addressDataVersion(names))
+			if err != nil {
+				return err
+			}
+			responses = append(responses, propResponse(contact.href(), contactProps(contact, names), names, false))
+		}
+		writeMultistatus(w, responses, "")
+		return nil
+
+	case xml.Name{Space: nsDAV, Local: "sync-collection"}:
+		return a.davSync(w, c, a.controller(r).Journal(), a.owner(r), &report, names)
+	}
+	return &davBadRequest{msg: "unsupported report " + report.XMLName.Local}
+}
+
+// davSync answers sync-collection report (RFC 6578). Without token it returns
+// all contacts of the book of owner, otherwise only the ones changed after
+// the token was issued. Tokens are issued by journal of the tenant.
+func (a *API) davSync(w http.ResponseWriter, c controllers.UserStore, journal *controllers.Journal, owner string, report *davReport, names []davName) error {
+	if journal == nil {
+		return ErrSyncToken
+	}
+	// seq is taken before reading data, so changes made meanwhile are reported next time
+	seq := journal.Seq()
+	responses := make([]davResponse, 0)
+	if report.SyncToken == "" {
+		users, err := c.ListUsers()
+		if err != nil {
+			return err
+		}
+		for i := range users {
+			contact, err := newDAVContact(&users[i], addressDataVersion(names))
+			if err != nil {
+				return err
+			}
+			responses = append(responses, propResponse(contact.href(), contactProps(contact, names), names, false))
+		}
+		writeMultistatus(w, responses, syncTokenAt(journal, seq))
+		return nil
+	}
+
+	since, err := parseSyncToken(journal, report.SyncToken)
+	if err != nil {
+		return err
+	}
+	changes, ok := journal.Since(owner, since)
+	if !ok {
+		return ErrSyncToken
+	}
+	for _, change := range changes {
+		if change.Seq > seq {
+			continue
+		}
+		href := davBook + change.ID.String() + ".vcf"
+		if change.Type == models.EventDeleted {
+			responses = append(responses, davResponse{Href: href, Status: http.StatusNotFound})
+			continue
+		}
+		user, err := c.SelectUser(change.ID)
+		if err == models.ErrNotFound {
+			responses = append(responses, davResponse{Href: href, Status: http.StatusNotFound})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		contact, err := newDAVContact(user, addressDataVersion(names))
+		if err != nil {
+			return err
+		}
+		responses = append(responses, propResponse(href, contactProps(contact, names), names, false))
+	}
+	writeMultistatus(w, responses, syncTokenAt(journal, seq))
+	return nil
+}
+
+func (a *API) syncToken(journal *controllers.Journal) string {
+	if journal == nil {
+		return ""
+	}
+	return syncTokenAt(journal, journal.Seq())
+}
+
+func syncTokenAt(journal *controllers.Journal, seq uint64) string {
+	return syncTokenPrefix + journal.Epoch() + "-" + strconv.FormatUint(seq, 10)
+}
+
+func parseSyncToken(journal *controllers.Journal, token string) (uint64, error) {
+	token = strings.TrimPrefix(strings.TrimSpace(token), syncTokenPrefix)
+	parts := strings.SplitN(token, "-", 2)
+	if len(parts) != 2 || parts[0] != journal.Epoch() {
+		return 0, ErrSyncToken
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, ErrSyncToken
+	}
+	return seq, nil
+}
+
+func (a *API) davContact(w http.ResponseWriter, r *http.Request, id models.ID) error {
+	c := a.controller(r).User()
+	var current *davContact
+	user, err := c.SelectUser(id)
+	switch err {
+	case nil:
+		if current, err = newDAVContact(user, vcard.Version3); err != nil {
+			return err
+		}
+	case models.ErrNotFound:
+	default:
+		return err
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if current == nil {
+			return models.ErrNotFound
+		}
+		w.Header().Set("Content-type", davContentType)
+		w.Header().Set("ETag", current.etag)
+		w.Header().Set("Content-length", strconv.Itoa(len(current.card)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(current.card)
+		}
+		return nil
+
+	case "PROPFIND":
+		if current == nil {
+			return models.ErrNotFound
+		}
+		names, all, err := parsePropfind(r.Body)
+		if err != nil {
+			return err
+		}
+		writeMultistatus(w, []davResponse{propResponse(current.href(), contactProps(current, names), names, all)}, "")
+		return nil
+
+	case http.MethodPut:
+		if err = checkDAVPreconditions(r, current); err != nil {
+			return err
+		}
+		card, err := vcard.NewReader(io.LimitReader(r.Body, MAXFILESIZE)).Read()
+		if err != nil {
+			return &davBadRequest{msg: "can't parse vCard: " + err.Error()}
+		}
+		user := vcard.ToUser(card)
+		user.ID = id
+		if errs := a.validator.Prepare(user); errs != nil {
+			writeDAVCondition(w, http.StatusForbidden, nsCard, "valid-address-data")
+			return nil
+		}
+		status := http.StatusCreated
+		if current != nil {
+			status = http.StatusNoContent
+			user.Version = current.user.Version
+			_, err = c.UpdateUser(user)
+		} else {
+			err = c.UploadUser(user)
+		}
+		if err == models.ErrAlreadyExists && current == nil {
+			if _, serr := c.SelectUser(id); serr == nil {
+				// somebody else has created the contact meanwhile
+				return ErrPrecondition
+			}
+		}
+		if err == models.ErrNotFound || err == models.ErrVersionMismatch {
+			// somebody else has changed or deleted the contact meanwhile
+			return ErrPrecondition
+		}
+		if err != nil {
+			return err
+		}
+		stored, err := newDAVContact(user, vcard.Version3)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("ETag", stored.etag)
+		w.WriteHeader(status)
+		return nil
+
+	case http.MethodDelete:
+		if current == nil {
+			return models.ErrNotFound
+		}
+		if err = checkDAVPreconditions(r, current); err != nil {
+			return err
+		}
+		if _, err = c.DeleteUser(id); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	return errMethodNotAllowed
+}
+
+// checkDAVPreconditions checks If-Match and If-None-Match headers against current state.
+func checkDAVPreconditions(r *http.Request, current *davContact) error {
+	if match := r.Header.Get("If-Match"); match != "" {
+		if current == nil || (match != "*" && !etagMatches(match, current.etag)) {
+			return ErrPrecondition
+		}
+	}
+	if none := r.Header.Get("If-None-Match"); none != "" && current != nil {
+		if none == "*" || etagMatches(none, current.etag) {
+			return ErrPrecondition
+		}
+	}
+	return nil
+}
+
+// etagMatches checks if list of etags from header contains etag. Weak etags are compared as strong.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePropfind returns requested properties. Empty body means allprop.
+func parsePropfind(body io.Reader) ([]davName, bool, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(body, MAXFILESIZE))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, true, nil
+	}
+	var pf davPropfind
+	if err = xml.Unmarshal(data, &pf); err != nil {
+		return nil, false, &davBadRequest{msg: "can't parse propfind: " + err.Error()}
+	}
+	if pf.Prop == nil {
+		return nil, true, nil
+	}
+	return pf.Prop.Names, false, nil
+}
+
+// addressDataVersion returns vCard version requested in address-data property.
+func addressDataVersion(names []davName) string {
+	for _, n := range names {
+		if n.XMLName != (xml.Name{Space: nsCard, Local: "address-data"}) {
+			continue
+		}
+		for _, attr := range n.Attrs {
+			if attr.Name.Local == "version" && attr.Value == vcard.Version4 {
+				return vcard.Version4
+			}
+		}
+	}
+	return vcard.Version3
+}
+
+func davHref(href string) string {
+	return "<d:href>" + escapeXML(href) + "</d:href>"
+}
+
+func principalProps() []davProp {
+	return []davProp{
+		{Name: xml.Name{Space: nsDAV, Local: "resourcetype"}, Inner: "<d:collection/><d:principal/>"},
+		{Name: xml.Name{Space: nsDAV, Local: "displayname"}, Inner: "Address Book"},
+		{Name: xml.Name{Space: nsDAV, Local: "current-user-principal"}, Inner: davHref(davPrincipal)},
+		{Name: xml.Name{Space: nsDAV, Local: "principal-URL"}, Inner: davHref(davPrincipal)},
+		{Name: xml.Name{Space: nsCard, Local: "addressbook-home-set"}, Inner: davHref(davPrincipal)},
+	}
+}
+
+func bookProps(token string) []davProp {
+	props := []davProp{
+		{Name: xml.Name{Space: nsDAV, Local: "resourcetype"}, Inner: "<d:collection/><card:addressbook/>"},
+		{Name: xml.Name{Space: nsDAV, Local: "displayname"}, Inner: "Address Book"},
+		{Name: xml.Name{Space: nsDAV, Local: "current-user-principal"}, Inner: davHref(davPrincipal)},
+		{Name: xml.Name{Space: nsDAV, Local: "owner"}, Inner: davHref(davPrincipal)},
+		{Name: xml.Name{Space: nsDAV, Local: "supported-report-set"}, Inner: "" +
+			"<d:supported-report><d:report><card:addressbook-query/></d:report></d:supported-report>" +
+			"<d:supported-report><d:report><card:addressbook-multiget/></d:report></d:supported-report>" +
+			"<d:supported-report><d:report><d:sync-collection/></d:report></d:supported-report>"},
+		{Name: xml.Name{Space: nsDAV, Local: "current-user-privilege-set"}, Inner: "" +
+			"<d:privilege><d:read/></d:privilege><d:privilege><d:write/></d:privilege>" +
+			"<d:privilege><d:write-content/></d:privilege><d:privilege><d:bind/></d:privilege>" +
+			"<d:privilege><d:unbind/></d:privilege>"},
+		{Name: xml.Name{Space: nsCard, Local: "supported-address-data"}, Inner: "" +
+			`<card:address-data-type content-type="text/vcard" version="3.0"/>` +
+			`<card:address-data-type content-type="text/vcard" version="4.0"/>`},
+		{Name: xml.Name{Space: nsCard, Local: "max-resource-size"}, Inner: strconv.Itoa(MAXFILESIZE)},
+	}
+	if token != "" {
+		props = append(props,
+			davProp{Name: xml.Name{Space: nsDAV, Local: "sync-token"}, Inner: escapeXML(token)},
+			davProp{Name: xml.Name{Space: nsCS, Local: "getctag"}, Inner: escapeXML(token)},
+		)
+	}
+	return props
+}
+
+// contactProps returns properties of contact. Address data is returned only if requested.
+func contactProps(c *davContact, names []davName) []davProp {
+	props := []davProp{
+		{Name: xml.Name{Space: nsDAV, Local: "resourcetype"}},
+		{Name: xml.Name{Space: nsDAV, Local: "getetag"}, Inner: escapeXML(c.etag)},
+		{Name: xml.Name{Space: nsDAV, Local: "getcontenttype"}, Inner: davContentType},
+		{Name: xml.Name{Space: nsDAV, Local: "getcontentlength"}, Inner: strconv.Itoa(len(c.card))},
+	}
+	for _, n := range names {
+		if n.XMLName == (xml.Name{Space: nsCard, Local: "address-data"}) {
+			props = append(props, davProp{Name: n.XMLName, Inner: escapeXML(string(c.card))})
+		}
+	}
+	return props
+}
+
+// propResponse selects requested properties. All means every known property.
+func propResponse(href string, props []davProp, names []davName, all bool) davResponse {
+	resp := davResponse{Href: href}
+	if all {
+		resp.Found = props
+		return resp
+	}
+	for _, n := range names {
+		found := false
+		for _, p := range props {
+			if p.Name == n.XMLName {
+				resp.Found = append(resp.Found, p)
+				found = true
+				break
+			}
+		}
+		if !found {
+			resp.Missing = append(resp.Missing, n.XMLName)
+		}
+	}
+	return resp
+}
+
+func writeMultistatus(w http.ResponseWriter, responses []davResponse, syncToken string) {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<d:multistatus xmlns:d="DAV:" xmlns:card="` + nsCard + `" xmlns:cs="` + nsCS + `">`)
+	for _, resp := range responses {
+		b.WriteString("<d:response>")
+		b.WriteString(davHref(resp.Href))
+		if resp.Status != 0 {
+			b.WriteString("<d:status>" + statusLine(resp.Status) + "</d:status>")
+		}
+		if len(resp.Found) > 0 {
+			b.WriteString("<d:propstat><d:prop>")
+			for _, p := range resp.Found {
+				writeElement(&b, p.Name, p.Inner)
+			}
+			b.WriteString("</d:prop><d:status>" + statusLine(http.StatusOK) + "</d:status></d:propstat>")
+		}
+		if len(resp.Missing) > 0 {
+			b.WriteString("<d:propstat><d:prop>")
+			for _, name := range resp.Missing {
+				writeElement(&b, name, "")
+			}
+			b.WriteString("</d:prop><d:status>" + statusLine(http.StatusNotFound) + "</d:status></d:propstat>")
+		}
+		b.WriteString("</d:response>")
+	}
+	if syncToken != "" {
+		b.WriteString("<d:sync-token>" + escapeXML(syncToken) + "</d:sync-token>")
+	}
+	b.WriteString("</d:multistatus>")
+
+	w.Header().Set("Content-type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	w.Write(b.Bytes())
+}
+
+// writeDAVCondition writes error element with failed precondition (RFC 4918, section 16).
+func writeDAVCondition(w http.ResponseWriter, code int, space, condition string) {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<d:error xmlns:d="DAV:" xmlns:card="` + nsCard + `">`)
+	writeElement(&b, xml.Name{Space: space, Local: condition}, "")
+	b.WriteString("</d:error>")
+	w.Header().Set("Content-type", "application/xml; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(b.Bytes())
+}
+
+// writeElement writes element with raw inner XML. Elements of unknown
+// namespaces declare their namespace.
+func writeElement(b *bytes.Buffer, name xml.Name, inner string) {
+	tag, decl := name.Local, ""
+	if prefix, ok := davPrefixes[name.Space]; ok {
+		tag = prefix + ":" + name.Local
+	} else if name.Space != "" {
+		tag = "x:" + name.Local
+		decl = ` xmlns:x="` + escapeXML(name.Space) + `"`
+	}
+	if inner == "" {
+		b.WriteString("<" + tag + decl + "/>")
+		return
+	}
+	b.WriteString("<" + tag + decl + ">" + inner + "</" + tag + ">")
+}
+
+func statusLine(code int) string {
+	return fmt.Sprintf("HTTP/1.1 %d %s", code, http.StatusText(code))
+}
+
+func escapeXML(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// match checks if card satisfies filter of addressbook-query (RFC 6352, section 10.5).
+func (f *davCardFilter) match(data []byte) bool {
+	if f == nil || len(f.PropFilters) == 0 {
+		return true
+	}
+	card, err := vcard.NewReader(bytes.NewReader(data)).Read()
+	if err != nil {
+		return false
+	}
+	allof := f.Test == "allof"
+	for i := range f.PropFilters {
+		ok := f.PropFilters[i].match(card)
+		if allof && !ok {
+			return false
+		}
+		if !allof && ok {
+			return true
+		}
+	}
+	return allof
+}
+
+func (f *davPropFilter) match(card vcard.Card) bool {
+	props := card[strings.ToUpper(f.Name)]
+	if f.IsNotDefined != nil {
+		return len(props) == 0
+	}
+	if len(props) == 0 {
+		return false
+	}
+	if len(f.TextMatches) == 0 {
+		return true
+	}
+	allof := f.Test == "allof"
+	for _, tm := range f.TextMatches {
+		ok := false
+		for _, p := range props {
+			if tm.match(p.Text()) {
+				ok = true
+				break
+			}
+		}
+		if allof && !ok {
+			return false
+		}
+		if !allof && ok {
+			return true
+		}
+	}
+	return allof
+}
+
+// match compares value using i;unicode-casemap collation.
+func (tm *davTextMatch) match(value string) bool {
+	value, needle := strings.ToLower(value), strings.ToLower(strings.TrimSpace(tm.Value))
+	var ok bool
+	switch tm.MatchType {
+	case "equals":
+		ok = value == needle
+	case "starts-with":
+		ok = strings.HasPrefix(value, needle)
+	case "ends-with":
+		ok = strings.HasSuffix(value, needle)
+	default:
+		ok = strings.Contains(value, needle)
+	}
+	if tm.Negate == "yes" {
+		return !ok
+	}
+	return ok
+}
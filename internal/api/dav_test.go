@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// testMultistatus is multistatus of sync-collection report.
+type testMultistatus struct {
+	SyncToken string `xml:"DAV: sync-token"`
+	Responses []struct {
+		Href   string `xml:"DAV: href"`
+		Status string `xml:"DAV: status"`
+		ETag   string `xml:"DAV: propstat>prop>getetag"`
+	} `xml:"DAV: response"`
+}
+
+// statuses returns status of every href, deleted contacts have 404.
+func (m *testMultistatus) statuses() map[string]string {
+	res := make(map[string]string)
+	for _, r := range m.Responses {
+		status := "200"
+		if strings.Contains(r.Status, "404") {
+			status = "404"
+		}
+		res[r.Href] = status
+	}
+	return res
+}
+
+// syncCollection sends sync-collection report with token and checks status.
+func (c *testClient) syncCollection(status int, token string) *testMultistatus {
+	c.t.Helper()
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<d:sync-collection xmlns:d="DAV:">
+  <d:sync-token>` + token + `</d:sync-token>
+  <d:sync-level>1</d:sync-level>
+  <d:prop><d:getetag/></d:prop>
+</d:sync-collection>`
+	_, data := c.expect(status, nil, "REPORT", davBook, body, "Content-Type", "application/xml", "Depth", "1")
+	var m testMultistatus
+	if status == http.StatusMultiStatus {
+		if err := xml.Unmarshal(data, &m); err != nil {
+			c.t.Fatalf("can't decode multistatus %s: %v", data, err)
+		}
+	}
+	return &m
+}
+
+func TestDAVSyncToken(t *testing.T) {
+	c := newTestClient(t)
+	defer c.close()
+	ann := c.createUser("Ann", "Lee")
+	bob := c.createUser("Bob", "Lee")
+
+	full := c.syncCollection(http.StatusMultiStatus, "")
+	if !strings.HasPrefix(full.SyncToken, syncTokenPrefix) {
+		t.Fatalf("sync token is %q", full.SyncToken)
+	}
+	annHref, bobHref := davBook+ann.ID.String()+".vcf", davBook+bob.ID.String()+".vcf"
+	if got := full.statuses(); len(got) != 2 || got[annHref] != "200" || got[bobHref] != "200" {
+		t.Fatalf("full sync lists %v, want both users", got)
+	}
+
+	empty := c.syncCollection(http.StatusMultiStatus, full.SyncToken)
+	if len(empty.Responses) != 0 || empty.SyncToken != full.SyncToken {
+		t.Errorf("sync without changes is %+v", empty)
+	}
+
+	c.expect(http.StatusOK, nil, "DELETE", "/api/v1/book/user/"+ann.ID.String(), "")
+	cy := c.createUser("Cy", "Lee")
+	cyHref := davBook + cy.ID.String() + ".vcf"
+	delta := c.syncCollection(http.StatusMultiStatus, full.SyncToken)
+	if got := delta.statuses(); len(got) != 2 || got[annHref] != "404" || got[cyHref] != "200" {
+		t.Errorf("sync after changes lists %v, want Ann deleted and Cy changed", got)
+	}
+	if delta.SyncToken == full.SyncToken {
+		t.Error("sync token has not changed")
+	}
+
+	_, data := c.expect(http.StatusForbidden, nil, "REPORT", davBook, `<?xml version="1.0"?>
+<d:sync-collection xmlns:d="DAV:"><d:sync-token>`+syncTokenPrefix+`other-1</d:sync-token><d:prop><d:getetag/></d:prop></d:sync-collection>`,
+		"Content-Type", "application/xml")
+	if !strings.Contains(string(data), "valid-sync-token") {
+		t.Errorf("unknown token is reported as %s", data)
+	}
+}
+
+func TestDAVContactNames(t *testing.T) {
+	c := newTestClient(t)
+	defer c.close()
+	card := "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Ann Lee\r\nN:Lee;Ann;;;\r\nEMAIL:ann@example.com\r\nEND:VCARD\r\n"
+	c.expect(http.StatusForbidden, nil, "PUT", davBook+"ann.vcf", card, "Content-Type", davContentType)
+
+	ann := c.createUser("Bob", "Lee")
+	c.expect(http.StatusCreated, nil, "PUT", davBook+"5b4f1b7b8d6e4a2a1c3e9f10.vcf", card,
+		"Content-Type", davContentType, "If-None-Match", "*")
+	c.expect(http.StatusPreconditionFailed, nil, "PUT", davBook+ann.ID.String()+".vcf", card,
+		"Content-Type", davContentType, "If-None-Match", "*")
+	c.expect(http.StatusConflict, nil, "PUT", davBook+"5b4f1b7b8d6e4a2a1c3e9f11.vcf", card,
+		"Content-Type", davContentType, "If-None-Match", "*")
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ferux/addressbook/internal/controllers"
+	"github.com/ferux/addressbook/internal/models"
+)
+
+// userETag returns entity tag of the version of u.
+func userETag(u *models.User) string {
+	return `"` + strconv.FormatInt(u.Version, 10) + `"`
+}
+
+// matchVersion checks If-Match header of r against u. Absent header matches any version.
+func matchVersion(r *http.Request, u *models.User) bool {
+	match := strings.TrimSpace(r.Header.Get("If-Match"))
+	return match == "" || match == "*" || etagMatches(match, userETag(u))
+}
+
+// notModified checks if If-None-Match header of r lists the version of u.
+func notModified(r *http.Request, u *models.User) bool {
+	none := strings.TrimSpace(r.Header.Get("If-None-Match"))
+	return none == "*" || (none != "" && etagMatches(none, userETag(u)))
+}
+
+// expectedVersion returns the version If-Match header of r expects, 0 means
+// that any version may be replaced. Storage compares it with the version it
+// has at the moment of writing, so changes made meanwhile are not lost.
+// Missing user matches neither an entity tag nor "*".
+func expectedVersion(r *http.Request, c controllers.UserStore, id models.ID) (int64, error) {
+	match := strings.TrimSpace(r.Header.Get("If-Match"))
+	if match == "" {
+		return 0, nil
+	}
+	current, err := c.SelectUser(id)
+	if err != nil {
+		return 0, ifMatchMissing(r, err)
+	}
+	if match == "*" {
+		return 0, nil
+	}
+	if !etagMatches(match, userETag(current)) {
+		return 0, models.ErrVersionMismatch
+	}
+	return current.Version, nil
+}
+
+// ifMatchMissing turns models.ErrNotFound into models.ErrVersionMismatch
+// if r has If-Match header, precondition of a missing user fails.
+func ifMatchMissing(r *http.Request, err error) error {
+	if err == models.ErrNotFound && strings.TrimSpace(r.Header.Get("If-Match")) != "" {
+		return models.ErrVersionMismatch
+	}
+	return err
+}
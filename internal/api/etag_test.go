@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestETag(t *testing.T) {
+	c := newTestClient(t)
+	defer c.close()
+	u := c.createUser("Ann", "Lee")
+	path := "/api/v1/book/user/" + u.ID.String()
+
+	resp, _ := c.expect(http.StatusOK, nil, "GET", path, "")
+	if etag := resp.Header.Get("ETag"); etag != `"1"` {
+		t.Fatalf("ETag is %s, want \"1\"", etag)
+	}
+	resp, body := c.expect(http.StatusNotModified, nil, "GET", path, "", "If-None-Match", `"1"`)
+	if len(body) != 0 {
+		t.Errorf("304 has body %s", body)
+	}
+	c.expect(http.StatusOK, nil, "GET", path, "", "If-None-Match", `"7"`)
+
+	resp, _ = c.expect(http.StatusOK, nil, "PUT", path, userJSON("Anna", "Lee"), "If-Match", `"5", "1"`)
+	if etag := resp.Header.Get("ETag"); etag != `"2"` {
+		t.Errorf("ETag after update is %s, want \"2\"", etag)
+	}
+	c.expect(http.StatusPreconditionFailed, nil, "PUT", path, userJSON("Bob", "Lee"), "If-Match", `"1"`)
+	c.expect(http.StatusPreconditionFailed, nil, "PATCH", path, `{"first_name":"Bob"}`,
+		"Content-Type", "application/merge-patch+json", "If-Match", `"1"`)
+	if got := c.selectUser(u.ID); got.FirstName != "Anna" || got.Version != 2 {
+		t.Errorf("user is %+v after failed writes, want Anna of version 2", got)
+	}
+
+	resp, _ = c.expect(http.StatusOK, nil, "PATCH", path, `{"first_name":"Bob"}`,
+		"Content-Type", "application/merge-patch+json", "If-Match", `"2"`)
+	if etag := resp.Header.Get("ETag"); etag != `"3"` {
+		t.Errorf("ETag after patch is %s, want \"3\"", etag)
+	}
+
+	c.expect(http.StatusOK, nil, "PUT", path, userJSON("Cy", "Lee"), "If-Match", "*")
+
+	// no version of a missing user matches
+	missing := "/api/v1/book/user/5c2f8a1b9d3e4f0a1b2c3d4e"
+	for _, match := range []string{"*", `"1"`} {
+		c.expect(http.StatusPreconditionFailed, nil, "PUT", missing, userJSON("Dan", "Lee"), "If-Match", match)
+		c.expect(http.StatusPreconditionFailed, nil, "PATCH", missing, `{"first_name":"Dan"}`,
+			"Content-Type", "application/merge-patch+json", "If-Match", match)
+	}
+	c.expect(http.StatusNotFound, nil, "PATCH", missing, `{"first_name":"Dan"}`, "Content-Type", "application/merge-patch+json")
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ferux/addressbook/internal/controllers"
+	"github.com/ferux/addressbook/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// eventsPath is the path of the stream of changes. Streams are not limited
+// by request timeout nor by write timeout of the server, pings keep them open.
+const eventsPath = "/api/v1/book/events"
+
+const (
+	// eventsPing is how often a comment is sent to keep idle streams open.
+	eventsPing = time.Second * 15
+	// eventsRetry is the delay before the client reconnects, in milliseconds.
+	eventsRetry = 1000
+)
+
+// eventsHandler streams changes of users of the book as Server-Sent
+// Events. Id of event is the position in the journal, a client which
+// reconnects with Last-Event-ID gets the latest state of every user changed
+// since then. It gets reset event if those changes are forgotten, then it
+// should read the whole book again.
+func (a *API) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "eventsHandler",
+	})
+	logger.Info()
+	c := a.controller(r)
+	journal := c.Journal()
+	flusher, ok := w.(http.Flusher)
+	if journal == nil || !ok {
+		a.handleError(wrapError("events are not supported", r, http.StatusNotImplemented, nil), w)
+		return
+	}
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		logger.WithError(err).Warn("can't clear write deadline, the stream ends by write timeout")
+	}
+	events, stop := journal.Subscribe(c.Owner())
+	defer stop()
+	// seq is taken after subscribing, so events up to it are sent by replay and the rest by subscription
+	seq := journal.Seq()
+
+	w.Header().Set("content-type", "text/event-stream")
+	w.Header().Set("cache-control", "no-cache")
+	w.Header().Set("x-accel-buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	writeRetry(w)
+	if last := r.Header.Get("Last-Event-ID"); last != "" {
+		if err := a.replayEvents(w, c, journal, last, seq); err != nil {
+			logger.WithError(err).Error("can't replay events")
+			return
+		}
+	}
+	flusher.Flush()
+
+	ping := time.NewTicker(eventsPing)
+	defer ping.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				// the client fell behind, it catches up on reconnect
+				logger.Warn("events dropped")
+				return
+			}
+			if e.Seq <= seq {
+				continue
+			}
+			if err := writeEvent(w, journal, &e); err != nil {
+				logger.WithError(err).Error("can't write event")
+				return
+			}
+		case <-ping.C:
+			w.Write([]byte(": ping\n\n"))
+		case <-a.stopping:
+			return
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// replayEvents writes the latest state of users changed after the event
+// with id last up to seq. Users are read as they are now, with the type of
+// their latest change.
+func (a *API) replayEvents(w http.ResponseWriter, c *controllers.Controller, journal *controllers.Journal, last string, seq uint64) error {
+	since, err := parseSyncToken(journal, last)
+	var changes []controllers.Change
+	if err == nil {
+		var ok bool
+		if changes, ok = journal.Since(c.Owner(), since); !ok {
+			err = ErrSyncToken
+		}
+	}
+	if err != nil {
+		// the journal is another one or has forgotten changes
+		return writeEvent(w, journal, &controllers.Event{Change: controllers.Change{Seq: seq, Type: controllers.EventReset}})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Seq < changes[j].Seq })
+	store := c.User()
+	for _, change := range changes {
+		if change.Seq > seq {
+			continue
+		}
+		e := controllers.Event{Change: change, User: &models.User{ID: change.ID}}
+		if change.Type != models.EventDeleted {
+			user, err := store.SelectUser(change.ID)
+			switch err {
+			case nil:
+				e.User = user
+			case models.ErrNotFound:
+				// the user has been deleted after seq, the deletion is sent live
+				e.Type = models.EventDeleted
+			default:
+				return err
+			}
+		}
+		if err = writeEvent(w, journal, &e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeEvent writes e in the format of Server-Sent Events. Reset has no user.
+func writeEvent(w http.ResponseWriter, journal *controllers.Journal, e *controllers.Event) error {
+	data := []byte("{}")
+	if e.User != nil {
+		var err error
+		if data, err = json.Marshal(e.User); err != nil {
+			return err
+		}
+	}
+	id := journal.Epoch() + "-" + strconv.FormatUint(e.Seq, 10)
+	_, err := w.Write([]byte("id: " + id + "\nevent: " + e.Type + "\ndata: " + string(data) + "\n\n"))
+	return err
+}
+
+func writeRetry(w http.ResponseWriter) {
+	w.Write([]byte("retry: " + strconv.Itoa(eventsRetry) + "\n\n"))
+}
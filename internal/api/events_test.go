@@ -0,0 +1,157 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ferux/addressbook/internal/controllers"
+	"github.com/ferux/addressbook/internal/models"
+)
+
+// testEvent is an event read from the stream.
+type testEvent struct {
+	id   string
+	typ  string
+	user models.User
+}
+
+// seq returns position of the event in the journal.
+func (e *testEvent) seq() uint64 {
+	seq, _ := strconv.ParseUint(e.id[strings.LastIndexByte(e.id, '-')+1:], 10, 64)
+	return seq
+}
+
+// testStream reads Server-Sent Events of the book.
+type testStream struct {
+	t      *testing.T
+	resp   *http.Response
+	r      *bufio.Reader
+	cancel func()
+}
+
+// openEvents opens the stream of events, last is sent as Last-Event-ID unless it is empty.
+func (c *testClient) openEvents(last string) *testStream {
+	c.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	req, err := http.NewRequest("GET", c.server.URL+eventsPath, nil)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if last != "" {
+		req.Header.Set("Last-Event-ID", last)
+	}
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("content-type") != "text/event-stream" {
+		c.t.Fatalf("stream is %s of %s", resp.Status, resp.Header.Get("content-type"))
+	}
+	return &testStream{t: c.t, resp: resp, r: bufio.NewReader(resp.Body), cancel: cancel}
+}
+
+func (s *testStream) close() {
+	s.cancel()
+	s.resp.Body.Close()
+}
+
+// next reads the next event, comments and retry are skipped.
+func (s *testStream) next() testEvent {
+	s.t.Helper()
+	var e testEvent
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			s.t.Fatalf("can't read event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && e.typ != "":
+			return e
+		case strings.HasPrefix(line, "id: "):
+			e.id = line[len("id: "):]
+		case strings.HasPrefix(line, "event: "):
+			e.typ = line[len("event: "):]
+		case strings.HasPrefix(line, "data: "):
+			if err = json.Unmarshal([]byte(line[len("data: "):]), &e.user); err != nil {
+				s.t.Fatalf("can't decode data of event: %v", err)
+			}
+		}
+	}
+}
+
+// expect reads the next event and checks its type and user.
+func (s *testStream) expect(typ string, id models.ID, firstName string) testEvent {
+	s.t.Helper()
+	e := s.next()
+	if e.typ != typ || e.user.ID != id || e.user.FirstName != firstName {
+		s.t.Fatalf("event is %s of %+v, want %s of %s %s", e.typ, e.user, typ, id, firstName)
+	}
+	return e
+}
+
+func TestEventsResume(t *testing.T) {
+	c := newTestClient(t)
+	defer c.close()
+	stream := c.openEvents("")
+	ann := c.createUser("Ann", "Lee")
+	last := stream.expect(models.EventCreated, ann.ID, "Ann")
+	stream.close()
+
+	// changes made while the client is away
+	c.expect(http.StatusOK, nil, "PUT", "/api/v1/book/user/"+ann.ID.String(), userJSON("Anna", "Lee"))
+	bob := c.createUser("Bob", "Lee")
+	c.expect(http.StatusOK, nil, "PUT", "/api/v1/book/user/"+bob.ID.String(), userJSON("Bobby", "Lee"))
+	cy := c.createUser("Cy", "Lee")
+	c.expect(http.StatusOK, nil, "DELETE", "/api/v1/book/user/"+cy.ID.String(), "")
+	c.expect(http.StatusOK, nil, "POST", "/api/v1/book/trash/"+cy.ID.String()+"/restore", "")
+	dan := c.createUser("Dan", "Lee")
+	c.expect(http.StatusOK, nil, "DELETE", "/api/v1/book/user/"+dan.ID.String(), "")
+
+	stream = c.openEvents(last.id)
+	defer stream.close()
+	stream.expect(models.EventUpdated, ann.ID, "Anna")
+	stream.expect(models.EventCreated, bob.ID, "Bobby")
+	stream.expect(models.EventCreated, cy.ID, "Cy")
+	replayed := stream.expect(models.EventDeleted, dan.ID, "")
+
+	eve := c.createUser("Eve", "Lee")
+	live := stream.expect(models.EventCreated, eve.ID, "Eve")
+	if live.seq() <= replayed.seq() {
+		t.Errorf("live event %s goes before replayed %s", live.id, replayed.id)
+	}
+
+	reset := c.openEvents("other-1")
+	defer reset.close()
+	if e := reset.next(); e.typ != controllers.EventReset {
+		t.Errorf("stream with unknown id starts with %s, want reset", e.typ)
+	}
+}
+
+func TestEventsOfUpsertFromTrash(t *testing.T) {
+	c := newTestClient(t)
+	defer c.close()
+	ann := c.createUser("Ann", "Lee")
+	c.expect(http.StatusOK, nil, "DELETE", "/api/v1/book/user/"+ann.ID.String(), "")
+	stream := c.openEvents("")
+	defer stream.close()
+	c.expect(http.StatusOK, nil, "POST", "/api/v1/book/user:batch", `{"operations": [
+		{"op": "upsert", "user": {"id": "`+ann.ID.String()+`", "first_name": "Anna", "last_name": "Lee"}},
+		{"op": "upsert", "user": {"first_name": "Bob", "last_name": "Lee"}}
+	]}`)
+	stream.expect(models.EventCreated, ann.ID, "Anna")
+	bob := stream.next()
+	if bob.typ != models.EventCreated || bob.user.FirstName != "Bob" {
+		t.Errorf("event of upserted new user is %s of %+v", bob.typ, bob.user)
+	}
+	c.expect(http.StatusOK, nil, "POST", "/api/v1/book/user:batch", `{"operations": [
+		{"op": "upsert", "user": {"id": "`+ann.ID.String()+`", "first_name": "Ann", "last_name": "Lee"}}
+	]}`)
+	stream.expect(models.EventUpdated, ann.ID, "Ann")
+}
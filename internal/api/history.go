@@ -0,0 +1,157 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ferux/addressbook/internal/models"
+	"github.com/ferux/addressbook/internal/validation"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrAtInvalid reports in case at parameter is not a timestamp
+	ErrAtInvalid = errors.New("at should be a timestamp like 2006-01-02T15:04:05Z")
+	// ErrVersionInvalid reports in case version of revision is not a positive number
+	ErrVersionInvalid = errors.New("version should be a positive number")
+	// ErrRevisionDeleted reports in case revision deletes the user, so there is nothing to revert to
+	ErrRevisionDeleted = errors.New("revision deletes the user, restore it from trash instead")
+)
+
+// actor returns who makes the request for revisions: the client if it is
+// authenticated or anonymous browser session otherwise.
+func (a *API) actor(r *http.Request) string {
+	if p := GetPrincipal(r.Context()); p != nil {
+		return models.KeyOwner(p.ID)
+	}
+	return "anonymous"
+}
+
+// historyHandler lists revisions of the user, the latest go first. Users
+// are omitted from the list, they are read by ?at= of the user.
+func (a *API) historyHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "historyHandler",
+	})
+	logger.Info()
+	id, ok := models.ParseID(mux.Vars(r)["id"])
+	if !ok {
+		logger.WithError(ErrIDInvalid).Error("invalid OID")
+		a.handleError(wrapError(ErrIDInvalid.Error(), r, http.StatusBadRequest, ErrIDInvalid), w)
+		return
+	}
+	c := a.controller(r)
+	revisions, err := c.Revision().ListRevisions(id)
+	if err == nil && len(revisions) == 0 {
+		// users written before history was kept have no revisions
+		_, err = c.User().SelectUser(id)
+	}
+	if err != nil {
+		logger.WithError(err).Error("can't get history")
+		a.handleError(wrapError("can't get history", r, http.StatusInternalServerError, err), w)
+		return
+	}
+	for i := range revisions {
+		revisions[i].User = nil
+	}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(revisions)
+}
+
+// selectUserAt writes the user as it was at the time of at parameter. The
+// user is not found if it has not been created or has been deleted by then.
+func (a *API) selectUserAt(w http.ResponseWriter, r *http.Request, id models.ID, at string) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "selectUserAt",
+	})
+	t, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		logger.WithError(err).Error("invalid time")
+		a.handleError(wrapError(ErrAtInvalid.Error(), r, http.StatusBadRequest, ErrAtInvalid), w)
+		return
+	}
+	rev, err := a.controller(r).Revision().RevisionAt(id, t)
+	if err == nil && rev.User == nil {
+		err = models.ErrNotFound
+	}
+	if err != nil {
+		logger.WithError(err).Error("can't get user")
+		a.handleError(wrapError("can't get user", r, http.StatusInternalServerError, err), w)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(rev.User)
+}
+
+// revertHandler writes the user as it has been after the revision with the
+// version. It makes a new revision, the history is kept as it is.
+func (a *API) revertHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "revertHandler",
+	})
+	logger.Info()
+	vars := mux.Vars(r)
+	id, ok := models.ParseID(vars["id"])
+	if !ok {
+		logger.WithError(ErrIDInvalid).Error("invalid OID")
+		a.handleError(wrapError(ErrIDInvalid.Error(), r, http.StatusBadRequest, ErrIDInvalid), w)
+		return
+	}
+	version, err := strconv.ParseInt(vars["version"], 10, 64)
+	if err != nil || version <= 0 {
+		logger.WithError(ErrVersionInvalid).Error("invalid version")
+		a.handleError(wrapError(ErrVersionInvalid.Error(), r, http.StatusBadRequest, ErrVersionInvalid), w)
+		return
+	}
+	c := a.controller(r)
+	rev, err := c.Revision().SelectRevision(id, version)
+	if err == nil && rev.User == nil {
+		err = ErrRevisionDeleted
+	}
+	if err != nil {
+		logger.WithError(err).Error("can't get revision")
+		msg := "can't get revision"
+		if err == ErrRevisionDeleted {
+			msg = err.Error()
+		}
+		a.handleError(wrapError(msg, r, http.StatusInternalServerError, err), w)
+		return
+	}
+	user, err := c.User().PatchUser(id, func(u *models.User) error {
+		if !matchVersion(r, u) {
+			return models.ErrVersionMismatch
+		}
+		reverted := *rev.User
+		// rules of validation may have changed since the revision
+		if errs := a.validator.Prepare(&reverted); errs != nil {
+			return errs
+		}
+		reverted.ID, reverted.Owner = id, u.Owner
+		*u = reverted
+		return nil
+	})
+	err = ifMatchMissing(r, err)
+	if errs, ok := err.(validation.Errors); ok {
+		logger.WithError(errs).Error("invalid user")
+		a.handleError(validationError(r, errs), w)
+		return
+	}
+	if err != nil {
+		logger.WithError(err).Error("can't revert user")
+		a.handleError(wrapError("can't revert user", r, http.StatusInternalServerError, err), w)
+		return
+	}
+	w.Header().Set("ETag", userETag(user))
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(user)
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ferux/addressbook/internal/models"
+)
+
+// history returns revisions of the user, the latest first.
+func (c *testClient) history(id models.ID) []models.Revision {
+	c.t.Helper()
+	var revisions []models.Revision
+	c.expect(http.StatusOK, &revisions, "GET", "/api/v1/book/user/"+id.String()+"/history", "")
+	return revisions
+}
+
+func TestHistoryAndRevert(t *testing.T) {
+	c := newTestClient(t)
+	defer c.close()
+	ann := c.createUser("Ann", "Lee")
+	path := "/api/v1/book/user/" + ann.ID.String()
+	// created is taken between the writes, so the user at that time is the created one
+	time.Sleep(time.Millisecond * 10)
+	created := time.Now().UTC()
+	c.expect(http.StatusOK, nil, "PATCH", path, `{"first_name": "Anna", "job_title": "CTO"}`,
+		"Content-Type", "application/merge-patch+json")
+
+	revisions := c.history(ann.ID)
+	if len(revisions) != 2 {
+		t.Fatalf("history has %d revisions, want 2", len(revisions))
+	}
+	if r := revisions[1]; r.Action != models.ActionCreate || r.Version != 1 || r.Actor != "anonymous" {
+		t.Errorf("first revision is %+v", r)
+	}
+	r := revisions[0]
+	if r.Action != models.ActionUpdate || r.Version != 2 || len(r.Changes) != 2 {
+		t.Fatalf("second revision is %+v", r)
+	}
+	if ch := r.Changes[0]; ch.Field != "first_name" || ch.From != "Ann" || ch.To != "Anna" {
+		t.Errorf("change of first name is %+v", ch)
+	}
+	if ch := r.Changes[1]; ch.Field != "job_title" || ch.From != nil || ch.To != "CTO" {
+		t.Errorf("change of job title is %+v", ch)
+	}
+
+	var old models.User
+	c.expect(http.StatusOK, &old, "GET", path+"?at="+url.QueryEscape(created.Format(time.RFC3339Nano)), "")
+	if old.FirstName != "Ann" || old.Version != 1 {
+		t.Errorf("user at %s is %+v, want the created one", created, old)
+	}
+
+	c.expect(http.StatusPreconditionFailed, nil, "POST", path+"/history/1/revert", "", "If-Match", `"1"`)
+	c.expect(http.StatusNotFound, nil, "POST", path+"/history/7/revert", "")
+	var reverted models.User
+	c.expect(http.StatusOK, &reverted, "POST", path+"/history/1/revert", "", "If-Match", `"2"`)
+	if reverted.FirstName != "Ann" || reverted.JobTitle != "" || reverted.Version != 3 {
+		t.Errorf("reverted user is %+v, want Ann of version 3", reverted)
+	}
+	if revisions = c.history(ann.ID); len(revisions) != 3 || revisions[0].Version != 3 {
+		t.Errorf("history after revert is %+v", revisions)
+	}
+
+	c.expect(http.StatusOK, nil, "DELETE", path, "")
+	revisions = c.history(ann.ID)
+	if len(revisions) != 4 || revisions[0].Action != models.ActionDelete || revisions[0].Version != 4 {
+		t.Errorf("history after delete is %+v", revisions)
+	}
+}
+
+func TestHistoryOfUpsertFromTrash(t *testing.T) {
+	c := newTestClient(t)
+	defer c.close()
+	ann := c.createUser("Ann", "Lee")
+	c.expect(http.StatusOK, nil, "DELETE", "/api/v1/book/user/"+ann.ID.String(), "")
+	c.expect(http.StatusOK, nil, "POST", "/api/v1/book/user:batch", `{"operations": [
+		{"op": "upsert", "user": {"id": "`+ann.ID.String()+`", "first_name": "Anna", "last_name": "Lee"}}
+	]}`)
+
+	revisions := c.history(ann.ID)
+	if len(revisions) != 3 {
+		t.Fatalf("history has %d revisions, want 3", len(revisions))
+	}
+	r := revisions[0]
+	if r.Action != models.ActionRestore || r.Version != 3 || len(r.Changes) != 1 {
+		t.Errorf("revision of upsert from trash is %+v, want restore of version 3", r)
+	}
+}
@@ -0,0 +1,474 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/ferux/addressbook/internal/auth"
+	"github.com/ferux/addressbook/internal/controllers"
+	"github.com/ferux/addressbook/internal/models"
+	"github.com/ferux/addressbook/internal/validation"
+	"github.com/ferux/addressbook/internal/vcard"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrFileTooLarge reports in case uploaded file exceeds MAXFILESIZE
+	ErrFileTooLarge = errors.New("file is too large")
+	// ErrUnsupportedType reports in case uploaded file has unknown content type
+	ErrUnsupportedType = errors.New("unsupported content type")
+)
+
+// Append modes for import.
+const (
+	appendClear  = "clear"
+	appendAppend = "append"
+	appendUpsert = "upsert"
+)
+
+// Row statuses for import report.
+const (
+	rowInserted = "inserted"
+	rowUpdated  = "updated"
+	rowSkipped  = "skipped"
+	rowRejected = "rejected"
+)
+
+// csvHeader lists columns written by downloadCSVHandler. Lists are written as
+// label:value items separated by ';', parts of addresses are separated by '|'.
+var csvHeader = []string{"id", "first_name", "last_name", "emails", "phones", "addresses",
+	"organization", "job_title", "birthday", "website", "notes"}
+
+// csvLegacyColumns is the amount of columns in files exported by previous
+// versions: id, first_name, last_name, email, phone.
+const csvLegacyColumns = 5
+
+// Separators of list items and address parts in csv.
+const (
+	csvItemSep    = ";"
+	csvLabelSep   = ":"
+	csvAddressSep = "|"
+)
+
+// ImportRow describes result of importing one row.
+type ImportRow struct {
+	Line   int    `json:"line"`
+	ID     string `json:"id,omitempty"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+	// Errors describes problems with fields of rejected row.
+	Errors validation.Errors `json:"errors,omitempty"`
+}
+
+// ImportReport describes result of importing a file.
+type ImportReport struct {
+	Mode     string      `json:"mode"`
+	Inserted int         `json:"inserted"`
+	Updated  int         `json:"updated"`
+	Skipped  int         `json:"skipped"`
+	Rejected int         `json:"rejected"`
+	Rows     []ImportRow `json:"rows"`
+}
+
+func (rep *ImportReport) add(row ImportRow) {
+	switch row.Status {
+	case rowInserted:
+		rep.Inserted++
+	case rowUpdated:
+		rep.Updated++
+	case rowSkipped:
+		rep.Skipped++
+	case rowRejected:
+		rep.Rejected++
+	}
+	rep.Rows = append(rep.Rows, row)
+}
+
+// limitedReader acts like io.LimitReader but reports ErrFileTooLarge
+// instead of io.EOF when the limit is exceeded.
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		if n, err := l.r.Read(make([]byte, 1)); n == 0 && err == io.EOF {
+			return 0, io.EOF
+		}
+		return 0, ErrFileTooLarge
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
+
+// appendMode returns mode of import. Any other value of Append-type acts like upsert.
+func appendMode(r *http.Request) string {
+	switch mode := strings.ToLower(strings.TrimSpace(r.Header.Get("Append-type"))); mode {
+	case appendClear, appendAppend:
+		return mode
+	default:
+		return appendUpsert
+	}
+}
+
+// importSource reads users one by one from uploaded file.
+type importSource interface {
+	// next returns the next user. Problems with one record are returned
+	// as *rowError, reading continues after them.
+	next() (*models.User, error)
+	// line returns position of the last read record.
+	line() int
+}
+
+// rowError describes a record which can't be parsed.
+type rowError struct {
+	id     string
+	reason string
+}
+
+func (e *rowError) Error() string {
+	return e.reason
+}
+
+type csvSource struct {
+	r   *csv.Reader
+	pos int
+}
+
+func newCSVSource(r io.Reader) *csvSource {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	return &csvSource{r: reader}
+}
+
+func (s *csvSource) line() int {
+	return s.pos
+}
+
+func (s *csvSource) next() (*models.User, error) {
+	for {
+		record, err := s.r.Read()
+		if err == io.EOF {
+			return nil, err
+		}
+		s.pos++
+		if err != nil {
+			if perr, ok := err.(*csv.ParseError); ok {
+				return nil, &rowError{reason: perr.Err.Error()}
+			}
+			return nil, err
+		}
+		if s.pos == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "id") {
+			continue
+		}
+		return parseCSVRecord(record)
+	}
+}
+
+// parseCSVRecord converts csv row to user. Empty id means a new user.
+func parseCSVRecord(record []string) (*models.User, error) {
+	if len(record) != len(csvHeader) && len(record) != csvLegacyColumns {
+		return nil, &rowError{reason: "wrong number of columns"}
+	}
+	for i := range record {
+		record[i] = strings.TrimSpace(record[i])
+	}
+	user := &models.User{
+		FirstName: record[1],
+		LastName:  record[2],
+	}
+	if len(record) == csvLegacyColumns {
+		if record[3] != "" {
+			user.Emails = []models.Email{{Label: models.LabelOther, Value: record[3]}}
+		}
+		if record[4] != "" {
+			user.Phones = []models.Phone{{Label: models.LabelOther, Value: record[4]}}
+		}
+	} else {
+		for _, item := range splitCSVList(record[3]) {
+			label, value := splitCSVLabel(item, models.EmailLabels)
+			user.Emails = append(user.Emails, models.Email{Label: label, Value: value})
+		}
+		for _, item := range splitCSVList(record[4]) {
+			label, value := splitCSVLabel(item, models.PhoneLabels)
+			user.Phones = append(user.Phones, models.Phone{Label: label, Value: value})
+		}
+		for _, item := range splitCSVList(record[5]) {
+			label, value := splitCSVLabel(item, models.EmailLabels)
+			parts := make([]string, 5)
+			copy(parts, strings.Split(value, csvAddressSep))
+			user.Addresses = append(user.Addresses, models.Address{
+				Label:      label,
+				Street:     strings.TrimSpace(parts[0]),
+				City:       strings.TrimSpace(parts[1]),
+				Region:     strings.TrimSpace(parts[2]),
+				PostalCode: strings.TrimSpace(parts[3]),
+				Country:    strings.TrimSpace(parts[4]),
+			})
+		}
+		user.Organization = record[6]
+		user.JobTitle = record[7]
+		user.Birthday = record[8]
+		user.Website = record[9]
+		user.Notes = record[10]
+	}
+	if record[0] == "" {
+		user.ID = models.NewID()
+		return user, nil
+	}
+	id, ok := models.ParseID(record[0])
+	if !ok {
+		return nil, &rowError{id: record[0], reason: ErrIDInvalid.Error()}
+	}
+	user.ID = id
+	return user, nil
+}
+
+// csvRecord converts user to csv row in order of csvHeader.
+func csvRecord(u *models.User) []string {
+	emails := make([]string, 0, len(u.Emails))
+	for _, e := range u.Emails {
+		emails = append(emails, joinCSVLabel(e.Label, e.Value))
+	}
+	phones := make([]string, 0, len(u.Phones))
+	for _, p := range u.Phones {
+		phones = append(phones, joinCSVLabel(p.Label, p.Value))
+	}
+	addresses := make([]string, 0, len(u.Addresses))
+	for _, a := range u.Addresses {
+		parts := []string{a.Street, a.City, a.Region, a.PostalCode, a.Country}
+		addresses = append(addresses, joinCSVLabel(a.Label, strings.Join(parts, csvAddressSep)))
+	}
+	return []string{
+		u.ID.String(), u.FirstName, u.LastName,
+		strings.Join(emails, csvItemSep), strings.Join(phones, csvItemSep), strings.Join(addresses, csvItemSep),
+		u.Organization, u.JobTitle, u.Birthday, u.Website, u.Notes,
+	}
+}
+
+// splitCSVList returns non-empty items of csv list.
+func splitCSVList(s string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(s, csvItemSep) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// splitCSVLabel splits label:value item. Items without a known label are values.
+func splitCSVLabel(item string, labels []string) (string, string) {
+	kv := strings.SplitN(item, csvLabelSep, 2)
+	if len(kv) == 2 && models.ValidLabel(strings.ToLower(kv[0]), labels) {
+		return strings.ToLower(kv[0]), strings.TrimSpace(kv[1])
+	}
+	return "", item
+}
+
+func joinCSVLabel(label, value string) string {
+	if label == "" {
+		return value
+	}
+	return label + csvLabelSep + value
+}
+
+type vcardSource struct {
+	r *vcard.Reader
+	n int
+}
+
+func (s *vcardSource) line() int {
+	return s.n
+}
+
+func (s *vcardSource) next() (*models.User, error) {
+	card, err := s.r.Read()
+	if err == io.EOF {
+		return nil, err
+	}
+	s.n++
+	if perr, ok := err.(*vcard.ParseError); ok {
+		row := &rowError{reason: perr.Err.Error()}
+		if perr.UID != "" {
+			row.id = vcard.UIDToID(perr.UID).String()
+		}
+		return nil, row
+	}
+	if err != nil {
+		return nil, err
+	}
+	user := vcard.ToUser(card)
+	if user.ID == "" {
+		user.ID = models.NewID()
+	}
+	return user, nil
+}
+
+func (a *API) importHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "importHandler",
+	})
+	logger.Info()
+	defer r.Body.Close()
+
+	body := &limitedReader{r: r.Body, n: MAXFILESIZE}
+	var src importSource
+	mediatype, _, err := mime.ParseMediaType(r.Header.Get("Content-type"))
+	switch {
+	case err != nil:
+	case mediatype == "text/csv":
+		src = newCSVSource(body)
+	case mediatype == vcard.MediaType || mediatype == vcard.MediaTypeLegacy:
+		src = &vcardSource{r: vcard.NewReader(body)}
+	}
+	if src == nil {
+		logger.WithField("content-type", r.Header.Get("Content-type")).Error("unsupported content type")
+		err = wrapError(ErrUnsupportedType.Error(), r, http.StatusUnsupportedMediaType, ErrUnsupportedType)
+		a.handleError(err, w)
+		return
+	}
+	if r.ContentLength > MAXFILESIZE {
+		logger.WithField("size", r.ContentLength).Error("file is too large")
+		err = wrapError(ErrFileTooLarge.Error(), r, http.StatusRequestEntityTooLarge, ErrFileTooLarge)
+		a.handleError(err, w)
+		return
+	}
+
+	mode := appendMode(r)
+	if mode == appendClear && !a.can(r, auth.PermAdmin) {
+		a.forbidden(w, r, auth.PermAdmin)
+		return
+	}
+
+	c := a.controller(r).User()
+	report := &ImportReport{Mode: mode, Rows: []ImportRow{}}
+	// clear reads the whole file before the book is cleared, so the book is
+	// not cleared by a file which can't be read. Other modes store rows as
+	// soon as they are read.
+	var rows []importRow
+	for {
+		row, err := a.readImportRow(src)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logger.WithError(err).Error("can't read file")
+			code := http.StatusBadRequest
+			if err == ErrFileTooLarge {
+				code = http.StatusRequestEntityTooLarge
+			}
+			err = wrapError("can't read file", r, code, err)
+			a.handleError(err, w)
+			return
+		}
+		if mode == appendClear {
+			rows = append(rows, row)
+			continue
+		}
+		report.add(row.store(c, mode))
+	}
+	if mode == appendClear {
+		if _, err = c.CleanRecords(); err != nil {
+			logger.WithError(err).Error("can't clean records")
+			err = wrapError("can't clean records", r, http.StatusInternalServerError, err)
+			a.handleError(err, w)
+			return
+		}
+		for _, row := range rows {
+			report.add(row.store(c, mode))
+		}
+	}
+
+	logger.WithFields(logrus.Fields{
+		"inserted": report.Inserted,
+		"updated":  report.Updated,
+		"skipped":  report.Skipped,
+		"rejected": report.Rejected,
+	}).Info("file has been imported")
+	w.Header().Add("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
+
+// importRow is a record of uploaded file. It has user unless the record is rejected.
+type importRow struct {
+	ImportRow
+	user *models.User
+}
+
+// readImportRow reads and validates the next record of src.
+func (a *API) readImportRow(src importSource) (importRow, error) {
+	user, err := src.next()
+	if rerr, ok := err.(*rowError); ok {
+		return importRow{ImportRow: ImportRow{Line: src.line(), ID: rerr.id, Status: rowRejected, Reason: rerr.reason}}, nil
+	}
+	if err != nil {
+		return importRow{}, err
+	}
+	row := importRow{ImportRow: ImportRow{Line: src.line(), ID: user.ID.String()}}
+	if errs := a.validator.Prepare(user); errs != nil {
+		row.Status = rowRejected
+		row.Reason = errs.Error()
+		row.Errors = errs
+	} else {
+		row.user = user
+	}
+	return row, nil
+}
+
+// store imports user of the row according to mode and returns result of the row.
+func (row importRow) store(c controllers.UserStore, mode string) ImportRow {
+	if row.user != nil {
+		row.Status, row.Reason = importUser(c, mode, row.user)
+	}
+	return row.ImportRow
+}
+
+// importUser stores valid user according to mode. It returns status of the
+// row and the reason of it. Clear stores users by upsert, so users moved to
+// trash by clearing are restored by rows with their ids.
+func importUser(c controllers.UserStore, mode string, user *models.User) (string, string) {
+	if mode == appendAppend {
+		switch err := c.UploadUser(user); err {
+		case nil:
+			return rowInserted, ""
+		case models.ErrAlreadyExists:
+			return rowSkipped, models.ErrAlreadyExists.Error()
+		default:
+			return rowRejected, err.Error()
+		}
+	}
+
+	existing, err := c.SelectUser(user.ID)
+	switch err {
+	case nil:
+		if len(models.Diff(existing, user)) == 0 {
+			return rowSkipped, "unchanged"
+		}
+	case models.ErrNotFound:
+	default:
+		return rowRejected, err.Error()
+	}
+	before, err := c.UpsertUser(user)
+	if err != nil {
+		return rowRejected, err.Error()
+	}
+	if before == nil || before.DeletedAt != nil {
+		return rowInserted, ""
+	}
+	return rowUpdated, ""
+}
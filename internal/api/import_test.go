@@ -0,0 +1,136 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ferux/addressbook/internal/models"
+	"github.com/ferux/addressbook/internal/vcard"
+)
+
+// importFile posts file to import with Append-type mode, empty mode is not sent.
+func (c *testClient) importFile(mode, contentType, file string) *ImportReport {
+	c.t.Helper()
+	header := []string{"Content-Type", contentType}
+	if mode != "" {
+		header = append(header, "Append-type", mode)
+	}
+	var rep ImportReport
+	c.expect(http.StatusOK, &rep, "POST", "/api/v1/book/import", file, header...)
+	return &rep
+}
+
+// lastNames returns sorted last names of users of the book.
+func (c *testClient) lastNames() string {
+	c.t.Helper()
+	var page models.UserPage
+	c.expect(http.StatusOK, &page, "GET", "/api/v1/book/user?sort=last_name", "")
+	names := make([]string, 0, len(page.Users))
+	for _, u := range page.Users {
+		names = append(names, u.LastName)
+	}
+	return strings.Join(names, " ")
+}
+
+func TestImportModes(t *testing.T) {
+	c := newTestClient(t)
+	defer c.close()
+	ann := c.createUser("Ann", "Adams", "ann@example.com")
+	id := ann.ID.String()
+
+	rep := c.importFile("", "text/csv", strings.Join([]string{
+		"id,first_name,last_name,emails,phones,addresses,organization,job_title,birthday,website,notes",
+		id + ",Anna,Adams,work:ann@example.com,,,,,,,",
+		",Bob,Brown,,,,,,,,",
+		",Cy,Clark,work:ann@example.com,,,,,,,",
+	}, "\n"))
+	if rep.Mode != appendUpsert || rep.Updated != 1 || rep.Inserted != 1 || rep.Rejected != 1 {
+		t.Errorf("upsert report is %+v, want 1 updated, 1 inserted and 1 rejected", rep)
+	}
+	if got := c.selectUser(ann.ID); got.FirstName != "Anna" {
+		t.Errorf("upsert left first name %s", got.FirstName)
+	}
+
+	rep = c.importFile(appendUpsert, "text/csv", id+",Anna,Adams,work:ann@example.com,,,,,,,\n")
+	if rep.Skipped != 1 || rep.Updated != 0 {
+		t.Errorf("upsert of unchanged row is %+v, want skipped", rep)
+	}
+
+	rep = c.importFile(appendAppend, "text/csv", id+",Ann,Adams,,,,,,,,\n,Dan,Doe,,,,,,,,\n")
+	if rep.Skipped != 1 || rep.Inserted != 1 {
+		t.Errorf("append report is %+v, want 1 skipped and 1 inserted", rep)
+	}
+	if got := c.selectUser(ann.ID); got.FirstName != "Anna" {
+		t.Errorf("append changed existing user to %s", got.FirstName)
+	}
+
+	rep = c.importFile("merge", "text/csv", id+",Ann,Adams,work:ann@example.com,,,,,,,\n,Eve,Evans,,,,,,,,\n")
+	if rep.Mode != appendUpsert || rep.Updated != 1 || rep.Inserted != 1 {
+		t.Errorf("report of unknown mode is %+v, want it acting like upsert", rep)
+	}
+	if names := c.lastNames(); names != "Adams Brown Doe Evans" {
+		t.Errorf("book is %q after unknown mode, want Adams Brown Doe Evans", names)
+	}
+
+	// the book is not cleared by a file which can't be read
+	broken := "BEGIN:VCARD\r\nVERSION:3.0\r\nN:Gray;Gus;;;\r\nEND:VCARD\r\nNOTE:" + strings.Repeat("x", 2<<20)
+	c.expect(http.StatusBadRequest, nil, "POST", "/api/v1/book/import", broken,
+		"Content-Type", vcard.MediaType, "Append-type", appendClear)
+	if names := c.lastNames(); names != "Adams Brown Doe Evans" {
+		t.Errorf("book is %q after broken file, want Adams Brown Doe Evans", names)
+	}
+
+	rep = c.importFile(appendClear, "text/csv", ",Eve,Evans,,,,,,,,\n,Fay,Fox,,,,,,,,\n")
+	if rep.Inserted != 2 {
+		t.Errorf("clear report is %+v, want 2 inserted", rep)
+	}
+	if names := c.lastNames(); names != "Evans Fox" {
+		t.Errorf("book is %q after clear, want Evans Fox", names)
+	}
+
+	c.expect(http.StatusUnsupportedMediaType, nil, "POST", "/api/v1/book/import", "{}",
+		"Content-Type", "application/json", "Append-type", appendClear)
+	if names := c.lastNames(); names != "Evans Fox" {
+		t.Errorf("book is %q after rejected clear, want Evans Fox", names)
+	}
+}
+
+func TestVCardRoundTrip(t *testing.T) {
+	c := newTestClient(t)
+	defer c.close()
+	var u models.User
+	c.expect(http.StatusOK, &u, "POST", "/api/v1/book/user", `{
+		"first_name": "Ann", "last_name": "Lee",
+		"emails": [{"label": "work", "value": "ann@example.com"}],
+		"phones": [{"label": "mobile", "value": "+1 555 010 0000"}],
+		"addresses": [{"label": "home", "street": "1 Main St", "city": "Springfield", "country": "USA"}],
+		"organization": "ACME", "job_title": "CTO", "birthday": "--04-12",
+		"website": "https://example.com", "notes": "Met at the fair"
+	}`)
+	path := "/api/v1/book/user/" + u.ID.String()
+
+	resp, card := c.expect(http.StatusOK, nil, "GET", path+".vcf", "")
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, vcard.MediaType) {
+		t.Errorf("content type is %s", ct)
+	}
+	if !strings.Contains(string(card), "UID:"+u.ID.String()) {
+		t.Fatalf("card has no UID of the user:\n%s", card)
+	}
+
+	c.expect(http.StatusOK, nil, "PATCH", path, `{"first_name": "Anna", "notes": null}`,
+		"Content-Type", "application/merge-patch+json")
+	rep := c.importFile(appendUpsert, vcard.MediaType, string(card))
+	if rep.Updated != 1 || len(rep.Rows) != 1 || rep.Rows[0].ID != u.ID.String() {
+		t.Fatalf("import of the card is %+v, want the user updated", rep)
+	}
+	got := c.selectUser(u.ID)
+	if d := models.Diff(&u, got); len(d) != 0 {
+		t.Errorf("user after round trip differs in %+v", d)
+	}
+
+	rep = c.importFile(appendUpsert, vcard.MediaType, string(card))
+	if rep.Skipped != 1 {
+		t.Errorf("import of the same card is %+v, want skipped", rep)
+	}
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/ferux/addressbook/internal/models"
+	"github.com/ferux/addressbook/internal/patch"
+	"github.com/ferux/addressbook/internal/validation"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrPatchResult reports in case patched document is not a user
+	ErrPatchResult = errors.New("patched document is not a valid user")
+	// ErrIDChanged reports in case patch changes id of user
+	ErrIDChanged = errors.New("id of user can't be changed")
+)
+
+// acceptPatch lists media types of patches, it is sent with 415 responses.
+var acceptPatch = strings.Join([]string{patch.MediaTypeMerge, patch.MediaTypeJSON}, ", ")
+
+// patchUserHandler changes only the fields mentioned by the patch. The user
+// is read, patched, validated and written by the storage as one step.
+func (a *API) patchUserHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "patchUserHandler",
+	})
+	logger.Info()
+	id, ok := models.ParseID(mux.Vars(r)["id"])
+	if !ok {
+		logger.WithError(ErrIDInvalid).Error("invalid OID")
+		a.handleError(wrapError(ErrIDInvalid.Error(), r, http.StatusBadRequest, ErrIDInvalid), w)
+		return
+	}
+	var apply func(doc, p []byte) ([]byte, error)
+	mediatype, _, err := mime.ParseMediaType(r.Header.Get("Content-type"))
+	switch {
+	case err != nil:
+	case mediatype == patch.MediaTypeMerge:
+		apply = patch.Merge
+	case mediatype == patch.MediaTypeJSON:
+		apply = patch.Apply
+	}
+	if apply == nil {
+		logger.WithField("content-type", r.Header.Get("Content-type")).Error("unsupported content type")
+		w.Header().Set("accept-patch", acceptPatch)
+		a.handleError(wrapError(ErrUnsupportedType.Error(), r, http.StatusUnsupportedMediaType, ErrUnsupportedType), w)
+		return
+	}
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(&limitedReader{r: r.Body, n: MAXFILESIZE})
+	if err != nil {
+		logger.WithError(err).Error("can't read body")
+		a.handleError(wrapError("can't read body", r, http.StatusBadRequest, err), w)
+		return
+	}
+
+	user, err := a.controller(r).User().PatchUser(id, func(u *models.User) error {
+		if !matchVersion(r, u) {
+			return models.ErrVersionMismatch
+		}
+		doc, err := json.Marshal(u)
+		if err != nil {
+			return err
+		}
+		if doc, err = apply(doc, body); err != nil {
+			return err
+		}
+		var patched models.User
+		if err = json.Unmarshal(doc, &patched); err != nil {
+			return ErrPatchResult
+		}
+		if patched.ID != id {
+			return ErrIDChanged
+		}
+		if errs := a.validator.Prepare(&patched); errs != nil {
+			return errs
+		}
+		patched.Owner = u.Owner
+		*u = patched
+		return nil
+	})
+	err = ifMatchMissing(r, err)
+	if errs, ok := err.(validation.Errors); ok {
+		logger.WithError(errs).Error("invalid user")
+		a.handleError(validationError(r, errs), w)
+		return
+	}
+	if err != nil {
+		logger.WithError(err).Error("can't patch user")
+		msg := "can't patch user"
+		switch code, _ := knownProblem(err); code {
+		case ProblemInvalidPatch, ProblemPatchTestFailed, ProblemMalformedBody:
+			msg = err.Error()
+		}
+		a.handleError(wrapError(msg, r, http.StatusInternalServerError, err), w)
+		return
+	}
+	w.Header().Set("ETag", userETag(user))
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(user)
+}
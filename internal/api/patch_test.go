@@ -0,0 +1,44 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/ferux/addressbook/internal/models"
+	"github.com/ferux/addressbook/internal/patch"
+)
+
+func TestPatchUser(t *testing.T) {
+	c := newTestClient(t)
+	defer c.close()
+	ann := c.createUser("Ann", "Lee", "ann@example.com")
+	path := "/api/v1/book/user/" + ann.ID.String()
+
+	var got models.User
+	resp, _ := c.expect(http.StatusOK, &got, "PATCH", path, `{"job_title": "CTO", "notes": null}`,
+		"Content-Type", patch.MediaTypeMerge)
+	if got.JobTitle != "CTO" || got.LastName != "Lee" || got.FirstName != "Ann" || len(got.Emails) != 1 || got.Version != 2 {
+		t.Errorf("merged user is %+v", got)
+	}
+	etag := resp.Header.Get("ETag")
+
+	ops := `[{"op": "test", "path": "/first_name", "value": "Ann"},
+		{"op": "copy", "from": "/emails/0", "path": "/emails/-"},
+		{"op": "replace", "path": "/emails/1/value", "value": "lee@example.com"}]`
+	c.expect(http.StatusOK, &got, "PATCH", path, ops, "Content-Type", patch.MediaTypeJSON, "If-Match", etag)
+	if len(got.Emails) != 2 || got.Emails[0].Value != "ann@example.com" || got.Emails[1].Value != "lee@example.com" {
+		t.Errorf("emails of patched user are %+v", got.Emails)
+	}
+
+	c.expect(http.StatusPreconditionFailed, nil, "PATCH", path, `{"notes": "x"}`, "Content-Type", patch.MediaTypeMerge, "If-Match", etag)
+	c.expect(http.StatusUnsupportedMediaType, nil, "PATCH", path, `{"notes": "x"}`, "Content-Type", "application/json")
+	c.expect(http.StatusConflict, nil, "PATCH", path, `[{"op": "test", "path": "/first_name", "value": "Bob"}]`,
+		"Content-Type", patch.MediaTypeJSON)
+	c.expect(http.StatusUnprocessableEntity, nil, "PATCH", path, `[{"op": "remove", "path": "/nickname"}]`,
+		"Content-Type", patch.MediaTypeJSON)
+	c.expect(http.StatusUnprocessableEntity, nil, "PATCH", path, `{"id": "5c2f8a1b9d3e4f0a1b2c3d4e"}`, "Content-Type", patch.MediaTypeMerge)
+	c.expect(http.StatusBadRequest, nil, "PATCH", path, `{"last_name": null}`, "Content-Type", patch.MediaTypeMerge)
+	if u := c.selectUser(ann.ID); u.Version != 3 || u.Notes != "" {
+		t.Errorf("user after failed patches is %+v", u)
+	}
+}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/ferux/addressbook/internal/auth"
+	"github.com/ferux/addressbook/internal/controllers"
+	"github.com/ferux/addressbook/internal/models"
+	"github.com/ferux/addressbook/internal/patch"
+	"github.com/ferux/addressbook/internal/validation"
+)
+
+// problemMediaType is the content type of errors (RFC 7807).
+const problemMediaType = "application/problem+json"
+
+// problemTypePrefix starts type URI of every problem, the code follows it.
+const problemTypePrefix = "urn:addressbook:problem:"
+
+// Codes of problems. They are stable, so clients can switch on them.
+const (
+	ProblemBadRequest       = "bad_request"
+	ProblemMalformedBody    = "malformed_body"
+	ProblemValidation       = "validation_failed"
+	ProblemInvalidID        = "invalid_id"
+	ProblemInvalidQuery     = "invalid_query"
+	ProblemInvalidBatch     = "invalid_batch"
+	ProblemInvalidWebhook   = "invalid_webhook"
+	ProblemUnauthorized     = "unauthorized"
+	ProblemInvalidKey       = "invalid_key"
+	ProblemInvalidToken     = "invalid_token"
+	ProblemTokenExpired     = "token_expired"
+	ProblemWrongTenant      = "wrong_tenant"
+	ProblemForbidden        = "forbidden"
+	ProblemQuotaExceeded    = "quota_exceeded"
+	ProblemNotFound         = "not_found"
+	ProblemUnknownTenant    = "unknown_tenant"
+	ProblemMethodNotAllowed = "method_not_allowed"
+	ProblemConflict         = "conflict"
+	ProblemEditConflict     = "edit_conflict"
+	ProblemPatchTestFailed  = "patch_test_failed"
+	ProblemPrecondition     = "precondition_failed"
+	ProblemTooLarge         = "payload_too_large"
+	ProblemUnsupportedType  = "unsupported_media_type"
+	ProblemInvalidPatch     = "invalid_patch"
+	ProblemInvalidRevision  = "invalid_revision"
+	ProblemNotApplied       = "not_applied"
+	ProblemInternal         = "internal"
+	ProblemNotSupported     = "not_supported"
+	ProblemTimeout          = "timeout"
+)
+
+// problemKind is the status and the title shared by problems with one code.
+type problemKind struct {
+	status int
+	title  string
+}
+
+// problems is the catalogue of codes.
+var problems = map[string]problemKind{
+	ProblemBadRequest:       {http.StatusBadRequest, "Bad request"},
+	ProblemMalformedBody:    {http.StatusBadRequest, "Request body is malformed"},
+	ProblemValidation:       {http.StatusBadRequest, "Validation failed"},
+	ProblemInvalidID:        {http.StatusBadRequest, "Invalid id"},
+	ProblemInvalidQuery:     {http.StatusBadRequest, "Invalid query"},
+	ProblemInvalidBatch:     {http.StatusBadRequest, "Invalid batch"},
+	ProblemInvalidWebhook:   {http.StatusBadRequest, "Invalid webhook"},
+	ProblemUnauthorized:     {http.StatusUnauthorized, "Credentials are required"},
+	ProblemInvalidKey:       {http.StatusUnauthorized, "Invalid API key"},
+	ProblemInvalidToken:     {http.StatusUnauthorized, "Invalid token"},
+	ProblemTokenExpired:     {http.StatusUnauthorized, "Token expired"},
+	ProblemWrongTenant:      {http.StatusUnauthorized, "Token belongs to another tenant"},
+	ProblemForbidden:        {http.StatusForbidden, "Not allowed"},
+	ProblemQuotaExceeded:    {http.StatusForbidden, "Quota exceeded"},
+	ProblemNotFound:         {http.StatusNotFound, "Not found"},
+	ProblemUnknownTenant:    {http.StatusNotFound, "Unknown tenant"},
+	ProblemMethodNotAllowed: {http.StatusMethodNotAllowed, "Method not allowed"},
+	ProblemConflict:         {http.StatusConflict, "Already exists"},
+	ProblemEditConflict:     {http.StatusConflict, "Changed concurrently"},
+	ProblemPatchTestFailed:  {http.StatusConflict, "Patch test failed"},
+	ProblemPrecondition:     {http.StatusPreconditionFailed, "Precondition failed"},
+	ProblemTooLarge:         {http.StatusRequestEntityTooLarge, "Request body is too large"},
+	ProblemUnsupportedType:  {http.StatusUnsupportedMediaType, "Unsupported media type"},
+	ProblemInvalidPatch:     {http.StatusUnprocessableEntity, "Patch can't be applied"},
+	ProblemInvalidRevision:  {http.StatusUnprocessableEntity, "Revision can't be reverted to"},
+	ProblemNotApplied:       {http.StatusFailedDependency, "Not applied"},
+	ProblemInternal:         {http.StatusInternalServerError, "Internal error"},
+	ProblemNotSupported:     {http.StatusNotImplemented, "Not supported by storage"},
+	ProblemTimeout:          {http.StatusGatewayTimeout, "Request timed out"},
+}
+
+// errorProblems maps known errors to their codes. It is a list because
+// validation.Errors can't be a key of map.
+var errorProblems = []struct {
+	err  error
+	code string
+}{
+	{ErrIDInvalid, ProblemInvalidID},
+	{ErrLimitInvalid, ProblemInvalidQuery},
+	{ErrQueryEmpty, ProblemInvalidQuery},
+	{models.ErrBadCursor, ProblemInvalidQuery},
+	{models.ErrBadSort, ProblemInvalidQuery},
+	{models.ErrBadFilter, ProblemInvalidQuery},
+	{ErrAtInvalid, ProblemInvalidQuery},
+	{ErrVersionInvalid, ProblemInvalidQuery},
+	{ErrNoCredentials, ProblemUnauthorized},
+	{ErrAnonymous, ProblemUnauthorized},
+	{auth.ErrInvalidKey, ProblemInvalidKey},
+	{auth.ErrInvalidToken, ProblemInvalidToken},
+	{auth.ErrTokenExpired, ProblemTokenExpired},
+	{ErrWrongTenant, ProblemWrongTenant},
+	{ErrForbidden, ProblemForbidden},
+	{ErrResourceName, ProblemForbidden},
+	{controllers.ErrQuotaExceeded, ProblemQuotaExceeded},
+	{models.ErrNotFound, ProblemNotFound},
+	{ErrUnknownTenant, ProblemUnknownTenant},
+	{errMethodNotAllowed, ProblemMethodNotAllowed},
+	{models.ErrAlreadyExists, ProblemConflict},
+	{models.ErrConflict, ProblemEditConflict},
+	{patch.ErrMalformed, ProblemMalformedBody},
+	{patch.ErrTestFailed, ProblemPatchTestFailed},
+	{ErrPatchResult, ProblemInvalidPatch},
+	{ErrIDChanged, ProblemInvalidPatch},
+	{ErrRevisionDeleted, ProblemInvalidRevision},
+	{ErrBatchEmpty, ProblemInvalidBatch},
+	{ErrBatchOp, ProblemInvalidBatch},
+	{ErrBatchRepeated, ProblemInvalidBatch},
+	{ErrBatchTooLarge, ProblemTooLarge},
+	{ErrHookURLInvalid, ProblemInvalidWebhook},
+	{ErrEventInvalid, ProblemInvalidWebhook},
+	{models.ErrNotApplied, ProblemNotApplied},
+	{models.ErrAtomicUnsupported, ProblemNotSupported},
+	{ErrPrecondition, ProblemPrecondition},
+	{models.ErrVersionMismatch, ProblemPrecondition},
+	{ErrFileTooLarge, ProblemTooLarge},
+	{ErrUnsupportedType, ProblemUnsupportedType},
+	{ErrTimeout, ProblemTimeout},
+	{context.DeadlineExceeded, ProblemTimeout},
+}
+
+// statusProblems gives codes by status to errors which are not known.
+var statusProblems = map[int]string{
+	http.StatusBadRequest:            ProblemBadRequest,
+	http.StatusUnauthorized:          ProblemUnauthorized,
+	http.StatusForbidden:             ProblemForbidden,
+	http.StatusNotFound:              ProblemNotFound,
+	http.StatusMethodNotAllowed:      ProblemMethodNotAllowed,
+	http.StatusConflict:              ProblemConflict,
+	http.StatusPreconditionFailed:    ProblemPrecondition,
+	http.StatusRequestEntityTooLarge: ProblemTooLarge,
+	http.StatusUnsupportedMediaType:  ProblemUnsupportedType,
+	http.StatusNotImplemented:        ProblemNotSupported,
+	http.StatusGatewayTimeout:        ProblemTimeout,
+}
+
+// knownProblem returns code of err if it is known.
+func knownProblem(err error) (string, bool) {
+	switch err.(type) {
+	case validation.Errors:
+		return ProblemValidation, true
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+		return ProblemMalformedBody, true
+	case *patch.Error:
+		return ProblemInvalidPatch, true
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ProblemMalformedBody, true
+	}
+	for _, p := range errorProblems {
+		if err == p.err {
+			return p.code, true
+		}
+	}
+	return "", false
+}
+
+// newProblem describes err with detail. Known errors get status from the
+// catalogue, so e.g. not found error of storage is not reported as
+// internal one. Other errors get the code of status.
+func newProblem(detail string, status int, err error) *ResponseError {
+	code, ok := knownProblem(err)
+	if ok {
+		status = problems[code].status
+	} else if code, ok = statusProblems[status]; !ok {
+		code = ProblemInternal
+	}
+	return &ResponseError{
+		Type:   problemTypePrefix + code,
+		Title:  problems[code].title,
+		Status: status,
+		Detail: detail,
+		Code:   code,
+		origin: err,
+	}
+}
+
+// writeProblem writes e as problem+json.
+func (a *API) writeProblem(w http.ResponseWriter, e *ResponseError) {
+	w.Header().Set("content-type", problemMediaType)
+	w.Header().Set("x-content-type-options", "nosniff")
+	w.WriteHeader(e.Status)
+	if err := json.NewEncoder(w).Encode(e); err != nil {
+		a.logger.WithError(err).Info("can't encode")
+	}
+}
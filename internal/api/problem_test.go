@@ -0,0 +1,32 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestProblems(t *testing.T) {
+	c := newTestClient(t)
+	defer c.close()
+	tests := []struct {
+		method, path, body string
+		status             int
+		code               string
+	}{
+		{"DELETE", "/api/v1/book/user", "", http.StatusMethodNotAllowed, ProblemMethodNotAllowed},
+		{"GET", "/api/v1/nothing", "", http.StatusNotFound, ProblemNotFound},
+		{"GET", "/api/v1/book/user/42", "", http.StatusBadRequest, ProblemInvalidID},
+		{"GET", "/api/v1/book/user/5c2f8a1b9d3e4f0a1b2c3d4e", "", http.StatusNotFound, ProblemNotFound},
+		{"POST", "/api/v1/book/user", "{", http.StatusBadRequest, ProblemMalformedBody},
+	}
+	for _, tt := range tests {
+		var problem ResponseError
+		resp, _ := c.expect(tt.status, &problem, tt.method, tt.path, tt.body)
+		if ct := resp.Header.Get("content-type"); ct != problemMediaType {
+			t.Errorf("%s %s: content type is %q, want %q", tt.method, tt.path, ct, problemMediaType)
+		}
+		if problem.Code != tt.code || problem.Status != tt.status || problem.RequestID == "" {
+			t.Errorf("%s %s: problem is %+v", tt.method, tt.path, problem)
+		}
+	}
+}
@@ -5,26 +5,63 @@ import (
 	"net/http"
 
 	"github.com/ferux/addressbook"
+	"github.com/ferux/addressbook/internal/auth"
 	"github.com/gorilla/mux"
 )
 
 func (a *API) registerRoutes() *mux.Router {
 	r := mux.NewRouter()
 
-	r.Use(a.sessionControl, a.logRequests)
+	r.Use(a.sessionControl, a.logRequests, a.limitTime, a.resolveTenant, a.copySession, a.authenticate, a.selectBook)
 
 	r.HandleFunc("/status", a.handleServerStatus)
+	r.HandleFunc("/.well-known/carddav", a.wellKnownCardDAVHandler)
+	r.PathPrefix(davPrefix).HandlerFunc(a.allowBy(davPermission, a.davHandler))
 
 	r.NotFoundHandler = a.sessionControl(a.logRequests(a.notFoundHandler()))
+	// subrouters report wrong method to r, their own handler would be
+	// replaced by nil handler of their route in mux
+	r.MethodNotAllowedHandler = a.sessionControl(a.logRequests(a.methodNotAllowedHandler()))
 	rv1 := r.PathPrefix("/api/v1/book").Subrouter()
-	rv1.HandleFunc("", a.helloHandler).Methods("GET")
-	rv1.HandleFunc("/", a.helloHandler).Methods("GET")
-	rv1.HandleFunc("/user", a.listUsersHandler).Methods("GET")
-	rv1.HandleFunc("/user", a.createUserHandler).Methods("POST")
-	rv1.HandleFunc("/user/{id}", a.selectUserHandler).Methods("GET")
-	rv1.HandleFunc("/user/{id}", a.updateUserHandler).Methods("PUT")
-	rv1.HandleFunc("/user/{id}", a.deleteUserHandler).Methods("DELETE")
-	rv1.HandleFunc("/export", a.downloadCSVHandler).Methods("GET")
+	rv1.HandleFunc("", a.allow(auth.PermRead, a.helloHandler)).Methods("GET")
+	rv1.HandleFunc("/", a.allow(auth.PermRead, a.helloHandler)).Methods("GET")
+	rv1.HandleFunc("/user", a.allow(auth.PermRead, a.listUsersHandler)).Methods("GET")
+	rv1.HandleFunc("/user", a.allow(auth.PermWrite, a.createUserHandler)).Methods("POST")
+	rv1.HandleFunc("/user:batch", a.allow(auth.PermWrite, a.batchHandler)).Methods("POST")
+	rv1.HandleFunc("/user/{id}.vcf", a.allow(auth.PermRead, a.selectVCFHandler)).Methods("GET")
+	rv1.HandleFunc("/user/{id}/history", a.allow(auth.PermRead, a.historyHandler)).Methods("GET")
+	rv1.HandleFunc("/user/{id}/history/{version}/revert", a.allow(auth.PermWrite, a.revertHandler)).Methods("POST")
+	rv1.HandleFunc("/user/{id}", a.allow(auth.PermRead, a.selectUserHandler)).Methods("GET")
+	rv1.HandleFunc("/user/{id}", a.allow(auth.PermWrite, a.updateUserHandler)).Methods("PUT")
+	rv1.HandleFunc("/user/{id}", a.allow(auth.PermWrite, a.patchUserHandler)).Methods("PATCH")
+	rv1.HandleFunc("/user/{id}", a.allow(auth.PermWrite, a.deleteUserHandler)).Methods("DELETE")
+	rv1.HandleFunc("/events", a.allow(auth.PermRead, a.eventsHandler)).Methods("GET")
+	rv1.HandleFunc("/trash", a.allow(auth.PermRead, a.listTrashHandler)).Methods("GET")
+	rv1.HandleFunc("/trash/{id}/restore", a.allow(auth.PermWrite, a.restoreUserHandler)).Methods("POST")
+	rv1.HandleFunc("/trash/{id}", a.allow(auth.PermWrite, a.purgeUserHandler)).Methods("DELETE")
+	rv1.HandleFunc("/webhooks", a.allow(auth.PermAdmin, a.listWebhooksHandler)).Methods("GET")
+	rv1.HandleFunc("/webhooks", a.allow(auth.PermAdmin, a.createWebhookHandler)).Methods("POST")
+	rv1.HandleFunc("/webhooks/dead-letters", a.allow(auth.PermAdmin, a.listDeadLettersHandler)).Methods("GET")
+	rv1.HandleFunc("/webhooks/dead-letters/{id}", a.allow(auth.PermAdmin, a.deleteDeadLetterHandler)).Methods("DELETE")
+	rv1.HandleFunc("/webhooks/{id}", a.allow(auth.PermAdmin, a.selectWebhookHandler)).Methods("GET")
+	rv1.HandleFunc("/webhooks/{id}", a.allow(auth.PermAdmin, a.updateWebhookHandler)).Methods("PUT")
+	rv1.HandleFunc("/webhooks/{id}", a.allow(auth.PermAdmin, a.deleteWebhookHandler)).Methods("DELETE")
+	rv1.HandleFunc("/search", a.allow(auth.PermRead, a.searchHandler)).Methods("GET")
+	rv1.HandleFunc("/export", a.allow(auth.PermRead, a.exportHandler)).Methods("GET")
+	rv1.HandleFunc("/import", a.allow(auth.PermWrite, a.importHandler)).Methods("POST")
+	rv1.HandleFunc("/claim", a.allow(auth.PermWrite, a.claimHandler)).Methods("POST")
+
+	r.HandleFunc("/api/v1/auth/token", a.allow(auth.PermRead, a.tokenHandler)).Methods("POST")
+	radmin := r.PathPrefix("/api/v1/admin").Subrouter()
+	radmin.HandleFunc("/keys", a.allow(auth.PermAdmin, a.listKeysHandler)).Methods("GET")
+	radmin.HandleFunc("/keys", a.allow(auth.PermAdmin, a.createKeyHandler)).Methods("POST")
+	radmin.HandleFunc("/keys/{id}/role", a.allow(auth.PermAdmin, a.setKeyRoleHandler)).Methods("PUT")
+	radmin.HandleFunc("/keys/{id}", a.allow(auth.PermAdmin, a.deleteKeyHandler)).Methods("DELETE")
+	radmin.HandleFunc("/tenants", a.requireOperator(a.listTenantsHandler)).Methods("GET")
+	radmin.HandleFunc("/tenants", a.requireOperator(a.createTenantHandler)).Methods("POST")
+	radmin.HandleFunc("/tenants/{id}", a.requireOperator(a.selectTenantHandler)).Methods("GET")
+	radmin.HandleFunc("/tenants/{id}", a.requireOperator(a.updateTenantHandler)).Methods("PUT")
+	radmin.HandleFunc("/tenants/{id}", a.requireOperator(a.deleteTenantHandler)).Methods("DELETE")
 	return r
 }
 
@@ -0,0 +1,240 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ferux/addressbook/internal/controllers"
+	"github.com/ferux/addressbook/internal/models"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultTenantHeader names the tenant when config does not specify the header.
+const defaultTenantHeader = "X-Tenant"
+
+// noKeys is the empty key storage of tenants which are not provisioned.
+var noKeys controllers.KeyStore = controllers.NewMemoryKey()
+
+var (
+	// ErrUnknownTenant reports in case request names tenant which is not provisioned
+	ErrUnknownTenant = errors.New("unknown tenant")
+	// ErrTenantIDInvalid reports in case new tenant has id which can't be used in names of databases
+	ErrTenantIDInvalid = errors.New("tenant id should be up to 32 lowercase letters, digits or dashes")
+)
+
+// resolveTenant finds the tenant named by header or subdomain of the
+// request and stores it in context, so the request is served on its data.
+// Requests which do not name a tenant use the default storage.
+func (a *API) resolveTenant(f http.Handler) http.Handler {
+	if !a.conf.Tenants.Enabled {
+		return f
+	}
+	m := func(w http.ResponseWriter, r *http.Request) {
+		id := a.tenantName(r)
+		if id == "" {
+			f.ServeHTTP(w, r)
+			return
+		}
+		if !models.IsValidTenantID(id) {
+			a.unknownTenant(w, r, id)
+			return
+		}
+		t, err := a.repo.Tenant(id)
+		if err == models.ErrNotFound {
+			a.unknownTenant(w, r, id)
+			return
+		}
+		if err != nil {
+			a.logger.WithFields(logrus.Fields{
+				"requestID": GetRID(r.Context()),
+				"fn":        "resolveTenant",
+			}).WithError(err).Error("can't get tenant")
+			a.handleError(wrapError("can't get tenant", r, http.StatusInternalServerError, err), w)
+			return
+		}
+		f.ServeHTTP(w, r.WithContext(WithTenant(r.Context(), t)))
+	}
+	return middlewareFunc(m)
+}
+
+// unknownTenant answers request which names tenant that is not provisioned.
+// Client is authenticated first as if the tenant had no keys, so clients
+// without valid credentials get the same answer as from a known tenant and
+// can't find out which tenants exist.
+func (a *API) unknownTenant(w http.ResponseWriter, r *http.Request, id string) {
+	if a.auth.Enabled() && !publicPaths[r.URL.Path] {
+		_, err := a.principal(r, noKeys, id)
+		if err != nil && !(err == ErrNoCredentials && a.auth.Anonymous()) {
+			a.unauthorized(w, r, err)
+			return
+		}
+	}
+	a.handleError(wrapError(ErrUnknownTenant.Error(), r, http.StatusNotFound, ErrUnknownTenant), w)
+}
+
+// tenantName returns the tenant named by the request. Header takes
+// precedence over subdomain of the configured domain.
+func (a *API) tenantName(r *http.Request) string {
+	header := a.conf.Tenants.Header
+	if header == "" {
+		header = defaultTenantHeader
+	}
+	if id := strings.TrimSpace(r.Header.Get(header)); id != "" {
+		return strings.ToLower(id)
+	}
+	domain := strings.ToLower(strings.Trim(a.conf.Tenants.Domain, "."))
+	if domain == "" {
+		return ""
+	}
+	host := strings.ToLower(r.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if !strings.HasSuffix(host, "."+domain) {
+		return ""
+	}
+	return strings.TrimSuffix(host, "."+domain)
+}
+
+// tenantID returns id of the tenant of the request. It is empty for the default storage.
+func tenantID(r *http.Request) string {
+	if t := GetTenant(r.Context()); t != nil {
+		return t.ID
+	}
+	return ""
+}
+
+// tenantRequest describes tenant to provision or changes of the tenant.
+// Quota of config is used if request does not specify it.
+type tenantRequest struct {
+	ID    string        `json:"id"`
+	Name  string        `json:"name"`
+	Quota *models.Quota `json:"quota"`
+}
+
+func (a *API) listTenantsHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "listTenantsHandler",
+	})
+	logger.Info()
+	tenants, err := a.repo.Tenants().ListTenants()
+	if err != nil {
+		logger.WithError(err).Error("can't get tenants")
+		a.handleError(wrapError("can't get tenants", r, http.StatusInternalServerError, err), w)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(tenants)
+}
+
+// createTenantHandler provisions new tenant: registers it and prepares its storage.
+func (a *API) createTenantHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "createTenantHandler",
+	})
+	logger.Info()
+	var req tenantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		a.handleError(wrapError("error parsing body", r, http.StatusBadRequest, err), w)
+		return
+	}
+	if !models.IsValidTenantID(req.ID) {
+		a.handleError(wrapError(ErrTenantIDInvalid.Error(), r, http.StatusBadRequest, ErrTenantIDInvalid), w)
+		return
+	}
+	t := &models.Tenant{
+		ID:        req.ID,
+		Name:      req.Name,
+		Quota:     models.Quota{MaxUsers: a.conf.Tenants.MaxUsers, MaxKeys: a.conf.Tenants.MaxKeys},
+		CreatedAt: time.Now().UTC(),
+	}
+	if req.Quota != nil {
+		t.Quota = *req.Quota
+	}
+	if err := a.repo.ProvisionTenant(t, a.conf.Tenants.Isolation); err != nil {
+		logger.WithError(err).Error("can't provision tenant")
+		a.handleError(wrapError("can't provision tenant", r, http.StatusInternalServerError, err), w)
+		return
+	}
+	logger.WithField("tenant", t.ID).Info("tenant has been provisioned")
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(t)
+}
+
+func (a *API) selectTenantHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "selectTenantHandler",
+	})
+	logger.Info()
+	t, err := a.repo.Tenants().SelectTenant(mux.Vars(r)["id"])
+	if err != nil {
+		logger.WithError(err).Error("can't get tenant")
+		a.handleError(wrapError("can't get tenant", r, http.StatusInternalServerError, err), w)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(t)
+}
+
+// updateTenantHandler changes name or quota of the tenant. Users and keys
+// above the new quota are kept, but no more can be added.
+func (a *API) updateTenantHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "updateTenantHandler",
+	})
+	logger.Info()
+	var req tenantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		a.handleError(wrapError("error parsing body", r, http.StatusBadRequest, err), w)
+		return
+	}
+	t, err := a.repo.Tenants().SelectTenant(mux.Vars(r)["id"])
+	if err == nil {
+		if req.Name != "" {
+			t.Name = req.Name
+		}
+		if req.Quota != nil {
+			t.Quota = *req.Quota
+		}
+		err = a.repo.UpdateTenant(t)
+	}
+	if err != nil {
+		logger.WithError(err).Error("can't update tenant")
+		a.handleError(wrapError("can't update tenant", r, http.StatusInternalServerError, err), w)
+		return
+	}
+	logger.WithField("tenant", t.ID).Info("tenant has been updated")
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(t)
+}
+
+// deleteTenantHandler deprovisions the tenant and removes all its data.
+func (a *API) deleteTenantHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "deleteTenantHandler",
+	})
+	logger.Info()
+	id := mux.Vars(r)["id"]
+	if err := a.repo.DeprovisionTenant(id); err != nil {
+		logger.WithError(err).Error("can't deprovision tenant")
+		a.handleError(wrapError("can't deprovision tenant", r, http.StatusInternalServerError, err), w)
+		return
+	}
+	logger.WithField("tenant", id).Info("tenant has been deprovisioned")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/ferux/addressbook/internal/models"
+	"github.com/ferux/addressbook/internal/types"
+)
+
+func TestTenants(t *testing.T) {
+	conf := types.API{Tenants: types.Tenants{Enabled: true, MaxUsers: 2, MaxKeys: 1}}
+	c := newConfiguredClient(t, conf, types.Auth{AdminKey: testAdminKey, TokenSecret: "secret"})
+	defer c.close()
+	admin := []string{"X-API-Key", testAdminKey}
+	c.expect(http.StatusCreated, nil, "POST", "/api/v1/admin/tenants", `{"id": "acme", "name": "ACME"}`, admin...)
+
+	// clients without valid credentials can't tell known tenants from unknown ones
+	for _, tenant := range []string{"acme", "nope", "Not_Valid"} {
+		c.expect(http.StatusUnauthorized, nil, "GET", "/api/v1/book/user", "", "X-Tenant", tenant)
+		c.expect(http.StatusUnauthorized, nil, "GET", "/api/v1/book/user", "", "X-Tenant", tenant, "X-API-Key", "abk_unknown")
+	}
+	c.expect(http.StatusNotFound, nil, "GET", "/api/v1/book/user", "", "X-Tenant", "nope", "X-API-Key", testAdminKey)
+	// the second request is answered from cache of unknown tenants
+	c.expect(http.StatusNotFound, nil, "GET", "/api/v1/book/user", "", "X-Tenant", "nope", "X-API-Key", testAdminKey)
+
+	k := &createdKey{}
+	body, _ := json.Marshal(map[string]string{"name": "ci", "role": models.RoleEditor})
+	c.expect(http.StatusCreated, k, "POST", "/api/v1/admin/keys", string(body), "X-API-Key", testAdminKey, "X-Tenant", "acme")
+	c.expect(http.StatusForbidden, nil, "POST", "/api/v1/admin/keys", string(body), "X-API-Key", testAdminKey, "X-Tenant", "acme")
+	// keys work only in their tenant
+	c.expect(http.StatusUnauthorized, nil, "GET", "/api/v1/book/user", "", "X-API-Key", k.Key)
+
+	acme := []string{"X-Tenant", "acme", "X-API-Key", k.Key}
+	var ann models.User
+	c.expect(http.StatusOK, &ann, "POST", "/api/v1/book/user", userJSON("Ann", "Lee"), acme...)
+	c.expect(http.StatusOK, nil, "POST", "/api/v1/book/user", userJSON("Bob", "Lee"), acme...)
+	c.expect(http.StatusForbidden, nil, "POST", "/api/v1/book/user", userJSON("Cy", "Lee"), acme...)
+	// the default book has no quota
+	c.expect(http.StatusOK, nil, "POST", "/api/v1/book/user", userJSON("Cy", "Lee"), admin...)
+
+	c.expect(http.StatusOK, nil, "DELETE", "/api/v1/book/user/"+ann.ID.String(), "", acme...)
+	c.expect(http.StatusOK, nil, "POST", "/api/v1/book/user", userJSON("Cy", "Lee"), acme...)
+	c.expect(http.StatusForbidden, nil, "POST", "/api/v1/book/trash/"+ann.ID.String()+"/restore", "", acme...)
+
+	c.expect(http.StatusOK, nil, "PUT", "/api/v1/admin/tenants/acme", `{"quota": {"max_users": 3}}`, admin...)
+	c.expect(http.StatusOK, nil, "POST", "/api/v1/book/trash/"+ann.ID.String()+"/restore", "", acme...)
+
+	c.expect(http.StatusNoContent, nil, "DELETE", "/api/v1/admin/tenants/acme", "", admin...)
+	c.expect(http.StatusNotFound, nil, "GET", "/api/v1/book/user", "", "X-Tenant", "acme", "X-API-Key", testAdminKey)
+}
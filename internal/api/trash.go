@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ferux/addressbook/internal/models"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// listTrashHandler returns deleted users of the book, recently deleted go first.
+func (a *API) listTrashHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "listTrashHandler",
+	})
+	logger.Info()
+	users, err := a.controller(r).User().ListTrash()
+	if err != nil {
+		logger.WithError(err).Error("can't list trash")
+		a.handleError(wrapError("can't list trash", r, http.StatusInternalServerError, err), w)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(users)
+}
+
+// restoreUserHandler moves user out of trash. It fails with conflict if
+// another user of the book has got its phones or emails meanwhile.
+func (a *API) restoreUserHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "restoreUserHandler",
+	})
+	logger.Info()
+	id, ok := models.ParseID(mux.Vars(r)["id"])
+	if !ok {
+		logger.WithError(ErrIDInvalid).Error("invalid OID")
+		a.handleError(wrapError(ErrIDInvalid.Error(), r, http.StatusBadRequest, ErrIDInvalid), w)
+		return
+	}
+	user, err := a.controller(r).User().RestoreUser(id)
+	if err != nil {
+		logger.WithError(err).Error("can't restore user")
+		a.handleError(wrapError("can't restore user", r, http.StatusInternalServerError, err), w)
+		return
+	}
+	w.Header().Set("ETag", userETag(user))
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(user)
+}
+
+// purgeUserHandler removes user from trash for good.
+func (a *API) purgeUserHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "purgeUserHandler",
+	})
+	logger.Info()
+	id, ok := models.ParseID(mux.Vars(r)["id"])
+	if !ok {
+		logger.WithError(ErrIDInvalid).Error("invalid OID")
+		a.handleError(wrapError(ErrIDInvalid.Error(), r, http.StatusBadRequest, ErrIDInvalid), w)
+		return
+	}
+	if err := a.controller(r).User().PurgeUser(id); err != nil {
+		logger.WithError(err).Error("can't purge user")
+		a.handleError(wrapError("can't purge user", r, http.StatusInternalServerError, err), w)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&models.User{ID: id})
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/ferux/addressbook/internal/models"
+)
+
+// trash returns ids of users in trash, recently deleted first.
+func (c *testClient) trash() []models.ID {
+	c.t.Helper()
+	var users []models.User
+	c.expect(http.StatusOK, &users, "GET", "/api/v1/book/trash", "")
+	ids := make([]models.ID, 0, len(users))
+	for _, u := range users {
+		if u.DeletedAt == nil {
+			c.t.Errorf("user %s in trash has no deleted_at", u.ID)
+		}
+		ids = append(ids, u.ID)
+	}
+	return ids
+}
+
+func TestTrashRestoreAndPurge(t *testing.T) {
+	c := newTestClient(t)
+	defer c.close()
+	ann := c.createUser("Ann", "Lee", "ann@example.com")
+	path := "/api/v1/book/user/" + ann.ID.String()
+
+	c.expect(http.StatusOK, nil, "DELETE", path, "")
+	c.expect(http.StatusNotFound, nil, "GET", path, "")
+	if ids := c.trash(); len(ids) != 1 || ids[0] != ann.ID {
+		t.Fatalf("trash is %v, want %s", ids, ann.ID)
+	}
+	var users []models.User
+	c.expect(http.StatusOK, &users, "GET", "/api/v1/book/search?q=ann", "")
+	if len(users) != 0 {
+		t.Errorf("search finds deleted user: %+v", users)
+	}
+
+	// the email of deleted user is free until it is restored
+	bob := c.createUser("Bob", "Lee", "ann@example.com")
+	c.expect(http.StatusConflict, nil, "POST", "/api/v1/book/trash/"+ann.ID.String()+"/restore", "")
+	c.expect(http.StatusOK, nil, "DELETE", "/api/v1/book/user/"+bob.ID.String(), "")
+
+	var restored models.User
+	c.expect(http.StatusOK, &restored, "POST", "/api/v1/book/trash/"+ann.ID.String()+"/restore", "")
+	if restored.DeletedAt != nil || restored.Version != 3 {
+		t.Errorf("restored user is %+v, want version 3 without deleted_at", restored)
+	}
+	if u := c.selectUser(ann.ID); u.FirstName != "Ann" {
+		t.Errorf("restored user is %+v", u)
+	}
+	if ids := c.trash(); len(ids) != 1 || ids[0] != bob.ID {
+		t.Errorf("trash is %v, want only %s", ids, bob.ID)
+	}
+
+	c.expect(http.StatusNotFound, nil, "DELETE", "/api/v1/book/trash/"+ann.ID.String(), "")
+	c.expect(http.StatusOK, nil, "DELETE", "/api/v1/book/trash/"+bob.ID.String(), "")
+	if ids := c.trash(); len(ids) != 0 {
+		t.Errorf("trash is %v after purge", ids)
+	}
+	c.expect(http.StatusNotFound, nil, "POST", "/api/v1/book/trash/"+bob.ID.String()+"/restore", "")
+	c.expect(http.StatusNotFound, nil, "GET", "/api/v1/book/user/"+bob.ID.String(), "")
+}
+
+func TestClearMovesToTrash(t *testing.T) {
+	c := newTestClient(t)
+	defer c.close()
+	ann := c.createUser("Ann", "Lee", "ann@example.com")
+	bob := c.createUser("Bob", "Lee")
+	cy := c.createUser("Cy", "Lee")
+	c.expect(http.StatusOK, nil, "DELETE", "/api/v1/book/user/"+cy.ID.String(), "")
+	var before []models.User
+	c.expect(http.StatusOK, &before, "GET", "/api/v1/book/trash", "")
+
+	rep := c.importFile(appendClear, "text/csv", ann.ID.String()+",Anna,Lee,ann@example.com,,,,,,,\n")
+	if rep.Inserted != 1 {
+		t.Errorf("clear report is %+v, want 1 inserted", rep)
+	}
+	// the user with the id of the row is restored with the content of the row
+	if u := c.selectUser(ann.ID); u.FirstName != "Anna" || u.Version != 3 {
+		t.Errorf("imported user is %+v, want Anna of version 3", u)
+	}
+	var trash []models.User
+	c.expect(http.StatusOK, &trash, "GET", "/api/v1/book/trash", "")
+	if len(trash) != 2 || trash[0].ID != bob.ID || trash[0].Version != 2 || trash[1].ID != cy.ID {
+		t.Fatalf("trash after clear is %+v", trash)
+	}
+	// users trashed before are left as they were
+	if trash[1].Version != before[0].Version || !trash[1].DeletedAt.Equal(*before[0].DeletedAt) {
+		t.Errorf("user trashed before clear is %+v, was %+v", trash[1], before[0])
+	}
+	c.expect(http.StatusOK, nil, "POST", "/api/v1/book/trash/"+bob.ID.String()+"/restore", "")
+}
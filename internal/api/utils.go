@@ -3,40 +3,41 @@ package api
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
+	"net"
 	"net/http"
-	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/ferux/addressbook/internal/auth"
+	"github.com/ferux/addressbook/internal/controllers"
 	"github.com/ferux/addressbook/internal/models"
+	"github.com/ferux/addressbook/internal/validation"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
-
-	"gopkg.in/mgo.v2/bson"
 )
 
 //MAXFILESIZE limits the maximum size of CSV file (used in import)
 const MAXFILESIZE = 1024 * 1024 * 8
 
-//Precompiled checks for email and names
-var (
-	emailCheck = regexp.MustCompile("^[a-zA-Z0-9.!#$%&’*+/=?^_`{|}~-]+@[a-zA-Z0-9-]+(?:\\.[a-zA-Z0-9-]+)*$").MatchString
-	nameCheck  = regexp.MustCompile("^[a-zA-Z]+$").MatchString
-)
-
-// ResponseError stores information about request ID and corresponding error.
+// ResponseError describes the error to the client as problem details
+// (RFC 7807) with request ID. Code is one of Problem codes.
 type ResponseError struct {
-	RequestID string `json:"request_id"`
-	Message   string `json:"message"`
-	Code      int    `json:"code"`
-	origin    error
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+	// Errors describes problems with fields of user.
+	Errors validation.Errors `json:"errors,omitempty"`
+	origin error
 }
 
 func (e *ResponseError) Error() string {
-	return fmt.Sprintf("reqid=%s msg=%s code=%d", e.RequestID, e.Message, e.Code)
+	return fmt.Sprintf("reqid=%s msg=%s code=%s status=%d", e.RequestID, e.Detail, e.Code, e.Status)
 }
 
 // GetOrigin returns core error, not wrapped.
@@ -44,36 +45,42 @@ func (e *ResponseError) GetOrigin() error {
 	return e.origin
 }
 
+// wrapError describes err of the request to the client. msg is the detail,
+// code is the status used if err is not known by the catalogue of problems.
 func wrapError(msg string, r *http.Request, code int, err error) *ResponseError {
-	rid := GetRID(r.Context())
-	return &ResponseError{
-		RequestID: rid,
-		Message:   msg,
-		Code:      code,
-		origin:    err,
+	if isTimeout(r, err) {
+		msg, err = ErrTimeout.Error(), ErrTimeout
+	} else if err == controllers.ErrQuotaExceeded {
+		msg = err.Error()
 	}
+	e := newProblem(msg, code, err)
+	e.RequestID = GetRID(r.Context())
+	return e
 }
 
-func checkCorrectValues(u models.User) []string {
-	msgs := make([]string, 0, 3)
-	if !emailCheck(u.Email) {
-		msgs = append(msgs, "Email is incorrect")
-	}
-	if !nameCheck(u.FirstName) {
-		msgs = append(msgs, "First Name is incorrect")
+// isTimeout reports whether err has been caused by deadline of the request
+// or by timeout of database connection.
+func isTimeout(r *http.Request, err error) bool {
+	if err == nil {
+		return false
 	}
-	if !nameCheck(u.LastName) {
-		msgs = append(msgs, "Last Name is incorrect")
+	if err == context.DeadlineExceeded || r.Context().Err() == context.DeadlineExceeded {
+		return true
 	}
-	if len(msgs) == 0 {
-		return nil
-	}
-	return msgs
+	e, ok := err.(net.Error)
+	return ok && e.Timeout()
+}
+
+// validationError wraps problems found in user.
+func validationError(r *http.Request, errs validation.Errors) *ResponseError {
+	e := wrapError("validation failed", r, http.StatusBadRequest, errs)
+	e.Errors = errs
+	return e
 }
 
 func findUser(r *http.Request) (user *models.User, err error) {
-	varsID := mux.Vars(r)["id"]
-	if !bson.IsObjectIdHex(varsID) {
+	id, ok := models.ParseID(mux.Vars(r)["id"])
+	if !ok {
 		err := ErrIDInvalid
 		return user, err
 	}
@@ -81,15 +88,47 @@ func findUser(r *http.Request) (user *models.User, err error) {
 	if err = json.NewDecoder(r.Body).Decode(&user); err != nil {
 		return user, err
 	}
-	if msgs := checkCorrectValues(*user); msgs != nil {
-		err = errors.New(strings.Join(msgs, ";"))
-		return user, err
-	}
-	id := bson.ObjectIdHex(varsID)
 	user.ID = id
 	return user, err
 }
 
+// parseListQuery builds query from url parameters: limit, after, sort and
+// filters named after fields. Sort field prefixed with "-" means descending order.
+// Filter value ending with "*" matches values with such prefix.
+func parseListQuery(r *http.Request) (*models.ListQuery, error) {
+	values := r.URL.Query()
+	q := &models.ListQuery{}
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return nil, ErrLimitInvalid
+		}
+		q.Limit = n
+	}
+	if sort := values.Get("sort"); sort != "" {
+		q.Desc = strings.HasPrefix(sort, "-")
+		q.Sort = strings.TrimPrefix(sort, "-")
+	}
+	if after := values.Get("after"); after != "" {
+		cursor, err := models.DecodeCursor(after)
+		if err != nil {
+			return nil, err
+		}
+		q.After = cursor
+	}
+	for _, field := range models.FilterFields {
+		for _, value := range values[field] {
+			f := models.Filter{Field: field, Value: value}
+			if strings.HasSuffix(value, "*") {
+				f.Prefix = true
+				f.Value = strings.TrimSuffix(value, "*")
+			}
+			q.Filters = append(q.Filters, f)
+		}
+	}
+	return q, q.Validate()
+}
+
 // daemonKeys for context
 type daemonKeys uint8
 
@@ -97,6 +136,9 @@ type daemonKeys uint8
 const (
 	keySID daemonKeys = iota
 	keyReqID
+	keyController
+	keyPrincipal
+	keyTenant
 )
 
 // WithSID adds sid to ctx
@@ -123,15 +165,53 @@ func GetRID(ctx context.Context) string {
 	return rid
 }
 
+// WithController adds controller used by the request to ctx
+func WithController(ctx context.Context, c *controllers.Controller) context.Context {
+	return context.WithValue(ctx, keyController, c)
+}
+
+// GetController retrieves controller from ctx
+func GetController(ctx context.Context) *controllers.Controller {
+	c, _ := ctx.Value(keyController).(*controllers.Controller)
+	return c
+}
+
+// WithPrincipal adds authenticated client to ctx
+func WithPrincipal(ctx context.Context, p *auth.Principal) context.Context {
+	return context.WithValue(ctx, keyPrincipal, p)
+}
+
+// GetPrincipal retrieves authenticated client from ctx. It is nil when
+// authentication is disabled or the path is public.
+func GetPrincipal(ctx context.Context) *auth.Principal {
+	p, _ := ctx.Value(keyPrincipal).(*auth.Principal)
+	return p
+}
+
+// WithTenant adds tenant of the request to ctx
+func WithTenant(ctx context.Context, t *models.Tenant) context.Context {
+	return context.WithValue(ctx, keyTenant, t)
+}
+
+// GetTenant retrieves tenant from ctx. It is nil when request uses the default storage.
+func GetTenant(ctx context.Context) *models.Tenant {
+	t, _ := ctx.Value(keyTenant).(*models.Tenant)
+	return t
+}
+
 type middlewareFunc func(w http.ResponseWriter, r *http.Request)
 
 func (f middlewareFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	f(w, r)
 }
 
-func addSessionCookie(w http.ResponseWriter) string {
+// addSessionCookie sets cookie of session sid which expires in a week.
+// New session is started if sid is empty.
+func addSessionCookie(w http.ResponseWriter, sid string) string {
 	cookie := &http.Cookie{}
-	sid := uuid.New().String()
+	if sid == "" {
+		sid = uuid.New().String()
+	}
 	cookie.Value = sid
 	cookie.Expires = time.Now().Add(time.Hour * 24 * 7)
 	cookie.HttpOnly = true
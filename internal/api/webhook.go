@@ -0,0 +1,221 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ferux/addressbook/internal/models"
+	"github.com/ferux/addressbook/internal/webhook"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrHookURLInvalid reports in case URL of webhook is not an absolute http or https URL
+	ErrHookURLInvalid = errors.New("url should be an absolute http or https URL")
+	// ErrEventInvalid reports in case webhook subscribes to unknown event
+	ErrEventInvalid = errors.New("events should be user.created, user.updated or user.deleted")
+)
+
+// webhookRequest creates or changes a webhook. Empty Events subscribes to all events.
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// decodeWebhook reads and checks webhookRequest of r.
+func decodeWebhook(r *http.Request) (*models.Webhook, error) {
+	var req webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if !models.IsValidHookURL(req.URL) {
+		return nil, ErrHookURLInvalid
+	}
+	if req.Events == nil {
+		req.Events = make([]string, 0)
+	}
+	for _, e := range req.Events {
+		if !models.IsValidEvent(e) {
+			return nil, ErrEventInvalid
+		}
+	}
+	return &models.Webhook{URL: req.URL, Events: req.Events}, nil
+}
+
+// webhookError writes error of decodeWebhook.
+func (a *API) webhookError(w http.ResponseWriter, r *http.Request, err error) {
+	msg := "error parsing body"
+	if err == ErrHookURLInvalid || err == ErrEventInvalid {
+		msg = err.Error()
+	}
+	a.handleError(wrapError(msg, r, http.StatusBadRequest, err), w)
+}
+
+// listWebhooksHandler returns webhooks of the book. Secrets are not shown.
+func (a *API) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "listWebhooksHandler",
+	})
+	logger.Info()
+	hooks, err := a.controller(r).Webhook().ListWebhooks()
+	if err != nil {
+		logger.WithError(err).Error("can't get webhooks")
+		a.handleError(wrapError("can't get webhooks", r, http.StatusInternalServerError, err), w)
+		return
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(hooks)
+}
+
+// createWebhookHandler registers a webhook. Its secret is returned once,
+// later it is not shown.
+func (a *API) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "createWebhookHandler",
+	})
+	logger.Info()
+	h, err := decodeWebhook(r)
+	if err != nil {
+		a.webhookError(w, r, err)
+		return
+	}
+	h.CreatedAt = time.Now().UTC()
+	h.Secret, err = webhook.NewSecret()
+	if err == nil {
+		err = a.controller(r).Webhook().CreateWebhook(h)
+	}
+	if err != nil {
+		logger.WithError(err).Error("can't create webhook")
+		a.handleError(wrapError("can't create webhook", r, http.StatusInternalServerError, err), w)
+		return
+	}
+	logger.WithField("webhookid", h.ID).Info("webhook has been created")
+	w.Header().Set("content-type", "application/json")
+	w.Header().Set("cache-control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(h)
+}
+
+func (a *API) selectWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "selectWebhookHandler",
+	})
+	logger.Info()
+	id, ok := models.ParseID(mux.Vars(r)["id"])
+	if !ok {
+		a.handleError(wrapError(ErrIDInvalid.Error(), r, http.StatusBadRequest, ErrIDInvalid), w)
+		return
+	}
+	h, err := a.controller(r).Webhook().SelectWebhook(id)
+	if err != nil {
+		logger.WithError(err).Error("can't get webhook")
+		a.handleError(wrapError("can't get webhook", r, http.StatusInternalServerError, err), w)
+		return
+	}
+	h.Secret = ""
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(h)
+}
+
+// updateWebhookHandler changes URL and events of the webhook, its secret is kept.
+func (a *API) updateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "updateWebhookHandler",
+	})
+	logger.Info()
+	id, ok := models.ParseID(mux.Vars(r)["id"])
+	if !ok {
+		a.handleError(wrapError(ErrIDInvalid.Error(), r, http.StatusBadRequest, ErrIDInvalid), w)
+		return
+	}
+	h, err := decodeWebhook(r)
+	if err != nil {
+		a.webhookError(w, r, err)
+		return
+	}
+	h.ID = id
+	hooks := a.controller(r).Webhook()
+	if err = hooks.UpdateWebhook(h); err == nil {
+		h, err = hooks.SelectWebhook(h.ID)
+	}
+	if err != nil {
+		logger.WithError(err).Error("can't update webhook")
+		a.handleError(wrapError("can't update webhook", r, http.StatusInternalServerError, err), w)
+		return
+	}
+	logger.WithField("webhookid", id).Info("webhook has been updated")
+	h.Secret = ""
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(h)
+}
+
+func (a *API) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "deleteWebhookHandler",
+	})
+	logger.Info()
+	id, ok := models.ParseID(mux.Vars(r)["id"])
+	if !ok {
+		a.handleError(wrapError(ErrIDInvalid.Error(), r, http.StatusBadRequest, ErrIDInvalid), w)
+		return
+	}
+	if err := a.controller(r).Webhook().DeleteWebhook(id); err != nil {
+		logger.WithError(err).Error("can't delete webhook")
+		a.handleError(wrapError("can't delete webhook", r, http.StatusInternalServerError, err), w)
+		return
+	}
+	logger.WithField("webhookid", id).Info("webhook has been deleted")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listDeadLettersHandler returns deliveries which have failed too many
+// times, the latest go first.
+func (a *API) listDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "listDeadLettersHandler",
+	})
+	logger.Info()
+	letters, err := a.controller(r).Webhook().ListDeadLetters()
+	if err != nil {
+		logger.WithError(err).Error("can't get dead letters")
+		a.handleError(wrapError("can't get dead letters", r, http.StatusInternalServerError, err), w)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(letters)
+}
+
+func (a *API) deleteDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.WithFields(logrus.Fields{
+		"requestID": GetRID(r.Context()),
+		"fn":        "deleteDeadLetterHandler",
+	})
+	logger.Info()
+	id, ok := models.ParseID(mux.Vars(r)["id"])
+	if !ok {
+		a.handleError(wrapError(ErrIDInvalid.Error(), r, http.StatusBadRequest, ErrIDInvalid), w)
+		return
+	}
+	if err := a.controller(r).Webhook().DeleteDeadLetter(id); err != nil {
+		logger.WithError(err).Error("can't delete dead letter")
+		a.handleError(wrapError("can't delete dead letter", r, http.StatusInternalServerError, err), w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ferux/addressbook/internal/models"
+	"github.com/ferux/addressbook/internal/webhook"
+)
+
+// testDelivery is a request received by testReceiver.
+type testDelivery struct {
+	header http.Header
+	body   []byte
+}
+
+// testReceiver is a webhook which fails the first attempts of deliveries.
+type testReceiver struct {
+	*httptest.Server
+	mu         sync.Mutex
+	fails      int
+	deliveries []testDelivery
+}
+
+func newTestReceiver(fails int) *testReceiver {
+	rec := &testReceiver{fails: fails}
+	rec.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		rec.deliveries = append(rec.deliveries, testDelivery{header: r.Header, body: body})
+		if len(rec.deliveries) <= rec.fails {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	return rec
+}
+
+// received returns the deliveries received so far.
+func (rec *testReceiver) received() []testDelivery {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]testDelivery(nil), rec.deliveries...)
+}
+
+// createWebhook registers webhook with url and events and returns it with secret.
+func (c *testClient) createWebhook(url string, events ...string) *models.Webhook {
+	c.t.Helper()
+	body, _ := json.Marshal(webhookRequest{URL: url, Events: events})
+	var h models.Webhook
+	c.expect(http.StatusCreated, &h, "POST", "/api/v1/book/webhooks", string(body))
+	return &h
+}
+
+func TestWebhookSigningAndRetry(t *testing.T) {
+	c := newTestClient(t)
+	defer c.close()
+	rec := newTestReceiver(1)
+	defer rec.Close()
+	h := c.createWebhook(rec.URL, models.EventCreated)
+	if !strings.HasPrefix(h.Secret, webhook.SecretPrefix) {
+		t.Fatalf("secret of webhook is %q", h.Secret)
+	}
+	var hooks []models.Webhook
+	c.expect(http.StatusOK, &hooks, "GET", "/api/v1/book/webhooks", "")
+	if len(hooks) != 1 || hooks[0].Secret != "" {
+		t.Errorf("webhooks are listed as %+v, want one without secret", hooks)
+	}
+	c.expect(http.StatusBadRequest, nil, "POST", "/api/v1/book/webhooks", `{"url": "ftp://example.com"}`)
+	c.expect(http.StatusBadRequest, nil, "POST", "/api/v1/book/webhooks", `{"url": "http://example.com", "events": ["user.moved"]}`)
+
+	ann := c.createUser("Ann", "Lee")
+	// update is not subscribed, so it is not delivered
+	c.expect(http.StatusOK, nil, "PUT", "/api/v1/book/user/"+ann.ID.String(), userJSON("Anna", "Lee"))
+	eventually(t, "retry of delivery", func() bool { return len(rec.received()) >= 2 })
+
+	deliveries := rec.received()
+	if len(deliveries) != 2 {
+		t.Fatalf("webhook got %d requests, want the failed one and its retry", len(deliveries))
+	}
+	first, retry := deliveries[0], deliveries[1]
+	if first.header.Get(webhook.HeaderDelivery) != retry.header.Get(webhook.HeaderDelivery) || string(first.body) != string(retry.body) {
+		t.Error("retry differs from the first attempt")
+	}
+	if e := retry.header.Get(webhook.HeaderEvent); e != models.EventCreated {
+		t.Errorf("event header is %q", e)
+	}
+	sig := webhook.Sign(h.Secret, retry.header.Get(webhook.HeaderTimestamp), retry.body)
+	if got := retry.header.Get(webhook.HeaderSignature); got != sig {
+		t.Errorf("signature is %q, want %q", got, sig)
+	}
+	var e models.Event
+	if err := json.Unmarshal(retry.body, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != models.EventCreated || e.User == nil || e.User.ID != ann.ID || e.User.FirstName != "Ann" {
+		t.Errorf("event is %+v, want creation of Ann", e)
+	}
+}
+
+func TestWebhookDeadLetter(t *testing.T) {
+	c := newTestClient(t)
+	defer c.close()
+	rec := newTestReceiver(testWebhooks.MaxAttempts)
+	defer rec.Close()
+	h := c.createWebhook(rec.URL)
+	ann := c.createUser("Ann", "Lee")
+
+	var letters []models.DeadLetter
+	eventually(t, "dead letter", func() bool {
+		c.expect(http.StatusOK, &letters, "GET", "/api/v1/book/webhooks/dead-letters", "")
+		return len(letters) > 0
+	})
+	l := letters[0]
+	if l.WebhookID != h.ID || l.Attempts != testWebhooks.MaxAttempts || l.Event.User.ID != ann.ID || l.Error == "" {
+		t.Errorf("dead letter is %+v", l)
+	}
+	if n := len(rec.received()); n != testWebhooks.MaxAttempts {
+		t.Errorf("webhook got %d attempts, want %d", n, testWebhooks.MaxAttempts)
+	}
+	c.expect(http.StatusNoContent, nil, "DELETE", "/api/v1/book/webhooks/dead-letters/"+l.ID.String(), "")
+	c.expect(http.StatusOK, &letters, "GET", "/api/v1/book/webhooks/dead-letters", "")
+	if len(letters) != 0 {
+		t.Errorf("dead letters are %+v after deletion", letters)
+	}
+}
@@ -0,0 +1,226 @@
+// Package auth checks credentials of API clients. Clients use API keys,
+// which are stored hashed, or bearer tokens signed by the server and
+// issued in exchange for a key.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ferux/addressbook/internal/controllers"
+	"github.com/ferux/addressbook/internal/models"
+	"github.com/ferux/addressbook/internal/types"
+)
+
+// DefaultTokenTTL limits the life of tokens when config does not specify it.
+const DefaultTokenTTL = time.Hour
+
+// KeyPrefix starts every API key, so keys can be told apart from tokens.
+const KeyPrefix = "abk_"
+
+// Methods of authentication.
+const (
+	MethodKey   = "key"
+	MethodToken = "token"
+)
+
+// OperatorID is the id of the client with admin key from config. The
+// operator manages tenants and is admin of every tenant.
+const OperatorID = "config"
+
+// minAdminKeyLength protects from guessing the key from config.
+const minAdminKeyLength = 16
+
+var (
+	// ErrInvalidKey reports in case API key is unknown
+	ErrInvalidKey = errors.New("invalid api key")
+	// ErrInvalidToken reports in case token is malformed or its signature is wrong
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrTokenExpired reports in case token is too old
+	ErrTokenExpired = errors.New("token expired")
+	// ErrWrongTenant reports in case token has been issued in another tenant
+	ErrWrongTenant = errors.New("token is issued for another tenant")
+)
+
+// Principal is the client who makes the request. Keys and tokens are valid
+// only in their Tenant, except the ones of the operator. Book is the book
+// of the key, it is shared by keys with the same one.
+type Principal struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Role   string `json:"role"`
+	Book   string `json:"book"`
+	Method string `json:"method"`
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// Can reports whether role of p grants perm.
+func (p *Principal) Can(perm Permission) bool {
+	return RoleCan(p.Role, perm)
+}
+
+// Operator reports whether p is the operator of the service.
+func (p *Principal) Operator() bool {
+	return p.ID == OperatorID
+}
+
+// claims are signed in token.
+type claims struct {
+	Subject   string `json:"sub"`
+	Name      string `json:"name,omitempty"`
+	Role      string `json:"role"`
+	Tenant    string `json:"tenant,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Auth checks keys and tokens.
+type Auth struct {
+	disabled  bool
+	anonymous bool
+	adminKey  string
+	secret    []byte
+	ttl       time.Duration
+}
+
+// New creates Auth configured by conf. Without secret in conf tokens are
+// signed by a random one.
+func New(conf types.Auth) (*Auth, error) {
+	a := &Auth{disabled: conf.Disabled, anonymous: conf.Anonymous, ttl: conf.TokenTTL.Or(DefaultTokenTTL)}
+	if conf.AdminKey != "" {
+		if len(conf.AdminKey) < minAdminKeyLength {
+			return nil, errors.New("auth: admin key is too short")
+		}
+		a.adminKey = HashKey(conf.AdminKey)
+	}
+	a.secret = []byte(conf.TokenSecret)
+	if len(a.secret) == 0 {
+		a.secret = make([]byte, 32)
+		if _, err := rand.Read(a.secret); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// Enabled reports whether requests should be authenticated.
+func (a *Auth) Enabled() bool {
+	return !a.disabled
+}
+
+// Anonymous reports whether requests without credentials are served on
+// books of their sessions.
+func (a *Auth) Anonymous() bool {
+	return a.anonymous
+}
+
+// HashKey returns the hash of key which is stored instead of the key.
+// Keys are random, so there is no need in salt.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewKey generates new key with role. The key itself is returned only
+// once, the record keeps its hash.
+func NewKey(name, role string) (string, *models.APIKey, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	key := KeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, &models.APIKey{
+		Name:      name,
+		Prefix:    key[:len(KeyPrefix)+6],
+		Hash:      HashKey(key),
+		Role:      role,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// IsKey reports whether credential looks like API key rather than token.
+func IsKey(credential string) bool {
+	return strings.HasPrefix(credential, KeyPrefix) || !strings.Contains(credential, ".")
+}
+
+// CheckKey finds the owner of key in keys of tenant. Admin key from config is checked first.
+func (a *Auth) CheckKey(keys controllers.KeyStore, tenant, key string) (*Principal, error) {
+	hash := HashKey(key)
+	if a.adminKey != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(a.adminKey)) == 1 {
+		return &Principal{ID: OperatorID, Name: "admin", Role: models.RoleAdmin, Book: OperatorID, Method: MethodKey}, nil
+	}
+	k, err := keys.FindKey(hash)
+	if err == models.ErrNotFound {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{ID: k.ID.String(), Name: k.Name, Role: k.Role, Book: k.Book, Method: MethodKey, Tenant: tenant}, nil
+}
+
+// Issue signs token for p and returns it with the time it expires.
+func (a *Auth) Issue(p *Principal) (string, time.Time, error) {
+	expires := time.Now().Add(a.ttl).UTC().Truncate(time.Second)
+	payload, err := json.Marshal(claims{Subject: p.ID, Name: p.Name, Role: p.Role, Tenant: p.Tenant, ExpiresAt: expires.Unix()})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	body := base64.RawURLEncoding.EncodeToString(payload)
+	return body + "." + a.sign(body), expires, nil
+}
+
+// Verify checks signature of token issued in tenant and returns its
+// principal. The key of the token is looked up in keys, so tokens of deleted
+// keys are rejected and the current role and book of the key are used.
+func (a *Auth) Verify(keys controllers.KeyStore, tenant, token string) (*Principal, error) {
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 {
+		return nil, ErrInvalidToken
+	}
+	body, sig := token[:i], token[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(a.sign(body))) {
+		return nil, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var c claims
+	if err = json.Unmarshal(payload, &c); err != nil || c.Subject == "" || !models.IsValidRole(c.Role) {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().Unix() >= c.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+	if c.Subject == OperatorID {
+		if a.adminKey == "" {
+			return nil, ErrInvalidToken
+		}
+		return &Principal{ID: OperatorID, Name: c.Name, Role: models.RoleAdmin, Book: OperatorID, Method: MethodToken}, nil
+	}
+	if c.Tenant != tenant {
+		return nil, ErrWrongTenant
+	}
+	k, err := keys.SelectKey(models.ID(c.Subject))
+	if err == models.ErrNotFound {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{ID: c.Subject, Name: k.Name, Role: k.Role, Book: k.Book, Method: MethodToken, Tenant: c.Tenant}, nil
+}
+
+func (a *Auth) sign(body string) string {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(body))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ferux/addressbook/internal/controllers"
+	"github.com/ferux/addressbook/internal/models"
+	"github.com/ferux/addressbook/internal/types"
+)
+
+func newTestAuth(t *testing.T, conf types.Auth) *Auth {
+	a, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// createKey stores new key with role and returns the key.
+func createKey(t *testing.T, keys controllers.KeyStore, role string) (string, *models.APIKey) {
+	key, k, err := NewKey("test", role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = keys.CreateKey(k); err != nil {
+		t.Fatal(err)
+	}
+	return key, k
+}
+
+func TestCheckKey(t *testing.T) {
+	a := newTestAuth(t, types.Auth{AdminKey: "0123456789abcdef"})
+	keys := controllers.NewMemoryKey()
+	key, k := createKey(t, keys, models.RoleViewer)
+	if !strings.HasPrefix(key, KeyPrefix) || k.Hash != HashKey(key) || strings.Contains(k.Hash, key) {
+		t.Errorf("key %s is stored as %+v", key, k)
+	}
+
+	p, err := a.CheckKey(keys, "acme", key)
+	if err != nil || p.ID != k.ID.String() || p.Role != models.RoleViewer || p.Tenant != "acme" || p.Method != MethodKey {
+		t.Errorf("principal of key is %+v, %v", p, err)
+	}
+	if p.Can(PermWrite) || !p.Can(PermRead) {
+		t.Errorf("viewer can write or can't read")
+	}
+	if _, err = a.CheckKey(keys, "", key+"x"); err != ErrInvalidKey {
+		t.Errorf("unknown key is checked with %v", err)
+	}
+	if p, err = a.CheckKey(keys, "acme", "0123456789abcdef"); err != nil || !p.Operator() || !p.Can(PermAdmin) {
+		t.Errorf("principal of admin key is %+v, %v", p, err)
+	}
+	if _, err = New(types.Auth{AdminKey: "short"}); err == nil {
+		t.Error("short admin key is accepted")
+	}
+}
+
+func TestToken(t *testing.T) {
+	a := newTestAuth(t, types.Auth{TokenSecret: "secret"})
+	keys := controllers.NewMemoryKey()
+	key, k := createKey(t, keys, models.RoleEditor)
+	p, err := a.CheckKey(keys, "acme", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, expires, err := a.Issue(p)
+	if err != nil || IsKey(token) || time.Until(expires) > DefaultTokenTTL {
+		t.Fatalf("token %s expires at %s, %v", token, expires, err)
+	}
+	got, err := a.Verify(keys, "acme", token)
+	if err != nil || got.ID != p.ID || got.Role != models.RoleEditor || got.Method != MethodToken {
+		t.Errorf("principal of token is %+v, %v", got, err)
+	}
+
+	// role of the key is taken from storage, not from the token
+	if err = keys.SetKeyRole(k.ID, models.RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = a.Verify(keys, "acme", token); err != nil || got.Role != models.RoleViewer {
+		t.Errorf("principal after role change is %+v, %v", got, err)
+	}
+	if _, err = a.Verify(keys, "other", token); err != ErrWrongTenant {
+		t.Errorf("token of another tenant is verified with %v", err)
+	}
+	if _, err = a.Verify(keys, "acme", token[:len(token)-2]+"xx"); err != ErrInvalidToken {
+		t.Errorf("token with wrong signature is verified with %v", err)
+	}
+	if _, err = newTestAuth(t, types.Auth{TokenSecret: "other"}).Verify(keys, "acme", token); err != ErrInvalidToken {
+		t.Errorf("token signed by another secret is verified with %v", err)
+	}
+	if err = keys.DeleteKey(k.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = a.Verify(keys, "acme", token); err != ErrInvalidToken {
+		t.Errorf("token of deleted key is verified with %v", err)
+	}
+
+	short := newTestAuth(t, types.Auth{TokenTTL: types.Duration(time.Nanosecond)})
+	token, _, err = short.Issue(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = short.Verify(keys, "acme", token); err != ErrTokenExpired {
+		t.Errorf("expired token is verified with %v", err)
+	}
+}
+
+func TestRoleCan(t *testing.T) {
+	tests := []struct {
+		role               string
+		read, write, admin bool
+	}{
+		{models.RoleViewer, true, false, false},
+		{models.RoleEditor, true, true, false},
+		{models.RoleAdmin, true, true, true},
+		{"owner", false, false, false},
+	}
+	for _, tt := range tests {
+		got := []bool{RoleCan(tt.role, PermRead), RoleCan(tt.role, PermWrite), RoleCan(tt.role, PermAdmin)}
+		if got[0] != tt.read || got[1] != tt.write || got[2] != tt.admin {
+			t.Errorf("%s can read, write, admin: %v", tt.role, got)
+		}
+	}
+}
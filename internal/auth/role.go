@@ -0,0 +1,32 @@
+package auth
+
+import "github.com/ferux/addressbook/internal/models"
+
+// Permission is a kind of operations allowed to a role.
+type Permission string
+
+// Permissions checked by API.
+const (
+	// PermRead allows to read users, search, export and sync the book
+	PermRead Permission = "read"
+	// PermWrite allows to create, update, delete, import and claim users
+	PermWrite Permission = "write"
+	// PermAdmin allows to clear the book and to manage keys and their roles
+	PermAdmin Permission = "admin"
+)
+
+// AnonymousRole is the role of requests without credentials when anonymous
+// access is allowed. They work on the books of their sessions only.
+const AnonymousRole = models.RoleEditor
+
+// roles lists permissions granted to each role.
+var roles = map[string]map[Permission]bool{
+	models.RoleViewer: {PermRead: true},
+	models.RoleEditor: {PermRead: true, PermWrite: true},
+	models.RoleAdmin:  {PermRead: true, PermWrite: true, PermAdmin: true},
+}
+
+// RoleCan reports whether role grants perm.
+func RoleCan(role string, perm Permission) bool {
+	return roles[role][perm]
+}
@@ -1,16 +1,75 @@
 package main
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"github.com/ferux/addressbook/internal/api"
+	"github.com/ferux/addressbook/internal/auth"
 	"github.com/ferux/addressbook/internal/db"
 	"github.com/ferux/addressbook/internal/types"
+	"github.com/ferux/addressbook/internal/validation"
+	"github.com/ferux/addressbook/internal/webhook"
 )
 
+// defaultShutdownTimeout limits waiting for active requests on shutdown.
+const defaultShutdownTimeout = time.Second * 30
+
 func run(c *types.Config) error {
+	validator, err := validation.New(c.Validation)
+	if err != nil {
+		return err
+	}
+	authenticator, err := auth.New(c.Auth)
+	if err != nil {
+		return err
+	}
+	switch {
+	case c.Auth.Disabled:
+		logger.Printf("authentication is disabled, everyone can use api")
+	case c.Auth.TokenSecret == "":
+		logger.Printf("token secret is not set, tokens become invalid after restart")
+	}
 	repo, err := db.New(c.Database)
 	if err != nil {
 		return err
 	}
-	api := api.NewAPI(repo, c.API)
-	return api.Run()
+	defer repo.Close()
+	if c.API.Tenants.Enabled {
+		if err = repo.CheckTenants(); err != nil {
+			return err
+		}
+	}
+	hooks := webhook.New(c.Webhooks, repo.AddDeadLetter)
+	// deliveries left on shutdown are stored as dead letters before the repo is closed
+	defer hooks.Close()
+	repo.SetNotifier(hooks)
+	api := api.NewAPI(repo, c.API, validator, authenticator)
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- api.Run()
+	}()
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigc)
+	select {
+	case err = <-errc:
+		return err
+	case sig := <-sigc:
+		logger.Printf("got signal %v, shutting down", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.API.ShutdownTimeout.Or(defaultShutdownTimeout))
+	defer cancel()
+	if err = api.Shutdown(ctx); err != nil {
+		logger.Printf("can't finish active requests: %v", err)
+	}
+	if runErr := <-errc; runErr != nil {
+		return runErr
+	}
+	return err
 }
@@ -1,14 +1,41 @@
 package controllers
 
 import (
+	"context"
+	"database/sql"
+	"sync"
+
 	"github.com/ferux/addressbook"
+	"github.com/ferux/addressbook/internal/models"
 	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 )
 
 // Controller stores connection to database.
 type Controller struct {
-	db     *mgo.Database
-	status addressbook.Code
+	db        *mgo.Database
+	sql       *sql.DB
+	users     *MemoryUser
+	keys      KeyStore
+	revisions *MemoryRevision
+	hooks     *MemoryWebhook
+	journal   *Journal
+	notifier  Notifier
+	status    addressbook.Code
+	ctx       context.Context
+	owner     string
+	// actor and requestID are written to revisions of users.
+	actor     string
+	requestID string
+	tenants   TenantStore
+	// tenant is the id of the tenant the controller works on, it is empty
+	// for the default storage. Collections of the tenant start with prefix.
+	tenant string
+	prefix string
+	quota  models.Quota
+	// quotaLock orders writes checked against quota of memory and SQLite
+	// storages, copies of the controller share it.
+	quotaLock *sync.Mutex
 }
 
 // NewController creates new instance of repo.
@@ -16,7 +43,185 @@ func NewController(db *mgo.Database) *Controller {
 	return &Controller{db: db, status: addressbook.Running}
 }
 
-// User returns User collection.
-func (c *Controller) User() *User {
-	return &User{Collection: c.db.C(userCollection)}
+// NewMemoryController creates new instance of repo which keeps data in memory.
+func NewMemoryController() *Controller {
+	return &Controller{users: NewMemoryUser(), keys: NewMemoryKey(), revisions: NewMemoryRevision(), hooks: NewMemoryWebhook(), tenants: NewMemoryTenant(), status: addressbook.Running, quotaLock: new(sync.Mutex)}
+}
+
+// NewSQLiteController creates new instance of repo which keeps data in SQLite.
+func NewSQLiteController(db *sql.DB) *Controller {
+	return &Controller{sql: db, status: addressbook.Running, quotaLock: new(sync.Mutex)}
+}
+
+// WithJournal makes controller record changes of users to j.
+func (c *Controller) WithJournal(j *Journal) *Controller {
+	c.journal = j
+	return c
+}
+
+// WithNotifier returns a copy of controller which passes changes of users
+// to webhooks of their book through n.
+func (c *Controller) WithNotifier(n Notifier) *Controller {
+	cc := *c
+	cc.notifier = n
+	return &cc
+}
+
+// WithContext returns a copy of controller which stops SQL queries when ctx
+// is done. MongoDB queries can't be cancelled, their session should have
+// timeouts limited by deadline of ctx.
+func (c *Controller) WithContext(ctx context.Context) *Controller {
+	cc := *c
+	cc.ctx = ctx
+	return &cc
+}
+
+// WithOwner returns a copy of controller which works on the book of owner.
+func (c *Controller) WithOwner(owner string) *Controller {
+	cc := *c
+	cc.owner = owner
+	return &cc
+}
+
+// WithActor returns a copy of controller which writes actor and requestID
+// to revisions of users it changes.
+func (c *Controller) WithActor(actor, requestID string) *Controller {
+	cc := *c
+	cc.actor, cc.requestID = actor, requestID
+	return &cc
+}
+
+// Owner returns the owner of the book used by controller.
+func (c *Controller) Owner() string {
+	return c.owner
+}
+
+// WithTenant returns a copy of controller which works on data of t and
+// checks its quota. MongoDB controller switches to the database or the
+// prefix of t, other storages should be opened for t.
+func (c *Controller) WithTenant(t *models.Tenant) *Controller {
+	cc := *c
+	cc.tenant, cc.quota = t.ID, t.Quota
+	if c.db != nil {
+		if t.Database != "" {
+			cc.db = c.db.Session.DB(t.Database)
+		} else {
+			cc.prefix = t.Prefix
+		}
+	}
+	return &cc
+}
+
+// TenantID returns the id of the tenant used by controller. It is empty for the default storage.
+func (c *Controller) TenantID() string {
+	return c.tenant
+}
+
+// Journal returns journal of changes. It may be nil.
+func (c *Controller) Journal() *Journal {
+	return c.journal
+}
+
+// User returns User storage.
+func (c *Controller) User() UserStore {
+	var store UserStore
+	switch {
+	case c.users != nil:
+		store = c.users.WithOwner(c.owner)
+	case c.sql != nil:
+		store = &SQLiteUser{DB: c.sql, Ctx: c.ctx, Owner: c.owner}
+	default:
+		store = &User{Collection: c.db.C(c.prefix + userCollection), Owner: c.owner}
+	}
+	if c.quota.MaxUsers > 0 {
+		store = &quotaUser{UserStore: store, max: c.quota.MaxUsers, count: c.countUsers, reserver: c.reserver()}
+	}
+	store = &historyUser{UserStore: store, revisions: c.Revision(), actor: c.actor, requestID: c.requestID}
+	if c.notifier != nil {
+		store = &notifyingUser{UserStore: store, webhooks: c.Webhook(), notifier: c.notifier, tenant: c.tenant, owner: c.owner}
+	}
+	if c.journal != nil {
+		store = &journaledUser{UserStore: store, journal: c.journal, owner: c.owner}
+	}
+	return store
+}
+
+// Revision returns storage of revisions of users of the book.
+func (c *Controller) Revision() RevisionStore {
+	switch {
+	case c.revisions != nil:
+		return c.revisions.WithOwner(c.owner)
+	case c.sql != nil:
+		return &SQLiteRevision{DB: c.sql, Ctx: c.ctx, Owner: c.owner}
+	}
+	return &Revision{Collection: c.db.C(c.prefix + revisionCollection), Owner: c.owner}
+}
+
+// Webhook returns storage of webhooks of the book.
+func (c *Controller) Webhook() WebhookStore {
+	switch {
+	case c.hooks != nil:
+		return c.hooks.WithOwner(c.owner)
+	case c.sql != nil:
+		return &SQLiteWebhook{DB: c.sql, Ctx: c.ctx, Owner: c.owner}
+	}
+	return &Webhook{Hooks: c.db.C(c.prefix + webhookCollection), Letters: c.db.C(c.prefix + deadLetterCollection), Owner: c.owner}
+}
+
+// Key returns storage of API keys.
+func (c *Controller) Key() KeyStore {
+	var store KeyStore
+	switch {
+	case c.keys != nil:
+		store = c.keys
+	case c.sql != nil:
+		store = &SQLiteKey{DB: c.sql, Ctx: c.ctx}
+	default:
+		store = &Key{Collection: c.db.C(c.prefix + keyCollection)}
+	}
+	if c.quota.MaxKeys > 0 {
+		store = &quotaKey{KeyStore: store, max: c.quota.MaxKeys, reserver: c.reserver()}
+	}
+	return store
+}
+
+// Tenant returns registry of tenants. It is kept in the default storage.
+func (c *Controller) Tenant() TenantStore {
+	switch {
+	case c.tenants != nil:
+		return c.tenants
+	case c.sql != nil:
+		return &SQLiteTenant{DB: c.sql, Ctx: c.ctx}
+	}
+	return &Tenant{Collection: c.db.C(tenantCollection)}
+}
+
+// countUsers returns the amount of users in all books of the storage.
+// Users in trash are not counted.
+func (c *Controller) countUsers() (int, error) {
+	switch {
+	case c.users != nil:
+		return c.users.Count()
+	case c.sql != nil:
+		var n int
+		err := c.sql.QueryRowContext(c.context(), "SELECT COUNT(*) FROM users WHERE deleted_at = 0").Scan(&n)
+		return n, err
+	}
+	return c.db.C(c.prefix + userCollection).Find(bson.M{"deleted_at": bson.M{"$exists": false}}).Count()
+}
+
+// reserver returns the way places in quota are held by writes. MongoDB is
+// shared by instances, so it keeps reservations in the database.
+func (c *Controller) reserver() reserver {
+	if c.db != nil {
+		return &mongoReserver{Collection: c.db.C(c.prefix + reservationCollection)}
+	}
+	return lockReserver{mu: c.quotaLock}
+}
+
+func (c *Controller) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
 }
@@ -0,0 +1,284 @@
+package controllers
+
+import (
+	"sync"
+
+	"github.com/ferux/addressbook/internal/models"
+)
+
+// journalSize is the amount of changes kept by Journal. Journal grows up
+// to twice of this size before old changes are dropped.
+const journalSize = 10000
+
+// subscriptionSize is the amount of events waiting for a subscriber. The
+// subscriber which falls behind more is dropped.
+const subscriptionSize = 256
+
+// EventReset tells subscribers that changes are forgotten and they should
+// read the whole book again.
+const EventReset = "reset"
+
+// Change describes a write of one user of the book of Owner. Type is one
+// of events of models or EventReset.
+type Change struct {
+	Seq   uint64
+	Owner string
+	ID    models.ID
+	Type  string
+}
+
+// Event is a change passed to subscribers of the journal. User is the user
+// after the change, the deleted one has only ID.
+type Event struct {
+	Change
+	User *models.User
+}
+
+// Journal keeps recent changes of users in memory, so clients can ask
+// what has been changed since they synced last time. Journal starts empty
+// on every start of the app, Epoch tells apart journals of different runs.
+// Changes are passed to subscribers of their book as they are recorded.
+type Journal struct {
+	mu      sync.RWMutex
+	epoch   string
+	seq     uint64
+	first   uint64
+	changes []Change
+	subs    map[chan Event]string
+}
+
+// NewJournal creates new empty journal.
+func NewJournal() *Journal {
+	return &Journal{epoch: models.NewID().String(), first: 1, subs: make(map[chan Event]string)}
+}
+
+// Record adds a change of u of type typ and returns its sequence number.
+func (j *Journal) Record(owner, typ string, u *models.User) uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.seq++
+	c := Change{Seq: j.seq, Owner: owner, ID: u.ID, Type: typ}
+	j.changes = append(j.changes, c)
+	if len(j.changes) > 2*journalSize {
+		j.changes = append(j.changes[:0], j.changes[len(j.changes)-journalSize:]...)
+		j.first = j.changes[0].Seq
+	}
+	user := *u
+	j.publish(Event{Change: c, User: &user})
+	return j.seq
+}
+
+// Reset forgets all changes. It is used when all records of a book are
+// moved to trash at once, clients of other books do a full sync after it too.
+func (j *Journal) Reset() {
+	j.mu.Lock()
+	j.seq++
+	j.first = j.seq + 1
+	j.changes = nil
+	j.publish(Event{Change: Change{Seq: j.seq, Type: EventReset}})
+	j.mu.Unlock()
+}
+
+// Subscribe returns events of the book of owner recorded from now on and
+// the function which stops them. The channel is closed when the subscriber
+// falls behind, it should catch up by Since.
+func (j *Journal) Subscribe(owner string) (<-chan Event, func()) {
+	ch := make(chan Event, subscriptionSize)
+	j.mu.Lock()
+	j.subs[ch] = owner
+	j.mu.Unlock()
+	return ch, func() {
+		j.mu.Lock()
+		if _, ok := j.subs[ch]; ok {
+			delete(j.subs, ch)
+			close(ch)
+		}
+		j.mu.Unlock()
+	}
+}
+
+// Watched checks if the book of owner has subscribers.
+func (j *Journal) Watched(owner string) bool {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	for _, o := range j.subs {
+		if o == owner {
+			return true
+		}
+	}
+	return false
+}
+
+// publish passes e to subscribers of its book, reset goes to all of them.
+// j.mu should be locked.
+func (j *Journal) publish(e Event) {
+	for ch, owner := range j.subs {
+		if e.Type != EventReset && owner != e.Owner {
+			continue
+		}
+		select {
+		case ch <- e:
+		default:
+			delete(j.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Since returns the latest change of every user of the book changed after seq.
+// A user created after seq and updated later is reported as created. It
+// returns false if some changes after seq have been forgotten.
+func (j *Journal) Since(owner string, seq uint64) ([]Change, bool) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	if seq+1 < j.first || seq > j.seq {
+		return nil, false
+	}
+	latest := make(map[models.ID]int)
+	changes := make([]Change, 0)
+	for _, c := range j.changes {
+		if c.Seq <= seq || c.Owner != owner {
+			continue
+		}
+		if i, ok := latest[c.ID]; ok {
+			if changes[i].Type == models.EventCreated && c.Type == models.EventUpdated {
+				c.Type = models.EventCreated
+			}
+			changes[i] = c
+			continue
+		}
+		latest[c.ID] = len(changes)
+		changes = append(changes, c)
+	}
+	return changes, true
+}
+
+// Seq returns sequence number of the last change.
+func (j *Journal) Seq() uint64 {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.seq
+}
+
+// Epoch returns identifier of the journal.
+func (j *Journal) Epoch() string {
+	return j.epoch
+}
+
+// journaledUser records every successful write to the journal.
+type journaledUser struct {
+	UserStore
+	journal *Journal
+	owner   string
+}
+
+// CreateUser func
+func (c *journaledUser) CreateUser(u *models.User) (models.ID, error) {
+	id, err := c.UserStore.CreateUser(u)
+	if err == nil {
+		c.journal.Record(c.owner, models.EventCreated, u)
+	}
+	return id, err
+}
+
+// UpdateUser func
+func (c *journaledUser) UpdateUser(u *models.User) (*models.User, error) {
+	before, err := c.UserStore.UpdateUser(u)
+	if err == nil {
+		c.journal.Record(c.owner, models.EventUpdated, u)
+	}
+	return before, err
+}
+
+// PatchUser func
+func (c *journaledUser) PatchUser(id models.ID, patch func(u *models.User) error) (*models.User, error) {
+	u, err := c.UserStore.PatchUser(id, patch)
+	if err == nil {
+		c.journal.Record(c.owner, models.EventUpdated, u)
+	}
+	return u, err
+}
+
+// BatchUsers func
+func (c *journaledUser) BatchUsers(ops []models.BatchOp, atomic bool) ([]error, error) {
+	errs, err := c.UserStore.BatchUsers(ops, atomic)
+	for i := range errs {
+		switch {
+		case errs[i] != nil:
+		case ops[i].Op == models.BatchDelete:
+			c.journal.Record(c.owner, models.EventDeleted, &models.User{ID: ops[i].ID})
+		case ops[i].Op == models.BatchCreate:
+			c.journal.Record(c.owner, models.EventCreated, ops[i].User)
+		case ops[i].Op == models.BatchUpsert:
+			c.journal.Record(c.owner, written(ops[i].Before), ops[i].User)
+		default:
+			c.journal.Record(c.owner, models.EventUpdated, ops[i].User)
+		}
+	}
+	return errs, err
+}
+
+// DeleteUser func
+func (c *journaledUser) DeleteUser(id models.ID) (*models.User, error) {
+	before, err := c.UserStore.DeleteUser(id)
+	if err == nil {
+		c.journal.Record(c.owner, models.EventDeleted, &models.User{ID: id})
+	}
+	return before, err
+}
+
+// RestoreUser func
+func (c *journaledUser) RestoreUser(id models.ID) (*models.User, error) {
+	u, err := c.UserStore.RestoreUser(id)
+	if err == nil {
+		c.journal.Record(c.owner, models.EventCreated, u)
+	}
+	return u, err
+}
+
+// UploadUser func
+func (c *journaledUser) UploadUser(u *models.User) error {
+	err := c.UserStore.UploadUser(u)
+	if err == nil {
+		c.journal.Record(c.owner, models.EventCreated, u)
+	}
+	return err
+}
+
+// UpsertUser func
+func (c *journaledUser) UpsertUser(u *models.User) (*models.User, error) {
+	before, err := c.UserStore.UpsertUser(u)
+	if err == nil {
+		c.journal.Record(c.owner, written(before), u)
+	}
+	return before, err
+}
+
+// CleanRecords func
+func (c *journaledUser) CleanRecords() ([]models.User, error) {
+	trashed, err := c.UserStore.CleanRecords()
+	if err == nil {
+		c.journal.Reset()
+	}
+	return trashed, err
+}
+
+// ClaimUsers records claimed users as deleted from the book of from and
+// created in the book of owner. They are read only if the book is watched.
+func (c *journaledUser) ClaimUsers(from string) (*models.ClaimResult, error) {
+	res, err := c.UserStore.ClaimUsers(from)
+	if res != nil {
+		watched := c.journal.Watched(c.owner)
+		for _, id := range res.Claimed {
+			c.journal.Record(from, models.EventDeleted, &models.User{ID: id})
+			u := &models.User{ID: id}
+			if watched {
+				if claimed, err := c.UserStore.SelectUser(id); err == nil {
+					u = claimed
+				}
+			}
+			c.journal.Record(c.owner, models.EventCreated, u)
+		}
+	}
+	return res, err
+}
@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"github.com/ferux/addressbook/internal/models"
+
+	"gopkg.in/mgo.v2"
+)
+
+const keyCollection = "api_keys"
+
+// KeyStore describes operations over API keys regardless of the storage behind them.
+type KeyStore interface {
+	CreateKey(k *models.APIKey) error
+	ListKeys() ([]models.APIKey, error)
+	FindKey(hash string) (*models.APIKey, error)
+	SelectKey(id models.ID) (*models.APIKey, error)
+	SetKeyRole(id models.ID, role string) error
+	DeleteKey(id models.ID) error
+}
+
+// Key controller keeps API keys in MongoDB collection
+type Key struct{ Collection *mgo.Collection }
+
+var _ KeyStore = (*Key)(nil)
+
+// CreateKey func
+func (c *Key) CreateKey(k *models.APIKey) error {
+	return models.CreateKey(c.Collection, k)
+}
+
+// ListKeys func
+func (c *Key) ListKeys() ([]models.APIKey, error) {
+	return models.ListKeys(c.Collection)
+}
+
+// FindKey func
+func (c *Key) FindKey(hash string) (*models.APIKey, error) {
+	return models.FindKey(c.Collection, hash)
+}
+
+// SelectKey func
+func (c *Key) SelectKey(id models.ID) (*models.APIKey, error) {
+	return models.SelectKey(c.Collection, id)
+}
+
+// SetKeyRole func
+func (c *Key) SetKeyRole(id models.ID, role string) error {
+	return models.SetKeyRole(c.Collection, id, role)
+}
+
+// DeleteKey func
+func (c *Key) DeleteKey(id models.ID) error {
+	return models.DeleteKey(c.Collection, id)
+}
@@ -0,0 +1,786 @@
+package controllers
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ferux/addressbook/internal/models"
+)
+
+// memoryUsers is the storage shared by books of all owners.
+type memoryUsers struct {
+	mu    sync.RWMutex
+	users map[models.ID]models.User
+}
+
+// MemoryUser keeps users of the book of Owner in memory. It is safe for concurrent use.
+type MemoryUser struct {
+	*memoryUsers
+	Owner string
+}
+
+var _ UserStore = (*MemoryUser)(nil)
+
+// NewMemoryUser creates new empty in-memory storage.
+func NewMemoryUser() *MemoryUser {
+	return &MemoryUser{memoryUsers: &memoryUsers{users: make(map[models.ID]models.User)}}
+}
+
+// WithOwner returns the book of owner which shares storage with c.
+func (c *MemoryUser) WithOwner(owner string) *MemoryUser {
+	return &MemoryUser{memoryUsers: c.memoryUsers, Owner: owner}
+}
+
+// exists checks if a user of the book other than u has any phone or email of u. It should be called under lock.
+func (c *MemoryUser) exists(owner string, u *models.User) bool {
+	for _, item := range c.users {
+		if item.Owner != owner || item.ID == u.ID || item.DeletedAt != nil {
+			continue
+		}
+		if intersects(item.PhoneKeys(), u.PhoneKeys()) || intersects(item.EmailValues(), u.EmailValues()) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns user with id of the book unless it is in trash. It should be called under lock.
+func (c *MemoryUser) lookup(id models.ID) (models.User, bool) {
+	u, ok := c.users[id]
+	return u, ok && u.Owner == c.Owner && u.DeletedAt == nil
+}
+
+// CreateUser func
+func (c *MemoryUser) CreateUser(u *models.User) (models.ID, error) {
+	if u == nil {
+		return "", errors.New("Nil pointer to User struct")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.create(u)
+}
+
+// create adds u. It should be called under lock.
+func (c *MemoryUser) create(u *models.User) (models.ID, error) {
+	u.ID = ""
+	if c.exists(c.Owner, u) {
+		return "", models.ErrAlreadyExists
+	}
+	u.ID = models.NewID()
+	u.Owner = c.Owner
+	u.Version = 1
+	u.DeletedAt = nil
+	c.users[u.ID] = *u
+	return u.ID, nil
+}
+
+// UpdateUser func. If u.Version is set it should match the stored one.
+func (c *MemoryUser) UpdateUser(u *models.User) (*models.User, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.update(u)
+}
+
+// update replaces u and returns the user as it was before. It should be called under lock.
+func (c *MemoryUser) update(u *models.User) (*models.User, error) {
+	item, ok := c.lookup(u.ID)
+	if !ok {
+		return nil, models.ErrNotFound
+	}
+	if u.Version != 0 && u.Version != item.Version {
+		return nil, models.ErrVersionMismatch
+	}
+	if c.exists(c.Owner, u) {
+		return nil, models.ErrAlreadyExists
+	}
+	u.Owner = c.Owner
+	u.Version = item.Version + 1
+	u.DeletedAt = nil
+	c.users[u.ID] = *u
+	return &item, nil
+}
+
+// PatchUser patches a copy of the user under lock, so nobody sees it half patched.
+func (c *MemoryUser) PatchUser(id models.ID, patch func(u *models.User) error) (*models.User, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	u, ok := c.lookup(id)
+	if !ok {
+		return nil, models.ErrNotFound
+	}
+	version := u.Version
+	if err := patch(&u); err != nil {
+		return nil, err
+	}
+	u.ID, u.Owner, u.Version, u.DeletedAt = id, c.Owner, version+1, nil
+	if c.exists(c.Owner, &u) {
+		return nil, models.ErrAlreadyExists
+	}
+	c.users[id] = u
+	return &u, nil
+}
+
+// DeleteUser func
+func (c *MemoryUser) DeleteUser(id models.ID) (*models.User, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.delete(id)
+}
+
+// delete moves user with id to trash and returns the user as it was before.
+// It should be called under lock.
+func (c *MemoryUser) delete(id models.ID) (*models.User, error) {
+	item, ok := c.lookup(id)
+	if !ok {
+		return nil, models.ErrNotFound
+	}
+	before := item
+	now := time.Now().UTC()
+	item.DeletedAt = &now
+	item.Version++
+	c.users[id] = item
+	return &before, nil
+}
+
+// SelectUser func
+func (c *MemoryUser) SelectUser(id models.ID) (*models.User, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	u, ok := c.lookup(id)
+	if !ok {
+		return nil, models.ErrNotFound
+	}
+	return &u, nil
+}
+
+// ListTrash returns users of the book in trash, recently deleted go first.
+func (c *MemoryUser) ListTrash() ([]models.User, error) {
+	c.mu.RLock()
+	users := make([]models.User, 0)
+	for _, u := range c.users {
+		if u.Owner == c.Owner && u.DeletedAt != nil {
+			users = append(users, u)
+		}
+	}
+	c.mu.RUnlock()
+	sort.Slice(users, func(i, j int) bool {
+		if !users[i].DeletedAt.Equal(*users[j].DeletedAt) {
+			return users[i].DeletedAt.After(*users[j].DeletedAt)
+		}
+		return users[i].ID < users[j].ID
+	})
+	return users, nil
+}
+
+// RestoreUser moves user out of trash unless another user of the book has its phones or emails.
+func (c *MemoryUser) RestoreUser(id models.ID) (*models.User, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	u, ok := c.users[id]
+	if !ok || u.Owner != c.Owner || u.DeletedAt == nil {
+		return nil, models.ErrNotFound
+	}
+	if c.exists(c.Owner, &u) {
+		return nil, models.ErrAlreadyExists
+	}
+	u.DeletedAt = nil
+	u.Version++
+	c.users[id] = u
+	return &u, nil
+}
+
+// PurgeUser removes user in trash for good.
+func (c *MemoryUser) PurgeUser(id models.ID) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if u, ok := c.users[id]; !ok || u.Owner != c.Owner || u.DeletedAt == nil {
+		return models.ErrNotFound
+	}
+	delete(c.users, id)
+	return nil
+}
+
+// PurgeTrash removes users of all books deleted before the time.
+func (c *MemoryUser) PurgeTrash(before time.Time) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for id, u := range c.users {
+		if u.DeletedAt != nil && u.DeletedAt.Before(before) {
+			delete(c.users, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Count returns the amount of users of all books, ones in trash are not counted.
+func (c *MemoryUser) Count() (int, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	n := 0
+	for _, u := range c.users {
+		if u.DeletedAt == nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// ListUsers returns users ordered by id, which matches the order of insertion.
+func (c *MemoryUser) ListUsers() ([]models.User, error) {
+	c.mu.RLock()
+	users := make([]models.User, 0)
+	for _, u := range c.users {
+		if u.Owner == c.Owner && u.DeletedAt == nil {
+			users = append(users, u)
+		}
+	}
+	c.mu.RUnlock()
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+// FindUsers func
+func (c *MemoryUser) FindUsers(q *models.ListQuery) (*models.UserPage, error) {
+	users, err := c.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+	return q.Apply(users), nil
+}
+
+// SearchUsers ranks all users, there are not too many of them in such storage.
+func (c *MemoryUser) SearchUsers(text string, limit int) ([]models.User, error) {
+	users, err := c.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+	return models.SearchUsers(users, text, limit), nil
+}
+
+// UploadUser func
+func (c *MemoryUser) UploadUser(u *models.User) error {
+	if u == nil {
+		return errors.New("Nil pointer to User struct")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if u.ID == "" {
+		u.ID = models.NewID()
+	}
+	if _, ok := c.users[u.ID]; ok || c.exists(c.Owner, u) {
+		return models.ErrAlreadyExists
+	}
+	u.Owner = c.Owner
+	u.Version = 1
+	u.DeletedAt = nil
+	c.users[u.ID] = *u
+	return nil
+}
+
+// UpsertUser func. IDs are unique across books, ID of a user of another book can't be used.
+func (c *MemoryUser) UpsertUser(u *models.User) (*models.User, error) {
+	if u == nil {
+		return nil, errors.New("Nil pointer to User struct")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.upsert(u)
+}
+
+// upsert adds or replaces u, user in trash is restored. It returns the user
+// as it was before, nil if it is new. It should be called under lock.
+func (c *MemoryUser) upsert(u *models.User) (*models.User, error) {
+	if u.ID == "" {
+		u.ID = models.NewID()
+	}
+	item, ok := c.users[u.ID]
+	if (ok && item.Owner != c.Owner) || c.exists(c.Owner, u) {
+		return nil, models.ErrAlreadyExists
+	}
+	u.Owner = c.Owner
+	u.Version = item.Version + 1
+	u.DeletedAt = nil
+	c.users[u.ID] = *u
+	if !ok {
+		return nil, nil
+	}
+	return &item, nil
+}
+
+// BatchUsers applies ops one by one under lock. Atomic batch is rolled back
+// by restoring users changed before the failed operation.
+func (c *MemoryUser) BatchUsers(ops []models.BatchOp, atomic bool) ([]error, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	errs := make([]error, len(ops))
+	// undo keeps users as they were before the batch, nil for absent ones
+	undo := make(map[models.ID]*models.User)
+	for i := range ops {
+		op := &ops[i]
+		id := op.ID
+		if op.Op == models.BatchUpsert {
+			id = op.User.ID
+		}
+		if _, ok := undo[id]; atomic && !ok && id != "" {
+			if item, ok := c.users[id]; ok {
+				undo[id] = &item
+			} else {
+				undo[id] = nil
+			}
+		}
+		switch op.Op {
+		case models.BatchCreate:
+			_, errs[i] = c.create(op.User)
+		case models.BatchUpdate:
+			op.User.ID = op.ID
+			op.Before, errs[i] = c.update(op.User)
+		case models.BatchUpsert:
+			op.Before, errs[i] = c.upsert(op.User)
+		case models.BatchDelete:
+			op.Before, errs[i] = c.delete(op.ID)
+		}
+		if atomic && errs[i] == nil && id == "" {
+			// created user has got its id now
+			undo[op.User.ID] = nil
+		}
+		if atomic && errs[i] != nil {
+			for id, item := range undo {
+				if item == nil {
+					delete(c.users, id)
+				} else {
+					c.users[id] = *item
+				}
+			}
+			return notApplied(errs, i), nil
+		}
+	}
+	return errs, nil
+}
+
+// CleanRecords func
+func (c *MemoryUser) CleanRecords() ([]models.User, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	trashed := make([]models.User, 0)
+	for id, u := range c.users {
+		if u.Owner == c.Owner && u.DeletedAt == nil {
+			c.delete(id)
+			u = c.users[id]
+			trashed = append(trashed, models.User{ID: id, Version: u.Version, DeletedAt: u.DeletedAt})
+		}
+	}
+	return trashed, nil
+}
+
+// ClaimUsers func
+func (c *MemoryUser) ClaimUsers(from string) (*models.ClaimResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ids := make([]models.ID, 0)
+	for id, u := range c.users {
+		if u.Owner == from {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	res := models.NewClaimResult()
+	for _, id := range ids {
+		u := c.users[id]
+		if c.exists(c.Owner, &u) {
+			res.Skipped = append(res.Skipped, id)
+			continue
+		}
+		u.Owner = c.Owner
+		c.users[id] = u
+		res.Claimed = append(res.Claimed, id)
+	}
+	return res, nil
+}
+
+// intersects checks if a and b have a common non-empty value.
+func intersects(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x != "" && x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// memoryRevisions is the storage of revisions shared by books of all owners.
+type memoryRevisions struct {
+	mu        sync.RWMutex
+	revisions []models.Revision
+}
+
+// MemoryRevision keeps revisions of the book of Owner in memory. It is safe for concurrent use.
+type MemoryRevision struct {
+	*memoryRevisions
+	Owner string
+}
+
+var _ RevisionStore = (*MemoryRevision)(nil)
+
+// NewMemoryRevision creates new empty in-memory storage of revisions.
+func NewMemoryRevision() *MemoryRevision {
+	return &MemoryRevision{memoryRevisions: &memoryRevisions{}}
+}
+
+// WithOwner returns the book of owner which shares storage with c.
+func (c *MemoryRevision) WithOwner(owner string) *MemoryRevision {
+	return &MemoryRevision{memoryRevisions: c.memoryRevisions, Owner: owner}
+}
+
+// AddRevision func
+func (c *MemoryRevision) AddRevision(r *models.Revision) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r.ID = models.NewID()
+	r.Owner = c.Owner
+	c.revisions = append(c.revisions, *r)
+	return nil
+}
+
+// ListRevisions returns revisions of the user, the latest go first.
+func (c *MemoryRevision) ListRevisions(id models.ID) ([]models.Revision, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	revisions := make([]models.Revision, 0)
+	for i := len(c.revisions) - 1; i >= 0; i-- {
+		if r := c.revisions[i]; r.Owner == c.Owner && r.UserID == id {
+			revisions = append(revisions, r)
+		}
+	}
+	return revisions, nil
+}
+
+// SelectRevision returns the last revision of the user which has written the version.
+func (c *MemoryRevision) SelectRevision(id models.ID, version int64) (*models.Revision, error) {
+	return c.last(func(r *models.Revision) bool { return r.UserID == id && r.Version == version })
+}
+
+// RevisionAt returns the last revision of the user written not later than at.
+func (c *MemoryRevision) RevisionAt(id models.ID, at time.Time) (*models.Revision, error) {
+	return c.last(func(r *models.Revision) bool { return r.UserID == id && !r.At.After(at) })
+}
+
+// last returns the latest revision of the book which matches.
+func (c *MemoryRevision) last(match func(r *models.Revision) bool) (*models.Revision, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for i := len(c.revisions) - 1; i >= 0; i-- {
+		if r := c.revisions[i]; r.Owner == c.Owner && match(&r) {
+			return &r, nil
+		}
+	}
+	return nil, models.ErrNotFound
+}
+
+// MoveRevisions func
+func (c *MemoryRevision) MoveRevisions(from string, ids []models.ID) error {
+	moved := make(map[models.ID]bool, len(ids))
+	for _, id := range ids {
+		moved[id] = true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.revisions {
+		if r := &c.revisions[i]; r.Owner == from && moved[r.UserID] {
+			r.Owner = c.Owner
+		}
+	}
+	return nil
+}
+
+// memoryWebhooks is the storage of webhooks and dead letters shared by books of all owners.
+type memoryWebhooks struct {
+	mu      sync.RWMutex
+	hooks   []models.Webhook
+	letters []models.DeadLetter
+}
+
+// MemoryWebhook keeps webhooks of the book of Owner in memory. It is safe for concurrent use.
+type MemoryWebhook struct {
+	*memoryWebhooks
+	Owner string
+}
+
+var _ WebhookStore = (*MemoryWebhook)(nil)
+
+// NewMemoryWebhook creates new empty in-memory storage of webhooks.
+func NewMemoryWebhook() *MemoryWebhook {
+	return &MemoryWebhook{memoryWebhooks: &memoryWebhooks{}}
+}
+
+// WithOwner returns the book of owner which shares storage with c.
+func (c *MemoryWebhook) WithOwner(owner string) *MemoryWebhook {
+	return &MemoryWebhook{memoryWebhooks: c.memoryWebhooks, Owner: owner}
+}
+
+// CreateWebhook func
+func (c *MemoryWebhook) CreateWebhook(h *models.Webhook) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h.ID = models.NewID()
+	h.Owner = c.Owner
+	c.hooks = append(c.hooks, *h)
+	return nil
+}
+
+// ListWebhooks returns webhooks of the book ordered by creation time.
+func (c *MemoryWebhook) ListWebhooks() ([]models.Webhook, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	hooks := make([]models.Webhook, 0)
+	for _, h := range c.hooks {
+		if h.Owner == c.Owner {
+			hooks = append(hooks, h)
+		}
+	}
+	return hooks, nil
+}
+
+// SelectWebhook func
+func (c *MemoryWebhook) SelectWebhook(id models.ID) (*models.Webhook, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if i := c.hook(id); i >= 0 {
+		h := c.hooks[i]
+		return &h, nil
+	}
+	return nil, models.ErrNotFound
+}
+
+// UpdateWebhook changes URL and events of the webhook.
+func (c *MemoryWebhook) UpdateWebhook(h *models.Webhook) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := c.hook(h.ID)
+	if i < 0 {
+		return models.ErrNotFound
+	}
+	c.hooks[i].URL, c.hooks[i].Events = h.URL, h.Events
+	return nil
+}
+
+// DeleteWebhook func
+func (c *MemoryWebhook) DeleteWebhook(id models.ID) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := c.hook(id)
+	if i < 0 {
+		return models.ErrNotFound
+	}
+	c.hooks = append(c.hooks[:i], c.hooks[i+1:]...)
+	return nil
+}
+
+// hook returns index of the webhook of the book with id or -1. c.mu should be locked.
+func (c *MemoryWebhook) hook(id models.ID) int {
+	for i, h := range c.hooks {
+		if h.ID == id && h.Owner == c.Owner {
+			return i
+		}
+	}
+	return -1
+}
+
+// AddDeadLetter func
+func (c *MemoryWebhook) AddDeadLetter(d *models.DeadLetter) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d.ID = models.NewID()
+	d.Owner = c.Owner
+	c.letters = append(c.letters, *d)
+	return nil
+}
+
+// ListDeadLetters returns failed deliveries of the book, the latest go first.
+func (c *MemoryWebhook) ListDeadLetters() ([]models.DeadLetter, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	letters := make([]models.DeadLetter, 0)
+	for i := len(c.letters) - 1; i >= 0; i-- {
+		if d := c.letters[i]; d.Owner == c.Owner {
+			letters = append(letters, d)
+		}
+	}
+	return letters, nil
+}
+
+// DeleteDeadLetter func
+func (c *MemoryWebhook) DeleteDeadLetter(id models.ID) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, d := range c.letters {
+		if d.ID == id && d.Owner == c.Owner {
+			c.letters = append(c.letters[:i], c.letters[i+1:]...)
+			return nil
+		}
+	}
+	return models.ErrNotFound
+}
+
+// MemoryKey keeps API keys in memory. It is safe for concurrent use.
+type MemoryKey struct {
+	mu   sync.RWMutex
+	keys map[models.ID]models.APIKey
+}
+
+var _ KeyStore = (*MemoryKey)(nil)
+
+// NewMemoryKey creates new empty in-memory storage of keys.
+func NewMemoryKey() *MemoryKey {
+	return &MemoryKey{keys: make(map[models.ID]models.APIKey)}
+}
+
+// CreateKey func
+func (c *MemoryKey) CreateKey(k *models.APIKey) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, item := range c.keys {
+		if item.Hash == k.Hash {
+			return models.ErrAlreadyExists
+		}
+	}
+	k.ID = models.NewID()
+	k.Normalize()
+	c.keys[k.ID] = *k
+	return nil
+}
+
+// ListKeys returns keys ordered by id, which matches the order of creation.
+func (c *MemoryKey) ListKeys() ([]models.APIKey, error) {
+	c.mu.RLock()
+	keys := make([]models.APIKey, 0, len(c.keys))
+	for _, k := range c.keys {
+		keys = append(keys, k)
+	}
+	c.mu.RUnlock()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, nil
+}
+
+// FindKey func
+func (c *MemoryKey) FindKey(hash string) (*models.APIKey, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, k := range c.keys {
+		if k.Hash == hash {
+			return &k, nil
+		}
+	}
+	return nil, models.ErrNotFound
+}
+
+// SelectKey func
+func (c *MemoryKey) SelectKey(id models.ID) (*models.APIKey, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	k, ok := c.keys[id]
+	if !ok {
+		return nil, models.ErrNotFound
+	}
+	return &k, nil
+}
+
+// SetKeyRole func
+func (c *MemoryKey) SetKeyRole(id models.ID, role string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k, ok := c.keys[id]
+	if !ok {
+		return models.ErrNotFound
+	}
+	k.Role, k.Admin = role, false
+	c.keys[id] = k
+	return nil
+}
+
+// DeleteKey func
+func (c *MemoryKey) DeleteKey(id models.ID) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.keys[id]; !ok {
+		return models.ErrNotFound
+	}
+	delete(c.keys, id)
+	return nil
+}
+
+// MemoryTenant keeps registry of tenants in memory. It is safe for concurrent use.
+type MemoryTenant struct {
+	mu      sync.RWMutex
+	tenants map[string]models.Tenant
+}
+
+var _ TenantStore = (*MemoryTenant)(nil)
+
+// NewMemoryTenant creates new empty in-memory registry of tenants.
+func NewMemoryTenant() *MemoryTenant {
+	return &MemoryTenant{tenants: make(map[string]models.Tenant)}
+}
+
+// CreateTenant func
+func (c *MemoryTenant) CreateTenant(t *models.Tenant) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.tenants[t.ID]; ok {
+		return models.ErrAlreadyExists
+	}
+	c.tenants[t.ID] = *t
+	return nil
+}
+
+// ListTenants returns tenants ordered by id.
+func (c *MemoryTenant) ListTenants() ([]models.Tenant, error) {
+	c.mu.RLock()
+	tenants := make([]models.Tenant, 0, len(c.tenants))
+	for _, t := range c.tenants {
+		tenants = append(tenants, t)
+	}
+	c.mu.RUnlock()
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].ID < tenants[j].ID })
+	return tenants, nil
+}
+
+// SelectTenant func
+func (c *MemoryTenant) SelectTenant(id string) (*models.Tenant, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	t, ok := c.tenants[id]
+	if !ok {
+		return nil, models.ErrNotFound
+	}
+	return &t, nil
+}
+
+// UpdateTenant updates name and quota of the tenant.
+func (c *MemoryTenant) UpdateTenant(t *models.Tenant) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item, ok := c.tenants[t.ID]
+	if !ok {
+		return models.ErrNotFound
+	}
+	item.Name, item.Quota = t.Name, t.Quota
+	c.tenants[t.ID] = item
+	return nil
+}
+
+// DeleteTenant func
+func (c *MemoryTenant) DeleteTenant(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.tenants[id]; !ok {
+		return models.ErrNotFound
+	}
+	delete(c.tenants, id)
+	return nil
+}
@@ -0,0 +1,218 @@
+package controllers
+
+import (
+	"time"
+
+	"github.com/ferux/addressbook/internal/models"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+)
+
+const revisionCollection = "revisions"
+
+// RevisionStore describes operations over revisions of users of a book
+// regardless of the storage behind them. Revisions are never changed.
+type RevisionStore interface {
+	AddRevision(r *models.Revision) error
+	ListRevisions(id models.ID) ([]models.Revision, error)
+	SelectRevision(id models.ID, version int64) (*models.Revision, error)
+	RevisionAt(id models.ID, at time.Time) (*models.Revision, error)
+	MoveRevisions(from string, ids []models.ID) error
+}
+
+// Revision controller keeps revisions of the book of Owner in MongoDB collection
+type Revision struct {
+	Collection *mgo.Collection
+	Owner      string
+}
+
+var _ RevisionStore = (*Revision)(nil)
+
+// AddRevision func
+func (c *Revision) AddRevision(r *models.Revision) error {
+	return models.AddRevision(c.Collection, c.Owner, r)
+}
+
+// ListRevisions func
+func (c *Revision) ListRevisions(id models.ID) ([]models.Revision, error) {
+	return models.ListRevisions(c.Collection, c.Owner, id)
+}
+
+// SelectRevision func
+func (c *Revision) SelectRevision(id models.ID, version int64) (*models.Revision, error) {
+	return models.SelectRevision(c.Collection, c.Owner, id, version)
+}
+
+// RevisionAt func
+func (c *Revision) RevisionAt(id models.ID, at time.Time) (*models.Revision, error) {
+	return models.RevisionAt(c.Collection, c.Owner, id, at)
+}
+
+// MoveRevisions func
+func (c *Revision) MoveRevisions(from string, ids []models.ID) error {
+	return models.MoveRevisions(c.Collection, c.Owner, from, ids)
+}
+
+// historyLogger reports revisions which can't be stored.
+var historyLogger = logrus.New().WithFields(logrus.Fields{
+	"package": "controllers",
+	"entity":  "history",
+})
+
+// historyUser records a revision of every successful write. Storage returns
+// users as they were before the write to find changed fields. The write has
+// happened when its revision is recorded, so a revision which can't be
+// stored is logged and the write still succeeds.
+type historyUser struct {
+	UserStore
+	revisions RevisionStore
+	actor     string
+	requestID string
+}
+
+// record stores revision of the user with id. after is nil for deletion.
+func (c *historyUser) record(action string, id models.ID, version int64, changes []models.Change, after *models.User) {
+	r := &models.Revision{
+		UserID:    id,
+		Version:   version,
+		Action:    action,
+		Actor:     c.actor,
+		RequestID: c.requestID,
+		At:        time.Now().UTC(),
+		Changes:   changes,
+	}
+	if r.Changes == nil {
+		r.Changes = make([]models.Change, 0)
+	}
+	if after != nil {
+		u := *after
+		r.User = &u
+	}
+	if err := c.revisions.AddRevision(r); err != nil {
+		historyLogger.WithFields(logrus.Fields{
+			"requestID": c.requestID,
+			"fn":        "record",
+			"user":      id,
+			"action":    action,
+		}).WithError(err).Error("can't store revision")
+	}
+}
+
+// recordWrite stores revision of written user u, before is nil for new one.
+// User written over the one in trash is restored.
+func (c *historyUser) recordWrite(before, u *models.User) {
+	action := models.ActionUpdate
+	switch {
+	case before == nil:
+		action = models.ActionCreate
+	case before.DeletedAt != nil:
+		action = models.ActionRestore
+	}
+	c.record(action, u.ID, u.Version, models.Diff(before, u), u)
+}
+
+// CreateUser func
+func (c *historyUser) CreateUser(u *models.User) (models.ID, error) {
+	id, err := c.UserStore.CreateUser(u)
+	if err == nil {
+		c.recordWrite(nil, u)
+	}
+	return id, err
+}
+
+// UpdateUser func
+func (c *historyUser) UpdateUser(u *models.User) (*models.User, error) {
+	before, err := c.UserStore.UpdateUser(u)
+	if err == nil {
+		c.recordWrite(before, u)
+	}
+	return before, err
+}
+
+// PatchUser func. The user is captured when the storage passes it to patch.
+func (c *historyUser) PatchUser(id models.ID, patch func(u *models.User) error) (*models.User, error) {
+	var before models.User
+	u, err := c.UserStore.PatchUser(id, func(u *models.User) error {
+		before = *u
+		return patch(u)
+	})
+	if err == nil {
+		c.recordWrite(&before, u)
+	}
+	return u, err
+}
+
+// BatchUsers func
+func (c *historyUser) BatchUsers(ops []models.BatchOp, atomic bool) ([]error, error) {
+	errs, err := c.UserStore.BatchUsers(ops, atomic)
+	for i := range errs {
+		switch {
+		case errs[i] != nil:
+		case ops[i].Op == models.BatchDelete:
+			c.record(models.ActionDelete, ops[i].ID, ops[i].Before.Version+1, nil, nil)
+		default:
+			c.recordWrite(ops[i].Before, ops[i].User)
+		}
+	}
+	return errs, err
+}
+
+// DeleteUser func
+func (c *historyUser) DeleteUser(id models.ID) (*models.User, error) {
+	before, err := c.UserStore.DeleteUser(id)
+	if err == nil {
+		c.record(models.ActionDelete, id, before.Version+1, nil, nil)
+	}
+	return before, err
+}
+
+// RestoreUser func
+func (c *historyUser) RestoreUser(id models.ID) (*models.User, error) {
+	u, err := c.UserStore.RestoreUser(id)
+	if err == nil {
+		c.record(models.ActionRestore, id, u.Version, nil, u)
+	}
+	return u, err
+}
+
+// UploadUser func
+func (c *historyUser) UploadUser(u *models.User) error {
+	err := c.UserStore.UploadUser(u)
+	if err == nil {
+		c.recordWrite(nil, u)
+	}
+	return err
+}
+
+// UpsertUser func
+func (c *historyUser) UpsertUser(u *models.User) (*models.User, error) {
+	before, err := c.UserStore.UpsertUser(u)
+	if err == nil {
+		c.recordWrite(before, u)
+	}
+	return before, err
+}
+
+// CleanRecords records deletion of every user moved to trash.
+func (c *historyUser) CleanRecords() ([]models.User, error) {
+	trashed, err := c.UserStore.CleanRecords()
+	for i := range trashed {
+		c.record(models.ActionDelete, trashed[i].ID, trashed[i].Version, nil, nil)
+	}
+	return trashed, err
+}
+
+// ClaimUsers moves revisions of claimed users along with them.
+func (c *historyUser) ClaimUsers(from string) (*models.ClaimResult, error) {
+	res, err := c.UserStore.ClaimUsers(from)
+	if res != nil {
+		if merr := c.revisions.MoveRevisions(from, res.Claimed); merr != nil {
+			historyLogger.WithFields(logrus.Fields{
+				"requestID": c.requestID,
+				"fn":        "ClaimUsers",
+			}).WithError(merr).Error("can't move revisions")
+		}
+	}
+	return res, err
+}
@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/ferux/addressbook/internal/models"
+)
+
+// testNotifier keeps events passed to webhooks.
+type testNotifier struct {
+	events []models.Event
+}
+
+func (n *testNotifier) Notify(tenant, owner string, hooks []models.Webhook, e *models.Event) {
+	n.events = append(n.events, *e)
+}
+
+// testControllers returns empty controllers with journals which work without a server.
+func testControllers(t *testing.T) map[string]*Controller {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+	if err = MigrateSQLite(db); err != nil {
+		t.Fatal(err)
+	}
+	return map[string]*Controller{
+		"memory": NewMemoryController().WithJournal(NewJournal()),
+		"sqlite": NewSQLiteController(db).WithJournal(NewJournal()),
+	}
+}
+
+func TestUpsertRestoresUser(t *testing.T) {
+	for name, c := range testControllers(t) {
+		t.Run(name, func(t *testing.T) {
+			n := &testNotifier{}
+			c = c.WithOwner("ann").WithNotifier(n)
+			if err := c.Webhook().CreateWebhook(&models.Webhook{URL: "http://example.com"}); err != nil {
+				t.Fatal(err)
+			}
+			users := c.User()
+			ann, bob := testUser("Ann", "Lee", ""), testUser("Bob", "Lee", "")
+			for _, u := range []*models.User{ann, bob} {
+				if _, err := users.CreateUser(u); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := users.DeleteUser(u.ID); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			seq := c.Journal().Seq()
+			n.events = nil
+			ann.Version, bob.Version = 0, 0
+			if _, err := users.UpsertUser(ann); err != nil {
+				t.Fatal(err)
+			}
+			errs, err := users.BatchUsers([]models.BatchOp{{Op: models.BatchUpsert, User: bob}}, false)
+			if err != nil || errs[0] != nil {
+				t.Fatalf("batch returns %v, %v", errs, err)
+			}
+
+			for _, u := range []*models.User{ann, bob} {
+				revisions, err := c.Revision().ListRevisions(u.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if r := revisions[0]; r.Action != models.ActionRestore || r.Version != 3 {
+					t.Errorf("revision of upsert of %s is %s of version %d, want restore of version 3", u.FirstName, r.Action, r.Version)
+				}
+			}
+			if len(n.events) != 2 || n.events[0].Type != models.EventCreated || n.events[1].Type != models.EventCreated {
+				t.Errorf("webhooks get %+v, want two created events", n.events)
+			}
+			changes, _ := c.Journal().Since("ann", seq)
+			if len(changes) != 2 || changes[0].Type != models.EventCreated || changes[1].Type != models.EventCreated {
+				t.Errorf("journal has %+v, want two created changes", changes)
+			}
+		})
+	}
+}
@@ -0,0 +1,1352 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ferux/addressbook/internal/models"
+)
+
+// SQLiteSchema creates tables used by SQLiteUser. It is safe to run it on every start.
+// Databases created by previous versions are upgraded by MigrateSQLite.
+const SQLiteSchema = `
+CREATE TABLE IF NOT EXISTS users (
+	id           TEXT PRIMARY KEY,
+	first_name   TEXT NOT NULL DEFAULT '',
+	last_name    TEXT NOT NULL DEFAULT '',
+	birthday     TEXT NOT NULL DEFAULT '',
+	organization TEXT NOT NULL DEFAULT '',
+	job_title    TEXT NOT NULL DEFAULT '',
+	website      TEXT NOT NULL DEFAULT '',
+	notes        TEXT NOT NULL DEFAULT '',
+	owner        TEXT NOT NULL DEFAULT '',
+	version      INTEGER NOT NULL DEFAULT 1,
+	deleted_at   INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS user_emails (
+	user_id TEXT NOT NULL,
+	pos     INTEGER NOT NULL,
+	label   TEXT NOT NULL DEFAULT '',
+	value   TEXT NOT NULL,
+	PRIMARY KEY (user_id, pos)
+);
+CREATE INDEX IF NOT EXISTS user_emails_value ON user_emails (value);
+CREATE TABLE IF NOT EXISTS user_phones (
+	user_id TEXT NOT NULL,
+	pos     INTEGER NOT NULL,
+	label   TEXT NOT NULL DEFAULT '',
+	value   TEXT NOT NULL,
+	e164    TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (user_id, pos)
+);
+CREATE INDEX IF NOT EXISTS user_phones_value ON user_phones (value);
+CREATE TABLE IF NOT EXISTS user_addresses (
+	user_id     TEXT NOT NULL,
+	pos         INTEGER NOT NULL,
+	label       TEXT NOT NULL DEFAULT '',
+	street      TEXT NOT NULL DEFAULT '',
+	city        TEXT NOT NULL DEFAULT '',
+	region      TEXT NOT NULL DEFAULT '',
+	postal_code TEXT NOT NULL DEFAULT '',
+	country     TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (user_id, pos)
+);
+CREATE TABLE IF NOT EXISTS revisions (
+	id         TEXT PRIMARY KEY,
+	user_id    TEXT NOT NULL,
+	owner      TEXT NOT NULL DEFAULT '',
+	version    INTEGER NOT NULL DEFAULT 0,
+	action     TEXT NOT NULL,
+	actor      TEXT NOT NULL DEFAULT '',
+	request_id TEXT NOT NULL DEFAULT '',
+	at         INTEGER NOT NULL,
+	changes    TEXT NOT NULL DEFAULT '[]',
+	snapshot   TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS revisions_user ON revisions (owner, user_id, at);
+CREATE TABLE IF NOT EXISTS webhooks (
+	id         TEXT PRIMARY KEY,
+	owner      TEXT NOT NULL DEFAULT '',
+	url        TEXT NOT NULL,
+	secret     TEXT NOT NULL,
+	events     TEXT NOT NULL DEFAULT '[]',
+	created_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS webhooks_owner ON webhooks (owner);
+CREATE TABLE IF NOT EXISTS dead_letters (
+	id         TEXT PRIMARY KEY,
+	owner      TEXT NOT NULL DEFAULT '',
+	webhook_id TEXT NOT NULL,
+	url        TEXT NOT NULL,
+	event      TEXT NOT NULL,
+	attempts   INTEGER NOT NULL DEFAULT 0,
+	error      TEXT NOT NULL DEFAULT '',
+	failed_at  INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS dead_letters_owner ON dead_letters (owner, failed_at);
+CREATE TABLE IF NOT EXISTS tenants (
+	id         TEXT PRIMARY KEY,
+	name       TEXT NOT NULL DEFAULT '',
+	max_users  INTEGER NOT NULL DEFAULT 0,
+	max_keys   INTEGER NOT NULL DEFAULT 0,
+	created_at INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS api_keys (
+	id         TEXT PRIMARY KEY,
+	name       TEXT NOT NULL DEFAULT '',
+	prefix     TEXT NOT NULL DEFAULT '',
+	hash       TEXT NOT NULL UNIQUE,
+	admin      INTEGER NOT NULL DEFAULT 0,
+	created_at INTEGER NOT NULL,
+	role       TEXT NOT NULL DEFAULT '',
+	book       TEXT NOT NULL DEFAULT ''
+);
+`
+
+const (
+	userColumns      = "id, first_name, last_name, birthday, organization, job_title, website, notes, owner, version, deleted_at"
+	userPlaceholders = "?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?"
+)
+
+// userListTables lists tables which keep emails, phones and addresses of users.
+var userListTables = []string{"user_emails", "user_phones", "user_addresses"}
+
+// MigrateSQLite creates tables and moves single email and phone columns of
+// the previous schema into the lists.
+func MigrateSQLite(db *sql.DB) error {
+	if _, err := db.Exec(SQLiteSchema); err != nil {
+		return err
+	}
+	columns, err := tableColumns(db, "users")
+	if err != nil {
+		return err
+	}
+	phoneColumns, err := tableColumns(db, "user_phones")
+	if err != nil {
+		return err
+	}
+	keyColumns, err := tableColumns(db, "api_keys")
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, column := range strings.Split(userColumns, ", ") {
+		if columns[column] {
+			continue
+		}
+		// versions of existing users start from 1, 0 means that the writer does not expect any
+		kind := "TEXT NOT NULL DEFAULT ''"
+		switch column {
+		case "version":
+			kind = "INTEGER NOT NULL DEFAULT 1"
+		case "deleted_at":
+			kind = "INTEGER NOT NULL DEFAULT 0"
+		}
+		if _, err = tx.Exec("ALTER TABLE users ADD COLUMN " + column + " " + kind); err != nil {
+			return err
+		}
+	}
+	if !phoneColumns["e164"] {
+		if _, err = tx.Exec("ALTER TABLE user_phones ADD COLUMN e164 TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+	}
+	// keys created before roles keep admin column, their role is derived from it
+	if !keyColumns["role"] {
+		if _, err = tx.Exec("ALTER TABLE api_keys ADD COLUMN role TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+	}
+	// keys created before books were shared have their own books
+	if !keyColumns["book"] {
+		if _, err = tx.Exec("ALTER TABLE api_keys ADD COLUMN book TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+	}
+	if _, err = tx.Exec("CREATE INDEX IF NOT EXISTS user_phones_e164 ON user_phones (e164)"); err != nil {
+		return err
+	}
+	if _, err = tx.Exec("CREATE INDEX IF NOT EXISTS users_owner ON users (owner)"); err != nil {
+		return err
+	}
+	if _, err = tx.Exec("CREATE INDEX IF NOT EXISTS users_deleted_at ON users (deleted_at)"); err != nil {
+		return err
+	}
+	// old columns are kept empty, they can't be dropped by older versions of sqlite
+	for column, table := range map[string]string{"email": "user_emails", "phone": "user_phones"} {
+		if !columns[column] {
+			continue
+		}
+		_, err = tx.Exec(
+			"INSERT OR IGNORE INTO "+table+" (user_id, pos, label, value) "+
+				"SELECT id, 0, ?, "+column+" FROM users WHERE "+column+" <> ''",
+			models.LabelOther,
+		)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec("UPDATE users SET " + column + " = '' WHERE " + column + " <> ''"); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// tableColumns returns names of columns of table.
+func tableColumns(db *sql.DB, table string) (map[string]bool, error) {
+	rows, err := db.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, kind       string
+			value            sql.NullString
+		)
+		if err = rows.Scan(&cid, &name, &kind, &notNull, &value, &pk); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
+// SQLiteUser keeps users of the book of Owner in SQLite database. Queries
+// are cancelled when Ctx is done, nil Ctx never expires.
+type SQLiteUser struct {
+	DB    *sql.DB
+	Ctx   context.Context
+	Owner string
+}
+
+var _ UserStore = (*SQLiteUser)(nil)
+
+func (c *SQLiteUser) context() context.Context {
+	if c.Ctx == nil {
+		return context.Background()
+	}
+	return c.Ctx
+}
+
+// CreateUser func
+func (c *SQLiteUser) CreateUser(u *models.User) (models.ID, error) {
+	if u == nil {
+		return "", errors.New("Nil pointer to User struct")
+	}
+	tx, err := c.DB.BeginTx(c.context(), nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	if err = c.createUser(tx, u); err != nil {
+		return "", err
+	}
+	return u.ID, tx.Commit()
+}
+
+// createUser inserts u with new id if no user of the book has its phones or emails.
+func (c *SQLiteUser) createUser(tx *sql.Tx, u *models.User) error {
+	u.ID = ""
+	if err := checkUnique(tx, c.Owner, u); err != nil {
+		return err
+	}
+	u.ID = models.NewID()
+	u.Owner = c.Owner
+	u.Version = 1
+	u.DeletedAt = nil
+	if _, err := tx.Exec("INSERT INTO users ("+userColumns+") VALUES ("+userPlaceholders+")", userValues(u)...); err != nil {
+		return err
+	}
+	return saveLists(tx, u)
+}
+
+// UpdateUser func. If u.Version is set it should match the stored one.
+func (c *SQLiteUser) UpdateUser(u *models.User) (*models.User, error) {
+	tx, err := c.DB.BeginTx(c.context(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	before, err := c.updateUser(tx, u)
+	if err != nil {
+		return nil, err
+	}
+	return before, tx.Commit()
+}
+
+// PatchUser reads, patches and writes the user in one transaction.
+func (c *SQLiteUser) PatchUser(id models.ID, patch func(u *models.User) error) (*models.User, error) {
+	tx, err := c.DB.BeginTx(c.context(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	u, err := c.selectUser(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	version := u.Version
+	if err = patch(u); err != nil {
+		return nil, err
+	}
+	u.ID, u.Version = id, version
+	if _, err = c.updateUser(tx, u); err != nil {
+		return nil, err
+	}
+	return u, tx.Commit()
+}
+
+// updateUser replaces the fields and the lists of u and increments its
+// version. It returns the user as it was before.
+func (c *SQLiteUser) updateUser(tx *sql.Tx, u *models.User) (*models.User, error) {
+	before, err := c.selectUser(tx, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	if u.Version != 0 && u.Version != before.Version {
+		return nil, models.ErrVersionMismatch
+	}
+	if err = checkUnique(tx, c.Owner, u); err != nil {
+		return nil, err
+	}
+	u.Owner = c.Owner
+	u.Version = before.Version + 1
+	u.DeletedAt = nil
+	_, err = tx.Exec(
+		"UPDATE users SET first_name = ?, last_name = ?, birthday = ?, organization = ?, job_title = ?, website = ?, notes = ?, version = ? WHERE id = ? AND owner = ?",
+		u.FirstName, u.LastName, u.Birthday, u.Organization, u.JobTitle, u.Website, u.Notes, u.Version, string(u.ID), c.Owner,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err = saveLists(tx, u); err != nil {
+		return nil, err
+	}
+	return before, nil
+}
+
+// DeleteUser func
+func (c *SQLiteUser) DeleteUser(id models.ID) (*models.User, error) {
+	tx, err := c.DB.BeginTx(c.context(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	before, err := c.deleteUser(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	return before, tx.Commit()
+}
+
+// deleteUser moves user with id to trash, its lists are kept until it is
+// purged. It returns the user as it was before.
+func (c *SQLiteUser) deleteUser(tx *sql.Tx, id models.ID) (*models.User, error) {
+	before, err := c.selectUser(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(
+		"UPDATE users SET deleted_at = ?, version = version + 1 WHERE id = ? AND owner = ?",
+		time.Now().Unix(), string(id), c.Owner,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return before, nil
+}
+
+// SelectUser func
+func (c *SQLiteUser) SelectUser(id models.ID) (*models.User, error) {
+	return c.selectUser(c.DB, id)
+}
+
+// selectUser reads user with its lists by q unless it is in trash.
+func (c *SQLiteUser) selectUser(q querier, id models.ID) (*models.User, error) {
+	return c.selectWhere(q, id, "deleted_at = 0")
+}
+
+// selectWhere reads user with its lists by q if it matches cond.
+func (c *SQLiteUser) selectWhere(q querier, id models.ID, cond string) (*models.User, error) {
+	u, err := scanUser(q.QueryRowContext(c.context(), "SELECT "+userColumns+" FROM users WHERE id = ? AND owner = ? AND "+cond, string(id), c.Owner))
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	users := []models.User{*u}
+	if err = c.loadLists(q, users, "?", string(id)); err != nil {
+		return nil, err
+	}
+	return &users[0], nil
+}
+
+// ListUsers func
+func (c *SQLiteUser) ListUsers() ([]models.User, error) {
+	users, err := c.queryUsers("SELECT "+userColumns+" FROM users WHERE owner = ? AND deleted_at = 0 ORDER BY id", c.Owner)
+	if err != nil {
+		return nil, err
+	}
+	return users, c.loadLists(c.DB, users, "SELECT id FROM users WHERE owner = ? AND deleted_at = 0", c.Owner)
+}
+
+// ListTrash returns users of the book in trash, recently deleted go first.
+func (c *SQLiteUser) ListTrash() ([]models.User, error) {
+	users, err := c.queryUsers("SELECT "+userColumns+" FROM users WHERE owner = ? AND deleted_at <> 0 ORDER BY deleted_at DESC, id", c.Owner)
+	if err != nil {
+		return nil, err
+	}
+	return users, c.loadLists(c.DB, users, "SELECT id FROM users WHERE owner = ? AND deleted_at <> 0", c.Owner)
+}
+
+// RestoreUser moves user out of trash unless another user of the book has its phones or emails.
+func (c *SQLiteUser) RestoreUser(id models.ID) (*models.User, error) {
+	tx, err := c.DB.BeginTx(c.context(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	u, err := c.selectWhere(tx, id, "deleted_at <> 0")
+	if err != nil {
+		return nil, err
+	}
+	if err = checkUnique(tx, c.Owner, u); err != nil {
+		return nil, err
+	}
+	if _, err = tx.Exec("UPDATE users SET deleted_at = 0, version = version + 1 WHERE id = ?", string(id)); err != nil {
+		return nil, err
+	}
+	u.DeletedAt = nil
+	u.Version++
+	return u, tx.Commit()
+}
+
+// PurgeUser removes user in trash and its lists for good.
+func (c *SQLiteUser) PurgeUser(id models.ID) error {
+	tx, err := c.DB.BeginTx(c.context(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("DELETE FROM users WHERE id = ? AND owner = ? AND deleted_at <> 0", string(id), c.Owner)
+	if err = affected(res, err, models.ErrNotFound); err != nil {
+		return err
+	}
+	if err = deleteLists(tx, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PurgeTrash removes users of all books deleted before the time.
+func (c *SQLiteUser) PurgeTrash(before time.Time) (int, error) {
+	tx, err := c.DB.BeginTx(c.context(), nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	const purged = "SELECT id FROM users WHERE deleted_at <> 0 AND deleted_at < ?"
+	for _, table := range userListTables {
+		if _, err = tx.Exec("DELETE FROM "+table+" WHERE user_id IN ("+purged+")", before.Unix()); err != nil {
+			return 0, err
+		}
+	}
+	res, err := tx.Exec("DELETE FROM users WHERE id IN ("+purged+")", before.Unix())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), tx.Commit()
+}
+
+// queryUsers returns users selected by query without their lists.
+func (c *SQLiteUser) queryUsers(query string, args ...interface{}) ([]models.User, error) {
+	rows, err := c.DB.QueryContext(c.context(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := make([]models.User, 0)
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *u)
+	}
+	return users, rows.Err()
+}
+
+// sqlField returns SQL expression of query field. Filters on emails and
+// phones are checked separately, sorting uses the first one.
+func sqlField(name string) string {
+	switch name {
+	case models.FieldEmail:
+		return "COALESCE((SELECT value FROM user_emails WHERE user_id = users.id ORDER BY pos LIMIT 1), '')"
+	case models.FieldPhone:
+		return "COALESCE((SELECT value FROM user_phones WHERE user_id = users.id ORDER BY pos LIMIT 1), '')"
+	default:
+		return name
+	}
+}
+
+// FindUsers func
+func (c *SQLiteUser) FindUsers(q *models.ListQuery) (*models.UserPage, error) {
+	where := []string{"owner = ?", "deleted_at = 0"}
+	args := []interface{}{c.Owner}
+	for _, f := range q.Filters {
+		cond := f.Field + " = ?"
+		if f.Prefix {
+			cond = "substr(" + f.Field + ", 1, length(?)) = ?"
+			args = append(args, f.Value)
+		}
+		switch f.Field {
+		case models.FieldEmail:
+			cond = "id IN (SELECT user_id FROM user_emails WHERE " + strings.Replace(cond, f.Field, "value", 1) + ")"
+		case models.FieldPhone:
+			cond = "id IN (SELECT user_id FROM user_phones WHERE " + strings.Replace(cond, f.Field, "value", 1) + ")"
+		}
+		where = append(where, cond)
+		args = append(args, f.Value)
+	}
+	page := &models.UserPage{}
+	err := c.DB.QueryRowContext(c.context(), "SELECT COUNT(*) FROM users WHERE "+strings.Join(where, " AND "), args...).Scan(&page.Total)
+	if err != nil {
+		return nil, err
+	}
+
+	op, order := ">", " ASC"
+	if q.Desc {
+		op, order = "<", " DESC"
+	}
+	sort := sqlField(q.Sort)
+	orderBy := "id" + order
+	if q.Sort != "" {
+		orderBy = sort + order + ", " + orderBy
+	}
+	switch {
+	case q.After == nil:
+	case q.Sort == "":
+		where = append(where, "id "+op+" ?")
+		args = append(args, string(q.After.ID))
+	default:
+		where = append(where, "("+sort+" "+op+" ? OR ("+sort+" = ? AND id "+op+" ?))")
+		args = append(args, q.After.Value, q.After.Value, string(q.After.ID))
+	}
+	selected := "SELECT %s FROM users WHERE " + strings.Join(where, " AND ") +
+		" ORDER BY " + orderBy + " LIMIT " + strconv.Itoa(q.Limit+1)
+	if page.Users, err = c.queryUsers(fmt.Sprintf(selected, userColumns), args...); err != nil {
+		return nil, err
+	}
+	if err = c.loadLists(c.DB, page.Users, fmt.Sprintf(selected, "id"), args...); err != nil {
+		return nil, err
+	}
+	if len(page.Users) > q.Limit {
+		page.Users = page.Users[:q.Limit]
+		page.Next = q.CursorFor(&page.Users[q.Limit-1]).Encode()
+	}
+	return page, nil
+}
+
+// SearchUsers ranks all users, there are not too many of them in such storage.
+func (c *SQLiteUser) SearchUsers(text string, limit int) ([]models.User, error) {
+	users, err := c.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+	return models.SearchUsers(users, text, limit), nil
+}
+
+// UploadUser func
+func (c *SQLiteUser) UploadUser(u *models.User) error {
+	_, err := c.insertUser(u, "INSERT OR IGNORE", models.ErrAlreadyExists)
+	return err
+}
+
+// UpsertUser func. IDs are unique across books, ID of a user of another book can't be used.
+func (c *SQLiteUser) UpsertUser(u *models.User) (*models.User, error) {
+	return c.insertUser(u, "INSERT OR REPLACE", nil)
+}
+
+// insertUser inserts u with its lists, errNone is returned if no row is inserted.
+func (c *SQLiteUser) insertUser(u *models.User, insert string, errNone error) (*models.User, error) {
+	if u == nil {
+		return nil, errors.New("Nil pointer to User struct")
+	}
+	tx, err := c.DB.BeginTx(c.context(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	before, err := c.storeUser(tx, u, insert, errNone)
+	if err != nil {
+		return nil, err
+	}
+	return before, tx.Commit()
+}
+
+// storeUser writes u by insert statement unless id of u is used in another book.
+// User in trash is restored when it is replaced. It returns the user as it
+// was before, nil if it is new.
+func (c *SQLiteUser) storeUser(tx *sql.Tx, u *models.User, insert string, errNone error) (*models.User, error) {
+	if u.ID == "" {
+		u.ID = models.NewID()
+	}
+	var owner string
+	err := tx.QueryRow("SELECT owner FROM users WHERE id = ?", string(u.ID)).Scan(&owner)
+	if err == nil && owner != c.Owner {
+		return nil, models.ErrAlreadyExists
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	var before *models.User
+	if err == nil {
+		if before, err = c.selectWhere(tx, u.ID, "1 = 1"); err != nil {
+			return nil, err
+		}
+	}
+	if err = checkUnique(tx, c.Owner, u); err != nil {
+		return nil, err
+	}
+	u.Owner = c.Owner
+	u.Version = 1
+	if before != nil {
+		u.Version = before.Version + 1
+	}
+	u.DeletedAt = nil
+	res, err := tx.Exec(insert+" INTO users ("+userColumns+") VALUES ("+userPlaceholders+")", userValues(u)...)
+	if err = affected(res, err, errNone); err != nil {
+		return nil, err
+	}
+	if err = saveLists(tx, u); err != nil {
+		return nil, err
+	}
+	return before, nil
+}
+
+// BatchUsers applies ops in one transaction. Every operation of not atomic
+// batch runs in its own savepoint, so failed one does not undo the others.
+func (c *SQLiteUser) BatchUsers(ops []models.BatchOp, atomic bool) ([]error, error) {
+	tx, err := c.DB.BeginTx(c.context(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	errs := make([]error, len(ops))
+	for i := range ops {
+		if !atomic {
+			if _, err = tx.Exec("SAVEPOINT batch_op"); err != nil {
+				return nil, err
+			}
+		}
+		switch op := &ops[i]; op.Op {
+		case models.BatchCreate:
+			errs[i] = c.createUser(tx, op.User)
+		case models.BatchUpdate:
+			op.User.ID = op.ID
+			op.Before, errs[i] = c.updateUser(tx, op.User)
+		case models.BatchUpsert:
+			op.Before, errs[i] = c.storeUser(tx, op.User, "INSERT OR REPLACE", nil)
+		case models.BatchDelete:
+			op.Before, errs[i] = c.deleteUser(tx, op.ID)
+		}
+		switch {
+		case errs[i] != nil && atomic:
+			return notApplied(errs, i), nil
+		case errs[i] != nil:
+			_, err = tx.Exec("ROLLBACK TO batch_op")
+		}
+		if err == nil && !atomic {
+			_, err = tx.Exec("RELEASE batch_op")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return errs, tx.Commit()
+}
+
+// CleanRecords func
+func (c *SQLiteUser) CleanRecords() ([]models.User, error) {
+	tx, err := c.DB.BeginTx(c.context(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query("SELECT id, version FROM users WHERE owner = ? AND deleted_at = 0", c.Owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	now := time.Now().UTC().Truncate(time.Second)
+	trashed := make([]models.User, 0)
+	for rows.Next() {
+		u := models.User{DeletedAt: &now}
+		var id string
+		if err = rows.Scan(&id, &u.Version); err != nil {
+			return nil, err
+		}
+		u.ID = models.ID(id)
+		u.Version++
+		trashed = append(trashed, u)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec("UPDATE users SET deleted_at = ?, version = version + 1 WHERE owner = ? AND deleted_at = 0", now.Unix(), c.Owner)
+	if err != nil {
+		return nil, err
+	}
+	return trashed, tx.Commit()
+}
+
+// ClaimUsers func
+func (c *SQLiteUser) ClaimUsers(from string) (*models.ClaimResult, error) {
+	// users are read before the transaction, it holds the only connection
+	users, err := (&SQLiteUser{DB: c.DB, Ctx: c.Ctx, Owner: from}).ListUsers()
+	if err != nil {
+		return nil, err
+	}
+	tx, err := c.DB.BeginTx(c.context(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	res := models.NewClaimResult()
+	for i := range users {
+		u := &users[i]
+		exists, err := isExistInBook(tx, c.Owner, u)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			res.Skipped = append(res.Skipped, u.ID)
+			continue
+		}
+		moved, err := tx.Exec("UPDATE users SET owner = ? WHERE id = ? AND owner = ?", c.Owner, string(u.ID), from)
+		if err = affected(moved, err, models.ErrNotFound); err == models.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		res.Claimed = append(res.Claimed, u.ID)
+	}
+	return res, tx.Commit()
+}
+
+// checkUnique returns ErrAlreadyExists if another user of the book has any phone or email of u.
+func checkUnique(tx *sql.Tx, owner string, u *models.User) error {
+	exists, err := isExistInBook(tx, owner, u)
+	if err == nil && exists {
+		err = models.ErrAlreadyExists
+	}
+	return err
+}
+
+// isExistInBook checks if another user of the book has any phone or email of u.
+func isExistInBook(tx *sql.Tx, owner string, u *models.User) (bool, error) {
+	exists, err := isExistByValues(tx, owner, u.ID, "user_phones", []string{"e164", "value"}, u.PhoneKeys())
+	if err == nil && !exists {
+		exists, err = isExistByValues(tx, owner, u.ID, "user_emails", []string{"value"}, u.EmailValues())
+	}
+	return exists, err
+}
+
+// isExistByValues checks if any of values is used in one of columns of the
+// list table by a user of the book other than id.
+func isExistByValues(tx *sql.Tx, owner string, id models.ID, table string, columns []string, values []string) (bool, error) {
+	if len(values) == 0 {
+		return false, nil
+	}
+	in := " IN (?" + strings.Repeat(", ?", len(values)-1) + ")"
+	where := make([]string, len(columns))
+	args := make([]interface{}, 0, len(values)*len(columns))
+	for i, column := range columns {
+		where[i] = "(" + column + " <> '' AND " + column + in + ")"
+		for _, v := range values {
+			args = append(args, v)
+		}
+	}
+	args = append(args, owner, string(id))
+	var n int
+	err := tx.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE ("+strings.Join(where, " OR ")+
+		") AND user_id IN (SELECT id FROM users WHERE owner = ? AND deleted_at = 0 AND id <> ?)", args...).Scan(&n)
+	return n > 0, err
+}
+
+// deleteLists removes emails, phones and addresses of user.
+func deleteLists(tx *sql.Tx, id models.ID) error {
+	for _, table := range userListTables {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE user_id = ?", string(id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveLists replaces emails, phones and addresses of u.
+func saveLists(tx *sql.Tx, u *models.User) error {
+	if err := deleteLists(tx, u.ID); err != nil {
+		return err
+	}
+	id := string(u.ID)
+	for i, e := range u.Emails {
+		if _, err := tx.Exec("INSERT INTO user_emails (user_id, pos, label, value) VALUES (?, ?, ?, ?)", id, i, e.Label, e.Value); err != nil {
+			return err
+		}
+	}
+	for i, p := range u.Phones {
+		if _, err := tx.Exec("INSERT INTO user_phones (user_id, pos, label, value, e164) VALUES (?, ?, ?, ?, ?)", id, i, p.Label, p.Value, p.E164); err != nil {
+			return err
+		}
+	}
+	for i, a := range u.Addresses {
+		_, err := tx.Exec(
+			"INSERT INTO user_addresses (user_id, pos, label, street, city, region, postal_code, country) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			id, i, a.Label, a.Street, a.City, a.Region, a.PostalCode, a.Country,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// querier runs queries on database or in transaction.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// loadLists fills emails, phones and addresses of users. ids is a query
+// which selects ids of the users.
+func (c *SQLiteUser) loadLists(q querier, users []models.User, ids string, args ...interface{}) error {
+	if len(users) == 0 {
+		return nil
+	}
+	index := make(map[models.ID]*models.User, len(users))
+	for i := range users {
+		index[users[i].ID] = &users[i]
+	}
+	in := " WHERE user_id IN (" + ids + ") ORDER BY user_id, pos"
+	var e models.Email
+	err := c.eachListRow(q, "SELECT user_id, label, value FROM user_emails"+in, args, index,
+		[]interface{}{&e.Label, &e.Value}, func(u *models.User) { u.Emails = append(u.Emails, e) })
+	if err != nil {
+		return err
+	}
+	var p models.Phone
+	err = c.eachListRow(q, "SELECT user_id, label, value, e164 FROM user_phones"+in, args, index,
+		[]interface{}{&p.Label, &p.Value, &p.E164}, func(u *models.User) { u.Phones = append(u.Phones, p) })
+	if err != nil {
+		return err
+	}
+	var a models.Address
+	return c.eachListRow(q, "SELECT user_id, label, street, city, region, postal_code, country FROM user_addresses"+in, args, index,
+		[]interface{}{&a.Label, &a.Street, &a.City, &a.Region, &a.PostalCode, &a.Country},
+		func(u *models.User) { u.Addresses = append(u.Addresses, a) })
+}
+
+// eachListRow scans rows of query into dest and calls add with the user
+// the row belongs to. The first column of rows is user id.
+func (c *SQLiteUser) eachListRow(q querier, query string, args []interface{}, index map[models.ID]*models.User, dest []interface{}, add func(u *models.User)) error {
+	rows, err := q.QueryContext(c.context(), query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var id string
+	dest = append([]interface{}{&id}, dest...)
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return err
+		}
+		if u := index[models.ID(id)]; u != nil {
+			add(u)
+		}
+	}
+	return rows.Err()
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row scanner) (*models.User, error) {
+	var u models.User
+	var id string
+	var deleted int64
+	err := row.Scan(&id, &u.FirstName, &u.LastName, &u.Birthday, &u.Organization, &u.JobTitle, &u.Website, &u.Notes, &u.Owner, &u.Version, &deleted)
+	if err != nil {
+		return nil, err
+	}
+	u.ID = models.ID(id)
+	if deleted != 0 {
+		at := time.Unix(deleted, 0).UTC()
+		u.DeletedAt = &at
+	}
+	return &u, nil
+}
+
+// userValues returns values of userColumns, 0 in deleted_at means that the user is not in trash.
+func userValues(u *models.User) []interface{} {
+	var deleted int64
+	if u.DeletedAt != nil {
+		deleted = u.DeletedAt.Unix()
+	}
+	return []interface{}{string(u.ID), u.FirstName, u.LastName, u.Birthday, u.Organization, u.JobTitle, u.Website, u.Notes, u.Owner, u.Version, deleted}
+}
+
+// affected returns errNone if statement has not changed any rows.
+func affected(res sql.Result, err error, errNone error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 && errNone != nil {
+		return errNone
+	}
+	return nil
+}
+
+// SQLiteRevision keeps revisions of the book of Owner in SQLite database.
+// Time of revisions is kept in nanoseconds, changes and users in JSON.
+type SQLiteRevision struct {
+	DB    *sql.DB
+	Ctx   context.Context
+	Owner string
+}
+
+var _ RevisionStore = (*SQLiteRevision)(nil)
+
+const revisionColumns = "id, user_id, owner, version, action, actor, request_id, at, changes, snapshot"
+
+func (c *SQLiteRevision) context() context.Context {
+	if c.Ctx == nil {
+		return context.Background()
+	}
+	return c.Ctx
+}
+
+// AddRevision func
+func (c *SQLiteRevision) AddRevision(r *models.Revision) error {
+	changes, err := json.Marshal(r.Changes)
+	if err != nil {
+		return err
+	}
+	var snapshot []byte
+	if r.User != nil {
+		if snapshot, err = json.Marshal(r.User); err != nil {
+			return err
+		}
+	}
+	r.ID = models.NewID()
+	r.Owner = c.Owner
+	_, err = c.DB.ExecContext(c.context(), "INSERT INTO revisions ("+revisionColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		string(r.ID), string(r.UserID), r.Owner, r.Version, r.Action, r.Actor, r.RequestID, r.At.UnixNano(), string(changes), string(snapshot))
+	return err
+}
+
+// ListRevisions returns revisions of the user, the latest go first.
+func (c *SQLiteRevision) ListRevisions(id models.ID) ([]models.Revision, error) {
+	rows, err := c.DB.QueryContext(c.context(),
+		"SELECT "+revisionColumns+" FROM revisions WHERE owner = ? AND user_id = ? ORDER BY at DESC, id DESC", c.Owner, string(id))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	revisions := make([]models.Revision, 0)
+	for rows.Next() {
+		r, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, *r)
+	}
+	return revisions, rows.Err()
+}
+
+// SelectRevision returns the last revision of the user which has written the version.
+func (c *SQLiteRevision) SelectRevision(id models.ID, version int64) (*models.Revision, error) {
+	return c.last("version = ?", string(id), version)
+}
+
+// RevisionAt returns the last revision of the user written not later than at.
+func (c *SQLiteRevision) RevisionAt(id models.ID, at time.Time) (*models.Revision, error) {
+	return c.last("at <= ?", string(id), at.UnixNano())
+}
+
+// last returns the latest revision of the user with id which matches cond.
+func (c *SQLiteRevision) last(cond string, id string, arg interface{}) (*models.Revision, error) {
+	r, err := scanRevision(c.DB.QueryRowContext(c.context(),
+		"SELECT "+revisionColumns+" FROM revisions WHERE owner = ? AND user_id = ? AND "+cond+" ORDER BY at DESC, id DESC LIMIT 1",
+		c.Owner, id, arg))
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNotFound
+	}
+	return r, err
+}
+
+// MoveRevisions func
+func (c *SQLiteRevision) MoveRevisions(from string, ids []models.ID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := []interface{}{c.Owner, from}
+	for _, id := range ids {
+		args = append(args, string(id))
+	}
+	_, err := c.DB.ExecContext(c.context(),
+		"UPDATE revisions SET owner = ? WHERE owner = ? AND user_id IN (?"+strings.Repeat(", ?", len(ids)-1)+")", args...)
+	return err
+}
+
+func scanRevision(row scanner) (*models.Revision, error) {
+	var (
+		r                             models.Revision
+		id, userID, changes, snapshot string
+		at                            int64
+	)
+	err := row.Scan(&id, &userID, &r.Owner, &r.Version, &r.Action, &r.Actor, &r.RequestID, &at, &changes, &snapshot)
+	if err != nil {
+		return nil, err
+	}
+	r.ID, r.UserID, r.At = models.ID(id), models.ID(userID), time.Unix(0, at).UTC()
+	if err = json.Unmarshal([]byte(changes), &r.Changes); err != nil {
+		return nil, err
+	}
+	if snapshot != "" {
+		r.User = &models.User{}
+		if err = json.Unmarshal([]byte(snapshot), r.User); err != nil {
+			return nil, err
+		}
+	}
+	return &r, nil
+}
+
+// SQLiteWebhook keeps webhooks of the book of Owner in SQLite database.
+// Events are kept in JSON, time of failed deliveries in nanoseconds.
+type SQLiteWebhook struct {
+	DB    *sql.DB
+	Ctx   context.Context
+	Owner string
+}
+
+var _ WebhookStore = (*SQLiteWebhook)(nil)
+
+const (
+	webhookColumns    = "id, owner, url, secret, events, created_at"
+	deadLetterColumns = "id, owner, webhook_id, url, event, attempts, error, failed_at"
+)
+
+func (c *SQLiteWebhook) context() context.Context {
+	if c.Ctx == nil {
+		return context.Background()
+	}
+	return c.Ctx
+}
+
+// CreateWebhook func
+func (c *SQLiteWebhook) CreateWebhook(h *models.Webhook) error {
+	events, err := json.Marshal(h.Events)
+	if err != nil {
+		return err
+	}
+	h.ID = models.NewID()
+	h.Owner = c.Owner
+	_, err = c.DB.ExecContext(c.context(), "INSERT INTO webhooks ("+webhookColumns+") VALUES (?, ?, ?, ?, ?, ?)",
+		string(h.ID), h.Owner, h.URL, h.Secret, string(events), h.CreatedAt.Unix())
+	return err
+}
+
+// ListWebhooks returns webhooks of the book ordered by creation time.
+func (c *SQLiteWebhook) ListWebhooks() ([]models.Webhook, error) {
+	rows, err := c.DB.QueryContext(c.context(),
+		"SELECT "+webhookColumns+" FROM webhooks WHERE owner = ? ORDER BY created_at, id", c.Owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hooks := make([]models.Webhook, 0)
+	for rows.Next() {
+		h, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, *h)
+	}
+	return hooks, rows.Err()
+}
+
+// SelectWebhook func
+func (c *SQLiteWebhook) SelectWebhook(id models.ID) (*models.Webhook, error) {
+	h, err := scanWebhook(c.DB.QueryRowContext(c.context(),
+		"SELECT "+webhookColumns+" FROM webhooks WHERE id = ? AND owner = ?", string(id), c.Owner))
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNotFound
+	}
+	return h, err
+}
+
+// UpdateWebhook changes URL and events of the webhook.
+func (c *SQLiteWebhook) UpdateWebhook(h *models.Webhook) error {
+	events, err := json.Marshal(h.Events)
+	if err != nil {
+		return err
+	}
+	res, err := c.DB.ExecContext(c.context(), "UPDATE webhooks SET url = ?, events = ? WHERE id = ? AND owner = ?",
+		h.URL, string(events), string(h.ID), c.Owner)
+	return affected(res, err, models.ErrNotFound)
+}
+
+// DeleteWebhook func
+func (c *SQLiteWebhook) DeleteWebhook(id models.ID) error {
+	res, err := c.DB.ExecContext(c.context(), "DELETE FROM webhooks WHERE id = ? AND owner = ?", string(id), c.Owner)
+	return affected(res, err, models.ErrNotFound)
+}
+
+// AddDeadLetter func
+func (c *SQLiteWebhook) AddDeadLetter(d *models.DeadLetter) error {
+	event, err := json.Marshal(d.Event)
+	if err != nil {
+		return err
+	}
+	d.ID = models.NewID()
+	d.Owner = c.Owner
+	_, err = c.DB.ExecContext(c.context(), "INSERT INTO dead_letters ("+deadLetterColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		string(d.ID), d.Owner, string(d.WebhookID), d.URL, string(event), d.Attempts, d.Error, d.FailedAt.UnixNano())
+	return err
+}
+
+// ListDeadLetters returns failed deliveries of the book, the latest go first.
+func (c *SQLiteWebhook) ListDeadLetters() ([]models.DeadLetter, error) {
+	rows, err := c.DB.QueryContext(c.context(),
+		"SELECT "+deadLetterColumns+" FROM dead_letters WHERE owner = ? ORDER BY failed_at DESC, id DESC", c.Owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	letters := make([]models.DeadLetter, 0)
+	for rows.Next() {
+		var (
+			d                    models.DeadLetter
+			id, webhookID, event string
+			failed               int64
+		)
+		if err = rows.Scan(&id, &d.Owner, &webhookID, &d.URL, &event, &d.Attempts, &d.Error, &failed); err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal([]byte(event), &d.Event); err != nil {
+			return nil, err
+		}
+		d.ID, d.WebhookID, d.FailedAt = models.ID(id), models.ID(webhookID), time.Unix(0, failed).UTC()
+		letters = append(letters, d)
+	}
+	return letters, rows.Err()
+}
+
+// DeleteDeadLetter func
+func (c *SQLiteWebhook) DeleteDeadLetter(id models.ID) error {
+	res, err := c.DB.ExecContext(c.context(), "DELETE FROM dead_letters WHERE id = ? AND owner = ?", string(id), c.Owner)
+	return affected(res, err, models.ErrNotFound)
+}
+
+func scanWebhook(row scanner) (*models.Webhook, error) {
+	var (
+		h          models.Webhook
+		id, events string
+		created    int64
+	)
+	if err := row.Scan(&id, &h.Owner, &h.URL, &h.Secret, &events, &created); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(events), &h.Events); err != nil {
+		return nil, err
+	}
+	h.ID, h.CreatedAt = models.ID(id), time.Unix(created, 0).UTC()
+	return &h, nil
+}
+
+// SQLiteKey keeps API keys in SQLite database.
+type SQLiteKey struct {
+	DB  *sql.DB
+	Ctx context.Context
+}
+
+var _ KeyStore = (*SQLiteKey)(nil)
+
+func (c *SQLiteKey) context() context.Context {
+	if c.Ctx == nil {
+		return context.Background()
+	}
+	return c.Ctx
+}
+
+const keyColumns = "id, name, prefix, hash, admin, created_at, role, book"
+
+// CreateKey func
+func (c *SQLiteKey) CreateKey(k *models.APIKey) error {
+	k.ID = models.NewID()
+	k.Normalize()
+	_, err := c.DB.ExecContext(c.context(), "INSERT INTO api_keys ("+keyColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		string(k.ID), k.Name, k.Prefix, k.Hash, k.Admin, k.CreatedAt.Unix(), k.Role, k.Book)
+	return err
+}
+
+// ListKeys func
+func (c *SQLiteKey) ListKeys() ([]models.APIKey, error) {
+	rows, err := c.DB.QueryContext(c.context(), "SELECT "+keyColumns+" FROM api_keys ORDER BY created_at, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := make([]models.APIKey, 0)
+	for rows.Next() {
+		k, err := scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *k)
+	}
+	return keys, rows.Err()
+}
+
+// FindKey func
+func (c *SQLiteKey) FindKey(hash string) (*models.APIKey, error) {
+	k, err := scanKey(c.DB.QueryRowContext(c.context(), "SELECT "+keyColumns+" FROM api_keys WHERE hash = ?", hash))
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNotFound
+	}
+	return k, err
+}
+
+// SelectKey func
+func (c *SQLiteKey) SelectKey(id models.ID) (*models.APIKey, error) {
+	k, err := scanKey(c.DB.QueryRowContext(c.context(), "SELECT "+keyColumns+" FROM api_keys WHERE id = ?", string(id)))
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNotFound
+	}
+	return k, err
+}
+
+// SetKeyRole func
+func (c *SQLiteKey) SetKeyRole(id models.ID, role string) error {
+	res, err := c.DB.ExecContext(c.context(), "UPDATE api_keys SET role = ?, admin = 0 WHERE id = ?", role, string(id))
+	return affected(res, err, models.ErrNotFound)
+}
+
+// DeleteKey func
+func (c *SQLiteKey) DeleteKey(id models.ID) error {
+	res, err := c.DB.ExecContext(c.context(), "DELETE FROM api_keys WHERE id = ?", string(id))
+	return affected(res, err, models.ErrNotFound)
+}
+
+func scanKey(row scanner) (*models.APIKey, error) {
+	var k models.APIKey
+	var id string
+	var created int64
+	if err := row.Scan(&id, &k.Name, &k.Prefix, &k.Hash, &k.Admin, &created, &k.Role, &k.Book); err != nil {
+		return nil, err
+	}
+	k.ID = models.ID(id)
+	k.CreatedAt = time.Unix(created, 0).UTC()
+	k.Normalize()
+	return &k, nil
+}
+
+// SQLiteTenant keeps registry of tenants in SQLite database. Every tenant
+// has its own database file, so Database and Prefix are not stored.
+type SQLiteTenant struct {
+	DB  *sql.DB
+	Ctx context.Context
+}
+
+var _ TenantStore = (*SQLiteTenant)(nil)
+
+func (c *SQLiteTenant) context() context.Context {
+	if c.Ctx == nil {
+		return context.Background()
+	}
+	return c.Ctx
+}
+
+const tenantColumns = "id, name, max_users, max_keys, created_at"
+
+// CreateTenant func
+func (c *SQLiteTenant) CreateTenant(t *models.Tenant) error {
+	res, err := c.DB.ExecContext(c.context(), "INSERT OR IGNORE INTO tenants ("+tenantColumns+") VALUES (?, ?, ?, ?, ?)",
+		t.ID, t.Name, t.Quota.MaxUsers, t.Quota.MaxKeys, t.CreatedAt.Unix())
+	return affected(res, err, models.ErrAlreadyExists)
+}
+
+// ListTenants func
+func (c *SQLiteTenant) ListTenants() ([]models.Tenant, error) {
+	rows, err := c.DB.QueryContext(c.context(), "SELECT "+tenantColumns+" FROM tenants ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tenants := make([]models.Tenant, 0)
+	for rows.Next() {
+		t, err := scanTenant(rows)
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, *t)
+	}
+	return tenants, rows.Err()
+}
+
+// SelectTenant func
+func (c *SQLiteTenant) SelectTenant(id string) (*models.Tenant, error) {
+	t, err := scanTenant(c.DB.QueryRowContext(c.context(), "SELECT "+tenantColumns+" FROM tenants WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNotFound
+	}
+	return t, err
+}
+
+// UpdateTenant updates name and quota of the tenant.
+func (c *SQLiteTenant) UpdateTenant(t *models.Tenant) error {
+	res, err := c.DB.ExecContext(c.context(), "UPDATE tenants SET name = ?, max_users = ?, max_keys = ? WHERE id = ?",
+		t.Name, t.Quota.MaxUsers, t.Quota.MaxKeys, t.ID)
+	return affected(res, err, models.ErrNotFound)
+}
+
+// DeleteTenant func
+func (c *SQLiteTenant) DeleteTenant(id string) error {
+	res, err := c.DB.ExecContext(c.context(), "DELETE FROM tenants WHERE id = ?", id)
+	return affected(res, err, models.ErrNotFound)
+}
+
+func scanTenant(row scanner) (*models.Tenant, error) {
+	var t models.Tenant
+	var created int64
+	if err := row.Scan(&t.ID, &t.Name, &t.Quota.MaxUsers, &t.Quota.MaxKeys, &created); err != nil {
+		return nil, err
+	}
+	t.CreatedAt = time.Unix(created, 0).UTC()
+	return &t, nil
+}
@@ -0,0 +1,314 @@
+package controllers
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/ferux/addressbook/internal/models"
+
+	// registers sqlite3 driver for database/sql
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// testStore is a storage of users checked by tests of every backend.
+type testStore struct {
+	name string
+	// book returns the book of owner, books of one storage share it.
+	book func(owner string) UserStore
+}
+
+// testStores returns empty storages which work without a server.
+func testStores(t *testing.T) []testStore {
+	users := NewMemoryUser()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	// every connection opens its own in-memory database
+	db.SetMaxOpenConns(1)
+	if err = MigrateSQLite(db); err != nil {
+		t.Fatal(err)
+	}
+	return []testStore{
+		{name: "memory", book: func(owner string) UserStore { return users.WithOwner(owner) }},
+		{name: "sqlite", book: func(owner string) UserStore { return &SQLiteUser{DB: db, Owner: owner} }},
+	}
+}
+
+func testUser(first, last, email string) *models.User {
+	u := &models.User{FirstName: first, LastName: last}
+	if email != "" {
+		u.Emails = []models.Email{{Label: models.LabelWork, Value: email}}
+	}
+	return u
+}
+
+func TestStoreWrites(t *testing.T) {
+	for _, s := range testStores(t) {
+		t.Run(s.name, func(t *testing.T) {
+			c := s.book("ann")
+			id, err := c.CreateUser(testUser("Ann", "Lee", "ann@example.com"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = c.CreateUser(testUser("Bob", "Lee", "ann@example.com")); err != models.ErrAlreadyExists {
+				t.Errorf("user with taken email is created with %v", err)
+			}
+			if _, err = s.book("bob").CreateUser(testUser("Bob", "Lee", "ann@example.com")); err != nil {
+				t.Errorf("email of another book is taken: %v", err)
+			}
+			if _, err = s.book("bob").SelectUser(id); err != models.ErrNotFound {
+				t.Errorf("user of another book is selected with %v", err)
+			}
+
+			u := testUser("Anna", "Lee", "ann@example.com")
+			u.ID, u.Version = id, 1
+			before, err := c.UpdateUser(u)
+			if err != nil || u.Version != 2 {
+				t.Fatalf("update returns %v and version %d", err, u.Version)
+			}
+			if before.FirstName != "Ann" || before.Version != 1 {
+				t.Errorf("user before update is %+v", before)
+			}
+			u.Version = 1
+			if _, err = c.UpdateUser(u); err != models.ErrVersionMismatch {
+				t.Errorf("update of old version returns %v", err)
+			}
+			got, err := c.SelectUser(id)
+			if err != nil || got.FirstName != "Anna" || got.Version != 2 || len(got.Emails) != 1 {
+				t.Fatalf("updated user is %+v, %v", got, err)
+			}
+
+			before, err = c.DeleteUser(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if before.FirstName != "Anna" || before.Version != 2 || before.DeletedAt != nil {
+				t.Errorf("user before delete is %+v", before)
+			}
+			if _, err = c.SelectUser(id); err != models.ErrNotFound {
+				t.Errorf("deleted user is selected with %v", err)
+			}
+			if _, err = c.DeleteUser(id); err != models.ErrNotFound {
+				t.Errorf("second delete returns %v", err)
+			}
+			restored, err := c.RestoreUser(id)
+			if err != nil || restored.Version != 4 || restored.DeletedAt != nil {
+				t.Fatalf("restored user is %+v, %v", restored, err)
+			}
+		})
+	}
+}
+
+func TestStoreUpsert(t *testing.T) {
+	for _, s := range testStores(t) {
+		t.Run(s.name, func(t *testing.T) {
+			c := s.book("ann")
+			u := testUser("Ann", "Lee", "")
+			u.ID = models.NewID()
+			if before, err := c.UpsertUser(u); err != nil || before != nil || u.Version != 1 {
+				t.Fatalf("upsert of new user returns %+v, %v and version %d", before, err, u.Version)
+			}
+			u.FirstName = "Anna"
+			if before, err := c.UpsertUser(u); err != nil || before == nil || before.FirstName != "Ann" || before.DeletedAt != nil {
+				t.Fatalf("upsert of stored user returns %+v, %v", before, err)
+			}
+			if _, err := c.DeleteUser(u.ID); err != nil {
+				t.Fatal(err)
+			}
+			before, err := c.UpsertUser(u)
+			if err != nil || before == nil || before.Version != 3 || before.DeletedAt == nil || u.Version != 4 {
+				t.Fatalf("upsert of user in trash returns %+v, %v and version %d", before, err, u.Version)
+			}
+
+			ops := []models.BatchOp{
+				{Op: models.BatchCreate, User: testUser("Bob", "Lee", "")},
+				{Op: models.BatchUpdate, ID: u.ID, User: testUser("Ann", "Lee", "")},
+				{Op: models.BatchDelete, ID: u.ID},
+			}
+			if _, err = c.BatchUsers(ops[:2], false); err != nil {
+				t.Fatal(err)
+			}
+			if ops[0].Before != nil || ops[1].Before == nil || ops[1].Before.FirstName != "Anna" {
+				t.Errorf("users before batch are %+v and %+v", ops[0].Before, ops[1].Before)
+			}
+			errs, err := c.BatchUsers(ops[2:], false)
+			if err != nil || errs[0] != nil || ops[2].Before == nil || ops[2].Before.Version != 5 {
+				t.Errorf("user before deletion by batch is %+v, %v %v", ops[2].Before, errs, err)
+			}
+		})
+	}
+}
+
+func TestStoreAtomicBatch(t *testing.T) {
+	for _, s := range testStores(t) {
+		t.Run(s.name, func(t *testing.T) {
+			c := s.book("ann")
+			id, err := c.CreateUser(testUser("Ann", "Lee", "ann@example.com"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ops := []models.BatchOp{
+				{Op: models.BatchCreate, User: testUser("Bob", "Lee", "")},
+				{Op: models.BatchUpdate, ID: id, User: testUser("Anna", "Lee", "")},
+				{Op: models.BatchDelete, ID: models.NewID()},
+			}
+			errs, err := c.BatchUsers(ops, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if errs[0] != models.ErrNotApplied || errs[1] != models.ErrNotApplied || errs[2] != models.ErrNotFound {
+				t.Errorf("errors of batch are %v", errs)
+			}
+			users, err := c.ListUsers()
+			if err != nil || len(users) != 1 || users[0].FirstName != "Ann" || users[0].Version != 1 {
+				t.Errorf("users after rolled back batch are %+v, %v", users, err)
+			}
+		})
+	}
+}
+
+func TestStoreFindUsers(t *testing.T) {
+	for _, s := range testStores(t) {
+		t.Run(s.name, func(t *testing.T) {
+			c := s.book("ann")
+			for _, name := range []string{"Evans", "Adams", "Doe", "Clark", "Brown"} {
+				if _, err := c.CreateUser(testUser("Ann", name, "")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var got []string
+			q := &models.ListQuery{Limit: 2, Sort: models.FieldLastName, Desc: true}
+			for {
+				page, err := c.FindUsers(q)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if page.Total != 5 {
+					t.Errorf("total is %d, want 5", page.Total)
+				}
+				for _, u := range page.Users {
+					got = append(got, u.LastName)
+				}
+				if page.Next == "" || len(got) > 5 {
+					break
+				}
+				if q.After, err = models.DecodeCursor(page.Next); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if strings.Join(got, " ") != "Evans Doe Clark Brown Adams" {
+				t.Errorf("pages list %v", got)
+			}
+
+			q = &models.ListQuery{Limit: 10, Filters: []models.Filter{{Field: models.FieldLastName, Value: "D", Prefix: true}}}
+			page, err := c.FindUsers(q)
+			if err != nil || len(page.Users) != 1 || page.Users[0].LastName != "Doe" || page.Total != 1 {
+				t.Errorf("filtered page is %+v, %v", page, err)
+			}
+		})
+	}
+}
+
+func TestStoreUniquePhones(t *testing.T) {
+	for _, s := range testStores(t) {
+		t.Run(s.name, func(t *testing.T) {
+			c := s.book("ann")
+			ann := testUser("Ann", "Lee", "")
+			ann.Phones = []models.Phone{{Value: "+1 555 010 0000", E164: "+15550100000"}}
+			annID, err := c.CreateUser(ann)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bob := testUser("Bob", "Lee", "")
+			bobID, err := c.CreateUser(bob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the same number written in another way has the same key
+			taken := []models.Phone{{Value: "+15550100000", E164: "+15550100000"}}
+
+			bob.Phones = taken
+			if _, err = c.UpdateUser(bob); err != models.ErrAlreadyExists {
+				t.Errorf("update returns %v", err)
+			}
+			upserted := testUser("Cy", "Lee", "")
+			upserted.Phones = taken
+			if _, err = c.UpsertUser(upserted); err != models.ErrAlreadyExists {
+				t.Errorf("upsert returns %v", err)
+			}
+			_, err = c.PatchUser(bobID, func(u *models.User) error {
+				u.Phones = taken
+				return nil
+			})
+			if err != models.ErrAlreadyExists {
+				t.Errorf("patch returns %v", err)
+			}
+			batch := testUser("Dan", "Lee", "")
+			batch.Phones = taken
+			errs, err := c.BatchUsers([]models.BatchOp{{Op: models.BatchCreate, User: batch}}, false)
+			if err != nil || errs[0] != models.ErrAlreadyExists {
+				t.Errorf("batch returns %v, %v", errs, err)
+			}
+
+			if _, err = c.DeleteUser(annID); err != nil {
+				t.Fatal(err)
+			}
+			bob.Phones, bob.Version = taken, 0
+			if _, err = c.UpdateUser(bob); err != nil {
+				t.Fatalf("phone of deleted user is taken: %v", err)
+			}
+			if _, err = c.RestoreUser(annID); err != models.ErrAlreadyExists {
+				t.Errorf("restore returns %v", err)
+			}
+		})
+	}
+}
+
+func TestStoreCleanRecords(t *testing.T) {
+	for _, s := range testStores(t) {
+		t.Run(s.name, func(t *testing.T) {
+			c := s.book("ann")
+			ann, err := c.CreateUser(testUser("Ann", "Lee", ""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			bob, err := c.CreateUser(testUser("Bob", "Lee", ""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = c.DeleteUser(bob); err != nil {
+				t.Fatal(err)
+			}
+			other, err := s.book("bob").CreateUser(testUser("Cy", "Lee", ""))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			trashed, err := c.CleanRecords()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(trashed) != 1 || trashed[0].ID != ann || trashed[0].Version != 2 || trashed[0].DeletedAt == nil {
+				t.Errorf("trashed users are %+v, want %s of version 2", trashed, ann)
+			}
+			users, err := c.ListTrash()
+			if err != nil || len(users) != 2 {
+				t.Fatalf("trash is %+v, %v", users, err)
+			}
+			for _, u := range users {
+				if u.ID == bob && u.Version != 2 {
+					t.Errorf("user trashed before is changed: %+v", u)
+				}
+			}
+			if _, err = s.book("bob").SelectUser(other); err != nil {
+				t.Errorf("user of another book is cleaned: %v", err)
+			}
+			if _, err = c.RestoreUser(ann); err != nil {
+				t.Errorf("cleaned user can't be restored: %v", err)
+			}
+		})
+	}
+}
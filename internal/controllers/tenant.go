@@ -0,0 +1,261 @@
+package controllers
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/ferux/addressbook/internal/models"
+
+	"gopkg.in/mgo.v2"
+)
+
+const (
+	tenantCollection      = "tenants"
+	reservationCollection = "reservations"
+)
+
+// ErrQuotaExceeded reports in case tenant has reached its quota.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// TenantStore describes operations over registry of tenants regardless of the storage behind them.
+type TenantStore interface {
+	CreateTenant(t *models.Tenant) error
+	ListTenants() ([]models.Tenant, error)
+	SelectTenant(id string) (*models.Tenant, error)
+	UpdateTenant(t *models.Tenant) error
+	DeleteTenant(id string) error
+}
+
+// Tenant controller keeps registry of tenants in MongoDB collection
+type Tenant struct{ Collection *mgo.Collection }
+
+var _ TenantStore = (*Tenant)(nil)
+
+// CreateTenant func
+func (c *Tenant) CreateTenant(t *models.Tenant) error {
+	return models.CreateTenant(c.Collection, t)
+}
+
+// ListTenants func
+func (c *Tenant) ListTenants() ([]models.Tenant, error) {
+	return models.ListTenants(c.Collection)
+}
+
+// SelectTenant func
+func (c *Tenant) SelectTenant(id string) (*models.Tenant, error) {
+	return models.SelectTenant(c.Collection, id)
+}
+
+// UpdateTenant func
+func (c *Tenant) UpdateTenant(t *models.Tenant) error {
+	return models.UpdateTenant(c.Collection, t)
+}
+
+// DeleteTenant func
+func (c *Tenant) DeleteTenant(id string) error {
+	return models.DeleteTenant(c.Collection, id)
+}
+
+// DropTenant removes all data of the tenant from MongoDB. db is the default database.
+func DropTenant(db *mgo.Database, t *models.Tenant) error {
+	if t.Database != "" {
+		return db.Session.DB(t.Database).DropDatabase()
+	}
+	for _, name := range []string{userCollection, keyCollection, revisionCollection, webhookCollection, deadLetterCollection, reservationCollection} {
+		if err := db.C(t.Prefix + name).DropCollection(); err != nil && !isNamespaceNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// isNamespaceNotFound checks if err reports that the collection does not exist.
+func isNamespaceNotFound(err error) bool {
+	if e, ok := err.(*mgo.QueryError); ok && e.Code == 26 {
+		return true
+	}
+	return err != nil && err.Error() == "ns not found"
+}
+
+// reserver holds places in quota for records a write is going to add.
+type reserver interface {
+	// reserve finds by added how many records the write adds and holds
+	// places for them if they fit into max together with count records and
+	// places held by other writes. release is called when the write is done.
+	reserve(kind string, max int, count, added func() (int, error)) (release func(), err error)
+}
+
+// lockReserver runs writes which may add records one at a time, so nothing
+// is added between the count and the write. It serves storages used by one
+// process, controllers of a storage share the lock.
+type lockReserver struct{ mu *sync.Mutex }
+
+func (r lockReserver) reserve(kind string, max int, count, added func() (int, error)) (func(), error) {
+	r.mu.Lock()
+	n, err := added()
+	if err == nil && n > 0 {
+		var used int
+		if used, err = count(); err == nil && used+n > max {
+			err = ErrQuotaExceeded
+		}
+	}
+	if err != nil {
+		r.mu.Unlock()
+		return nil, err
+	}
+	return r.mu.Unlock, nil
+}
+
+// mongoReserver keeps reservations in a collection shared by all instances.
+// A write stores its reservation before it counts records, so of two writes
+// running together the later one sees places of the other one either as
+// a reservation or as added records.
+type mongoReserver struct{ Collection *mgo.Collection }
+
+func (r *mongoReserver) reserve(kind string, max int, count, added func() (int, error)) (func(), error) {
+	n, err := added()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return func() {}, nil
+	}
+	id, held, err := models.Reserve(r.Collection, kind, n)
+	if err != nil {
+		return nil, err
+	}
+	release := func() { models.ReleaseReservation(r.Collection, id) }
+	used, err := count()
+	if err == nil && used+held > max {
+		err = ErrQuotaExceeded
+	}
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+// quotaUser refuses to add users when the tenant has max of them. Users of
+// all books of the tenant are counted, users in trash are not. Restoring a
+// user from trash adds it again.
+type quotaUser struct {
+	UserStore
+	max      int
+	count    func() (int, error)
+	reserver reserver
+}
+
+// reserve holds places for users which added finds, release should be
+// called after the write.
+func (c *quotaUser) reserve(added func() (int, error)) (func(), error) {
+	return c.reserver.reserve(userCollection, c.max, c.count, added)
+}
+
+// one is added func of writes which add a user.
+func one() (int, error) {
+	return 1, nil
+}
+
+// CreateUser func
+func (c *quotaUser) CreateUser(u *models.User) (models.ID, error) {
+	release, err := c.reserve(one)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	return c.UserStore.CreateUser(u)
+}
+
+// UploadUser func
+func (c *quotaUser) UploadUser(u *models.User) error {
+	release, err := c.reserve(one)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return c.UserStore.UploadUser(u)
+}
+
+// RestoreUser func
+func (c *quotaUser) RestoreUser(id models.ID) (*models.User, error) {
+	release, err := c.reserve(one)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return c.UserStore.RestoreUser(id)
+}
+
+// UpsertUser checks quota only if the user is new or in trash.
+func (c *quotaUser) UpsertUser(u *models.User) (*models.User, error) {
+	release, err := c.reserve(func() (int, error) {
+		return c.added(u.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return c.UserStore.UpsertUser(u)
+}
+
+// BatchUsers refuses the whole batch if users it adds don't fit into quota.
+func (c *quotaUser) BatchUsers(ops []models.BatchOp, atomic bool) ([]error, error) {
+	release, err := c.reserve(func() (int, error) {
+		added := 0
+		for _, op := range ops {
+			switch op.Op {
+			case models.BatchCreate:
+				added++
+			case models.BatchUpsert:
+				n, err := c.added(op.User.ID)
+				if err != nil {
+					return 0, err
+				}
+				added += n
+			}
+		}
+		return added, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return c.UserStore.BatchUsers(ops, atomic)
+}
+
+// added returns 1 if upsert of user with id adds a user, because it is new or in trash.
+func (c *quotaUser) added(id models.ID) (int, error) {
+	if id == "" {
+		return 1, nil
+	}
+	_, err := c.UserStore.SelectUser(id)
+	switch err {
+	case nil:
+		return 0, nil
+	case models.ErrNotFound:
+		return 1, nil
+	}
+	return 0, err
+}
+
+// quotaKey refuses to add keys when the tenant has max of them.
+type quotaKey struct {
+	KeyStore
+	max      int
+	reserver reserver
+}
+
+// CreateKey func
+func (c *quotaKey) CreateKey(k *models.APIKey) error {
+	count := func() (int, error) {
+		keys, err := c.ListKeys()
+		return len(keys), err
+	}
+	release, err := c.reserver.reserve(keyCollection, c.max, count, one)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return c.KeyStore.CreateKey(k)
+}
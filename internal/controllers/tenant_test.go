@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/ferux/addressbook/internal/models"
+)
+
+// testTenantControllers returns controllers of empty storages of tenant t.
+func testTenantControllers(t *testing.T, tenant *models.Tenant) map[string]*Controller {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+	if err = MigrateSQLite(db); err != nil {
+		t.Fatal(err)
+	}
+	return map[string]*Controller{
+		"memory": NewMemoryController().WithTenant(tenant),
+		"sqlite": NewSQLiteController(db).WithTenant(tenant),
+	}
+}
+
+// parallel runs n calls of f at once and returns the amount of them which succeeded.
+func parallel(n int, f func(i int) error) (int, []error) {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		ok   int
+		errs []error
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := f(i)
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				ok++
+			} else if err != ErrQuotaExceeded {
+				errs = append(errs, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	return ok, errs
+}
+
+func TestQuotaConcurrentWrites(t *testing.T) {
+	tenant := &models.Tenant{ID: "acme", Quota: models.Quota{MaxUsers: 5, MaxKeys: 2}}
+	for name, c := range testTenantControllers(t, tenant) {
+		t.Run(name, func(t *testing.T) {
+			created, errs := parallel(20, func(i int) error {
+				// every write gets its own copy of the controller as requests do
+				_, err := c.WithOwner(fmt.Sprint("book", i%3)).User().CreateUser(testUser("Ann", fmt.Sprint("Lee", i), ""))
+				return err
+			})
+			if created != 5 || len(errs) > 0 {
+				t.Fatalf("%d users are created of 5, errors: %v", created, errs)
+			}
+			if _, err := c.User().CreateUser(testUser("Bob", "Lee", "")); err != ErrQuotaExceeded {
+				t.Errorf("create above quota returns %v", err)
+			}
+
+			users, err := c.WithOwner("book0").User().ListUsers()
+			if err != nil || len(users) == 0 {
+				t.Fatalf("users are %v, %v", users, err)
+			}
+			book := c.WithOwner("book0").User()
+			if _, err = book.DeleteUser(users[0].ID); err != nil {
+				t.Fatal(err)
+			}
+			ops := []models.BatchOp{
+				{Op: models.BatchCreate, User: testUser("Cy", "Lee", "")},
+				{Op: models.BatchCreate, User: testUser("Dan", "Lee", "")},
+			}
+			if _, err = book.BatchUsers(ops, false); err != ErrQuotaExceeded {
+				t.Errorf("batch above quota returns %v", err)
+			}
+			restored, errs := parallel(5, func(int) error {
+				_, err := c.WithOwner("book0").User().RestoreUser(users[0].ID)
+				if err == models.ErrNotFound {
+					// restored by another call
+					return ErrQuotaExceeded
+				}
+				return err
+			})
+			if restored != 1 || len(errs) > 0 {
+				t.Errorf("user is restored %d times, errors: %v", restored, errs)
+			}
+
+			keys, errs := parallel(10, func(i int) error {
+				return c.Key().CreateKey(&models.APIKey{Name: "ci", Hash: fmt.Sprint("hash", i), Role: models.RoleEditor})
+			})
+			if keys != 2 || len(errs) > 0 {
+				t.Errorf("%d keys are created of 2, errors: %v", keys, errs)
+			}
+		})
+	}
+}
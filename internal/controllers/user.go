@@ -1,53 +1,164 @@
 package controllers
 
 import (
+	"time"
+
 	"github.com/ferux/addressbook/internal/models"
 
 	"gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
 )
 
 const userCollection = "users"
 
-// User controller type uses collection to manipulate data
-type User struct{ Collection *mgo.Collection }
+// UserStore describes operations over users regardless of the storage behind them.
+type UserStore interface {
+	CreateUser(u *models.User) (models.ID, error)
+	// UpdateUser, DeleteUser and UpsertUser return the user as it was
+	// before the write, UpsertUser returns nil for a new user.
+	UpdateUser(u *models.User) (*models.User, error)
+	BatchUsers(ops []models.BatchOp, atomic bool) ([]error, error)
+	PatchUser(id models.ID, patch func(u *models.User) error) (*models.User, error)
+	DeleteUser(id models.ID) (*models.User, error)
+	ListTrash() ([]models.User, error)
+	RestoreUser(id models.ID) (*models.User, error)
+	PurgeUser(id models.ID) error
+	PurgeTrash(before time.Time) (int, error)
+	SelectUser(id models.ID) (*models.User, error)
+	ListUsers() ([]models.User, error)
+	FindUsers(q *models.ListQuery) (*models.UserPage, error)
+	SearchUsers(text string, limit int) ([]models.User, error)
+	UploadUser(u *models.User) error
+	UpsertUser(u *models.User) (*models.User, error)
+	// CleanRecords moves all users of the book to trash and returns them
+	// with ids, versions and time of deletion.
+	CleanRecords() ([]models.User, error)
+	ClaimUsers(from string) (*models.ClaimResult, error)
+}
+
+// User controller type uses collection to manipulate data of the book of Owner
+type User struct {
+	Collection *mgo.Collection
+	Owner      string
+}
+
+var _ UserStore = (*User)(nil)
 
 // CreateUser func
-func (c *User) CreateUser(u *models.User) (bson.ObjectId, error) {
-	return models.CreateUser(c.Collection, u)
+func (c *User) CreateUser(u *models.User) (models.ID, error) {
+	return models.CreateUser(c.Collection, c.Owner, u)
 }
 
 // UpdateUser func
-func (c *User) UpdateUser(u *models.User) error {
-	return models.UpdateUser(c.Collection, u)
+func (c *User) UpdateUser(u *models.User) (*models.User, error) {
+	return models.UpdateUser(c.Collection, c.Owner, u)
+}
+
+// PatchUser func
+func (c *User) PatchUser(id models.ID, patch func(u *models.User) error) (*models.User, error) {
+	return models.PatchUser(c.Collection, c.Owner, id, patch)
 }
 
 // DeleteUser func
-func (c *User) DeleteUser(id bson.ObjectId) error {
-	return models.DeleteUser(c.Collection, id)
+func (c *User) DeleteUser(id models.ID) (*models.User, error) {
+	return models.DeleteUser(c.Collection, c.Owner, id)
+}
+
+// ListTrash func
+func (c *User) ListTrash() ([]models.User, error) {
+	return models.ListTrash(c.Collection, c.Owner)
+}
+
+// RestoreUser func
+func (c *User) RestoreUser(id models.ID) (*models.User, error) {
+	return models.RestoreUser(c.Collection, c.Owner, id)
+}
+
+// PurgeUser func
+func (c *User) PurgeUser(id models.ID) error {
+	return models.PurgeUser(c.Collection, c.Owner, id)
+}
+
+// PurgeTrash func. Users of all books of the collection are purged.
+func (c *User) PurgeTrash(before time.Time) (int, error) {
+	return models.PurgeTrash(c.Collection, before)
 }
 
 // SelectUser func
-func (c *User) SelectUser(id bson.ObjectId) (*models.User, error) {
-	return models.SelectUser(c.Collection, id)
+func (c *User) SelectUser(id models.ID) (*models.User, error) {
+	return models.SelectUser(c.Collection, c.Owner, id)
 }
 
 // ListUsers func
 func (c *User) ListUsers() ([]models.User, error) {
-	return models.ListUsers(c.Collection)
+	return models.ListUsers(c.Collection, c.Owner)
+}
+
+// FindUsers func
+func (c *User) FindUsers(q *models.ListQuery) (*models.UserPage, error) {
+	return models.FindUsers(c.Collection, c.Owner, q)
+}
+
+// SearchUsers func
+func (c *User) SearchUsers(text string, limit int) ([]models.User, error) {
+	return models.SearchUsersDB(c.Collection, c.Owner, text, limit)
 }
 
 // UploadUser func
 func (c *User) UploadUser(u *models.User) error {
-	return models.UploadUser(c.Collection, u)
+	return models.UploadUser(c.Collection, c.Owner, u)
 }
 
 // UpsertUser func
-func (c *User) UpsertUser(u *models.User) error {
-	return models.UpsertUser(c.Collection, u)
+func (c *User) UpsertUser(u *models.User) (*models.User, error) {
+	return models.UpsertUser(c.Collection, c.Owner, u)
 }
 
 // CleanRecords func
-func (c *User) CleanRecords() error {
-	return models.CleanRecords(c.Collection)
+func (c *User) CleanRecords() ([]models.User, error) {
+	return models.CleanRecords(c.Collection, c.Owner)
+}
+
+// ClaimUsers func
+func (c *User) ClaimUsers(from string) (*models.ClaimResult, error) {
+	return models.ClaimUsers(c.Collection, c.Owner, from)
+}
+
+// BatchUsers func. MongoDB can't roll back changes of several users, so atomic batches are refused.
+func (c *User) BatchUsers(ops []models.BatchOp, atomic bool) ([]error, error) {
+	if atomic {
+		return nil, models.ErrAtomicUnsupported
+	}
+	return models.BatchUsers(c.Collection, c.Owner, ops)
+}
+
+// notApplied reports every operation of rolled back batch except the failed one as not applied.
+func notApplied(errs []error, failed int) []error {
+	for i := range errs {
+		if i != failed {
+			errs[i] = models.ErrNotApplied
+		}
+	}
+	return errs
+}
+
+// EnsureIndexes creates indexes used by controllers on collections which names start with prefix.
+func EnsureIndexes(db *mgo.Database, prefix string) error {
+	if err := models.EnsureUserIndexes(db.C(prefix + userCollection)); err != nil {
+		return err
+	}
+	if err := models.EnsureRevisionIndexes(db.C(prefix + revisionCollection)); err != nil {
+		return err
+	}
+	if err := models.EnsureWebhookIndexes(db.C(prefix+webhookCollection), db.C(prefix+deadLetterCollection)); err != nil {
+		return err
+	}
+	if err := models.EnsureKeyIndexes(db.C(prefix + keyCollection)); err != nil {
+		return err
+	}
+	return models.EnsureReservationIndexes(db.C(prefix + reservationCollection))
+}
+
+// Migrate upgrades records stored by previous versions and returns the amount of changed records.
+func Migrate(db *mgo.Database) (int, error) {
+	return models.MigrateUsers(db.C(userCollection))
 }
@@ -0,0 +1,246 @@
+package controllers
+
+import (
+	"time"
+
+	"github.com/ferux/addressbook/internal/models"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+)
+
+const (
+	webhookCollection    = "webhooks"
+	deadLetterCollection = "dead_letters"
+)
+
+// WebhookStore describes operations over webhooks of a book and their
+// failed deliveries regardless of the storage behind them.
+type WebhookStore interface {
+	CreateWebhook(h *models.Webhook) error
+	ListWebhooks() ([]models.Webhook, error)
+	SelectWebhook(id models.ID) (*models.Webhook, error)
+	UpdateWebhook(h *models.Webhook) error
+	DeleteWebhook(id models.ID) error
+	AddDeadLetter(d *models.DeadLetter) error
+	ListDeadLetters() ([]models.DeadLetter, error)
+	DeleteDeadLetter(id models.ID) error
+}
+
+// Notifier delivers event of the book of owner in tenant to hooks. It
+// should not wait for the delivery, writes of users are not slowed down.
+type Notifier interface {
+	Notify(tenant, owner string, hooks []models.Webhook, e *models.Event)
+}
+
+// Webhook controller keeps webhooks of the book of Owner in MongoDB collections
+type Webhook struct {
+	Hooks   *mgo.Collection
+	Letters *mgo.Collection
+	Owner   string
+}
+
+var _ WebhookStore = (*Webhook)(nil)
+
+// CreateWebhook func
+func (c *Webhook) CreateWebhook(h *models.Webhook) error {
+	return models.CreateWebhook(c.Hooks, c.Owner, h)
+}
+
+// ListWebhooks func
+func (c *Webhook) ListWebhooks() ([]models.Webhook, error) {
+	return models.ListWebhooks(c.Hooks, c.Owner)
+}
+
+// SelectWebhook func
+func (c *Webhook) SelectWebhook(id models.ID) (*models.Webhook, error) {
+	return models.SelectWebhook(c.Hooks, c.Owner, id)
+}
+
+// UpdateWebhook func
+func (c *Webhook) UpdateWebhook(h *models.Webhook) error {
+	return models.UpdateWebhook(c.Hooks, c.Owner, h)
+}
+
+// DeleteWebhook func
+func (c *Webhook) DeleteWebhook(id models.ID) error {
+	return models.DeleteWebhook(c.Hooks, c.Owner, id)
+}
+
+// AddDeadLetter func
+func (c *Webhook) AddDeadLetter(d *models.DeadLetter) error {
+	return models.AddDeadLetter(c.Letters, c.Owner, d)
+}
+
+// ListDeadLetters func
+func (c *Webhook) ListDeadLetters() ([]models.DeadLetter, error) {
+	return models.ListDeadLetters(c.Letters, c.Owner)
+}
+
+// DeleteDeadLetter func
+func (c *Webhook) DeleteDeadLetter(id models.ID) error {
+	return models.DeleteDeadLetter(c.Letters, c.Owner, id)
+}
+
+// notifyLogger reports events which can't be sent.
+var notifyLogger = logrus.New().WithFields(logrus.Fields{
+	"package": "controllers",
+	"entity":  "webhook",
+})
+
+// notifyingUser passes events of successful writes to notifier. Webhooks of
+// the book are read after the write, so it is not slowed down when there are
+// none. If webhooks can't be read the events are lost and logged, the write
+// still succeeds.
+type notifyingUser struct {
+	UserStore
+	webhooks WebhookStore
+	notifier Notifier
+	tenant   string
+	owner    string
+}
+
+// hooks returns webhooks of the book, none if they can't be read. They are
+// read once per write, events of the write are filtered in memory.
+func (c *notifyingUser) hooks() []models.Webhook {
+	all, err := c.webhooks.ListWebhooks()
+	if err != nil {
+		notifyLogger.WithFields(logrus.Fields{
+			"fn":    "hooks",
+			"owner": c.owner,
+		}).WithError(err).Error("can't get webhooks")
+		return nil
+	}
+	return all
+}
+
+// notify sends event of type typ about users to webhooks which want it.
+func (c *notifyingUser) notify(typ string, users ...*models.User) {
+	c.send(c.hooks(), typ, users...)
+}
+
+// send sends event of type typ about users to those of all which want it.
+func (c *notifyingUser) send(all []models.Webhook, typ string, users ...*models.User) {
+	hooks := make([]models.Webhook, 0, len(all))
+	for _, h := range all {
+		if h.Wants(typ) {
+			hooks = append(hooks, h)
+		}
+	}
+	if len(hooks) == 0 {
+		return
+	}
+	for _, u := range users {
+		user := *u
+		c.notifier.Notify(c.tenant, c.owner, hooks, &models.Event{ID: models.NewID(), Type: typ, At: time.Now().UTC(), User: &user})
+	}
+}
+
+// written returns type of event of the user written by upsert, before is
+// the user as it was before the write. User written over the one in trash
+// is created again, as restored one is.
+func written(before *models.User) string {
+	if before == nil || before.DeletedAt != nil {
+		return models.EventCreated
+	}
+	return models.EventUpdated
+}
+
+// CreateUser func
+func (c *notifyingUser) CreateUser(u *models.User) (models.ID, error) {
+	id, err := c.UserStore.CreateUser(u)
+	if err == nil {
+		c.notify(models.EventCreated, u)
+	}
+	return id, err
+}
+
+// UpdateUser func
+func (c *notifyingUser) UpdateUser(u *models.User) (*models.User, error) {
+	before, err := c.UserStore.UpdateUser(u)
+	if err == nil {
+		c.notify(models.EventUpdated, u)
+	}
+	return before, err
+}
+
+// PatchUser func
+func (c *notifyingUser) PatchUser(id models.ID, patch func(u *models.User) error) (*models.User, error) {
+	u, err := c.UserStore.PatchUser(id, patch)
+	if err == nil {
+		c.notify(models.EventUpdated, u)
+	}
+	return u, err
+}
+
+// BatchUsers func
+func (c *notifyingUser) BatchUsers(ops []models.BatchOp, atomic bool) ([]error, error) {
+	errs, err := c.UserStore.BatchUsers(ops, atomic)
+	if len(errs) == 0 {
+		return errs, err
+	}
+	hooks := c.hooks()
+	for i := range errs {
+		if errs[i] != nil {
+			continue
+		}
+		switch ops[i].Op {
+		case models.BatchCreate:
+			c.send(hooks, models.EventCreated, ops[i].User)
+		case models.BatchUpdate:
+			c.send(hooks, models.EventUpdated, ops[i].User)
+		case models.BatchUpsert:
+			c.send(hooks, written(ops[i].Before), ops[i].User)
+		case models.BatchDelete:
+			c.send(hooks, models.EventDeleted, &models.User{ID: ops[i].ID})
+		}
+	}
+	return errs, err
+}
+
+// DeleteUser func
+func (c *notifyingUser) DeleteUser(id models.ID) (*models.User, error) {
+	before, err := c.UserStore.DeleteUser(id)
+	if err == nil {
+		c.notify(models.EventDeleted, &models.User{ID: id})
+	}
+	return before, err
+}
+
+// RestoreUser sends created event, the user is back after it has been deleted.
+func (c *notifyingUser) RestoreUser(id models.ID) (*models.User, error) {
+	u, err := c.UserStore.RestoreUser(id)
+	if err == nil {
+		c.notify(models.EventCreated, u)
+	}
+	return u, err
+}
+
+// UploadUser func
+func (c *notifyingUser) UploadUser(u *models.User) error {
+	err := c.UserStore.UploadUser(u)
+	if err == nil {
+		c.notify(models.EventCreated, u)
+	}
+	return err
+}
+
+// UpsertUser func
+func (c *notifyingUser) UpsertUser(u *models.User) (*models.User, error) {
+	before, err := c.UserStore.UpsertUser(u)
+	if err == nil {
+		c.notify(written(before), u)
+	}
+	return before, err
+}
+
+// CleanRecords sends deleted event of every user moved to trash.
+func (c *notifyingUser) CleanRecords() ([]models.User, error) {
+	trashed, err := c.UserStore.CleanRecords()
+	deleted := make([]*models.User, len(trashed))
+	for i := range trashed {
+		deleted[i] = &models.User{ID: trashed[i].ID}
+	}
+	c.notify(models.EventDeleted, deleted...)
+	return trashed, err
+}
@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/ferux/addressbook/internal/models"
+)
+
+// countingWebhook counts reads of webhooks.
+type countingWebhook struct {
+	WebhookStore
+	lists int
+}
+
+func (c *countingWebhook) ListWebhooks() ([]models.Webhook, error) {
+	c.lists++
+	return c.WebhookStore.ListWebhooks()
+}
+
+func TestNotifyBatch(t *testing.T) {
+	c := NewMemoryController().WithOwner("ann")
+	hooks := &countingWebhook{WebhookStore: c.Webhook()}
+	for _, events := range [][]string{{models.EventCreated}, {models.EventDeleted}, nil} {
+		if err := hooks.CreateWebhook(&models.Webhook{URL: "http://example.com", Events: events}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n := &testNotifier{}
+	users := &notifyingUser{UserStore: c.User(), webhooks: hooks, notifier: n, owner: "ann"}
+	ann, bob := testUser("Ann", "Lee", ""), testUser("Bob", "Lee", "")
+	if _, err := users.CreateUser(ann); err != nil {
+		t.Fatal(err)
+	}
+	hooks.lists, n.events = 0, nil
+	ops := []models.BatchOp{
+		{Op: models.BatchCreate, User: bob},
+		{Op: models.BatchUpdate, ID: ann.ID, User: testUser("Anna", "Lee", "")},
+		{Op: models.BatchDelete, ID: ann.ID},
+	}
+	if _, err := users.BatchUsers(ops, false); err != nil {
+		t.Fatal(err)
+	}
+	if hooks.lists != 1 {
+		t.Errorf("webhooks are read %d times by batch, want once", hooks.lists)
+	}
+	var got []string
+	for _, e := range n.events {
+		got = append(got, e.Type)
+	}
+	want := []string{models.EventCreated, models.EventUpdated, models.EventDeleted}
+	if len(n.events) != len(want) {
+		t.Fatalf("events of batch are %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("events of batch are %v, want %v", got, want)
+		}
+	}
+}
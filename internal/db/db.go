@@ -1,17 +1,36 @@
 package db
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
 	"time"
 
 	"github.com/ferux/addressbook"
+	"github.com/ferux/addressbook/internal/controllers"
+	"github.com/ferux/addressbook/internal/models"
 
 	"github.com/sirupsen/logrus"
 
 	"github.com/ferux/addressbook/internal/types"
 
+	// registers sqlite3 driver for database/sql
+	_ "github.com/mattn/go-sqlite3"
 	"gopkg.in/mgo.v2"
 )
 
+// Defaults of MongoDB connection.
+const (
+	defaultPoolLimit     = 4096
+	defaultDialTimeout   = time.Second * 10
+	defaultSocketTimeout = time.Second * 15
+	defaultSyncTimeout   = time.Second * 15
+)
+
+// ErrUnknownDriver reports in case config specifies unsupported storage driver.
+var ErrUnknownDriver = errors.New("unknown database driver")
+
 //Config is a struct to database configure
 type Config struct {
 	Database   string
@@ -25,9 +44,26 @@ type Config struct {
 type Repo struct {
 	Session *mgo.Session
 	DB      *mgo.Database
-	conf    types.DB
-	logger  *logrus.Entry
-	status  addressbook.Code
+	SQL     *sql.DB
+	memory  *controllers.Controller
+	journal *controllers.Journal
+	// notifier passes changes of users to webhooks, it may be nil. It is
+	// guarded by mu, trash is purged in background while it is set.
+	notifier controllers.Notifier
+	conf     types.DB
+	logger   *logrus.Entry
+	status   addressbook.Code
+	// stop is closed to stop keepConnection and purgeTrash, done and purged
+	// are closed when they have stopped.
+	stop      chan struct{}
+	done      chan struct{}
+	purged    chan struct{}
+	closeOnce sync.Once
+	// tenants caches tenants with their storage, missing caches the time
+	// unknown tenants were looked up. They are guarded by mu.
+	mu      sync.Mutex
+	tenants map[string]*tenantData
+	missing map[string]time.Time
 }
 
 // New updated version of constructor.
@@ -38,30 +74,81 @@ func New(dbconf types.DB) (*Repo, error) {
 			"package": "db",
 			"entity":  "repo",
 		}),
-		status: addressbook.Unknown,
+		status:  addressbook.Unknown,
+		journal: controllers.NewJournal(),
+		stop:    make(chan struct{}),
+		tenants: make(map[string]*tenantData),
+		missing: make(map[string]time.Time),
 	}
 
-	err := r.connect()
-	go r.keepConnection()
-	return &r, err
+	switch dbconf.Driver {
+	case "", types.DriverMongo:
+		if err := r.connect(); err != nil {
+			return &r, err
+		}
+		r.done = make(chan struct{})
+		go r.keepConnection()
+	case types.DriverMemory:
+		r.memory = controllers.NewMemoryController().WithJournal(r.journal)
+		r.status = addressbook.Running
+	case types.DriverSQLite:
+		if err := r.connectSQLite(); err != nil {
+			return &r, err
+		}
+	default:
+		return nil, ErrUnknownDriver
+	}
+
+	r.purged = make(chan struct{})
+	go r.purgeTrash()
+	return &r, nil
 }
 
 func (r *Repo) keepConnection() {
+	defer close(r.done)
 	logger := r.logger.WithField("fn", "keepConnection")
 	for {
-		err := r.Session.Ping()
-		if err != nil {
+		delay := time.Second * 10
+		if err := r.Session.Ping(); err != nil {
 			r.status = addressbook.HaveProblems
 			logger.WithError(err).Error("can't ping database")
 			r.Session.Refresh()
-			time.Sleep(time.Second * 3)
-			continue
+			delay = time.Second * 3
+		} else {
+			r.status = addressbook.Running
+		}
+		select {
+		case <-r.stop:
+			return
+		case <-time.After(delay):
 		}
-		r.status = addressbook.Running
-		time.Sleep(time.Second * 10)
 	}
 }
 
+// Close stops checking connection and closes connections to the database.
+// Requests should be finished before it is called.
+func (r *Repo) Close() (err error) {
+	r.closeOnce.Do(func() {
+		close(r.stop)
+		if r.done != nil {
+			<-r.done
+		}
+		if r.purged != nil {
+			<-r.purged
+		}
+		if r.Session != nil {
+			r.Session.Close()
+		}
+		r.closeTenants()
+		if r.SQL != nil {
+			err = r.SQL.Close()
+		}
+		r.status = addressbook.Unknown
+		r.logger.Info("database connections closed")
+	})
+	return err
+}
+
 func (r *Repo) connect() (err error) {
 	if r.Session != nil {
 		r.Session.Close()
@@ -69,13 +156,41 @@ func (r *Repo) connect() (err error) {
 		r.DB = nil
 	}
 
-	r.Session, err = mgo.Dial(r.conf.Connection)
+	info, err := mgo.ParseURL(r.conf.Connection)
 	if err != nil {
 		return err
 	}
-	r.Session.SetSocketTimeout(time.Second * 15)
-	r.Session.SetSyncTimeout(time.Second * 15)
+	info.Timeout = r.conf.DialTimeout.Or(defaultDialTimeout)
+	info.PoolLimit = r.conf.PoolLimit
+	if info.PoolLimit <= 0 {
+		info.PoolLimit = defaultPoolLimit
+	}
+	r.Session, err = mgo.DialWithInfo(info)
+	if err != nil {
+		return err
+	}
+	// copies of the session inherit these settings
+	r.Session.SetSocketTimeout(r.conf.SocketTimeout.Or(defaultSocketTimeout))
+	r.Session.SetSyncTimeout(r.conf.SyncTimeout.Or(defaultSyncTimeout))
 	r.DB = r.Session.DB(r.conf.Name)
+	if n, err := controllers.Migrate(r.DB); err != nil {
+		r.logger.WithError(err).Error("can't migrate users")
+	} else if n > 0 {
+		r.logger.WithField("users", n).Info("users migrated")
+	}
+	if err = controllers.EnsureIndexes(r.DB, ""); err != nil {
+		r.logger.WithError(err).Error("can't create indexes")
+	}
+	return nil
+}
+
+func (r *Repo) connectSQLite() (err error) {
+	r.SQL, err = openSQLite(r.conf.Connection)
+	if err != nil {
+		r.status = addressbook.HaveProblems
+		return err
+	}
+	r.status = addressbook.Running
 	return nil
 }
 
@@ -83,3 +198,55 @@ func (r *Repo) connect() (err error) {
 func (r *Repo) GetStatus() addressbook.Code {
 	return r.status
 }
+
+// Copy returns controller which works on its own copy of MongoDB session and
+// the function which closes the copy. Copies use separate sockets from the
+// pool, so a slow query or a broken socket affects only one request.
+// Other storages return the shared controller. Database calls of the
+// controller fail when ctx is done, MongoDB timeouts of the copy are
+// limited by deadline of ctx for that. Controller works on data of t, or
+// on the default storage if t is nil.
+func (r *Repo) Copy(ctx context.Context, t *models.Tenant) (*controllers.Controller, func(), error) {
+	if r.memory != nil || r.SQL != nil {
+		if t == nil {
+			return r.Controller().WithContext(ctx), func() {}, nil
+		}
+		c, err := r.tenantController(t)
+		if err != nil {
+			return nil, nil, err
+		}
+		return c.WithNotifier(r.currentNotifier()).WithContext(ctx), func() {}, nil
+	}
+	s := r.Session.Copy()
+	if deadline, ok := ctx.Deadline(); ok {
+		left := time.Until(deadline)
+		if left <= 0 {
+			left = time.Millisecond
+		}
+		s.SetSocketTimeout(minDuration(left, r.conf.SocketTimeout.Or(defaultSocketTimeout)))
+		s.SetSyncTimeout(minDuration(left, r.conf.SyncTimeout.Or(defaultSyncTimeout)))
+	}
+	c := controllers.NewController(s.DB(r.conf.Name)).WithJournal(r.tenantJournal(t)).WithNotifier(r.currentNotifier())
+	if t != nil {
+		c = c.WithTenant(t)
+	}
+	return c.WithContext(ctx), s.Close, nil
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Controller returns controller for the storage selected by config.
+func (r *Repo) Controller() *controllers.Controller {
+	switch {
+	case r.memory != nil:
+		return r.memory.WithNotifier(r.currentNotifier())
+	case r.SQL != nil:
+		return controllers.NewSQLiteController(r.SQL).WithJournal(r.journal).WithNotifier(r.currentNotifier())
+	}
+	return controllers.NewController(r.DB).WithJournal(r.journal).WithNotifier(r.currentNotifier())
+}
@@ -0,0 +1,268 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ferux/addressbook/internal/controllers"
+	"github.com/ferux/addressbook/internal/models"
+)
+
+const (
+	// tenantCacheTTL limits how long tenants are kept without reading the
+	// registry, so changes made by other instances are seen in time.
+	tenantCacheTTL = time.Second * 30
+	// missingTenantTTL limits how long unknown tenants are remembered, so
+	// requests naming them don't read the registry every time.
+	missingTenantTTL = time.Second * 5
+	// maxMissingTenants limits the amount of remembered unknown tenants.
+	maxMissingTenants = 10000
+)
+
+// ErrTenantStorage reports in case tenants are enabled with SQLite database
+// which is not a file, tenants can't be kept apart from the default book then.
+var ErrTenantStorage = errors.New("tenants need sqlite database in a file, not in memory or temporary one")
+
+// tenantData keeps the registry record of a tenant with its storage.
+// Journal, controller and SQLite database live as long as the tenant, so
+// writes checked against quota are ordered by the same lock.
+type tenantData struct {
+	tenant     *models.Tenant
+	loaded     time.Time
+	journal    *controllers.Journal
+	controller *controllers.Controller
+	sql        *sql.DB
+}
+
+// CheckTenants checks if the storage can keep tenants apart. SQLite keeps
+// every tenant in a file next to the default one, so it needs a file.
+func (r *Repo) CheckTenants() error {
+	if r.SQL != nil && isMemorySQLite(r.conf.Connection) {
+		return ErrTenantStorage
+	}
+	return nil
+}
+
+// Tenants returns registry of tenants, which is kept in the default storage.
+func (r *Repo) Tenants() controllers.TenantStore {
+	return r.Controller().Tenant()
+}
+
+// Tenant returns tenant with specified id. Tenants are cached for a while,
+// unknown ones too. It returns models.ErrNotFound if tenant is not provisioned.
+func (r *Repo) Tenant(id string) (*models.Tenant, error) {
+	r.mu.Lock()
+	if d, ok := r.tenants[id]; ok && d.tenant != nil && time.Since(d.loaded) < tenantCacheTTL {
+		r.mu.Unlock()
+		return d.tenant, nil
+	}
+	if at, ok := r.missing[id]; ok && time.Since(at) < missingTenantTTL {
+		r.mu.Unlock()
+		return nil, models.ErrNotFound
+	}
+	r.mu.Unlock()
+	t, err := r.Tenants().SelectTenant(id)
+	if err == models.ErrNotFound {
+		r.forget(id)
+		r.mu.Lock()
+		if len(r.missing) >= maxMissingTenants {
+			r.missing = make(map[string]time.Time)
+		}
+		r.missing[id] = time.Now()
+		r.mu.Unlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d, ok := r.tenants[id]
+	if !ok {
+		d = &tenantData{journal: controllers.NewJournal()}
+		r.tenants[id] = d
+	}
+	d.tenant, d.loaded = t, time.Now()
+	return t, nil
+}
+
+// ProvisionTenant registers t and prepares its storage. MongoDB keeps the
+// tenant in its own database or in prefixed collections as isolation says.
+func (r *Repo) ProvisionTenant(t *models.Tenant, isolation string) error {
+	if r.Session != nil {
+		if isolation == models.IsolationPrefix {
+			t.Prefix = t.ID + "_"
+		} else {
+			t.Database = r.conf.Name + "_" + t.ID
+		}
+	}
+	if err := r.Tenants().CreateTenant(t); err != nil {
+		return err
+	}
+	r.forget(t.ID)
+	if r.Session != nil {
+		db := r.DB
+		if t.Database != "" {
+			db = r.Session.DB(t.Database)
+		}
+		return controllers.EnsureIndexes(db, t.Prefix)
+	}
+	_, err := r.tenantController(t)
+	return err
+}
+
+// UpdateTenant changes name and quota of t.
+func (r *Repo) UpdateTenant(t *models.Tenant) error {
+	if err := r.Tenants().UpdateTenant(t); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	if d, ok := r.tenants[t.ID]; ok {
+		d.loaded = time.Time{}
+	}
+	r.mu.Unlock()
+	return nil
+}
+
+// DeprovisionTenant removes tenant with specified id and all its data.
+func (r *Repo) DeprovisionTenant(id string) error {
+	tenants := r.Tenants()
+	t, err := tenants.SelectTenant(id)
+	if err != nil {
+		return err
+	}
+	if err = tenants.DeleteTenant(id); err != nil {
+		return err
+	}
+	d := r.forget(id)
+	switch {
+	case r.Session != nil:
+		return controllers.DropTenant(r.DB, t)
+	case r.SQL != nil:
+		if d != nil && d.sql != nil {
+			d.sql.Close()
+		}
+		return removeSQLite(tenantPath(r.conf.Connection, id))
+	}
+	return nil
+}
+
+// forget drops cached tenant with its journal and storage opened by the repo.
+func (r *Repo) forget(id string) *tenantData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d := r.tenants[id]
+	delete(r.tenants, id)
+	delete(r.missing, id)
+	return d
+}
+
+// tenantController returns controller of the storage of t for memory and
+// SQLite drivers. The storage is opened on first use.
+func (r *Repo) tenantController(t *models.Tenant) (*controllers.Controller, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d, ok := r.tenants[t.ID]
+	if !ok {
+		d = &tenantData{tenant: t, loaded: time.Now(), journal: controllers.NewJournal()}
+		r.tenants[t.ID] = d
+	}
+	if d.controller != nil {
+		return d.controller.WithTenant(t), nil
+	}
+	if r.memory != nil {
+		d.controller = controllers.NewMemoryController().WithJournal(d.journal)
+		return d.controller.WithTenant(t), nil
+	}
+	db, err := openSQLite(tenantPath(r.conf.Connection, t.ID))
+	if err != nil {
+		return nil, err
+	}
+	d.sql = db
+	d.controller = controllers.NewSQLiteController(d.sql).WithJournal(d.journal)
+	return d.controller.WithTenant(t), nil
+}
+
+// tenantJournal returns journal of changes of t. Default storage uses the journal of repo.
+func (r *Repo) tenantJournal(t *models.Tenant) *controllers.Journal {
+	if t == nil {
+		return r.journal
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d, ok := r.tenants[t.ID]
+	if !ok {
+		d = &tenantData{tenant: t, loaded: time.Now(), journal: controllers.NewJournal()}
+		r.tenants[t.ID] = d
+	}
+	return d.journal
+}
+
+// closeTenants closes SQLite databases of tenants.
+func (r *Repo) closeTenants() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, d := range r.tenants {
+		if d.sql != nil {
+			d.sql.Close()
+		}
+	}
+	r.tenants = make(map[string]*tenantData)
+}
+
+func openSQLite(conn string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", conn)
+	if err != nil {
+		return nil, err
+	}
+	// sqlite allows only one writer at a time, so there is no need in more connections.
+	db.SetMaxOpenConns(1)
+	if err = controllers.MigrateSQLite(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// tenantPath returns connection to SQLite file of tenant id which is next
+// to the default one: addressbook.db becomes addressbook.<id>.db.
+func tenantPath(conn, id string) string {
+	path, query := conn, ""
+	if i := strings.IndexByte(conn, '?'); i >= 0 {
+		path, query = conn[:i], conn[i:]
+	}
+	if isMemorySQLite(conn) {
+		return conn
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + id + ext + query
+}
+
+// isMemorySQLite checks if conn opens in-memory or temporary SQLite database.
+func isMemorySQLite(conn string) bool {
+	path, query := strings.TrimPrefix(conn, "file:"), ""
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path, query = path[:i], path[i:]
+	}
+	return path == "" || strings.HasSuffix(path, ":memory:") || strings.Contains(query, "mode=memory")
+}
+
+// removeSQLite removes SQLite file of conn with its journals.
+func removeSQLite(conn string) error {
+	if isMemorySQLite(conn) {
+		return nil
+	}
+	path := strings.TrimPrefix(conn, "file:")
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	for _, suffix := range []string{"", "-journal", "-wal", "-shm"} {
+		if err := os.Remove(path + suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
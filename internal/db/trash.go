@@ -0,0 +1,63 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/ferux/addressbook/internal/models"
+)
+
+// Defaults of purging trash.
+const (
+	defaultTrashRetention = time.Hour * 24 * 30
+	defaultPurgeInterval  = time.Hour
+)
+
+// purgeTrash removes users kept in trash longer than retention every
+// interval until the repo is closed.
+func (r *Repo) purgeTrash() {
+	defer close(r.purged)
+	logger := r.logger.WithField("fn", "purgeTrash")
+	retention := r.conf.TrashRetention.Or(defaultTrashRetention)
+	for {
+		n, err := r.PurgeTrash(time.Now().Add(-retention))
+		if err != nil {
+			logger.WithError(err).Error("can't purge trash")
+		} else if n > 0 {
+			logger.WithField("users", n).Info("trash purged")
+		}
+		select {
+		case <-r.stop:
+			return
+		case <-time.After(r.conf.PurgeInterval.Or(defaultPurgeInterval)):
+		}
+	}
+}
+
+// PurgeTrash removes users deleted before the time from the default storage
+// and storages of all tenants. It returns the amount of removed users, the
+// ones removed before an error are counted too.
+func (r *Repo) PurgeTrash(before time.Time) (int, error) {
+	tenants, err := r.Tenants().ListTenants()
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for i := -1; i < len(tenants); i++ {
+		var t *models.Tenant
+		if i >= 0 {
+			t = &tenants[i]
+		}
+		c, done, err := r.Copy(context.Background(), t)
+		if err != nil {
+			return total, err
+		}
+		n, err := c.User().PurgeTrash(before)
+		done()
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
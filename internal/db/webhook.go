@@ -0,0 +1,41 @@
+package db
+
+import (
+	"context"
+
+	"github.com/ferux/addressbook/internal/controllers"
+	"github.com/ferux/addressbook/internal/models"
+)
+
+// SetNotifier makes controllers of the repo pass changes of users to
+// webhooks through n. It should be called before controllers are used.
+func (r *Repo) SetNotifier(n controllers.Notifier) {
+	r.mu.Lock()
+	r.notifier = n
+	r.mu.Unlock()
+}
+
+// currentNotifier returns the notifier set by SetNotifier.
+func (r *Repo) currentNotifier() controllers.Notifier {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.notifier
+}
+
+// AddDeadLetter stores failed delivery to a webhook of the book of owner in
+// tenant. Empty tenant means the default storage.
+func (r *Repo) AddDeadLetter(tenant, owner string, d *models.DeadLetter) error {
+	var t *models.Tenant
+	if tenant != "" {
+		var err error
+		if t, err = r.Tenant(tenant); err != nil {
+			return err
+		}
+	}
+	c, done, err := r.Copy(context.Background(), t)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return c.WithOwner(owner).Webhook().AddDeadLetter(d)
+}
@@ -0,0 +1,293 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Operations of a batch.
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchUpsert = "upsert"
+	BatchDelete = "delete"
+)
+
+var (
+	// ErrNotApplied is an error returned for operations of atomic batch which is rolled back.
+	ErrNotApplied = errors.New("operation is not applied, another operation of the batch failed")
+	// ErrAtomicUnsupported is an error returned when storage can't roll back batch.
+	ErrAtomicUnsupported = errors.New("storage can't apply batch atomically")
+)
+
+// BatchOp is one operation of a batch. Create and upsert take User, update
+// takes User with ID, delete takes ID. Update changes the user only if it has
+// User.Version, when it is set. Storage sets ID and Version of User on success
+// and Before to the user as it was before the operation, nil for a new user.
+type BatchOp struct {
+	Op     string `json:"op"`
+	ID     ID     `json:"id,omitempty"`
+	User   *User  `json:"user,omitempty"`
+	Before *User  `json:"-"`
+}
+
+// IsValidBatchOp checks if op is a known operation.
+func IsValidBatchOp(op string) bool {
+	return contains([]string{BatchCreate, BatchUpdate, BatchUpsert, BatchDelete}, op)
+}
+
+// BatchUsers applies ops by one unordered bulk write and returns an error of
+// every operation, nil if it succeeded. Targets of operations should differ.
+// Users are checked before the write, the ones changed meanwhile are
+// reported as ErrConflict. Written users get ErrAlreadyExists if their phones
+// or emails are used by other users of the book.
+func BatchUsers(db *mgo.Collection, owner string, ops []BatchOp) ([]error, error) {
+	errs := make([]error, len(ops))
+	ids := make([]ID, 0, len(ops))
+	phones, emails := []string{}, []string{}
+	for i := range ops {
+		op := &ops[i]
+		if op.Op != BatchDelete {
+			phones = append(phones, op.User.PhoneKeys()...)
+			emails = append(emails, op.User.EmailValues()...)
+		}
+		switch op.Op {
+		case BatchCreate:
+		case BatchUpsert:
+			if op.User.ID == "" {
+				op.User.ID = NewID()
+			}
+			ids = append(ids, op.User.ID)
+		default:
+			ids = append(ids, op.ID)
+		}
+	}
+	stored := make(map[ID]User, len(ids))
+	if len(ids) > 0 {
+		var found []User
+		err := db.Find(bson.M{"_id": bson.M{"$in": ids}}).All(&found)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range found {
+			stored[u.ID] = u
+		}
+	}
+	taken, err := takenKeys(db, owner, phones, emails)
+	if err != nil {
+		return nil, err
+	}
+
+	bulk := db.Bulk()
+	bulk.Unordered()
+	var (
+		positions []int // index of operation of every bulk write
+		updated   []int // operations which update users
+		deleted   []int // operations which move users to trash
+		matched   int   // users which should be matched by bulk
+		// deletedAt marks users trashed by the batch, Mongo keeps milliseconds
+		deletedAt = time.Now().UTC().Truncate(time.Millisecond)
+	)
+	for i := range ops {
+		op := &ops[i]
+		u := op.User
+		switch op.Op {
+		case BatchCreate:
+			keys := append(u.PhoneKeys(), u.EmailValues()...)
+			if taken.any(keys, "") {
+				errs[i] = ErrAlreadyExists
+				continue
+			}
+			u.ID, u.Owner, u.Version = NewID(), owner, 1
+			taken.add(keys, u.ID)
+			bulk.Insert(u)
+		case BatchUpdate:
+			current, ok := stored[op.ID]
+			if !ok || current.Owner != owner || current.DeletedAt != nil {
+				errs[i] = ErrNotFound
+				continue
+			}
+			if u.Version != 0 && u.Version != current.Version {
+				errs[i] = ErrVersionMismatch
+				continue
+			}
+			keys := append(u.PhoneKeys(), u.EmailValues()...)
+			if taken.any(keys, op.ID) {
+				errs[i] = ErrAlreadyExists
+				continue
+			}
+			taken.add(keys, op.ID)
+			u.ID, u.Owner, u.Version, u.DeletedAt = op.ID, owner, current.Version+1, nil
+			selector := inBook(owner)
+			selector["_id"], selector["version"] = op.ID, current.Version
+			bulk.Update(selector, u)
+			op.Before = &current
+			updated = append(updated, i)
+			matched++
+		case BatchUpsert:
+			current, ok := stored[u.ID]
+			if ok && current.Owner != owner {
+				errs[i] = ErrAlreadyExists
+				continue
+			}
+			keys := append(u.PhoneKeys(), u.EmailValues()...)
+			if taken.any(keys, u.ID) {
+				errs[i] = ErrAlreadyExists
+				continue
+			}
+			taken.add(keys, u.ID)
+			u.Owner, u.Version, u.DeletedAt = owner, current.Version+1, nil
+			if !ok {
+				bulk.Insert(u)
+				break
+			}
+			bulk.Update(bson.M{"_id": u.ID, "owner": owner, "version": current.Version}, u)
+			op.Before = &current
+			updated = append(updated, i)
+			matched++
+		case BatchDelete:
+			current, ok := stored[op.ID]
+			if !ok || current.Owner != owner || current.DeletedAt != nil {
+				errs[i] = ErrNotFound
+				continue
+			}
+			selector := inBook(owner)
+			selector["_id"], selector["version"] = op.ID, current.Version
+			bulk.Update(selector, trashUpdate(deletedAt))
+			op.Before = &current
+			deleted = append(deleted, i)
+			matched++
+		}
+		positions = append(positions, i)
+	}
+	if len(positions) == 0 {
+		return errs, nil
+	}
+
+	res, err := bulk.Run()
+	if berr, ok := err.(*mgo.BulkError); ok {
+		for _, c := range berr.Cases() {
+			if c.Index < 0 || c.Index >= len(positions) {
+				return nil, err
+			}
+			i := positions[c.Index]
+			switch {
+			case mgo.IsDup(c.Err) && ops[i].Op == BatchUpsert:
+				// the user is created meanwhile
+				errs[i] = ErrConflict
+			case mgo.IsDup(c.Err):
+				errs[i] = ErrAlreadyExists
+			default:
+				errs[i] = c.Err
+			}
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	if res != nil && res.Matched >= matched {
+		return errs, nil
+	}
+	// bulk does not tell which writes have not matched, so written users are compared with stored ones
+	return errs, checkWritten(db, ops, updated, deleted, stored, deletedAt, errs)
+}
+
+// checkWritten sets ErrConflict for updates, upserts of existing users and
+// deletes which have not been written. Users trashed by the batch have
+// deletedAt and the next version after the one read before the write.
+func checkWritten(db *mgo.Collection, ops []BatchOp, updated, deleted []int, before map[ID]User, deletedAt time.Time, errs []error) error {
+	ids := make([]ID, 0, len(updated)+len(deleted))
+	for _, i := range updated {
+		ids = append(ids, ops[i].User.ID)
+	}
+	for _, i := range deleted {
+		ids = append(ids, ops[i].ID)
+	}
+	var found []User
+	if err := db.Find(bson.M{"_id": bson.M{"$in": ids}}).All(&found); err != nil {
+		return err
+	}
+	stored := make(map[ID][]byte, len(found))
+	trashed := make(map[ID]bool, len(found))
+	for _, u := range found {
+		data, err := bson.Marshal(&u)
+		if err != nil {
+			return err
+		}
+		stored[u.ID] = data
+		trashed[u.ID] = u.DeletedAt != nil && u.DeletedAt.Equal(deletedAt) && u.Version == before[u.ID].Version+1
+	}
+	for _, i := range deleted {
+		if errs[i] == nil && !trashed[ops[i].ID] {
+			errs[i] = ErrConflict
+		}
+	}
+	for _, i := range updated {
+		if errs[i] != nil {
+			continue
+		}
+		data, err := bson.Marshal(ops[i].User)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(data, stored[ops[i].User.ID]) {
+			errs[i] = ErrConflict
+		}
+	}
+	return nil
+}
+
+// keySet keeps phones and emails used by users of a book along with their ids.
+type keySet map[string]map[ID]bool
+
+// any checks if any of keys is used by a user other than id.
+func (s keySet) any(keys []string, id ID) bool {
+	for _, k := range keys {
+		for user := range s[k] {
+			if user != id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s keySet) add(keys []string, id ID) {
+	for _, k := range keys {
+		if s[k] == nil {
+			s[k] = make(map[ID]bool)
+		}
+		s[k][id] = true
+	}
+}
+
+// takenKeys returns which of phones and emails are used by users of the book.
+func takenKeys(db *mgo.Collection, owner string, phones, emails []string) (keySet, error) {
+	taken := make(keySet)
+	if len(phones) == 0 && len(emails) == 0 {
+		return taken, nil
+	}
+	var found []User
+	filter := inBook(owner)
+	filter["$or"] = []bson.M{
+		{"phones.e164": bson.M{"$in": phones}},
+		{"phones.value": bson.M{"$in": phones}},
+		{"emails.value": bson.M{"$in": emails}},
+	}
+	err := db.Find(filter).Select(bson.M{"_id": 1, "phones": 1, "emails": 1}).All(&found)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range found {
+		taken.add(u.PhoneKeys(), u.ID)
+		taken.add(u.EmailValues(), u.ID)
+		// records stored before normalization are compared by values
+		for _, p := range u.Phones {
+			taken.add([]string{p.Value}, u.ID)
+		}
+	}
+	return taken, nil
+}
@@ -0,0 +1,159 @@
+package models
+
+import (
+	"encoding/json"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Labels of emails, phones and addresses.
+const (
+	LabelHome   = "home"
+	LabelWork   = "work"
+	LabelMobile = "mobile"
+	LabelOther  = "other"
+)
+
+// EmailLabels lists labels allowed for emails and addresses.
+var EmailLabels = []string{LabelHome, LabelWork, LabelOther}
+
+// PhoneLabels lists labels allowed for phones.
+var PhoneLabels = []string{LabelHome, LabelWork, LabelMobile, LabelOther}
+
+// ValidLabel checks if label is empty or one of allowed.
+func ValidLabel(label string, allowed []string) bool {
+	return label == "" || contains(allowed, label)
+}
+
+// Email is a labelled email address
+type Email struct {
+	Label string `json:"label,omitempty" bson:"label,omitempty"`
+	Value string `json:"value" bson:"value"`
+}
+
+// Phone is a labelled phone number. Value keeps formatting entered by user,
+// E164 is the normalized number used to find duplicates.
+type Phone struct {
+	Label string `json:"label,omitempty" bson:"label,omitempty"`
+	Value string `json:"value" bson:"value"`
+	E164  string `json:"e164,omitempty" bson:"e164,omitempty"`
+}
+
+// Key returns the value which identifies the number: E164 if the number
+// has been normalized or the value itself.
+func (p *Phone) Key() string {
+	if p.E164 != "" {
+		return p.E164
+	}
+	return p.Value
+}
+
+// Address is a labelled postal address
+type Address struct {
+	Label      string `json:"label,omitempty" bson:"label,omitempty"`
+	Street     string `json:"street,omitempty" bson:"street,omitempty"`
+	City       string `json:"city,omitempty" bson:"city,omitempty"`
+	Region     string `json:"region,omitempty" bson:"region,omitempty"`
+	PostalCode string `json:"postal_code,omitempty" bson:"postal_code,omitempty"`
+	Country    string `json:"country,omitempty" bson:"country,omitempty"`
+}
+
+// IsEmpty reports whether all parts of the address are empty.
+func (a *Address) IsEmpty() bool {
+	return a.Street == "" && a.City == "" && a.Region == "" && a.PostalCode == "" && a.Country == ""
+}
+
+// EmailValues returns all emails of the user.
+func (u *User) EmailValues() []string {
+	values := make([]string, 0, len(u.Emails))
+	for _, e := range u.Emails {
+		values = append(values, e.Value)
+	}
+	return values
+}
+
+// PhoneKeys returns keys of all phones of the user.
+func (u *User) PhoneKeys() []string {
+	keys := make([]string, 0, len(u.Phones))
+	for i := range u.Phones {
+		keys = append(keys, u.Phones[i].Key())
+	}
+	return keys
+}
+
+// PhoneValues returns all phones of the user.
+func (u *User) PhoneValues() []string {
+	values := make([]string, 0, len(u.Phones))
+	for _, p := range u.Phones {
+		values = append(values, p.Value)
+	}
+	return values
+}
+
+// UnmarshalJSON decodes user and accepts single email and phone fields
+// used by the previous version of API.
+func (u *User) UnmarshalJSON(data []byte) error {
+	type user User
+	var v struct {
+		user
+		Email string `json:"email"`
+		Phone string `json:"phone"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*u = User(v.user)
+	u.addLegacy(v.Email, v.Phone)
+	return nil
+}
+
+// addLegacy adds single email and phone to the lists unless they are already there.
+func (u *User) addLegacy(email, phone string) {
+	if email != "" && !contains(u.EmailValues(), email) {
+		u.Emails = append(u.Emails, Email{Label: LabelOther, Value: email})
+	}
+	if phone != "" && !contains(u.PhoneValues(), phone) {
+		u.Phones = append(u.Phones, Phone{Label: LabelOther, Value: phone})
+	}
+}
+
+// MigrateUsers moves single email and phone fields of old records into the
+// lists and gives owner to records without it
+func MigrateUsers(db *mgo.Collection) (int, error) {
+	legacy := bson.M{"$or": []bson.M{
+		{"email": bson.M{"$exists": true}},
+		{"phone": bson.M{"$exists": true}},
+	}}
+	type legacyUser struct {
+		User  `bson:",inline"`
+		Email string `bson:"email"`
+		Phone string `bson:"phone"`
+	}
+	// users stored before books became private belong to LegacyOwner
+	info, err := db.UpdateAll(bson.M{"owner": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"owner": LegacyOwner}})
+	if err != nil {
+		return 0, err
+	}
+	migrated := info.Updated
+	// versions start from 1, 0 means that the writer does not expect any
+	if info, err = db.UpdateAll(bson.M{"version": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"version": 1}}); err != nil {
+		return migrated, err
+	}
+	migrated += info.Updated
+	iter := db.Find(legacy).Iter()
+	for doc := new(legacyUser); iter.Next(doc); doc = new(legacyUser) {
+		u := doc.User
+		u.addLegacy(doc.Email, doc.Phone)
+		err := db.UpdateId(u.ID, bson.M{
+			"$set":   bson.M{"emails": u.Emails, "phones": u.Phones},
+			"$unset": bson.M{"email": "", "phone": ""},
+		})
+		if err != nil {
+			iter.Close()
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, iter.Close()
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestUserLegacyJSON(t *testing.T) {
+	var u User
+	err := json.Unmarshal([]byte(`{
+		"first_name": "Ann", "email": "ann@example.com", "phone": "555",
+		"emails": [{"label": "work", "value": "ann@example.com"}]
+	}`), &u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(u.Emails) != 1 || u.Emails[0].Label != LabelWork {
+		t.Errorf("emails are %+v, want the listed one only", u.Emails)
+	}
+	if len(u.Phones) != 1 || u.Phones[0].Label != LabelOther || u.Phones[0].Value != "555" {
+		t.Errorf("phones are %+v, want the legacy one", u.Phones)
+	}
+}
+
+func TestPhoneKeys(t *testing.T) {
+	u := User{Phones: []Phone{{Value: "+1 (555) 010-0000", E164: "+15550100000"}, {Value: "ext 12"}}}
+	keys := u.PhoneKeys()
+	if len(keys) != 2 || keys[0] != "+15550100000" || keys[1] != "ext 12" {
+		t.Errorf("phone keys are %v", keys)
+	}
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ID identifies a record. It is a 24 characters long hex string which
+// keeps the order of creation. MongoDB stores it as ObjectId, other storages
+// keep it as is.
+type ID string
+
+// bsonObjectIDKind is a bson element kind of ObjectId.
+const bsonObjectIDKind = 0x07
+
+var (
+	// idProcess distinguishes ids created by different processes.
+	idProcess = randUint32()
+	// idCounter makes ids created within the same second unique.
+	idCounter = randUint32()
+)
+
+func randUint32() uint32 {
+	var b [4]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint32(b[:])
+}
+
+// NewID generates a new unique id.
+func NewID() ID {
+	var b [12]byte
+	binary.BigEndian.PutUint32(b[:4], uint32(time.Now().Unix()))
+	binary.BigEndian.PutUint32(b[4:8], idProcess)
+	binary.BigEndian.PutUint32(b[8:], atomic.AddUint32(&idCounter, 1))
+	return ID(hex.EncodeToString(b[:]))
+}
+
+// IsValidID checks if s can be used as an id.
+func IsValidID(s string) bool {
+	if len(s) != 24 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// ParseID converts s to an id. Hex digits are lowercased, so every storage
+// finds the same user by it: MongoDB reads ObjectId back in lower case.
+func ParseID(s string) (ID, bool) {
+	if !IsValidID(s) {
+		return "", false
+	}
+	return ID(strings.ToLower(s)), true
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, so ids sent in JSON
+// are lowercased like ParseID does. Invalid ids are kept as is.
+func (id *ID) UnmarshalText(text []byte) error {
+	if parsed, ok := ParseID(string(text)); ok {
+		*id = parsed
+		return nil
+	}
+	*id = ID(text)
+	return nil
+}
+
+// String implements fmt.Stringer.
+func (id ID) String() string {
+	return string(id)
+}
+
+// GetBSON implements bson.Getter so the id is stored as ObjectId.
+func (id ID) GetBSON() (interface{}, error) {
+	if IsValidID(string(id)) {
+		return bson.ObjectIdHex(string(id)), nil
+	}
+	return string(id), nil
+}
+
+// SetBSON implements bson.Setter.
+func (id *ID) SetBSON(raw bson.Raw) error {
+	if raw.Kind == bsonObjectIDKind {
+		var oid bson.ObjectId
+		if err := raw.Unmarshal(&oid); err != nil {
+			return err
+		}
+		*id = ID(oid.Hex())
+		return nil
+	}
+	var s string
+	if err := raw.Unmarshal(&s); err != nil {
+		return err
+	}
+	*id = ID(s)
+	return nil
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseID(t *testing.T) {
+	for s, want := range map[string]ID{
+		"5b4f1b7b8d6e4a2a1c3e9f10": "5b4f1b7b8d6e4a2a1c3e9f10",
+		"5B4F1B7B8D6E4A2A1C3E9F10": "5b4f1b7b8d6e4a2a1c3e9f10",
+		"5b4f1b7b8d6e4a2a1c3e9f1":  "",
+		"5b4f1b7b8d6e4a2a1c3e9f1x": "",
+		"":                         "",
+	} {
+		id, ok := ParseID(s)
+		if id != want || ok != (want != "") {
+			t.Errorf("ParseID(%q) = %q, %v, want %q", s, id, ok, want)
+		}
+	}
+}
+
+func TestIDFromJSON(t *testing.T) {
+	var op BatchOp
+	if err := json.Unmarshal([]byte(`{"op": "delete", "id": "5B4F1B7B8D6E4A2A1C3E9F10"}`), &op); err != nil {
+		t.Fatal(err)
+	}
+	if op.ID != "5b4f1b7b8d6e4a2a1c3e9f10" {
+		t.Errorf("id is %q, want it lowercased", op.ID)
+	}
+	if err := json.Unmarshal([]byte(`{"id": "Ann"}`), &op); err != nil || op.ID != "Ann" {
+		t.Errorf("invalid id is read as %q, %v", op.ID, err)
+	}
+}
+
+func TestNewIDOrder(t *testing.T) {
+	a, b := NewID(), NewID()
+	if !IsValidID(string(a)) || a >= b {
+		t.Errorf("ids %s and %s are not in order of creation", a, b)
+	}
+}
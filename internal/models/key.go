@@ -0,0 +1,108 @@
+package models
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Roles of API keys. Every role has the rights of the previous one.
+const (
+	// RoleViewer only reads the book
+	RoleViewer = "viewer"
+	// RoleEditor creates, updates and deletes users
+	RoleEditor = "editor"
+	// RoleAdmin also clears the book and manages keys
+	RoleAdmin = "admin"
+)
+
+// IsValidRole checks if role is one of known roles
+func IsValidRole(role string) bool {
+	return role == RoleViewer || role == RoleEditor || role == RoleAdmin
+}
+
+// APIKey gives access to API. Only hash of the key is stored, Prefix is
+// the beginning of the key which helps to tell keys apart. Keys with the
+// same Book share the book, a key gets a book of its own by default.
+type APIKey struct {
+	ID     ID     `json:"id" bson:"_id,omitempty"`
+	Name   string `json:"name" bson:"name"`
+	Prefix string `json:"prefix" bson:"prefix"`
+	Hash   string `json:"-" bson:"hash"`
+	Role   string `json:"role" bson:"role,omitempty"`
+	Book   string `json:"book" bson:"book,omitempty"`
+	// Admin marks admin keys created before roles, it is kept for them only.
+	Admin     bool      `json:"-" bson:"admin,omitempty"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// Normalize sets Role of keys created before roles. They could write, so
+// they become editors unless they are admins. Keys created before books
+// were shared keep their own books, which are named by their ids.
+func (k *APIKey) Normalize() {
+	if k.Book == "" {
+		k.Book = k.ID.String()
+	}
+	if k.Role != "" {
+		return
+	}
+	k.Role = RoleEditor
+	if k.Admin {
+		k.Role = RoleAdmin
+	}
+}
+
+// CreateKey stores new key
+func CreateKey(db *mgo.Collection, k *APIKey) error {
+	k.ID = NewID()
+	k.Normalize()
+	return db.Insert(k)
+}
+
+// ListKeys returns all keys ordered by creation time
+func ListKeys(db *mgo.Collection) ([]APIKey, error) {
+	keys := make([]APIKey, 0)
+	if err := db.Find(nil).Sort("created_at").All(&keys); err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		keys[i].Normalize()
+	}
+	return keys, nil
+}
+
+// FindKey returns key with the hash
+func FindKey(db *mgo.Collection, hash string) (*APIKey, error) {
+	var k APIKey
+	if err := db.Find(bson.M{"hash": hash}).One(&k); err != nil {
+		return nil, notFound(err)
+	}
+	k.Normalize()
+	return &k, nil
+}
+
+// SelectKey returns key with specified id
+func SelectKey(db *mgo.Collection, id ID) (*APIKey, error) {
+	var k APIKey
+	if err := db.FindId(id).One(&k); err != nil {
+		return nil, notFound(err)
+	}
+	k.Normalize()
+	return &k, nil
+}
+
+// SetKeyRole changes role of key with specified id
+func SetKeyRole(db *mgo.Collection, id ID, role string) error {
+	return notFound(db.UpdateId(id, bson.M{"$set": bson.M{"role": role}, "$unset": bson.M{"admin": ""}}))
+}
+
+// DeleteKey removes key with specified id
+func DeleteKey(db *mgo.Collection, id ID) error {
+	return notFound(db.RemoveId(id))
+}
+
+// EnsureKeyIndexes creates indexes used by key queries
+func EnsureKeyIndexes(db *mgo.Collection) error {
+	return db.EnsureIndex(mgo.Index{Key: []string{"hash"}, Unique: true})
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// LegacyOwner owns users stored before address books became private.
+const LegacyOwner = ""
+
+// KeyOwner returns the owner of the book of API keys. Keys with the same book and tokens issued for them share it.
+func KeyOwner(book string) string {
+	return "key:" + book
+}
+
+// SessionOwner returns the owner of the anonymous book of browser session.
+func SessionOwner(sid string) string {
+	return "session:" + sid
+}
+
+// ClaimResult reports users moved from one book to another.
+type ClaimResult struct {
+	Claimed []ID `json:"claimed"`
+	// Skipped users have an email or a phone of a user of the new book, they stay in the old one.
+	Skipped []ID `json:"skipped"`
+}
+
+// NewClaimResult creates empty result.
+func NewClaimResult() *ClaimResult {
+	return &ClaimResult{Claimed: make([]ID, 0), Skipped: make([]ID, 0)}
+}
+
+//ClaimUsers moves users of from book to the book of owner
+func ClaimUsers(db *mgo.Collection, owner, from string) (*ClaimResult, error) {
+	res := NewClaimResult()
+	iter := db.Find(bson.M{"owner": from}).Sort("_id").Iter()
+	for u := new(User); iter.Next(u); u = new(User) {
+		if isExistInBook(db, owner, u) {
+			res.Skipped = append(res.Skipped, u.ID)
+			continue
+		}
+		err := db.Update(bson.M{"_id": u.ID, "owner": from}, bson.M{"$set": bson.M{"owner": owner}})
+		if err == mgo.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			iter.Close()
+			return res, err
+		}
+		res.Claimed = append(res.Claimed, u.ID)
+	}
+	return res, iter.Close()
+}
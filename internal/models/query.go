@@ -0,0 +1,255 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Limits for ListQuery.
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+var (
+	// ErrBadCursor reports in case cursor can't be decoded or belongs to another sort order.
+	ErrBadCursor = errors.New("invalid cursor")
+	// ErrBadSort reports in case users can't be sorted by requested field.
+	ErrBadSort = errors.New("invalid sort field")
+	// ErrBadFilter reports in case users can't be filtered by requested field.
+	ErrBadFilter = errors.New("invalid filter")
+)
+
+// Fields of User which can be used in queries.
+const (
+	FieldID        = "id"
+	FieldFirstName = "first_name"
+	FieldLastName  = "last_name"
+	FieldEmail     = "email"
+	FieldPhone     = "phone"
+	FieldOrg       = "organization"
+)
+
+// FilterFields lists fields which can be used in filters. Filters on email
+// and phone match any of user's emails and phones.
+var FilterFields = []string{FieldID, FieldFirstName, FieldLastName, FieldEmail, FieldPhone, FieldOrg}
+
+// SortFields lists fields which can be used for sorting besides id.
+// Users are sorted by their first email.
+var SortFields = []string{FieldFirstName, FieldLastName, FieldEmail, FieldOrg}
+
+// Filter describes a condition on a field. Prefix filter matches values
+// which start with Value, otherwise values should be equal.
+type Filter struct {
+	Field  string
+	Value  string
+	Prefix bool
+}
+
+// ListQuery describes which users and in which order should be returned.
+// Users are always ordered by Sort field first and id next.
+type ListQuery struct {
+	Limit   int
+	After   *Cursor
+	Sort    string
+	Desc    bool
+	Filters []Filter
+}
+
+// UserPage is a part of users list.
+type UserPage struct {
+	Users []User `json:"users"`
+	Next  string `json:"next,omitempty"`
+	Total int    `json:"total"`
+}
+
+// Cursor points to the last user of previous page.
+type Cursor struct {
+	Sort  string `json:"s,omitempty"`
+	Desc  bool   `json:"d,omitempty"`
+	Value string `json:"v,omitempty"`
+	ID    ID     `json:"id"`
+}
+
+// Validate checks query and fills default values.
+func (q *ListQuery) Validate() error {
+	if q.Limit <= 0 {
+		q.Limit = DefaultLimit
+	}
+	if q.Limit > MaxLimit {
+		q.Limit = MaxLimit
+	}
+	if q.Sort == FieldID {
+		q.Sort = ""
+	}
+	if q.Sort != "" && !contains(SortFields, q.Sort) {
+		return ErrBadSort
+	}
+	for _, f := range q.Filters {
+		if !contains(FilterFields, f.Field) || (f.Field == FieldID && f.Prefix) {
+			return ErrBadFilter
+		}
+	}
+	if q.After != nil && (q.After.Sort != q.Sort || q.After.Desc != q.Desc) {
+		return ErrBadCursor
+	}
+	return nil
+}
+
+// CursorFor creates cursor which points to u.
+func (q *ListQuery) CursorFor(u *User) *Cursor {
+	c := &Cursor{Sort: q.Sort, Desc: q.Desc, ID: u.ID}
+	if q.Sort != "" {
+		c.Value = u.Field(q.Sort)
+	}
+	return c
+}
+
+// Match checks if u satisfies filters of query.
+func (q *ListQuery) Match(u *User) bool {
+	for _, f := range q.Filters {
+		if !f.match(u.Values(f.Field)) {
+			return false
+		}
+	}
+	return true
+}
+
+// match checks if any of values satisfies the filter.
+func (f *Filter) match(values []string) bool {
+	for _, v := range values {
+		if f.Prefix && strings.HasPrefix(v, f.Value) || !f.Prefix && v == f.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// Less reports whether a goes before b in the order of query.
+func (q *ListQuery) Less(a, b *User) bool {
+	va, vb := a.Field(q.Sort), b.Field(q.Sort)
+	if va == vb {
+		va, vb = string(a.ID), string(b.ID)
+	}
+	if q.Desc {
+		return va > vb
+	}
+	return va < vb
+}
+
+// Apply filters, sorts and cuts users according to query. It is used
+// by storages which can't do it themselves.
+func (q *ListQuery) Apply(users []User) *UserPage {
+	matched := make([]User, 0, len(users))
+	for i := range users {
+		if q.Match(&users[i]) {
+			matched = append(matched, users[i])
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return q.Less(&matched[i], &matched[j]) })
+
+	page := &UserPage{Total: len(matched)}
+	start := 0
+	if q.After != nil {
+		after := User{ID: q.After.ID}
+		if q.Sort != "" {
+			after.SetField(q.Sort, q.After.Value)
+		}
+		start = sort.Search(len(matched), func(i int) bool { return q.Less(&after, &matched[i]) })
+	}
+	end := start + q.Limit
+	if end > len(matched) {
+		end = len(matched)
+	}
+	page.Users = matched[start:end]
+	if end < len(matched) {
+		page.Next = q.CursorFor(&matched[end-1]).Encode()
+	}
+	return page
+}
+
+// Encode returns string representation of cursor.
+func (c *Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses cursor created by Cursor.Encode.
+func DecodeCursor(s string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+	var c Cursor
+	if err = json.Unmarshal(data, &c); err != nil || !IsValidID(string(c.ID)) {
+		return nil, ErrBadCursor
+	}
+	return &c, nil
+}
+
+// Field returns value of field by its name. Empty name means id. For
+// emails and phones the first one is returned.
+func (u *User) Field(name string) string {
+	switch name {
+	case FieldFirstName:
+		return u.FirstName
+	case FieldLastName:
+		return u.LastName
+	case FieldEmail:
+		if len(u.Emails) > 0 {
+			return u.Emails[0].Value
+		}
+		return ""
+	case FieldPhone:
+		if len(u.Phones) > 0 {
+			return u.Phones[0].Value
+		}
+		return ""
+	case FieldOrg:
+		return u.Organization
+	default:
+		return string(u.ID)
+	}
+}
+
+// Values returns all values of field by its name.
+func (u *User) Values(name string) []string {
+	switch name {
+	case FieldEmail:
+		return u.EmailValues()
+	case FieldPhone:
+		return u.PhoneValues()
+	default:
+		return []string{u.Field(name)}
+	}
+}
+
+// SetField sets value of field by its name. Empty name means id.
+func (u *User) SetField(name, value string) {
+	switch name {
+	case FieldFirstName:
+		u.FirstName = value
+	case FieldLastName:
+		u.LastName = value
+	case FieldEmail:
+		u.Emails = []Email{{Value: value}}
+	case FieldPhone:
+		u.Phones = []Phone{{Value: value}}
+	case FieldOrg:
+		u.Organization = value
+	default:
+		u.ID = ID(value)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
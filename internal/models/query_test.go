@@ -0,0 +1,69 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func testUsers(names ...string) []User {
+	users := make([]User, 0, len(names))
+	for _, name := range names {
+		users = append(users, User{ID: NewID(), FirstName: "Ann", LastName: name})
+	}
+	return users
+}
+
+func lastNames(users []User) string {
+	names := make([]string, 0, len(users))
+	for _, u := range users {
+		names = append(names, u.LastName)
+	}
+	return strings.Join(names, " ")
+}
+
+func TestListQueryApply(t *testing.T) {
+	users := testUsers("Evans", "Adams", "Doe", "Clark", "Brown", "Doe")
+	q := &ListQuery{Limit: 4, Sort: FieldLastName}
+	if err := q.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	page := q.Apply(users)
+	if got := lastNames(page.Users); got != "Adams Brown Clark Doe" || page.Total != 6 || page.Next == "" {
+		t.Fatalf("first page is %q of %d with next %q", got, page.Total, page.Next)
+	}
+	if q.After, _ = DecodeCursor(page.Next); q.After == nil {
+		t.Fatalf("can't decode cursor %q", page.Next)
+	}
+	page = q.Apply(users)
+	if got := lastNames(page.Users); got != "Doe Evans" || page.Next != "" {
+		t.Errorf("second page is %q with next %q", got, page.Next)
+	}
+
+	q = &ListQuery{Sort: FieldLastName, Desc: true, Filters: []Filter{{Field: FieldLastName, Value: "D", Prefix: true}}}
+	if err := q.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if got := lastNames(q.Apply(users).Users); got != "Doe Doe" {
+		t.Errorf("filtered page is %q", got)
+	}
+}
+
+func TestListQueryValidate(t *testing.T) {
+	for _, q := range []ListQuery{
+		{Sort: "notes"},
+		{Filters: []Filter{{Field: "secret", Value: "x"}}},
+		{Filters: []Filter{{Field: FieldID, Value: "5b", Prefix: true}}},
+		{Sort: FieldLastName, After: &Cursor{ID: NewID()}},
+	} {
+		if err := q.Validate(); err == nil {
+			t.Errorf("query %+v is valid", q)
+		}
+	}
+	q := ListQuery{Limit: MaxLimit + 1, Sort: FieldID}
+	if err := q.Validate(); err != nil || q.Limit != MaxLimit || q.Sort != "" {
+		t.Errorf("query is %+v, %v", q, err)
+	}
+	if _, err := DecodeCursor("not a cursor"); err != ErrBadCursor {
+		t.Errorf("broken cursor is decoded with %v", err)
+	}
+}
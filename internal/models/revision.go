@@ -0,0 +1,131 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Actions recorded by revisions.
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionRestore = "restore"
+)
+
+// Change is a field of user changed by revision. From is missing for new
+// fields and To is missing for removed ones.
+type Change struct {
+	Field string      `json:"field" bson:"field"`
+	From  interface{} `json:"from,omitempty" bson:"from,omitempty"`
+	To    interface{} `json:"to,omitempty" bson:"to,omitempty"`
+}
+
+// Revision is an immutable record of a write of user. User keeps the user as
+// it is after the write, it is nil for deletion. Version is the version of
+// the user after the write.
+type Revision struct {
+	ID        ID        `json:"id" bson:"_id,omitempty"`
+	UserID    ID        `json:"user_id" bson:"user_id"`
+	Owner     string    `json:"-" bson:"owner"`
+	Version   int64     `json:"version" bson:"version"`
+	Action    string    `json:"action" bson:"action"`
+	Actor     string    `json:"actor,omitempty" bson:"actor,omitempty"`
+	RequestID string    `json:"request_id,omitempty" bson:"request_id,omitempty"`
+	At        time.Time `json:"at" bson:"at"`
+	Changes   []Change  `json:"changes" bson:"changes"`
+	User      *User     `json:"user,omitempty" bson:"user,omitempty"`
+}
+
+// Diff returns fields which differ in before and after, ordered by name.
+// Fields are compared as they are written in JSON, nil user has no fields.
+// Id, version and time of deletion are not compared.
+func Diff(before, after *User) []Change {
+	a, b := userFields(before), userFields(after)
+	changes := make([]Change, 0)
+	for field, from := range a {
+		if to, ok := b[field]; !ok || !reflect.DeepEqual(from, to) {
+			changes = append(changes, Change{Field: field, From: from, To: b[field]})
+		}
+	}
+	for field, to := range b {
+		if _, ok := a[field]; !ok {
+			changes = append(changes, Change{Field: field, To: to})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// userFields decodes JSON of u into map of fields.
+func userFields(u *User) map[string]interface{} {
+	fields := make(map[string]interface{})
+	if u == nil {
+		return fields
+	}
+	data, err := json.Marshal(u)
+	if err != nil {
+		return fields
+	}
+	json.Unmarshal(data, &fields)
+	for _, name := range []string{"id", "version", "deleted_at"} {
+		delete(fields, name)
+	}
+	return fields
+}
+
+//AddRevision stores revision of the book of owner
+func AddRevision(db *mgo.Collection, owner string, r *Revision) error {
+	r.ID = NewID()
+	r.Owner = owner
+	return db.Insert(r)
+}
+
+//ListRevisions returns revisions of the user, the latest go first
+func ListRevisions(db *mgo.Collection, owner string, id ID) ([]Revision, error) {
+	revisions := make([]Revision, 0)
+	err := db.Find(bson.M{"owner": owner, "user_id": id}).Sort("-at", "-_id").All(&revisions)
+	if err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+//SelectRevision returns revision of the user which has written the version
+func SelectRevision(db *mgo.Collection, owner string, id ID, version int64) (*Revision, error) {
+	r := Revision{}
+	err := db.Find(bson.M{"owner": owner, "user_id": id, "version": version}).Sort("-at", "-_id").One(&r)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &r, nil
+}
+
+//RevisionAt returns the last revision of the user written not later than at
+func RevisionAt(db *mgo.Collection, owner string, id ID, at time.Time) (*Revision, error) {
+	r := Revision{}
+	err := db.Find(bson.M{"owner": owner, "user_id": id, "at": bson.M{"$lte": at}}).Sort("-at", "-_id").One(&r)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &r, nil
+}
+
+//MoveRevisions moves revisions of users with ids from the book of from to the book of owner
+func MoveRevisions(db *mgo.Collection, owner, from string, ids []ID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := db.UpdateAll(bson.M{"owner": from, "user_id": bson.M{"$in": ids}}, bson.M{"$set": bson.M{"owner": owner}})
+	return err
+}
+
+//EnsureRevisionIndexes creates indexes used to find revisions of user
+func EnsureRevisionIndexes(db *mgo.Collection) error {
+	return db.EnsureIndex(mgo.Index{Key: []string{"owner", "user_id", "at"}})
+}
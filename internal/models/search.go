@@ -0,0 +1,194 @@
+package models
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// MaxSearchLimit limits the amount of search results.
+const MaxSearchLimit = 100
+
+// Weights of fields in search rank.
+const (
+	weightName  = 1.0
+	weightEmail = 0.8
+	weightPhone = 0.8
+	weightOrg   = 0.6
+)
+
+// searchField is a set of tokens of one field with its weight.
+type searchField struct {
+	tokens []string
+	weight float64
+	fuzzy  bool
+}
+
+// Tokenize splits s into lowercase words. Everything except letters and digits is a separator.
+func Tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// digits returns only digits of s.
+func digits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+func searchFields(u *User) []searchField {
+	emails := make([]string, 0, len(u.Emails)*3)
+	for _, e := range u.Emails {
+		email := strings.ToLower(e.Value)
+		local := email
+		if i := strings.LastIndex(email, "@"); i >= 0 {
+			local = email[:i]
+		}
+		emails = append(append(emails, Tokenize(local)...), email)
+	}
+	phones := make([]string, 0, len(u.Phones)*2)
+	for _, p := range u.Phones {
+		for _, phone := range []string{digits(p.E164), digits(p.Value)} {
+			if phone != "" {
+				phones = append(phones, phone)
+			}
+		}
+	}
+	return []searchField{
+		{tokens: append(Tokenize(u.FirstName), Tokenize(u.LastName)...), weight: weightName, fuzzy: true},
+		{tokens: emails, weight: weightEmail, fuzzy: true},
+		{tokens: phones, weight: weightPhone},
+		{tokens: Tokenize(u.Organization), weight: weightOrg, fuzzy: true},
+	}
+}
+
+// Rank returns how good u matches terms. Every term should match at least
+// one field, otherwise rank is zero. Terms match exactly, by prefix, with
+// typos (except phone) or, for longer terms, as a substring.
+func Rank(u *User, terms []string) float64 {
+	fields := searchFields(u)
+	var rank float64
+	for _, term := range terms {
+		var best float64
+		for _, f := range fields {
+			for _, token := range f.tokens {
+				if score := matchToken(term, token, f.fuzzy) * f.weight; score > best {
+					best = score
+				}
+			}
+		}
+		if best == 0 {
+			return 0
+		}
+		rank += best
+	}
+	return rank
+}
+
+func matchToken(term, token string, fuzzy bool) float64 {
+	switch {
+	case term == token:
+		return 1
+	case strings.HasPrefix(token, term):
+		return 0.8
+	}
+	tr, kr := []rune(term), []rune(token)
+	if allowed := typos(len(tr)); fuzzy && allowed > 0 {
+		d := distance(tr, kr)
+		if len(kr) > len(tr) {
+			// the term may be a misspelled beginning of the token
+			if dp := distance(tr, kr[:len(tr)]); dp < d {
+				d = dp
+			}
+		}
+		if d <= allowed {
+			return 0.7 - 0.2*float64(d-1)
+		}
+	}
+	if len(tr) >= 3 && strings.Contains(token, term) {
+		return 0.4
+	}
+	return 0
+}
+
+// typos returns how many typos are tolerated in term of n runes.
+func typos(n int) int {
+	switch {
+	case n < 3:
+		return 0
+	case n < 7:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// distance returns edit distance between a and b, where insertion, deletion,
+// substitution and transposition of two adjacent runes count as one typo.
+func distance(a, b []rune) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min3(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] && d[i-2][j-2]+1 < d[i][j] {
+				d[i][j] = d[i-2][j-2] + 1
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// SearchUsers ranks users by text and returns at most limit best matches.
+func SearchUsers(users []User, text string, limit int) []User {
+	terms := Tokenize(text)
+	type ranked struct {
+		user User
+		rank float64
+	}
+	found := make([]ranked, 0)
+	for i := range users {
+		if rank := Rank(&users[i], terms); rank > 0 {
+			found = append(found, ranked{user: users[i], rank: rank})
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].rank != found[j].rank {
+			return found[i].rank > found[j].rank
+		}
+		return found[i].user.ID < found[j].user.ID
+	})
+	if len(found) > limit {
+		found = found[:limit]
+	}
+	result := make([]User, len(found))
+	for i := range found {
+		result[i] = found[i].user
+	}
+	return result
+}
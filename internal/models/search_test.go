@@ -0,0 +1,47 @@
+package models
+
+import "testing"
+
+func TestDistance(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		want int
+	}{
+		{"jonathan", "jonathan", 0},
+		{"jonathn", "jonathan", 1},
+		{"jonahtan", "jonathan", 1},
+		{"smith", "smyth", 1},
+		{"", "ann", 3},
+	} {
+		if got := distance([]rune(c.a), []rune(c.b)); got != c.want {
+			t.Errorf("distance(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestSearchUsers(t *testing.T) {
+	users := []User{
+		{ID: NewID(), FirstName: "Mary", LastName: "Jones", Emails: []Email{{Value: "mary@example.com"}}},
+		{ID: NewID(), FirstName: "Jonathan", LastName: "Smith", Emails: []Email{{Value: "jsmith@example.com"}}},
+		{ID: NewID(), FirstName: "Peter", LastName: "Parker", Phones: []Phone{{Value: "+1 555 010 0000"}}},
+	}
+	for text, want := range map[string]string{
+		"jon smi":  "Jonathan",
+		"jonathn":  "Jonathan",
+		"jsmith":   "Jonathan",
+		"5550100":  "Peter",
+		"JONES":    "Mary",
+		"mary zzz": "",
+	} {
+		found := SearchUsers(users, text, 10)
+		switch {
+		case want == "" && len(found) != 0:
+			t.Errorf("search of %q found %+v", text, found)
+		case want != "" && (len(found) == 0 || found[0].FirstName != want):
+			t.Errorf("search of %q found %+v, want %s first", text, found, want)
+		}
+	}
+	if found := SearchUsers(users, "example", 1); len(found) != 1 {
+		t.Errorf("search with limit 1 found %d users", len(found))
+	}
+}
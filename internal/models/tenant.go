@@ -0,0 +1,124 @@
+package models
+
+import (
+	"regexp"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Isolation modes of tenants in MongoDB.
+const (
+	// IsolationDatabase keeps data of the tenant in its own database.
+	IsolationDatabase = "database"
+	// IsolationPrefix keeps data of the tenant in collections with its prefix.
+	IsolationPrefix = "prefix"
+)
+
+// tenantID allows names which are valid subdomains and parts of database names.
+var tenantID = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// IsValidTenantID checks if id can be used as tenant id
+func IsValidTenantID(id string) bool {
+	return tenantID.MatchString(id)
+}
+
+// Quota limits the resources of a tenant. Zero means no limit.
+type Quota struct {
+	MaxUsers int `json:"max_users,omitempty" bson:"max_users,omitempty"`
+	MaxKeys  int `json:"max_keys,omitempty" bson:"max_keys,omitempty"`
+}
+
+// Tenant is a team which has its own users and keys. Data of the tenant
+// is kept in Database or in collections which names start with Prefix.
+type Tenant struct {
+	ID        string    `json:"id" bson:"_id"`
+	Name      string    `json:"name,omitempty" bson:"name,omitempty"`
+	Database  string    `json:"database,omitempty" bson:"database,omitempty"`
+	Prefix    string    `json:"prefix,omitempty" bson:"prefix,omitempty"`
+	Quota     Quota     `json:"quota" bson:"quota"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// CreateTenant stores new tenant
+func CreateTenant(db *mgo.Collection, t *Tenant) error {
+	err := db.Insert(t)
+	if mgo.IsDup(err) {
+		return ErrAlreadyExists
+	}
+	return err
+}
+
+// ListTenants returns all tenants ordered by id
+func ListTenants(db *mgo.Collection) ([]Tenant, error) {
+	tenants := make([]Tenant, 0)
+	if err := db.Find(nil).Sort("_id").All(&tenants); err != nil {
+		return nil, err
+	}
+	return tenants, nil
+}
+
+// SelectTenant returns tenant with specified id
+func SelectTenant(db *mgo.Collection, id string) (*Tenant, error) {
+	var t Tenant
+	if err := db.FindId(id).One(&t); err != nil {
+		return nil, notFound(err)
+	}
+	return &t, nil
+}
+
+// UpdateTenant updates name and quota of the tenant
+func UpdateTenant(db *mgo.Collection, t *Tenant) error {
+	return notFound(db.UpdateId(t.ID, bson.M{"$set": bson.M{"name": t.Name, "quota": t.Quota}}))
+}
+
+// DeleteTenant removes tenant with specified id
+func DeleteTenant(db *mgo.Collection, id string) error {
+	return notFound(db.RemoveId(id))
+}
+
+// ReservationTTL limits how long a reservation holds places in quota. The
+// write it is made for should be done by then.
+const ReservationTTL = time.Minute
+
+// Reservation holds places in quota of a tenant for records a write is going
+// to add, so writes of several instances can't exceed the quota together.
+type Reservation struct {
+	ID        ID        `bson:"_id"`
+	Kind      string    `bson:"kind"`
+	Count     int       `bson:"count"`
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+// EnsureReservationIndexes creates index which removes expired reservations,
+// they are left by instances stopped in the middle of a write.
+func EnsureReservationIndexes(db *mgo.Collection) error {
+	return db.EnsureIndex(mgo.Index{Key: []string{"created_at"}, ExpireAfter: ReservationTTL})
+}
+
+// Reserve stores reservation of n places of kind. It returns id of the
+// reservation and the amount of places held by all live reservations of
+// kind, this one included.
+func Reserve(db *mgo.Collection, kind string, n int) (ID, int, error) {
+	r := Reservation{ID: NewID(), Kind: kind, Count: n, CreatedAt: time.Now().UTC()}
+	if err := db.Insert(&r); err != nil {
+		return "", 0, err
+	}
+	var held []Reservation
+	query := bson.M{"kind": kind, "created_at": bson.M{"$gt": r.CreatedAt.Add(-ReservationTTL)}}
+	if err := db.Find(query).Select(bson.M{"count": 1}).All(&held); err != nil {
+		db.RemoveId(r.ID)
+		return "", 0, err
+	}
+	total := 0
+	for _, h := range held {
+		total += h.Count
+	}
+	return r.ID, total, nil
+}
+
+// ReleaseReservation removes reservation with specified id
+func ReleaseReservation(db *mgo.Collection, id ID) error {
+	return notFound(db.RemoveId(id))
+}
@@ -2,6 +2,9 @@ package models
 
 import (
 	"errors"
+	"regexp"
+	"strings"
+	"time"
 
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
@@ -10,26 +13,61 @@ import (
 var (
 	// ErrAlreadyExists is an error returned by CreateUserFunction when the user already exists.
 	ErrAlreadyExists = errors.New("user already exists")
+	// ErrNotFound is an error returned when the user with specified id does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is an error returned when the user is changed by somebody else during the update.
+	ErrConflict = errors.New("user was changed concurrently")
+	// ErrVersionMismatch is an error returned when the user is updated with version which is not current.
+	ErrVersionMismatch = errors.New("version of user does not match")
 )
 
-//User struct describes the structure of user object
+// patchAttempts limits how many times PatchUser rereads the user changed concurrently.
+const patchAttempts = 5
+
+//User struct describes the structure of user object.
+//Birthday is YYYY-MM-DD or --MM-DD when the year is unknown.
+//Version starts from 1 and grows on every change, it is set by storage.
+//DeletedAt is set when the user is moved to trash
 type User struct {
-	ID        bson.ObjectId `json:"id" bson:"_id,omitempty"`
-	FirstName string        `json:"first_name,omitempty" bson:"first_name,omitempty"`
-	LastName  string        `json:"last_name,omitempty" bson:"last_name,omitempty"`
-	Email     string        `json:"email,omitempty" bson:"email,omitempty"`
-	Phone     string        `json:"phone,omitempty" bson:"phone,omitempty"`
+	ID           ID         `json:"id" bson:"_id,omitempty"`
+	Owner        string     `json:"-" bson:"owner"`
+	Version      int64      `json:"version,omitempty" bson:"version"`
+	FirstName    string     `json:"first_name,omitempty" bson:"first_name,omitempty"`
+	LastName     string     `json:"last_name,omitempty" bson:"last_name,omitempty"`
+	Emails       []Email    `json:"emails,omitempty" bson:"emails,omitempty"`
+	Phones       []Phone    `json:"phones,omitempty" bson:"phones,omitempty"`
+	Addresses    []Address  `json:"addresses,omitempty" bson:"addresses,omitempty"`
+	Birthday     string     `json:"birthday,omitempty" bson:"birthday,omitempty"`
+	Organization string     `json:"organization,omitempty" bson:"organization,omitempty"`
+	JobTitle     string     `json:"job_title,omitempty" bson:"job_title,omitempty"`
+	Website      string     `json:"website,omitempty" bson:"website,omitempty"`
+	Notes        string     `json:"notes,omitempty" bson:"notes,omitempty"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+}
+
+//inBook returns filter of users of the book which are not in trash
+func inBook(owner string) bson.M {
+	return bson.M{"owner": owner, "deleted_at": bson.M{"$exists": false}}
+}
+
+//inTrash returns filter of users of the book which are in trash
+func inTrash(owner string) bson.M {
+	return bson.M{"owner": owner, "deleted_at": bson.M{"$exists": true}}
 }
 
 //CreateUser creates a new user and put it to the database
-func CreateUser(db *mgo.Collection, u *User) (bson.ObjectId, error) {
+func CreateUser(db *mgo.Collection, owner string, u *User) (ID, error) {
 	if u == nil {
 		return "", errors.New("Nil pointer to User struct")
 	}
-	if isExistByFilter(db, bson.M{"phone": u.Phone}) || isExistByEmail(db, u.Email) {
+	u.ID = ""
+	if isExistInBook(db, owner, u) {
 		return "", ErrAlreadyExists
 	}
-	u.ID = bson.NewObjectId()
+	u.ID = NewID()
+	u.Owner = owner
+	u.Version = 1
+	u.DeletedAt = nil
 	if err := db.Insert(&u); err != nil {
 		return "", err
 	}
@@ -37,38 +75,115 @@ func CreateUser(db *mgo.Collection, u *User) (bson.ObjectId, error) {
 }
 
 //UploadUser creates a new user and put it to the database
-func UploadUser(db *mgo.Collection, u *User) error {
+func UploadUser(db *mgo.Collection, owner string, u *User) error {
 	if u == nil {
 		return errors.New("Nil pointer to User struct")
 	}
-	return db.Insert(&u)
+	if isExistInBook(db, owner, u) {
+		return ErrAlreadyExists
+	}
+	u.Owner = owner
+	u.Version = 1
+	u.DeletedAt = nil
+	err := db.Insert(&u)
+	if mgo.IsDup(err) {
+		return ErrAlreadyExists
+	}
+	return err
 }
 
-//UpsertUser inserts or updates user record if the item with the same ID is exists
-func UpsertUser(db *mgo.Collection, u *User) error {
+//UpsertUser inserts or updates user record if the item with the same ID is exists.
+//IDs are unique across books, ID of a user of another book can't be used.
+//User in trash is restored. It returns the user as it was before, nil if it is new
+func UpsertUser(db *mgo.Collection, owner string, u *User) (*User, error) {
 	if u == nil {
-		return errors.New("Nil pointer to User struct")
+		return nil, errors.New("Nil pointer to User struct")
 	}
-	_, err := db.UpsertId(&u.ID, &u)
-	return err
+	if isExistInBook(db, owner, u) {
+		return nil, ErrAlreadyExists
+	}
+	if u.ID == "" {
+		u.ID = NewID()
+	}
+	u.Owner = owner
+	u.DeletedAt = nil
+	for i := 0; i < patchAttempts; i++ {
+		var before User
+		err := db.FindId(u.ID).One(&before)
+		switch {
+		case err == mgo.ErrNotFound:
+			u.Version = 1
+			err = db.Insert(u)
+			if mgo.IsDup(err) {
+				// the user is created meanwhile
+				continue
+			}
+			return nil, err
+		case err != nil:
+			return nil, err
+		case before.Owner != owner:
+			return nil, ErrAlreadyExists
+		}
+		u.Version = before.Version + 1
+		err = db.Update(bson.M{"_id": u.ID, "owner": owner, "version": before.Version}, u)
+		if err == mgo.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &before, nil
+	}
+	return nil, ErrConflict
 }
 
 //SelectUser returns a user with specified id
-func SelectUser(db *mgo.Collection, id bson.ObjectId) (*User, error) {
+func SelectUser(db *mgo.Collection, owner string, id ID) (*User, error) {
 	u := User{}
-	if err := db.FindId(id).One(&u); err != nil {
-		return nil, err
+	filter := inBook(owner)
+	filter["_id"] = id
+	if err := db.Find(filter).One(&u); err != nil {
+		return nil, notFound(err)
 	}
 	return &u, nil
 }
 
-// isExistByEmail checks if the user exists
-func isExistByEmail(db *mgo.Collection, email string) bool {
-	n, _ := db.Find(bson.M{"email": email}).Count()
-	if n > 0 {
-		return true
+// isExistInBook checks if a user of the book other than u has any phone or email of u
+func isExistInBook(db *mgo.Collection, owner string, u *User) bool {
+	return isExistByPhones(db, owner, u.ID, u.PhoneKeys()) || isExistByEmails(db, owner, u.ID, u.EmailValues())
+}
+
+// isExistByEmails checks if a user of the book other than id with any of emails exists
+func isExistByEmails(db *mgo.Collection, owner string, id ID, emails []string) bool {
+	if len(emails) == 0 {
+		return false
 	}
-	return false
+	filter := others(owner, id)
+	filter["emails.value"] = bson.M{"$in": emails}
+	return isExistByFilter(db, filter)
+}
+
+// isExistByPhones checks if a user of the book other than id with any of phones exists. Phones are
+// compared by E.164, values are checked for records stored before normalization.
+func isExistByPhones(db *mgo.Collection, owner string, id ID, phones []string) bool {
+	if len(phones) == 0 {
+		return false
+	}
+	filter := others(owner, id)
+	filter["$or"] = []bson.M{
+		{"phones.e164": bson.M{"$in": phones}},
+		{"phones.value": bson.M{"$in": phones}},
+	}
+	return isExistByFilter(db, filter)
+}
+
+// others returns filter of users of the book other than id, empty id matches all of them.
+func others(owner string, id ID) bson.M {
+	filter := inBook(owner)
+	if id != "" {
+		filter["_id"] = bson.M{"$ne": id}
+	}
+	return filter
 }
 
 // isExistByFilter checks if the user exists.
@@ -80,31 +195,353 @@ func isExistByFilter(db *mgo.Collection, filter bson.M) bool {
 	return false
 }
 
-//UpdateUser updates a user info
-func UpdateUser(db *mgo.Collection, u *User) error {
-	return db.UpdateId(u.ID, &u)
+//UpdateUser updates a user info. If u.Version is set the user is updated
+//only if it has the same version, otherwise ErrVersionMismatch is returned.
+//It returns the user as it was before the update
+func UpdateUser(db *mgo.Collection, owner string, u *User) (*User, error) {
+	if u.Version == 0 {
+		updated := *u
+		var before User
+		stored, err := PatchUser(db, owner, u.ID, func(current *User) error {
+			before = *current
+			*current = updated
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		*u = *stored
+		return &before, nil
+	}
+	before, err := SelectUser(db, owner, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	if before.Version != u.Version {
+		return nil, ErrVersionMismatch
+	}
+	if isExistInBook(db, owner, u) {
+		return nil, ErrAlreadyExists
+	}
+	u.Owner = owner
+	u.Version++
+	u.DeletedAt = nil
+	selector := inBook(owner)
+	selector["_id"], selector["version"] = u.ID, u.Version-1
+	err = db.Update(selector, &u)
+	if err == mgo.ErrNotFound {
+		u.Version--
+		if _, err = SelectUser(db, owner, u.ID); err == nil {
+			return nil, ErrVersionMismatch
+		}
+	}
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return before, nil
+}
+
+//PatchUser reads the user, changes it by patch and writes it back. The user
+//is replaced only if its version is the same as read, otherwise the patch is applied again
+func PatchUser(db *mgo.Collection, owner string, id ID, patch func(u *User) error) (*User, error) {
+	for i := 0; i < patchAttempts; i++ {
+		u, err := SelectUser(db, owner, id)
+		if err != nil {
+			return nil, err
+		}
+		version := u.Version
+		if err = patch(u); err != nil {
+			return nil, err
+		}
+		u.ID, u.Owner, u.Version, u.DeletedAt = id, owner, version+1, nil
+		if isExistInBook(db, owner, u) {
+			return nil, ErrAlreadyExists
+		}
+		selector := inBook(owner)
+		selector["_id"], selector["version"] = id, version
+		err = db.Update(selector, u)
+		if err == mgo.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return u, nil
+	}
+	return nil, ErrConflict
+}
+
+//DeleteUser moves user with specified id to trash and returns the user as it was before
+func DeleteUser(db *mgo.Collection, owner string, id ID) (*User, error) {
+	selector := inBook(owner)
+	selector["_id"] = id
+	var before User
+	if _, err := db.Find(selector).Apply(mgo.Change{Update: trashUpdate(time.Now().UTC())}, &before); err != nil {
+		return nil, notFound(err)
+	}
+	return &before, nil
+}
+
+//trashUpdate marks the user as deleted at the time
+func trashUpdate(at time.Time) bson.M {
+	return bson.M{"$set": bson.M{"deleted_at": at}, "$inc": bson.M{"version": 1}}
+}
+
+//ListTrash returns users of the book in trash, recently deleted go first
+func ListTrash(db *mgo.Collection, owner string) ([]User, error) {
+	users := make([]User, 0)
+	if err := db.Find(inTrash(owner)).Sort("-deleted_at", "_id").All(&users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+//RestoreUser moves user with specified id out of trash unless another user of the book has its phones or emails
+func RestoreUser(db *mgo.Collection, owner string, id ID) (*User, error) {
+	selector := inTrash(owner)
+	selector["_id"] = id
+	u := User{}
+	if err := db.Find(selector).One(&u); err != nil {
+		return nil, notFound(err)
+	}
+	if isExistInBook(db, owner, &u) {
+		return nil, ErrAlreadyExists
+	}
+	selector["version"] = u.Version
+	err := db.Update(selector, bson.M{"$unset": bson.M{"deleted_at": ""}, "$inc": bson.M{"version": 1}})
+	if err == mgo.ErrNotFound {
+		return nil, ErrConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.DeletedAt = nil
+	u.Version++
+	return &u, nil
+}
+
+//PurgeUser removes user with specified id from trash for good
+func PurgeUser(db *mgo.Collection, owner string, id ID) error {
+	selector := inTrash(owner)
+	selector["_id"] = id
+	return notFound(db.Remove(selector))
 }
 
-//DeleteUser removes user with specified id
-func DeleteUser(db *mgo.Collection, id bson.ObjectId) error {
-	return db.RemoveId(id)
+//PurgeTrash removes users of all books deleted before the time and returns their amount
+func PurgeTrash(db *mgo.Collection, before time.Time) (int, error) {
+	info, err := db.RemoveAll(bson.M{"deleted_at": bson.M{"$lt": before}})
+	if err != nil {
+		return 0, err
+	}
+	return info.Removed, nil
 }
 
 //ListUsers returns the list of all users
-func ListUsers(db *mgo.Collection) ([]User, error) {
+func ListUsers(db *mgo.Collection, owner string) ([]User, error) {
 	users := make([]User, 0)
-	err := db.Find(nil).All(&users)
+	err := db.Find(inBook(owner)).All(&users)
 	if err != nil {
 		if err == mgo.ErrNotFound {
-			return users, err
+			return users, ErrNotFound
 		}
 		return nil, err
 	}
 	return users, nil
 }
 
-//CleanRecords erases all records at the collection
-func CleanRecords(db *mgo.Collection) error {
-	_, err := db.RemoveAll(bson.M{})
+//CleanRecords moves all users of the book to trash and returns them with ids, versions and time of deletion.
+//Users which are in trash already are left for PurgeTrash
+func CleanRecords(db *mgo.Collection, owner string) ([]User, error) {
+	// the time of deletion tells users trashed now from the ones trashed before, Mongo keeps milliseconds
+	at := time.Now().UTC().Truncate(time.Millisecond)
+	if _, err := db.UpdateAll(inBook(owner), trashUpdate(at)); err != nil {
+		return nil, err
+	}
+	users := make([]User, 0)
+	err := db.Find(bson.M{"owner": owner, "deleted_at": at}).Select(bson.M{"version": 1, "deleted_at": 1}).All(&users)
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// notFound replaces mgo.ErrNotFound with ErrNotFound so callers do not depend on mgo.
+func notFound(err error) error {
+	if err == mgo.ErrNotFound {
+		return ErrNotFound
+	}
 	return err
 }
+
+//FindUsers returns a page of users which satisfy the query
+func FindUsers(db *mgo.Collection, owner string, q *ListQuery) (*UserPage, error) {
+	filter := inBook(owner)
+	for _, f := range q.Filters {
+		name := f.Field
+		if name == FieldID {
+			filter["_id"] = ID(f.Value)
+			continue
+		}
+		name = bsonField(name, false)
+		if f.Prefix {
+			filter[name] = bson.RegEx{Pattern: "^" + regexp.QuoteMeta(f.Value)}
+			continue
+		}
+		filter[name] = f.Value
+	}
+	total, err := db.Find(filter).Count()
+	if err != nil {
+		return nil, err
+	}
+
+	op, order := "$gt", ""
+	if q.Desc {
+		op, order = "$lt", "-"
+	}
+	sorting := []string{order + "_id"}
+	sortField := bsonField(q.Sort, true)
+	if q.Sort != "" {
+		sorting = append([]string{order + sortField}, sorting...)
+	}
+	if q.After != nil {
+		after := bson.M{"_id": bson.M{op: q.After.ID}}
+		if q.Sort != "" {
+			// omitted fields are stored as missing and sorted like empty strings
+			same := interface{}(q.After.Value)
+			if q.After.Value == "" {
+				same = bson.M{"$in": []interface{}{nil, ""}}
+			}
+			or := []bson.M{
+				{sortField: bson.M{op: q.After.Value}},
+				{sortField: same, "_id": bson.M{op: q.After.ID}},
+			}
+			if q.Desc && q.After.Value != "" {
+				or = append(or, bson.M{sortField: nil})
+			}
+			after = bson.M{"$or": or}
+		}
+		filter = bson.M{"$and": []bson.M{filter, after}}
+	}
+
+	page := &UserPage{Users: make([]User, 0), Total: total}
+	if err = db.Find(filter).Sort(sorting...).Limit(q.Limit + 1).All(&page.Users); err != nil {
+		return nil, err
+	}
+	if len(page.Users) > q.Limit {
+		page.Users = page.Users[:q.Limit]
+		page.Next = q.CursorFor(&page.Users[q.Limit-1]).Encode()
+	}
+	return page, nil
+}
+
+// bsonField returns the path of query field in documents. Filters on lists
+// match any item, sorting uses the first one.
+func bsonField(name string, sorting bool) string {
+	item := ".value"
+	if sorting {
+		item = ".0.value"
+	}
+	switch name {
+	case FieldEmail:
+		return "emails" + item
+	case FieldPhone:
+		return "phones" + item
+	default:
+		return name
+	}
+}
+
+// searchCandidates limits the amount of users fetched by each query of SearchUsersDB.
+const searchCandidates = 1000
+
+// userTextIndex is the name of text index. Collection can have only one
+// text index, the index of previous schema is dropped before creating it.
+const (
+	userTextIndex    = "users_text_v2"
+	oldUserTextIndex = "users_text"
+)
+
+//EnsureUserIndexes creates indexes used by user queries
+func EnsureUserIndexes(db *mgo.Collection) error {
+	if err := db.DropIndexName(oldUserTextIndex); err != nil && !isIndexNotFound(err) {
+		return err
+	}
+	// books are looked up by owner and duplicates are checked inside a book
+	for _, key := range [][]string{{"owner", "emails.value"}, {"owner", "phones.e164"}, {"owner", "phones.value"}} {
+		if err := db.EnsureIndex(mgo.Index{Key: key}); err != nil {
+			return err
+		}
+	}
+	// trash is purged by time of deletion
+	if err := db.EnsureIndex(mgo.Index{Key: []string{"deleted_at"}, Sparse: true}); err != nil {
+		return err
+	}
+	return db.EnsureIndex(mgo.Index{
+		Key:  []string{"$text:first_name", "$text:last_name", "$text:emails.value", "$text:phones.value", "$text:organization"},
+		Name: userTextIndex,
+		Weights: map[string]int{
+			FieldFirstName: 10,
+			FieldLastName:  10,
+			"emails.value": 5,
+			"phones.value": 5,
+			FieldOrg:       3,
+		},
+		// names should not be stemmed
+		DefaultLanguage: "none",
+	})
+}
+
+//SearchUsersDB returns users which match text ordered by rank.
+//Text index finds whole words, regular expressions find words with typos after the beginning
+func SearchUsersDB(db *mgo.Collection, owner string, text string, limit int) ([]User, error) {
+	terms := Tokenize(text)
+	if len(terms) == 0 {
+		return []User{}, nil
+	}
+	candidates := make([]User, 0)
+	filter := inBook(owner)
+	filter["$text"] = bson.M{"$search": strings.Join(terms, " ")}
+	err := db.Find(filter).Limit(searchCandidates).All(&candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	or := make([]bson.M, 0, len(terms)*6)
+	for _, term := range terms {
+		if d := []rune(digits(term)); len(d) >= 3 {
+			phone := bson.RegEx{Pattern: string(d[0]) + `\D*` + string(d[1]) + `\D*` + string(d[2])}
+			or = append(or, bson.M{"phones.value": phone}, bson.M{"phones.e164": phone})
+		}
+		r := []rune(term)
+		if len(r) > 2 {
+			r = r[:2]
+		}
+		prefix := bson.RegEx{Pattern: `\b` + regexp.QuoteMeta(string(r)), Options: "i"}
+		or = append(or, bson.M{FieldFirstName: prefix}, bson.M{FieldLastName: prefix},
+			bson.M{"emails.value": prefix}, bson.M{FieldOrg: prefix})
+	}
+	similar := make([]User, 0)
+	filter = inBook(owner)
+	filter["$or"] = or
+	if err = db.Find(filter).Limit(searchCandidates).All(&similar); err != nil {
+		return nil, err
+	}
+	seen := make(map[ID]struct{}, len(candidates))
+	for _, u := range candidates {
+		seen[u.ID] = struct{}{}
+	}
+	for _, u := range similar {
+		if _, ok := seen[u.ID]; !ok {
+			candidates = append(candidates, u)
+		}
+	}
+	return SearchUsers(candidates, text, limit), nil
+}
+
+// isIndexNotFound checks if err reports that the index or the collection does not exist.
+func isIndexNotFound(err error) bool {
+	if e, ok := err.(*mgo.QueryError); ok && (e.Code == 26 || e.Code == 27) {
+		return true
+	}
+	return err != nil && (strings.Contains(err.Error(), "index not found") || strings.Contains(err.Error(), "ns not found"))
+}
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"net/url"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Events sent to webhooks.
+const (
+	EventCreated = "user.created"
+	EventUpdated = "user.updated"
+	EventDeleted = "user.deleted"
+)
+
+// IsValidEvent checks if event is one of known events
+func IsValidEvent(event string) bool {
+	return event == EventCreated || event == EventUpdated || event == EventDeleted
+}
+
+// IsValidHookURL checks if u is an absolute http or https URL
+func IsValidHookURL(u string) bool {
+	parsed, err := url.Parse(u)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// Webhook receives events of the book of Owner. Secret signs deliveries, it
+// is shown only when the webhook is created. Empty Events means all events.
+type Webhook struct {
+	ID        ID        `json:"id" bson:"_id,omitempty"`
+	Owner     string    `json:"-" bson:"owner"`
+	URL       string    `json:"url" bson:"url"`
+	Secret    string    `json:"secret,omitempty" bson:"secret"`
+	Events    []string  `json:"events" bson:"events"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// Wants checks if the webhook receives event
+func (h *Webhook) Wants(event string) bool {
+	return len(h.Events) == 0 || contains(h.Events, event)
+}
+
+// Event is a change of user sent to webhooks. User of deleted event has only ID.
+type Event struct {
+	ID   ID        `json:"id" bson:"id"`
+	Type string    `json:"type" bson:"type"`
+	At   time.Time `json:"at" bson:"at"`
+	User *User     `json:"user" bson:"user"`
+}
+
+// DeadLetter is a delivery of event which has failed too many times.
+type DeadLetter struct {
+	ID        ID        `json:"id" bson:"_id,omitempty"`
+	Owner     string    `json:"-" bson:"owner"`
+	WebhookID ID        `json:"webhook_id" bson:"webhook_id"`
+	URL       string    `json:"url" bson:"url"`
+	Event     Event     `json:"event" bson:"event"`
+	Attempts  int       `json:"attempts" bson:"attempts"`
+	Error     string    `json:"error" bson:"error"`
+	FailedAt  time.Time `json:"failed_at" bson:"failed_at"`
+}
+
+//CreateWebhook stores new webhook of the book of owner
+func CreateWebhook(db *mgo.Collection, owner string, h *Webhook) error {
+	h.ID = NewID()
+	h.Owner = owner
+	return db.Insert(h)
+}
+
+//ListWebhooks returns webhooks of the book ordered by creation time
+func ListWebhooks(db *mgo.Collection, owner string) ([]Webhook, error) {
+	hooks := make([]Webhook, 0)
+	if err := db.Find(bson.M{"owner": owner}).Sort("created_at", "_id").All(&hooks); err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+//SelectWebhook returns webhook with specified id
+func SelectWebhook(db *mgo.Collection, owner string, id ID) (*Webhook, error) {
+	h := Webhook{}
+	if err := db.Find(bson.M{"_id": id, "owner": owner}).One(&h); err != nil {
+		return nil, notFound(err)
+	}
+	return &h, nil
+}
+
+//UpdateWebhook changes URL and events of the webhook
+func UpdateWebhook(db *mgo.Collection, owner string, h *Webhook) error {
+	err := db.Update(bson.M{"_id": h.ID, "owner": owner}, bson.M{"$set": bson.M{"url": h.URL, "events": h.Events}})
+	return notFound(err)
+}
+
+//DeleteWebhook removes webhook with specified id
+func DeleteWebhook(db *mgo.Collection, owner string, id ID) error {
+	return notFound(db.Remove(bson.M{"_id": id, "owner": owner}))
+}
+
+//AddDeadLetter stores failed delivery of the book of owner
+func AddDeadLetter(db *mgo.Collection, owner string, d *DeadLetter) error {
+	d.ID = NewID()
+	d.Owner = owner
+	return db.Insert(d)
+}
+
+//ListDeadLetters returns failed deliveries of the book, the latest go first
+func ListDeadLetters(db *mgo.Collection, owner string) ([]DeadLetter, error) {
+	letters := make([]DeadLetter, 0)
+	if err := db.Find(bson.M{"owner": owner}).Sort("-failed_at", "-_id").All(&letters); err != nil {
+		return nil, err
+	}
+	return letters, nil
+}
+
+//DeleteDeadLetter removes failed delivery with specified id
+func DeleteDeadLetter(db *mgo.Collection, owner string, id ID) error {
+	return notFound(db.Remove(bson.M{"_id": id, "owner": owner}))
+}
+
+//EnsureWebhookIndexes creates indexes used to find webhooks and dead letters of a book
+func EnsureWebhookIndexes(hooks, letters *mgo.Collection) error {
+	if err := hooks.EnsureIndex(mgo.Index{Key: []string{"owner"}}); err != nil {
+		return err
+	}
+	return letters.EnsureIndex(mgo.Index{Key: []string{"owner", "failed_at"}})
+}
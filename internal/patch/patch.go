@@ -0,0 +1,350 @@
+// Package patch changes JSON documents by JSON Merge Patch (RFC 7396) and
+// JSON Patch (RFC 6902). Documents are patched as a whole: either all
+// operations are applied or the document is left as it is.
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Media types of patch documents.
+const (
+	MediaTypeMerge = "application/merge-patch+json"
+	MediaTypeJSON  = "application/json-patch+json"
+)
+
+var (
+	// ErrMalformed reports in case patch document is not valid
+	ErrMalformed = errors.New("malformed patch document")
+	// ErrTestFailed reports in case test operation of JSON Patch has not passed
+	ErrTestFailed = errors.New("test operation failed")
+)
+
+// Error reports an operation of JSON Patch which can't be applied to the document.
+type Error struct {
+	Index int
+	Op    string
+	Path  string
+	Msg   string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("operation %d (%s %s): %s", e.Index, e.Op, e.Path, e.Msg)
+}
+
+// Merge applies merge patch to doc and returns the patched document.
+// Members of patch set to null are removed from doc.
+func Merge(doc, patch []byte) ([]byte, error) {
+	var target, p interface{}
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, ErrMalformed
+	}
+	return json.Marshal(merge(target, p))
+}
+
+func merge(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for name, value := range p {
+		if value == nil {
+			delete(t, name)
+			continue
+		}
+		t[name] = merge(t[name], value)
+	}
+	return t
+}
+
+// operation is one operation of JSON Patch.
+type operation struct {
+	Op    string           `json:"op"`
+	Path  *string          `json:"path"`
+	From  *string          `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+// Apply applies JSON Patch to doc and returns the patched document.
+func Apply(doc, patch []byte) ([]byte, error) {
+	var ops []operation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, ErrMalformed
+	}
+	var target interface{}
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	for i, op := range ops {
+		if op.Path == nil {
+			return nil, ErrMalformed
+		}
+		var err error
+		target, err = apply(target, &op)
+		if err == ErrMalformed || err == ErrTestFailed {
+			return nil, err
+		}
+		if err != nil {
+			return nil, &Error{Index: i, Op: op.Op, Path: *op.Path, Msg: err.Error()}
+		}
+	}
+	return json.Marshal(target)
+}
+
+func apply(doc interface{}, op *operation) (interface{}, error) {
+	path, err := parsePointer(*op.Path)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, ErrMalformed
+		}
+		if err = json.Unmarshal(*op.Value, &value); err != nil {
+			return nil, ErrMalformed
+		}
+	case "move", "copy":
+		if op.From == nil {
+			return nil, ErrMalformed
+		}
+		from, err := parsePointer(*op.From)
+		if err != nil {
+			return nil, err
+		}
+		if value, err = get(doc, from); err != nil {
+			return nil, err
+		}
+		if op.Op == "copy" {
+			// the copy is changed apart from the source by next operations
+			value = deepCopy(value)
+		}
+		if op.Op == "move" {
+			if strings.HasPrefix(*op.Path+"/", *op.From+"/") && *op.Path != *op.From {
+				return nil, errors.New("can't move value into itself")
+			}
+			if doc, err = remove(doc, from); err != nil {
+				return nil, err
+			}
+		}
+	case "remove":
+	default:
+		return nil, ErrMalformed
+	}
+	switch op.Op {
+	case "add", "move", "copy":
+		return add(doc, path, value)
+	case "replace":
+		if len(path) == 0 {
+			return value, nil
+		}
+		if doc, err = remove(doc, path); err != nil {
+			return nil, err
+		}
+		return add(doc, path, value)
+	case "remove":
+		return remove(doc, path)
+	}
+	current, err := get(doc, path)
+	if err != nil {
+		return nil, err
+	}
+	if !equal(current, value) {
+		return nil, ErrTestFailed
+	}
+	return doc, nil
+}
+
+// parsePointer splits JSON Pointer (RFC 6901) into unescaped tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if pointer[0] != '/' {
+		return nil, ErrMalformed
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+// index parses token as position in array of length n. "-" means the end
+// of the array, it is allowed only if end is true.
+func index(token string, n int, end bool) (int, error) {
+	if token == "-" && end {
+		return n, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && token[0] == '0') {
+		return 0, fmt.Errorf("%q is not an array index", token)
+	}
+	if i > n || (i == n && !end) {
+		return 0, fmt.Errorf("index %d is out of range", i)
+	}
+	return i, nil
+}
+
+func get(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("member %q does not exist", token)
+			}
+			doc = value
+		case []interface{}:
+			i, err := index(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("can't find %q in a value", token)
+		}
+	}
+	return doc, nil
+}
+
+// add sets value at path and returns the changed document. Values of
+// arrays after the index are shifted.
+func add(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, err := get(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	token := path[len(path)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[token] = value
+		return doc, nil
+	case []interface{}:
+		i, err := index(token, len(node), true)
+		if err != nil {
+			return nil, err
+		}
+		node = append(node, nil)
+		copy(node[i+1:], node[i:])
+		node[i] = value
+		return set(doc, path[:len(path)-1], node)
+	}
+	return nil, fmt.Errorf("can't add %q to a value", token)
+}
+
+// remove deletes value at path and returns the changed document.
+func remove(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, errors.New("can't remove the whole document")
+	}
+	parent, err := get(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	token := path[len(path)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		if _, ok := node[token]; !ok {
+			return nil, fmt.Errorf("member %q does not exist", token)
+		}
+		delete(node, token)
+		return doc, nil
+	case []interface{}:
+		i, err := index(token, len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		node = append(node[:i:i], node[i+1:]...)
+		return set(doc, path[:len(path)-1], node)
+	}
+	return nil, fmt.Errorf("can't remove %q from a value", token)
+}
+
+// set replaces value at path, which exists, by value. It is used for
+// arrays, which change their slices when items are added or removed.
+func set(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, err := get(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	token := path[len(path)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[token] = value
+	case []interface{}:
+		i, err := index(token, len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = value
+	}
+	return doc, nil
+}
+
+// deepCopy returns a copy of decoded JSON value which shares no objects
+// or arrays with it.
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			m[k] = deepCopy(item)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for i, item := range v {
+			a[i] = deepCopy(item)
+		}
+		return a
+	}
+	return value
+}
+
+// equal compares decoded JSON values.
+func equal(a, b interface{}) bool {
+	switch x := a.(type) {
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			if w, ok := y[k]; !ok || !equal(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
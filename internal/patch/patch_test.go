@@ -0,0 +1,111 @@
+package patch
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// sameJSON checks if a and b are equal JSON documents.
+func sameJSON(t *testing.T, a, b string) bool {
+	t.Helper()
+	var x, y interface{}
+	if err := json.Unmarshal([]byte(a), &x); err != nil {
+		t.Fatalf("%s: %v", a, err)
+	}
+	if err := json.Unmarshal([]byte(b), &y); err != nil {
+		t.Fatalf("%s: %v", b, err)
+	}
+	return equal(x, y)
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		doc, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":["c","d"]}`, `{"a":["c","d"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`["a"]`, `{"a":"b"}`, `{"a":"b"}`},
+	}
+	for _, tt := range tests {
+		got, err := Merge([]byte(tt.doc), []byte(tt.patch))
+		if err != nil {
+			t.Errorf("%s + %s: %v", tt.doc, tt.patch, err)
+			continue
+		}
+		if !sameJSON(t, string(got), tt.want) {
+			t.Errorf("%s + %s = %s, want %s", tt.doc, tt.patch, got, tt.want)
+		}
+	}
+	if _, err := Merge([]byte(`{}`), []byte(`{`)); err != ErrMalformed {
+		t.Errorf("broken patch returns %v", err)
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		doc, patch, want string
+	}{
+		{`{"a":1}`, `[{"op":"add","path":"/b","value":2}]`, `{"a":1,"b":2}`},
+		{`{"a":[1,3]}`, `[{"op":"add","path":"/a/1","value":2}]`, `{"a":[1,2,3]}`},
+		{`{"a":[1]}`, `[{"op":"add","path":"/a/-","value":2}]`, `{"a":[1,2]}`},
+		{`{"a":[1,2,3]}`, `[{"op":"remove","path":"/a/1"}]`, `{"a":[1,3]}`},
+		{`{"a":1}`, `[{"op":"replace","path":"/a","value":2}]`, `{"a":2}`},
+		{`{"a":[1,2]}`, `[{"op":"replace","path":"/a/1","value":3}]`, `{"a":[1,3]}`},
+		{`{"a":1}`, `[{"op":"replace","path":"","value":{"b":2}}]`, `{"b":2}`},
+		{`{"a":{"b":1}}`, `[{"op":"move","from":"/a/b","path":"/c"}]`, `{"a":{},"c":1}`},
+		{`{"a/b":1,"m~n":2}`, `[{"op":"remove","path":"/a~1b"},{"op":"remove","path":"/m~0n"}]`, `{}`},
+		{`{"a":1}`, `[{"op":"test","path":"/a","value":1},{"op":"remove","path":"/a"}]`, `{}`},
+		// the copy does not share values with the source
+		{`{"a":{"b":[1]}}`, `[{"op":"copy","from":"/a","path":"/c"},{"op":"add","path":"/c/b/-","value":2},{"op":"add","path":"/c/d","value":3}]`,
+			`{"a":{"b":[1]},"c":{"b":[1,2],"d":3}}`},
+	}
+	for _, tt := range tests {
+		got, err := Apply([]byte(tt.doc), []byte(tt.patch))
+		if err != nil {
+			t.Errorf("%s + %s: %v", tt.doc, tt.patch, err)
+			continue
+		}
+		if !sameJSON(t, string(got), tt.want) {
+			t.Errorf("%s + %s = %s, want %s", tt.doc, tt.patch, got, tt.want)
+		}
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	tests := []struct {
+		patch string
+		want  error
+	}{
+		{`{"op":"add"}`, ErrMalformed},
+		{`[{"op":"add","value":1}]`, ErrMalformed},
+		{`[{"op":"add","path":"/b"}]`, ErrMalformed},
+		{`[{"op":"jump","path":"/a"}]`, ErrMalformed},
+		{`[{"op":"add","path":"a","value":1}]`, ErrMalformed},
+		{`[{"op":"copy","path":"/b"}]`, ErrMalformed},
+		{`[{"op":"test","path":"/a","value":2}]`, ErrTestFailed},
+	}
+	for _, tt := range tests {
+		if _, err := Apply([]byte(`{"a":1,"l":[1]}`), []byte(tt.patch)); err != tt.want {
+			t.Errorf("%s returns %v, want %v", tt.patch, err, tt.want)
+		}
+	}
+	for _, p := range []string{
+		`[{"op":"remove","path":"/b"}]`,
+		`[{"op":"replace","path":"/b","value":1}]`,
+		`[{"op":"add","path":"/l/2","value":1}]`,
+		`[{"op":"add","path":"/l/01","value":1}]`,
+		`[{"op":"remove","path":"/l/-"}]`,
+		`[{"op":"remove","path":""}]`,
+		`[{"op":"move","from":"/l","path":"/l/0"}]`,
+		`[{"op":"add","path":"/a/b","value":1}]`,
+	} {
+		_, err := Apply([]byte(`{"a":1,"l":[1]}`), []byte(p))
+		if e, ok := err.(*Error); !ok || e.Index != 0 {
+			t.Errorf("%s returns %v, want error of operation 0", p, err)
+		}
+	}
+}
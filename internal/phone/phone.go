@@ -0,0 +1,125 @@
+// Package phone parses phone numbers written in national or international
+// format and converts them to E.164 (+CCNNNNNNN).
+package phone
+
+import (
+	"errors"
+	"strings"
+)
+
+// Limits of E.164 number length in digits including country code.
+const (
+	minDigits = 7
+	maxDigits = 15
+)
+
+var (
+	// ErrInvalid reports in case the number can't be parsed.
+	ErrInvalid = errors.New("invalid phone number")
+	// ErrNoRegion reports in case national number is parsed without region.
+	ErrNoRegion = errors.New("phone number should start with + and country code")
+	// ErrUnknownRegion reports in case the region is not known.
+	ErrUnknownRegion = errors.New("unknown phone region")
+)
+
+// Region describes dialing rules of a country.
+type Region struct {
+	// Code is the country calling code.
+	Code string
+	// Trunk is the prefix dialled before national numbers, it is dropped in E.164.
+	Trunk string
+	// International is the prefix dialled before country code.
+	International string
+	// Length is the length of national numbers if it is fixed.
+	Length int
+}
+
+// Regions maps ISO 3166 codes to dialing rules.
+var Regions = map[string]Region{
+	"AU": {Code: "61", Trunk: "0", International: "0011"},
+	"BR": {Code: "55", Trunk: "0", International: "00"},
+	"BY": {Code: "375", Trunk: "8", International: "810"},
+	"CA": {Code: "1", Trunk: "1", International: "011", Length: 10},
+	"CH": {Code: "41", Trunk: "0", International: "00"},
+	"CN": {Code: "86", Trunk: "0", International: "00"},
+	"DE": {Code: "49", Trunk: "0", International: "00"},
+	"ES": {Code: "34", International: "00"},
+	"FR": {Code: "33", Trunk: "0", International: "00"},
+	"GB": {Code: "44", Trunk: "0", International: "00"},
+	"IL": {Code: "972", Trunk: "0", International: "00"},
+	"IN": {Code: "91", Trunk: "0", International: "00"},
+	// Italian numbers keep the leading zero
+	"IT": {Code: "39", International: "00"},
+	"JP": {Code: "81", Trunk: "0", International: "010"},
+	"KZ": {Code: "7", Trunk: "8", International: "810", Length: 10},
+	"MX": {Code: "52", International: "00"},
+	"NL": {Code: "31", Trunk: "0", International: "00"},
+	"NZ": {Code: "64", Trunk: "0", International: "00"},
+	"PL": {Code: "48", International: "00"},
+	"RU": {Code: "7", Trunk: "8", International: "810", Length: 10},
+	"SE": {Code: "46", Trunk: "0", International: "00"},
+	"TR": {Code: "90", Trunk: "0", International: "00"},
+	"UA": {Code: "380", Trunk: "0", International: "00"},
+	"US": {Code: "1", Trunk: "1", International: "011", Length: 10},
+}
+
+// extensions are markers of extension which is not a part of E.164 number.
+var extensions = []string{";ext=", "ext.", "ext", "#", "x"}
+
+// Parse converts number to E.164. Numbers without + are parsed by rules of
+// region. Extension is dropped.
+func Parse(number, region string) (string, error) {
+	number = strings.TrimPrefix(strings.TrimSpace(number), "tel:")
+	lower := strings.ToLower(number)
+	for _, ext := range extensions {
+		if i := strings.Index(lower, ext); i > 0 {
+			number, lower = number[:i], lower[:i]
+		}
+	}
+	number = strings.TrimSpace(number)
+	international := strings.HasPrefix(number, "+")
+	d := make([]byte, 0, len(number))
+	for i := 0; i < len(number); i++ {
+		switch c := number[i]; {
+		case c >= '0' && c <= '9':
+			d = append(d, c)
+		case c == '+' && i == 0:
+		case strings.IndexByte(" -().", c) >= 0:
+		default:
+			return "", ErrInvalid
+		}
+	}
+	digits := string(d)
+
+	if !international {
+		if region == "" {
+			return "", ErrNoRegion
+		}
+		r, ok := Regions[strings.ToUpper(region)]
+		if !ok {
+			return "", ErrUnknownRegion
+		}
+		switch {
+		case strings.HasPrefix(digits, r.International):
+			digits = digits[len(r.International):]
+		case r.Trunk != "" && strings.HasPrefix(digits, r.Trunk):
+			digits = digits[len(r.Trunk):]
+			fallthrough
+		default:
+			if r.Length > 0 && len(digits) != r.Length {
+				return "", ErrInvalid
+			}
+			digits = r.Code + digits
+		}
+	}
+	if len(digits) < minDigits || len(digits) > maxDigits || digits[0] == '0' {
+		return "", ErrInvalid
+	}
+	return "+" + digits, nil
+}
+
+// IsRegion checks if region is known.
+func IsRegion(region string) bool {
+	_, ok := Regions[strings.ToUpper(region)]
+	return ok
+}
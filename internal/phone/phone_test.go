@@ -0,0 +1,31 @@
+package phone
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	for _, c := range []struct {
+		number, region, want string
+		err                  error
+	}{
+		{"+1 (555) 010-0000", "", "+15550100000", nil},
+		{"tel:+44 20 7946 0958", "", "+442079460958", nil},
+		{"+1 555 010 0000 ext. 12", "", "+15550100000", nil},
+		{"(555) 010-0000", "US", "+15550100000", nil},
+		{"1 555 010 0000", "us", "+15550100000", nil},
+		{"011 44 20 7946 0958", "US", "+442079460958", nil},
+		{"020 7946 0958", "GB", "+442079460958", nil},
+		{"8 (912) 345-67-89", "RU", "+79123456789", nil},
+		{"06 1234 5678", "IT", "+390612345678", nil},
+		{"555 010", "US", "", ErrInvalid},
+		{"555-CALL-NOW", "US", "", ErrInvalid},
+		{"020 7946 0958", "", "", ErrNoRegion},
+		{"020 7946 0958", "XX", "", ErrUnknownRegion},
+		{"+0 555 010 0000", "", "", ErrInvalid},
+		{"+1234567890123456", "", "", ErrInvalid},
+	} {
+		got, err := Parse(c.number, c.region)
+		if got != c.want || err != c.err {
+			t.Errorf("Parse(%q, %q) = %q, %v, want %q, %v", c.number, c.region, got, err, c.want, c.err)
+		}
+	}
+}
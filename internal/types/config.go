@@ -1,24 +1,131 @@
 package types
 
-import "time"
-
 // Config is an app-wode configuration
 type Config struct {
-	Database     DB   `json:"database"`
-	DatabaseTest DB   `json:"database_test"`
-	API          API  `json:"api"`
-	Debug        bool `json:"debug"`
-	CustomTestDB bool `json:"custom_test_db"`
+	Database     DB         `json:"database"`
+	DatabaseTest DB         `json:"database_test"`
+	API          API        `json:"api"`
+	Validation   Validation `json:"validation"`
+	Auth         Auth       `json:"auth"`
+	Webhooks     Webhooks   `json:"webhooks"`
+	Debug        bool       `json:"debug"`
+	CustomTestDB bool       `json:"custom_test_db"`
 }
 
+// Available storage drivers.
+const (
+	// DriverMongo keeps data in MongoDB. It is used when no driver specified.
+	DriverMongo = "mongo"
+	// DriverMemory keeps data in memory. Data is lost when the app stops.
+	DriverMemory = "memory"
+	// DriverSQLite keeps data in SQLite file. Connection is a path to the file.
+	DriverSQLite = "sqlite"
+)
+
 // DB is a configuration of DB
 type DB struct {
+	Driver     string `json:"driver,omitempty"`
 	Connection string `json:"connection,omitempty"`
 	Name       string `json:"name,omitempty"`
+	// PoolLimit limits the amount of connections to each MongoDB server. Default is 4096.
+	PoolLimit int `json:"pool_limit,omitempty"`
+	// DialTimeout limits connecting to MongoDB. Default is 10s.
+	DialTimeout Duration `json:"dial_timeout,omitempty"`
+	// SocketTimeout limits waiting for a reply of MongoDB. Default is 15s.
+	SocketTimeout Duration `json:"socket_timeout,omitempty"`
+	// SyncTimeout limits waiting for a server to be available. Default is 15s.
+	SyncTimeout Duration `json:"sync_timeout,omitempty"`
+	// TrashRetention limits how long deleted users are kept in trash. Default is 720h.
+	TrashRetention Duration `json:"trash_retention,omitempty"`
+	// PurgeInterval is how often users are purged from trash. Default is 1h.
+	PurgeInterval Duration `json:"purge_interval,omitempty"`
 }
 
 // API is a configuration of API
 type API struct {
-	Listen string        `json:"listen,omitempty"`
-	Timeot time.Duration `json:"timeout,omitempty"`
+	Listen string `json:"listen,omitempty"`
+	// Timeot limits time of serving one request including database calls. Default is 30s.
+	Timeot Duration `json:"timeout,omitempty"`
+	// ReadTimeout limits reading the whole request. Default is 60s.
+	ReadTimeout Duration `json:"read_timeout,omitempty"`
+	// ReadHeaderTimeout limits reading request headers. Default is 10s.
+	ReadHeaderTimeout Duration `json:"read_header_timeout,omitempty"`
+	// WriteTimeout limits writing the response. Default is 10s more than Timeot.
+	WriteTimeout Duration `json:"write_timeout,omitempty"`
+	// IdleTimeout limits waiting for the next request on keep-alive connection. Default is 120s.
+	IdleTimeout Duration `json:"idle_timeout,omitempty"`
+	// MaxHeaderBytes limits the size of request headers. Default is 1 MiB.
+	MaxHeaderBytes int `json:"max_header_bytes,omitempty"`
+	// ShutdownTimeout limits waiting for active requests on shutdown. Default is 30s.
+	ShutdownTimeout Duration `json:"shutdown_timeout,omitempty"`
+	// Tenants configures serving several teams by one instance.
+	Tenants Tenants `json:"tenants"`
+}
+
+// Tenants is a configuration of multi-tenant deployment. Requests which
+// do not name a tenant use the default database.
+type Tenants struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Header names the tenant of the request. Default is X-Tenant.
+	Header string `json:"header,omitempty"`
+	// Domain lets requests to <tenant>.<domain> select the tenant by subdomain.
+	Domain string `json:"domain,omitempty"`
+	// Isolation is "database" to keep every tenant in its own MongoDB
+	// database or "prefix" to keep it in prefixed collections of the
+	// default one. Default is database. Other drivers use a file or a
+	// storage per tenant.
+	Isolation string `json:"isolation,omitempty"`
+	// MaxUsers is the quota of users of new tenants. Zero means no limit.
+	MaxUsers int `json:"max_users,omitempty"`
+	// MaxKeys is the quota of API keys of new tenants. Zero means no limit.
+	MaxKeys int `json:"max_keys,omitempty"`
+}
+
+// Auth is a configuration of authentication.
+type Auth struct {
+	// Disabled lets everyone use API without credentials. It is meant for development only.
+	Disabled bool `json:"disabled,omitempty"`
+	// Anonymous lets requests without credentials use the private book of
+	// their browser session.
+	Anonymous bool `json:"anonymous,omitempty"`
+	// AdminKey is an admin key which is not stored in database, it is used to create first keys.
+	AdminKey string `json:"admin_key,omitempty"`
+	// TokenSecret signs bearer tokens. Without it a random secret is used and
+	// tokens become invalid after restart.
+	TokenSecret string `json:"token_secret,omitempty"`
+	// TokenTTL limits the life of bearer tokens. Default is 1h.
+	TokenTTL Duration `json:"token_ttl,omitempty"`
+}
+
+// Webhooks is a configuration of delivering changes of users to webhooks.
+type Webhooks struct {
+	// Workers is the amount of deliveries sent at once. Default is 4.
+	Workers int `json:"workers,omitempty"`
+	// QueueSize limits deliveries waiting for a worker, the rest become dead letters. Default is 1000.
+	QueueSize int `json:"queue_size,omitempty"`
+	// Timeout limits one attempt of delivery. Default is 10s.
+	Timeout Duration `json:"timeout,omitempty"`
+	// MaxAttempts limits attempts of delivery before it becomes a dead letter. Default is 8.
+	MaxAttempts int `json:"max_attempts,omitempty"`
+	// Backoff is the delay before the second attempt, it doubles after every failure. Default is 1s.
+	Backoff Duration `json:"backoff,omitempty"`
+	// MaxBackoff limits the delay between attempts. Default is 10m.
+	MaxBackoff Duration `json:"max_backoff,omitempty"`
+}
+
+// Validation is a configuration of user validation. Zero values mean defaults.
+type Validation struct {
+	// Required lists fields which can't be empty. Default is first_name and last_name.
+	Required []string `json:"required,omitempty"`
+	// Disabled lists rules which are not checked, e.g. "name" or "email".
+	Disabled []string `json:"disabled,omitempty"`
+	// NamePattern replaces the default check of names with a regular expression.
+	NamePattern string `json:"name_pattern,omitempty"`
+	// MaxLength limits the length of every text field in characters. Default is 256.
+	MaxLength int `json:"max_length,omitempty"`
+	// MaxItems limits the amount of emails, phones and addresses. Default is 20.
+	MaxItems int `json:"max_items,omitempty"`
+	// PhoneRegion is ISO 3166 code of country used to parse phones without
+	// country code, e.g. "US". Without it phones should start with +.
+	PhoneRegion string `json:"phone_region,omitempty"`
 }
@@ -0,0 +1,42 @@
+package types
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Duration is a time.Duration which is written in config as a string
+// like "15s" or "1m30s", or as a number of seconds.
+type Duration time.Duration
+
+// UnmarshalJSON parses duration from string or number of seconds.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var seconds float64
+		if err = json.Unmarshal(data, &seconds); err != nil {
+			return err
+		}
+		*d = Duration(seconds * float64(time.Second))
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON writes duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Or returns d as time.Duration or def if d is not set.
+func (d Duration) Or(def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return time.Duration(d)
+}
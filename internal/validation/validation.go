@@ -0,0 +1,390 @@
+// Package validation checks users before they are stored. Rules are
+// configured by types.Validation and more rules can be added with Add.
+package validation
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ferux/addressbook/internal/models"
+	"github.com/ferux/addressbook/internal/phone"
+	"github.com/ferux/addressbook/internal/types"
+)
+
+// Default limits.
+const (
+	DefaultMaxLength = 256
+	DefaultMaxItems  = 20
+	// maxNotesLength limits notes, they are usually longer than other fields.
+	maxNotesLength = 4096
+)
+
+// Codes of errors.
+const (
+	CodeRequired = "required"
+	CodeInvalid  = "invalid"
+	CodeTooLong  = "too_long"
+	CodeTooMany  = "too_many"
+	CodeLabel    = "invalid_label"
+)
+
+// Names of default rules. They can be disabled in config.
+const (
+	RuleRequired = "required"
+	RuleLength   = "length"
+	RuleItems    = "items"
+	RuleName     = "name"
+	RuleEmail    = "email"
+	RulePhone    = "phone"
+	RuleLabel    = "label"
+	RuleAddress  = "address"
+	RuleBirthday = "birthday"
+	RuleWebsite  = "website"
+)
+
+// DefaultRequired lists fields required when config does not specify them.
+var DefaultRequired = []string{models.FieldFirstName, models.FieldLastName}
+
+// Error describes a problem with one field. Field is a path to the value,
+// e.g. "emails[1].value".
+type Error struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e Error) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// Errors is a list of problems found in a user.
+type Errors []Error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i := range e {
+		msgs[i] = e[i].Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Add adds a problem with the field.
+func (e *Errors) Add(field, code, msg string) {
+	*e = append(*e, Error{Field: field, Code: code, Message: msg})
+}
+
+// Check is a function which looks for problems in u.
+type Check func(u *models.User, errs *Errors)
+
+type rule struct {
+	name  string
+	check Check
+}
+
+// Validator checks users by a list of rules.
+type Validator struct {
+	rules  []rule
+	region string
+}
+
+// emailCheck allows letters of any script (RFC 6531) in both parts of address.
+var emailCheck = regexp.MustCompile("^[\\p{L}\\p{N}.!#$%&'’*+/=?^_`{|}~-]+@[\\p{L}\\p{N}-]+(?:\\.[\\p{L}\\p{N}-]+)*$").MatchString
+
+// New creates validator with default rules configured by conf.
+func New(conf types.Validation) (*Validator, error) {
+	if conf.MaxLength <= 0 {
+		conf.MaxLength = DefaultMaxLength
+	}
+	if conf.MaxItems <= 0 {
+		conf.MaxItems = DefaultMaxItems
+	}
+	required := conf.Required
+	if required == nil {
+		required = DefaultRequired
+	}
+	for _, field := range required {
+		if _, ok := emptyChecks[field]; !ok {
+			return nil, fmt.Errorf("validation: unknown required field %q", field)
+		}
+	}
+	name := IsName
+	if conf.NamePattern != "" {
+		re, err := regexp.Compile(conf.NamePattern)
+		if err != nil {
+			return nil, fmt.Errorf("validation: invalid name pattern: %v", err)
+		}
+		name = re.MatchString
+	}
+
+	if conf.PhoneRegion != "" && !phone.IsRegion(conf.PhoneRegion) {
+		return nil, fmt.Errorf("validation: unknown phone region %q", conf.PhoneRegion)
+	}
+
+	v := &Validator{region: conf.PhoneRegion}
+	v.Add(RuleRequired, requiredRule(required))
+	v.Add(RuleLength, lengthRule(conf.MaxLength))
+	v.Add(RuleItems, itemsRule(conf.MaxItems))
+	v.Add(RuleName, nameRule(name))
+	v.Add(RuleEmail, emailRule)
+	v.Add(RulePhone, phoneRule(conf.PhoneRegion))
+	v.Add(RuleLabel, labelRule)
+	v.Add(RuleAddress, addressRule)
+	v.Add(RuleBirthday, birthdayRule)
+	v.Add(RuleWebsite, websiteRule)
+	for _, disabled := range conf.Disabled {
+		if !v.Remove(disabled) {
+			return nil, fmt.Errorf("validation: unknown rule %q", disabled)
+		}
+	}
+	return v, nil
+}
+
+// Add adds a rule. Rule with the same name is replaced.
+func (v *Validator) Add(name string, check Check) {
+	for i := range v.rules {
+		if v.rules[i].name == name {
+			v.rules[i].check = check
+			return
+		}
+	}
+	v.rules = append(v.rules, rule{name: name, check: check})
+}
+
+// Remove removes the rule and reports whether it has been there.
+func (v *Validator) Remove(name string) bool {
+	for i := range v.rules {
+		if v.rules[i].name == name {
+			v.rules = append(v.rules[:i], v.rules[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Validate returns problems found in u or nil.
+func (v *Validator) Validate(u *models.User) Errors {
+	var errs Errors
+	for _, r := range v.rules {
+		r.check(u, &errs)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Normalize fills normalized values of u: E.164 of phones which can be parsed.
+func (v *Validator) Normalize(u *models.User) {
+	for i := range u.Phones {
+		u.Phones[i].E164, _ = phone.Parse(u.Phones[i].Value, v.region)
+	}
+}
+
+// Prepare normalizes u and returns problems found in it. It should be
+// called before user is stored.
+func (v *Validator) Prepare(u *models.User) Errors {
+	v.Normalize(u)
+	return v.Validate(u)
+}
+
+// IsName checks if s looks like a name in any script: words of letters
+// separated by a space, hyphen or apostrophe, words may end with a dot.
+// "José", "O'Brien", "Anne-Marie", "Ng Wei" and "Jr." are names.
+func IsName(s string) bool {
+	var prev rune
+	for i, r := range s {
+		switch {
+		case unicode.IsLetter(r):
+		case unicode.Is(unicode.M, r):
+			// combining marks follow letters
+			if i == 0 || !(unicode.IsLetter(prev) || unicode.Is(unicode.M, prev)) {
+				return false
+			}
+		case r == ' ' || r == '-' || r == '\'' || r == '’':
+			if i == 0 || !(unicode.IsLetter(prev) || unicode.Is(unicode.M, prev) || prev == '.' && r == ' ') {
+				return false
+			}
+		case r == '.':
+			if i == 0 || !(unicode.IsLetter(prev) || unicode.Is(unicode.M, prev)) {
+				return false
+			}
+		default:
+			return false
+		}
+		prev = r
+	}
+	return prev != 0 && prev != ' ' && prev != '-' && prev != '\'' && prev != '’'
+}
+
+func path(list string, i int, field string) string {
+	p := fmt.Sprintf("%s[%d]", list, i)
+	if field != "" {
+		p += "." + field
+	}
+	return p
+}
+
+// emptyChecks reports whether the field is empty, keys are names allowed in Required.
+var emptyChecks = map[string]func(u *models.User) bool{
+	models.FieldFirstName: func(u *models.User) bool { return strings.TrimSpace(u.FirstName) == "" },
+	models.FieldLastName:  func(u *models.User) bool { return strings.TrimSpace(u.LastName) == "" },
+	models.FieldEmail:     func(u *models.User) bool { return len(u.Emails) == 0 },
+	models.FieldPhone:     func(u *models.User) bool { return len(u.Phones) == 0 },
+	models.FieldOrg:       func(u *models.User) bool { return strings.TrimSpace(u.Organization) == "" },
+}
+
+func requiredRule(fields []string) Check {
+	return func(u *models.User, errs *Errors) {
+		for _, field := range fields {
+			if emptyChecks[field](u) {
+				errs.Add(listName(field), CodeRequired, "is required")
+			}
+		}
+	}
+}
+
+// listName returns json name of the field, emails and phones are lists.
+func listName(field string) string {
+	switch field {
+	case models.FieldEmail:
+		return "emails"
+	case models.FieldPhone:
+		return "phones"
+	default:
+		return field
+	}
+}
+
+func lengthRule(max int) Check {
+	return func(u *models.User, errs *Errors) {
+		long := func(field, value string, max int) {
+			if utf8.RuneCountInString(value) > max {
+				errs.Add(field, CodeTooLong, fmt.Sprintf("is longer than %d characters", max))
+			}
+		}
+		long("first_name", u.FirstName, max)
+		long("last_name", u.LastName, max)
+		long("organization", u.Organization, max)
+		long("job_title", u.JobTitle, max)
+		long("website", u.Website, max)
+		long("notes", u.Notes, maxNotesLength)
+		for i, e := range u.Emails {
+			long(path("emails", i, "value"), e.Value, max)
+		}
+		for i, p := range u.Phones {
+			long(path("phones", i, "value"), p.Value, max)
+		}
+		for i, a := range u.Addresses {
+			long(path("addresses", i, "street"), a.Street, max)
+			long(path("addresses", i, "city"), a.City, max)
+			long(path("addresses", i, "region"), a.Region, max)
+			long(path("addresses", i, "postal_code"), a.PostalCode, max)
+			long(path("addresses", i, "country"), a.Country, max)
+		}
+	}
+}
+
+func itemsRule(max int) Check {
+	return func(u *models.User, errs *Errors) {
+		many := func(field string, n int) {
+			if n > max {
+				errs.Add(field, CodeTooMany, fmt.Sprintf("has more than %d items", max))
+			}
+		}
+		many("emails", len(u.Emails))
+		many("phones", len(u.Phones))
+		many("addresses", len(u.Addresses))
+	}
+}
+
+func nameRule(valid func(string) bool) Check {
+	return func(u *models.User, errs *Errors) {
+		if u.FirstName != "" && !valid(u.FirstName) {
+			errs.Add("first_name", CodeInvalid, "is not a valid name")
+		}
+		if u.LastName != "" && !valid(u.LastName) {
+			errs.Add("last_name", CodeInvalid, "is not a valid name")
+		}
+	}
+}
+
+func emailRule(u *models.User, errs *Errors) {
+	for i, e := range u.Emails {
+		switch {
+		case e.Value == "":
+			errs.Add(path("emails", i, "value"), CodeRequired, "is required")
+		case !emailCheck(e.Value):
+			errs.Add(path("emails", i, "value"), CodeInvalid, "is not a valid email")
+		}
+	}
+}
+
+func phoneRule(region string) Check {
+	return func(u *models.User, errs *Errors) {
+		for i, p := range u.Phones {
+			field := path("phones", i, "value")
+			if p.Value == "" {
+				errs.Add(field, CodeRequired, "is required")
+				continue
+			}
+			if _, err := phone.Parse(p.Value, region); err != nil {
+				errs.Add(field, CodeInvalid, err.Error())
+			}
+		}
+	}
+}
+
+func labelRule(u *models.User, errs *Errors) {
+	for i, e := range u.Emails {
+		if !models.ValidLabel(e.Label, models.EmailLabels) {
+			errs.Add(path("emails", i, "label"), CodeLabel, "should be one of "+strings.Join(models.EmailLabels, ", "))
+		}
+	}
+	for i, p := range u.Phones {
+		if !models.ValidLabel(p.Label, models.PhoneLabels) {
+			errs.Add(path("phones", i, "label"), CodeLabel, "should be one of "+strings.Join(models.PhoneLabels, ", "))
+		}
+	}
+	for i, a := range u.Addresses {
+		if !models.ValidLabel(a.Label, models.EmailLabels) {
+			errs.Add(path("addresses", i, "label"), CodeLabel, "should be one of "+strings.Join(models.EmailLabels, ", "))
+		}
+	}
+}
+
+func addressRule(u *models.User, errs *Errors) {
+	for i := range u.Addresses {
+		if u.Addresses[i].IsEmpty() {
+			errs.Add(path("addresses", i, ""), CodeRequired, "is empty")
+		}
+	}
+}
+
+func birthdayRule(u *models.User, errs *Errors) {
+	if u.Birthday == "" {
+		return
+	}
+	date := u.Birthday
+	if strings.HasPrefix(date, "--") {
+		// leap year, so --02-29 is valid
+		date = "2000" + date[1:]
+	}
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		errs.Add("birthday", CodeInvalid, "should be YYYY-MM-DD or --MM-DD")
+	}
+}
+
+func websiteRule(u *models.User, errs *Errors) {
+	if u.Website == "" {
+		return
+	}
+	site, err := url.Parse(u.Website)
+	if err != nil || (site.Scheme != "http" && site.Scheme != "https") || site.Host == "" {
+		errs.Add("website", CodeInvalid, "should be http or https URL")
+	}
+}
@@ -0,0 +1,87 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ferux/addressbook/internal/models"
+	"github.com/ferux/addressbook/internal/types"
+)
+
+func TestIsName(t *testing.T) {
+	for _, name := range []string{"José", "O'Brien", "Anne-Marie", "Ng Wei", "Jr.", "Zoë", "Анна", "李", "Mary J. Blige"} {
+		if !IsName(name) {
+			t.Errorf("%q is not a name", name)
+		}
+	}
+	for _, name := range []string{"", " Ann", "Ann ", "-Ann", "Ann-", "R2D2", "Ann  Lee", ".Ann", "ann@example"} {
+		if IsName(name) {
+			t.Errorf("%q is a name", name)
+		}
+	}
+}
+
+// fields returns fields of errs with their codes.
+func fields(errs Errors) string {
+	res := make([]string, 0, len(errs))
+	for _, e := range errs {
+		res = append(res, e.Field+"="+e.Code)
+	}
+	return strings.Join(res, " ")
+}
+
+func TestValidate(t *testing.T) {
+	v, err := New(types.Validation{MaxItems: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := v.Validate(&models.User{FirstName: "Ann", LastName: "Lee"}); errs != nil {
+		t.Errorf("valid user has errors %v", errs)
+	}
+	errs := v.Validate(&models.User{
+		FirstName: "R2D2",
+		Emails:    []models.Email{{Value: "ann@example.com"}, {Label: "spam", Value: "ann"}},
+		Addresses: []models.Address{{}},
+		Birthday:  "2001-02-29",
+		Website:   "ftp://example.com",
+	})
+	want := "last_name=required emails=too_many first_name=invalid emails[1].value=invalid " +
+		"emails[1].label=invalid_label addresses[0]=required birthday=invalid website=invalid"
+	if got := fields(errs); got != want {
+		t.Errorf("errors are %s, want %s", got, want)
+	}
+}
+
+func TestRules(t *testing.T) {
+	v, err := New(types.Validation{
+		Required:    []string{models.FieldFirstName, models.FieldEmail},
+		Disabled:    []string{RuleName},
+		PhoneRegion: "US",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.Add("company", func(u *models.User, errs *Errors) {
+		if u.Organization == "" {
+			errs.Add("organization", CodeRequired, "is required")
+		}
+	})
+	u := &models.User{FirstName: "R2D2", Phones: []models.Phone{{Value: "(555) 010-0000"}}}
+	if got := fields(v.Prepare(u)); got != "emails=required organization=required" {
+		t.Errorf("errors are %s", got)
+	}
+	if u.Phones[0].E164 != "+15550100000" {
+		t.Errorf("phone is normalized to %q", u.Phones[0].E164)
+	}
+
+	for _, conf := range []types.Validation{
+		{Required: []string{"notes"}},
+		{Disabled: []string{"unknown"}},
+		{NamePattern: "("},
+		{PhoneRegion: "XX"},
+	} {
+		if _, err = New(conf); err == nil {
+			t.Errorf("config %+v is accepted", conf)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package vcard
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+)
+
+// maxLineSize limits the size of physical line. Photos are not always folded.
+const maxLineSize = 1024 * 1024
+
+// maxCardLines limits the amount of lines in one card, so broken file
+// without END:VCARD is not kept in memory.
+const maxCardLines = 1000
+
+// Reader reads cards from multi-contact vCard file.
+type Reader struct {
+	s    *bufio.Scanner
+	next string
+	eof  bool
+	card int
+}
+
+// NewReader creates new Reader from r.
+func NewReader(r io.Reader) *Reader {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), maxLineSize)
+	return &Reader{s: s}
+}
+
+// readLine returns next unfolded content line.
+func (r *Reader) readLine() (string, error) {
+	if r.next == "" && !r.eof {
+		if err := r.scan(); err != nil {
+			return "", err
+		}
+	}
+	if r.next == "" && r.eof {
+		return "", io.EOF
+	}
+	line := r.next
+	r.next = ""
+	for !r.eof {
+		if err := r.scan(); err != nil {
+			return "", err
+		}
+		if r.next == "" || (r.next[0] != ' ' && r.next[0] != '\t') {
+			break
+		}
+		line += r.next[1:]
+		r.next = ""
+	}
+	return line, nil
+}
+
+// scan reads next non-empty physical line into r.next.
+func (r *Reader) scan() error {
+	for r.s.Scan() {
+		if line := strings.TrimRight(r.s.Text(), "\r"); line != "" {
+			r.next = line
+			return nil
+		}
+	}
+	r.eof = true
+	return r.s.Err()
+}
+
+// Read returns next card. Problems with a card are returned as *ParseError,
+// the following call continues with the next card. io.EOF is returned when
+// there are no more cards.
+func (r *Reader) Read() (Card, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	r.card++
+	if !strings.EqualFold(line, "BEGIN:VCARD") {
+		r.skip()
+		return nil, &ParseError{Card: r.card, Err: ErrNoBegin}
+	}
+	card := Card{}
+	for i := 0; i < maxCardLines; i++ {
+		line, err = r.readLine()
+		if err == io.EOF {
+			return nil, &ParseError{Card: r.card, UID: card.Value("UID"), Err: ErrNoEnd}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(line, "END:VCARD") {
+			if err = card.check(r.card); err != nil {
+				return nil, err
+			}
+			return card, nil
+		}
+		prop, err := parseLine(line)
+		if err != nil {
+			r.skip()
+			return nil, &ParseError{Card: r.card, UID: card.Value("UID"), Err: err}
+		}
+		card[prop.Name] = append(card[prop.Name], prop)
+	}
+	r.skip()
+	return nil, &ParseError{Card: r.card, UID: card.Value("UID"), Err: ErrNoEnd}
+}
+
+// skip reads lines till the end of current card.
+func (r *Reader) skip() {
+	for {
+		line, err := r.readLine()
+		if err != nil || strings.EqualFold(line, "END:VCARD") {
+			return
+		}
+	}
+}
+
+func (c Card) check(n int) error {
+	switch v := c.Value("VERSION"); v {
+	case Version3, Version4, "2.1":
+		return nil
+	default:
+		return &ParseError{Card: n, UID: c.Value("UID"), Err: ErrVersion}
+	}
+}
+
+// parseLine parses content line: [group.]name *(;param) : value
+func parseLine(line string) (*Property, error) {
+	colon := -1
+	quoted := false
+	for i := 0; i < len(line) && colon < 0; i++ {
+		switch line[i] {
+		case '"':
+			quoted = !quoted
+		case ':':
+			if !quoted {
+				colon = i
+			}
+		}
+	}
+	if colon <= 0 {
+		return nil, errors.New("malformed line: " + line)
+	}
+	prop := &Property{Value: line[colon+1:], Params: map[string][]string{}}
+	params := strings.Split(line[:colon], ";")
+	name := params[0]
+	if dot := strings.IndexByte(name, '.'); dot >= 0 {
+		prop.Group, name = name[:dot], name[dot+1:]
+	}
+	prop.Name = strings.ToUpper(name)
+	for _, param := range params[1:] {
+		kv := strings.SplitN(param, "=", 2)
+		key := strings.ToUpper(kv[0])
+		if len(kv) == 1 {
+			// vCard 2.1 allows types without TYPE=, e.g. TEL;CELL
+			prop.Params["TYPE"] = append(prop.Params["TYPE"], strings.ToLower(key))
+			continue
+		}
+		for _, v := range strings.Split(kv[1], ",") {
+			prop.Params[key] = append(prop.Params[key], strings.Trim(v, `"`))
+		}
+	}
+	return prop, nil
+}
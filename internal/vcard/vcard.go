@@ -0,0 +1,310 @@
+// Package vcard converts users to vCard 3.0/4.0 (RFC 2426, RFC 6350) and back.
+package vcard
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ferux/addressbook/internal/models"
+)
+
+// Supported versions.
+const (
+	Version3 = "3.0"
+	Version4 = "4.0"
+)
+
+// MIME types of vCard files.
+const (
+	MediaType       = "text/vcard"
+	MediaTypeLegacy = "text/x-vcard"
+)
+
+// maxLineLength is a limit of line length in octets after which the line is folded.
+const maxLineLength = 75
+
+var (
+	// ErrVersion reports in case version is not supported.
+	ErrVersion = errors.New("unsupported vCard version")
+	// ErrNoBegin reports in case content does not start with BEGIN:VCARD.
+	ErrNoBegin = errors.New("BEGIN:VCARD expected")
+	// ErrNoEnd reports in case card is not closed with END:VCARD.
+	ErrNoEnd = errors.New("END:VCARD expected")
+)
+
+// ParseError describes a problem with one card. Reader can continue after it.
+type ParseError struct {
+	Card int
+	UID  string
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("card %d: %v", e.Card, e.Err)
+}
+
+// Property is a content line of vCard.
+type Property struct {
+	Group  string
+	Name   string
+	Params map[string][]string
+	Value  string
+}
+
+// Text returns unescaped value of the property.
+func (p *Property) Text() string {
+	return unescape(p.Value)
+}
+
+// Card is a set of properties grouped by upper-cased name.
+type Card map[string][]*Property
+
+// Get returns the first property with the name.
+func (c Card) Get(name string) *Property {
+	if props := c[name]; len(props) > 0 {
+		return props[0]
+	}
+	return nil
+}
+
+// Value returns the unescaped text value of the first property with the name.
+func (c Card) Value(name string) string {
+	if p := c.Get(name); p != nil {
+		return p.Text()
+	}
+	return ""
+}
+
+// Encode writes u as a vCard of the specified version.
+func Encode(w io.Writer, u *models.User, version string) error {
+	if version != Version3 && version != Version4 {
+		return ErrVersion
+	}
+	bw := bufio.NewWriter(w)
+	fn := strings.TrimSpace(u.FirstName + " " + u.LastName)
+	lines := []string{
+		"BEGIN:VCARD",
+		"VERSION:" + version,
+		"UID:" + u.ID.String(),
+		"FN:" + escape(fn),
+		"N:" + escape(u.LastName) + ";" + escape(u.FirstName) + ";;;",
+	}
+	for _, e := range u.Emails {
+		lines = append(lines, "EMAIL"+typeParam(e.Label)+":"+escape(e.Value))
+	}
+	for _, p := range u.Phones {
+		if version == Version4 {
+			lines = append(lines, "TEL;VALUE=text"+typeParam(p.Label)+":"+escape(p.Value))
+		} else {
+			lines = append(lines, "TEL"+typeParam(p.Label)+":"+escape(p.Value))
+		}
+	}
+	for _, a := range u.Addresses {
+		lines = append(lines, "ADR"+typeParam(a.Label)+":;;"+escape(a.Street)+";"+escape(a.City)+";"+
+			escape(a.Region)+";"+escape(a.PostalCode)+";"+escape(a.Country))
+	}
+	if u.Organization != "" {
+		lines = append(lines, "ORG:"+escape(u.Organization))
+	}
+	if u.JobTitle != "" {
+		lines = append(lines, "TITLE:"+escape(u.JobTitle))
+	}
+	if u.Birthday != "" {
+		lines = append(lines, "BDAY:"+formatBirthday(u.Birthday, version))
+	}
+	if u.Website != "" {
+		lines = append(lines, "URL:"+u.Website)
+	}
+	if u.Notes != "" {
+		lines = append(lines, "NOTE:"+escape(u.Notes))
+	}
+	lines = append(lines, "END:VCARD")
+	for _, line := range lines {
+		if _, err := bw.WriteString(fold(line)); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ToUser converts card to user. Cards which UID is not an id created by
+// this service get an id derived from the UID, so importing the same card
+// again updates the same user.
+func ToUser(c Card) *models.User {
+	u := &models.User{
+		JobTitle: c.Value("TITLE"),
+		Birthday: parseBirthday(c.Value("BDAY")),
+		Website:  c.Value("URL"),
+		Notes:    c.Value("NOTE"),
+	}
+	for _, p := range c["EMAIL"] {
+		if v := p.Text(); v != "" {
+			u.Emails = append(u.Emails, models.Email{Label: label(p, false), Value: v})
+		}
+	}
+	for _, p := range c["TEL"] {
+		if v := strings.TrimPrefix(p.Text(), "tel:"); v != "" {
+			u.Phones = append(u.Phones, models.Phone{Label: label(p, true), Value: v})
+		}
+	}
+	for _, p := range c["ADR"] {
+		parts := make([]string, 7)
+		copy(parts, splitUnescape(p.Value, ';'))
+		a := models.Address{Label: label(p, false), Street: parts[2], City: parts[3], Region: parts[4], PostalCode: parts[5], Country: parts[6]}
+		if !a.IsEmpty() {
+			u.Addresses = append(u.Addresses, a)
+		}
+	}
+	if org := c.Get("ORG"); org != nil {
+		// only organization name is kept, units are dropped
+		u.Organization = splitUnescape(org.Value, ';')[0]
+	}
+	if n := c.Get("N"); n != nil {
+		parts := splitUnescape(n.Value, ';')
+		if len(parts) > 0 {
+			u.LastName = parts[0]
+		}
+		if len(parts) > 1 {
+			u.FirstName = parts[1]
+		}
+	} else if fn := strings.Fields(c.Value("FN")); len(fn) > 0 {
+		u.FirstName = fn[0]
+		u.LastName = strings.Join(fn[1:], " ")
+	}
+	if uid := c.Value("UID"); uid != "" {
+		u.ID = UIDToID(uid)
+	}
+	return u
+}
+
+// typeParam returns TYPE parameter for label. Mobile phones are "cell" in vCard.
+func typeParam(label string) string {
+	switch label {
+	case "":
+		return ""
+	case models.LabelMobile:
+		return ";TYPE=cell"
+	default:
+		return ";TYPE=" + label
+	}
+}
+
+// label returns the label of the property by its TYPE parameter. Types
+// which are not labels, like "pref" or "voice", are ignored.
+func label(p *Property, phone bool) string {
+	for _, t := range p.Params["TYPE"] {
+		switch t = strings.ToLower(t); t {
+		case models.LabelHome, models.LabelWork, models.LabelOther:
+			return t
+		case "cell", models.LabelMobile:
+			if phone {
+				return models.LabelMobile
+			}
+		}
+	}
+	return ""
+}
+
+// formatBirthday converts YYYY-MM-DD or --MM-DD to the format of version.
+// vCard 4.0 uses basic format: YYYYMMDD or --MMDD.
+func formatBirthday(bday, version string) string {
+	if version != Version4 {
+		return bday
+	}
+	if strings.HasPrefix(bday, "--") {
+		return "--" + strings.Replace(bday[2:], "-", "", -1)
+	}
+	return strings.Replace(bday, "-", "", -1)
+}
+
+// parseBirthday converts date of BDAY property to YYYY-MM-DD or --MM-DD.
+// Time part and values in other formats are kept as is.
+func parseBirthday(bday string) string {
+	if i := strings.IndexByte(bday, 'T'); i >= 0 {
+		bday = bday[:i]
+	}
+	switch {
+	case len(bday) == 8 && !strings.HasPrefix(bday, "--"):
+		return bday[:4] + "-" + bday[4:6] + "-" + bday[6:]
+	case len(bday) == 6 && strings.HasPrefix(bday, "--"):
+		return "--" + bday[2:4] + "-" + bday[4:]
+	default:
+		return bday
+	}
+}
+
+// UIDToID converts vCard UID to user id.
+func UIDToID(uid string) models.ID {
+	uid = strings.TrimPrefix(uid, "urn:uuid:")
+	if id, ok := models.ParseID(uid); ok {
+		return id
+	}
+	sum := sha1.Sum([]byte(uid))
+	return models.ID(hex.EncodeToString(sum[:12]))
+}
+
+// fold splits line to several lines no longer than maxLineLength octets
+// and terminates it with CRLF. Multi-byte runes are not split.
+func fold(line string) string {
+	var b strings.Builder
+	limit := maxLineLength
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !isRuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// continuation lines start with a space
+		limit = maxLineLength - 1
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+	return b.String()
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, ",", `\,`, ";", `\;`)
+
+func escape(s string) string {
+	return escaper.Replace(strings.Replace(s, "\r\n", "\n", -1))
+}
+
+func unescape(s string) string {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s
+	}
+	return splitUnescape(s, 0)[0]
+}
+
+// splitUnescape splits s by unescaped sep and unescapes parts. Zero sep means no splitting.
+func splitUnescape(s string, sep byte) []string {
+	parts := []string{}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s):
+			i++
+			if s[i] == 'n' || s[i] == 'N' {
+				b.WriteByte('\n')
+			} else {
+				b.WriteByte(s[i])
+			}
+		case c == sep && sep != 0:
+			parts = append(parts, b.String())
+			b.Reset()
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return append(parts, b.String())
+}
@@ -0,0 +1,95 @@
+package vcard
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ferux/addressbook/internal/models"
+)
+
+func testUser() *models.User {
+	return &models.User{
+		ID:        models.NewID(),
+		FirstName: "Ann",
+		LastName:  "Lee, Jr.",
+		Emails:    []models.Email{{Label: models.LabelWork, Value: "ann@example.com"}},
+		Phones:    []models.Phone{{Label: models.LabelMobile, Value: "+1 555 010 0000"}},
+		Addresses: []models.Address{{Label: models.LabelHome, Street: "1 Main St; Apt 2", City: "Springfield", Country: "USA"}},
+		JobTitle:  "CTO",
+		Birthday:  "--04-12",
+		Website:   "https://example.com",
+		Notes:     "Met at the fair\nin " + strings.Repeat("spring ", 20),
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, version := range []string{Version3, Version4} {
+		u := testUser()
+		var buf bytes.Buffer
+		if err := Encode(&buf, u, version); err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(buf.String(), "\r\n") {
+			if len(line) > maxLineLength {
+				t.Errorf("vCard %s has line of %d octets: %s", version, len(line), line)
+			}
+		}
+		card, err := NewReader(&buf).Read()
+		if err != nil {
+			t.Fatalf("can't read vCard %s: %v", version, err)
+		}
+		if got := card.Value("VERSION"); got != version {
+			t.Errorf("version is %s, want %s", got, version)
+		}
+		if d := models.Diff(u, ToUser(card)); len(d) != 0 {
+			t.Errorf("user after round trip of vCard %s differs in %+v", version, d)
+		}
+	}
+	if err := Encode(&bytes.Buffer{}, testUser(), "2.1"); err != ErrVersion {
+		t.Errorf("vCard 2.1 is encoded with %v", err)
+	}
+}
+
+func TestReaderContinuesAfterBrokenCard(t *testing.T) {
+	file := strings.Join([]string{
+		"BEGIN:VCARD", "VERSION:3.0", "UID:first", "N:Lee;Ann;;;", "END:VCARD",
+		"BEGIN:VCARD", "VERSION:5.0", "UID:second", "END:VCARD",
+		"BEGIN:VCARD", "VERSION:3.0", "broken line", "END:VCARD",
+		"BEGIN:VCARD", "VERSION:2.1", "FN:Bob van Dyke", "TEL;CELL:555", "END:VCARD",
+	}, "\r\n")
+	r := NewReader(strings.NewReader(file))
+	card, err := r.Read()
+	if err != nil || ToUser(card).FirstName != "Ann" {
+		t.Fatalf("first card is %v, %v", card, err)
+	}
+	if _, err = r.Read(); err == nil || err.(*ParseError).Err != ErrVersion || err.(*ParseError).UID != "second" {
+		t.Errorf("card of unknown version is read with %v", err)
+	}
+	if _, err = r.Read(); err == nil || err.(*ParseError).Card != 3 {
+		t.Errorf("card with broken line is read with %v", err)
+	}
+	card, err = r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := ToUser(card)
+	if u.FirstName != "Bob" || u.LastName != "van Dyke" || len(u.Phones) != 1 || u.Phones[0].Label != models.LabelMobile {
+		t.Errorf("vCard 2.1 is read as %+v", u)
+	}
+	if _, err = r.Read(); err != io.EOF {
+		t.Errorf("read after the last card returns %v", err)
+	}
+}
+
+func TestUIDToID(t *testing.T) {
+	id := models.NewID()
+	if got := UIDToID("urn:uuid:" + strings.ToUpper(id.String())); got != id {
+		t.Errorf("UID of the service is converted to %s, want %s", got, id)
+	}
+	a, b := UIDToID("foreign-uid"), UIDToID("foreign-uid")
+	if a != b || !models.IsValidID(a.String()) || a == UIDToID("other-uid") {
+		t.Errorf("foreign UID is converted to %s and %s", a, b)
+	}
+}
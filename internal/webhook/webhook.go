@@ -0,0 +1,333 @@
+// Package webhook delivers changes of users to webhooks of their book.
+// Deliveries are sent in background and retried with exponential backoff,
+// the ones which fail too many times become dead letters.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ferux/addressbook/internal/models"
+	"github.com/ferux/addressbook/internal/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Defaults of delivery.
+const (
+	defaultWorkers     = 4
+	defaultQueueSize   = 1000
+	defaultTimeout     = time.Second * 10
+	defaultMaxAttempts = 8
+	defaultBackoff     = time.Second
+	defaultMaxBackoff  = time.Minute * 10
+)
+
+// Headers of deliveries. Signature is "sha256=" followed by hex of
+// HMAC-SHA256 of the timestamp, a dot and the body keyed by the secret of
+// the webhook.
+const (
+	HeaderEvent     = "X-Addressbook-Event"
+	HeaderDelivery  = "X-Addressbook-Delivery"
+	HeaderTimestamp = "X-Addressbook-Timestamp"
+	HeaderSignature = "X-Addressbook-Signature"
+)
+
+// maxResponseBytes limits reading responses of webhooks, they are ignored.
+const maxResponseBytes = 64 << 10
+
+var (
+	// ErrQueueFull reports in case there are too many deliveries waiting for a worker
+	ErrQueueFull = errors.New("delivery queue is full")
+	// ErrStopped reports in case the dispatcher is closed before delivery succeeds
+	ErrStopped = errors.New("delivery stopped on shutdown")
+)
+
+// DeadLetterFunc stores delivery to a webhook of the book of owner in
+// tenant which has failed too many times.
+type DeadLetterFunc func(tenant, owner string, d *models.DeadLetter) error
+
+// Dispatcher sends events to webhooks. It is safe for concurrent use.
+type Dispatcher struct {
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	deadLetter  DeadLetterFunc
+	logger      *logrus.Entry
+	queue       chan *delivery
+	// ctx is cancelled on close to stop deliveries in flight.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	// retries keeps timers of failed deliveries waiting for the next
+	// attempt, it is guarded by mu along with closed.
+	mu        sync.Mutex
+	closed    bool
+	retries   map[*delivery]*time.Timer
+	closeOnce sync.Once
+}
+
+// delivery is an event sent to one webhook.
+type delivery struct {
+	tenant   string
+	owner    string
+	hook     models.Webhook
+	event    *models.Event
+	body     []byte
+	attempts int
+	err      error
+}
+
+// New creates dispatcher and starts its workers. Deliveries which fail
+// are passed to deadLetter.
+func New(conf types.Webhooks, deadLetter DeadLetterFunc) *Dispatcher {
+	workers := conf.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	queueSize := conf.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	maxAttempts := conf.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Dispatcher{
+		client: &http.Client{
+			Timeout: conf.Timeout.Or(defaultTimeout),
+			// redirects are failures, the webhook should be updated instead
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		maxAttempts: maxAttempts,
+		backoff:     conf.Backoff.Or(defaultBackoff),
+		maxBackoff:  conf.MaxBackoff.Or(defaultMaxBackoff),
+		deadLetter:  deadLetter,
+		logger: logrus.New().WithFields(logrus.Fields{
+			"package": "webhook",
+			"entity":  "dispatcher",
+		}),
+		queue:   make(chan *delivery, queueSize),
+		ctx:     ctx,
+		cancel:  cancel,
+		retries: make(map[*delivery]*time.Timer),
+	}
+	d.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go d.work()
+	}
+	return d
+}
+
+// SecretPrefix starts every secret of webhooks.
+const SecretPrefix = "whsec_"
+
+// NewSecret generates a random secret which signs deliveries to a webhook.
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return SecretPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Sign returns signature of body sent at timestamp to the webhook with secret.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Notify queues delivery of e to every hook. It does not wait for them.
+func (d *Dispatcher) Notify(tenant, owner string, hooks []models.Webhook, e *models.Event) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		d.logger.WithError(err).WithField("fn", "Notify").Error("can't encode event")
+		return
+	}
+	for _, h := range hooks {
+		d.enqueue(&delivery{tenant: tenant, owner: owner, hook: h, event: e, body: body})
+	}
+}
+
+// Close stops workers and waits for them. Deliveries which have not
+// succeeded yet become dead letters, so Close should be called before the
+// storage of dead letters is closed.
+func (d *Dispatcher) Close() {
+	d.closeOnce.Do(func() {
+		d.mu.Lock()
+		d.closed = true
+		pending := make([]*delivery, 0, len(d.retries))
+		for dl, t := range d.retries {
+			t.Stop()
+			dl.err = fmt.Errorf("%v, stopped before attempt %d", dl.err, dl.attempts+1)
+			pending = append(pending, dl)
+		}
+		d.retries = nil
+		d.mu.Unlock()
+		d.cancel()
+		d.wg.Wait()
+		// workers have stopped and nothing is queued after closing
+		for len(d.queue) > 0 {
+			dl := <-d.queue
+			dl.err = ErrStopped
+			pending = append(pending, dl)
+		}
+		for _, dl := range pending {
+			d.bury(dl)
+		}
+		d.logger.Info("webhooks stopped")
+	})
+}
+
+// enqueue passes dl to workers. It becomes a dead letter if the queue is
+// full or the dispatcher is closed.
+func (d *Dispatcher) enqueue(dl *delivery) {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		dl.err = ErrStopped
+		d.bury(dl)
+		return
+	}
+	select {
+	case d.queue <- dl:
+		d.mu.Unlock()
+		return
+	default:
+	}
+	d.mu.Unlock()
+	dl.err = ErrQueueFull
+	d.bury(dl)
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case dl := <-d.queue:
+			d.deliver(dl)
+		}
+	}
+}
+
+// deliver makes an attempt to send dl and schedules the next one if it fails.
+func (d *Dispatcher) deliver(dl *delivery) {
+	logger := d.logger.WithFields(logrus.Fields{
+		"fn":       "deliver",
+		"webhook":  dl.hook.ID,
+		"delivery": dl.event.ID,
+		"attempt":  dl.attempts + 1,
+	})
+	dl.attempts++
+	if dl.err = d.send(dl); dl.err == nil {
+		logger.Debug("event delivered")
+		return
+	}
+	logger.WithError(dl.err).Warn("can't deliver event")
+	if d.ctx.Err() != nil {
+		dl.err = ErrStopped
+		d.bury(dl)
+		return
+	}
+	if dl.attempts >= d.maxAttempts {
+		d.bury(dl)
+		return
+	}
+	d.mu.Lock()
+	if !d.closed {
+		d.retries[dl] = time.AfterFunc(d.delay(dl.attempts), func() {
+			d.mu.Lock()
+			_, ok := d.retries[dl]
+			delete(d.retries, dl)
+			d.mu.Unlock()
+			if ok {
+				d.enqueue(dl)
+			}
+		})
+		d.mu.Unlock()
+		return
+	}
+	d.mu.Unlock()
+	// Close has collected retries already
+	d.bury(dl)
+}
+
+// delay returns backoff after failed attempt, it doubles after every one.
+func (d *Dispatcher) delay(attempt int) time.Duration {
+	delay := d.backoff
+	for i := 1; i < attempt && delay < d.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.maxBackoff {
+		delay = d.maxBackoff
+	}
+	return delay
+}
+
+// send posts the event of dl to its webhook. Only 2xx responses succeed.
+func (d *Dispatcher) send(dl *delivery) error {
+	req, err := http.NewRequest(http.MethodPost, dl.hook.URL, bytes.NewReader(dl.body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "addressbook-webhooks")
+	req.Header.Set(HeaderEvent, dl.event.Type)
+	req.Header.Set(HeaderDelivery, string(dl.event.ID))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(dl.hook.Secret, timestamp, dl.body))
+	resp, err := d.client.Do(req.WithContext(d.ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxResponseBytes))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+// bury stores dl as a dead letter.
+func (d *Dispatcher) bury(dl *delivery) {
+	logger := d.logger.WithFields(logrus.Fields{
+		"fn":       "bury",
+		"webhook":  dl.hook.ID,
+		"delivery": dl.event.ID,
+		"attempts": dl.attempts,
+	})
+	letter := &models.DeadLetter{
+		WebhookID: dl.hook.ID,
+		URL:       dl.hook.URL,
+		Event:     *dl.event,
+		Attempts:  dl.attempts,
+		Error:     dl.err.Error(),
+		FailedAt:  time.Now().UTC(),
+	}
+	logger.WithError(dl.err).Error("delivery failed")
+	if err := d.deadLetter(dl.tenant, dl.owner, letter); err != nil {
+		logger.WithError(err).Error("can't store dead letter")
+	}
+}
@@ -0,0 +1,154 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ferux/addressbook/internal/models"
+	"github.com/ferux/addressbook/internal/types"
+)
+
+// testHook is a webhook which responds with status to every request it keeps.
+type testHook struct {
+	*httptest.Server
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newTestHook(status int) *testHook {
+	h := &testHook{status: status}
+	h.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		h.mu.Lock()
+		h.requests = append(h.requests, r)
+		h.bodies = append(h.bodies, body)
+		status := h.status
+		h.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	return h
+}
+
+func (h *testHook) received() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.requests)
+}
+
+// testLetters keeps dead letters.
+type testLetters struct {
+	mu      sync.Mutex
+	letters []models.DeadLetter
+}
+
+func (l *testLetters) add(tenant, owner string, d *models.DeadLetter) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.letters = append(l.letters, *d)
+	return nil
+}
+
+func (l *testLetters) list() []models.DeadLetter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]models.DeadLetter(nil), l.letters...)
+}
+
+func eventually(t *testing.T, what string, check func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second * 5); !check(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: timed out", what)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func testEvent() *models.Event {
+	return &models.Event{ID: models.NewID(), Type: models.EventCreated, At: time.Now().UTC(), User: &models.User{ID: models.NewID(), FirstName: "Ann"}}
+}
+
+func TestDelay(t *testing.T) {
+	d := &Dispatcher{backoff: time.Second, maxBackoff: time.Second * 5}
+	// delays after the first attempt and the next ones
+	for i, want := range []time.Duration{time.Second, time.Second * 2, time.Second * 4, time.Second * 5, time.Second * 5} {
+		if got := d.delay(i + 1); got != want {
+			t.Errorf("delay after attempt %d is %s, want %s", i+1, got, want)
+		}
+	}
+}
+
+func TestNotify(t *testing.T) {
+	h := newTestHook(http.StatusNoContent)
+	defer h.Close()
+	letters := &testLetters{}
+	d := New(types.Webhooks{}, letters.add)
+	secret, err := NewSecret()
+	if err != nil || !strings.HasPrefix(secret, SecretPrefix) {
+		t.Fatalf("secret is %q, %v", secret, err)
+	}
+	e := testEvent()
+	d.Notify("", "ann", []models.Webhook{{ID: models.NewID(), URL: h.URL, Secret: secret}}, e)
+	eventually(t, "delivery", func() bool { return h.received() == 1 })
+	d.Close()
+
+	h.mu.Lock()
+	r, body := h.requests[0], h.bodies[0]
+	h.mu.Unlock()
+	if r.Header.Get(HeaderEvent) != models.EventCreated || r.Header.Get(HeaderDelivery) != e.ID.String() {
+		t.Errorf("headers of delivery are %v", r.Header)
+	}
+	if sig := Sign(secret, r.Header.Get(HeaderTimestamp), body); r.Header.Get(HeaderSignature) != sig {
+		t.Errorf("signature is %q, want %q", r.Header.Get(HeaderSignature), sig)
+	}
+	if Sign("whsec_other", r.Header.Get(HeaderTimestamp), body) == r.Header.Get(HeaderSignature) {
+		t.Error("signature does not depend on secret")
+	}
+	var got models.Event
+	if err = json.Unmarshal(body, &got); err != nil || got.ID != e.ID || got.User.FirstName != "Ann" {
+		t.Errorf("delivered event is %+v, %v", got, err)
+	}
+	if l := letters.list(); len(l) != 0 {
+		t.Errorf("delivered event has dead letters %+v", l)
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	h := newTestHook(http.StatusServiceUnavailable)
+	defer h.Close()
+	letters := &testLetters{}
+	d := New(types.Webhooks{MaxAttempts: 2, Backoff: types.Duration(time.Millisecond * 10)}, letters.add)
+	hook := models.Webhook{ID: models.NewID(), URL: h.URL}
+	d.Notify("", "ann", []models.Webhook{hook}, testEvent())
+	eventually(t, "dead letter", func() bool { return len(letters.list()) == 1 })
+	if l := letters.list()[0]; l.WebhookID != hook.ID || l.Attempts != 2 || !strings.Contains(l.Error, "503") {
+		t.Errorf("dead letter is %+v", l)
+	}
+	d.Close()
+
+	// retry waiting on close becomes a dead letter at once
+	letters = &testLetters{}
+	d = New(types.Webhooks{Backoff: types.Duration(time.Hour)}, letters.add)
+	d.Notify("", "ann", []models.Webhook{hook}, testEvent())
+	eventually(t, "scheduled retry", func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return len(d.retries) == 1
+	})
+	d.Close()
+	if l := letters.list(); len(l) != 1 || l[0].Attempts != 1 || !strings.Contains(l[0].Error, "stopped before attempt 2") {
+		t.Errorf("dead letters after close are %+v", l)
+	}
+	d.Notify("", "ann", []models.Webhook{hook}, testEvent())
+	if l := letters.list(); len(l) != 2 || l[1].Error != ErrStopped.Error() {
+		t.Errorf("dead letters after notify of closed dispatcher are %+v", l)
+	}
+}